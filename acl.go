@@ -0,0 +1,68 @@
+// Copyright [2021] [Christian Bandowski]
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"github.com/pkg/errors"
+	"net"
+	"strings"
+)
+
+// ways of handling clients that are not within the allowed networks
+const (
+	// connections are closed right after they were accepted
+	UnlistedClientsReject = "reject"
+	// clients may only use the commands needed to discover the UPS, like LIST UPS
+	UnlistedClientsLimited = "limited"
+)
+
+// commands clients outside of the allowed networks may use if they are limited
+var limitedCommands = map[commandRoute]bool{
+	{"LIST", "UPS"}: true,
+	{"VER", ""}:     true,
+	{"NETVER", ""}:  true,
+	{"PROTVER", ""}: true,
+	{"LOGOUT", ""}:  true,
+}
+
+// parseNetworks parses a comma separated list of networks in CIDR notation, like "192.168.0.0/24". Single addresses
+// are accepted as well and treated as network containing only this address.
+func parseNetworks(list string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, entry := range splitList(list) {
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, errors.Errorf("Invalid address %s", entry)
+			}
+
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 8 * net.IPv4len
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Invalid network %s", entry)
+		}
+		networks = append(networks, network)
+	}
+
+	return networks, nil
+}
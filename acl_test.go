@@ -0,0 +1,42 @@
+// Copyright [2021] [Christian Bandowski]
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestParseNetworks(t *testing.T) {
+	networks, err := parseNetworks("192.168.0.0/24, 10.0.0.1,fd00::/8, ::1")
+
+	assert.NoError(t, err)
+	if assert.Len(t, networks, 4) {
+		assert.Equal(t, "192.168.0.0/24", networks[0].String())
+		assert.Equal(t, "10.0.0.1/32", networks[1].String())
+		assert.Equal(t, "fd00::/8", networks[2].String())
+		assert.Equal(t, "::1/128", networks[3].String())
+	}
+}
+
+func TestParseNetworks_Invalid(t *testing.T) {
+	_, err := parseNetworks("192.168.0.0/24,foo")
+	assert.EqualError(t, err, "Invalid address foo")
+
+	_, err = parseNetworks("192.168.0.0/33")
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "Invalid network 192.168.0.0/33")
+	}
+}
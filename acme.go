@@ -0,0 +1,73 @@
+// Copyright [2021] [Christian Bandowski]
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/tls"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+	"net"
+	"net/http"
+)
+
+// loadACME creates the TLS configuration for certificates that are obtained from an ACME CA like Let's Encrypt and
+// renewed automatically. If enabled, it serves the HTTP challenges in the background.
+func (c *Config) loadACME() (*tls.Config, error) {
+	domains := splitList(c.acmeDomains)
+	if len(domains) == 0 {
+		return nil, errors.New("ACME requires at least one domain")
+	}
+
+	manager := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		Cache:      autocert.DirCache(c.acmeCacheDir),
+		HostPolicy: autocert.HostWhitelist(domains...),
+		Email:      c.acmeEmail,
+	}
+	if c.acmeDirectoryURL != "" {
+		manager.Client = &acme.Client{DirectoryURL: c.acmeDirectoryURL}
+	}
+
+	if c.acmeHTTPAddress != "" {
+		l, err := net.Listen("tcp", c.acmeHTTPAddress)
+		if err != nil {
+			return nil, errors.Wrap(err, "Couldn't start ACME HTTP challenge listener")
+		}
+
+		go func() {
+			if err := http.Serve(l, manager.HTTPHandler(nil)); err != nil {
+				logErrorf("Serving ACME HTTP challenges failed: %+v", errors.WithStack(err))
+			}
+		}()
+
+		logInfof("Serving ACME HTTP challenges on address %s", c.acmeHTTPAddress)
+	}
+
+	tlsConfig := manager.TLSConfig()
+	tlsConfig.MinVersion = tls.VersionTLS12
+
+	getCertificate := tlsConfig.GetCertificate
+	tlsConfig.GetCertificate = func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		// not all NUT clients send the server name, they get the certificate of the first domain
+		if hello.ServerName == "" {
+			hello.ServerName = domains[0]
+		}
+
+		return getCertificate(hello)
+	}
+
+	return tlsConfig, nil
+}
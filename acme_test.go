@@ -0,0 +1,155 @@
+// Copyright [2021] [Christian Bandowski]
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"github.com/stretchr/testify/assert"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeACMECacheEntry stores a self-signed certificate for the domain in the ACME cache directory, like autocert
+// does once it obtained one
+func writeACMECacheEntry(t *testing.T, cacheDir string, domain string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: domain},
+		DNSNames:     []string{domain},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(90 * 24 * time.Hour),
+	}
+	certDer, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	content := append(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}),
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDer})...)
+	if err := os.WriteFile(filepath.Join(cacheDir, domain), content, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConfig_loadTLS_ACME(t *testing.T) {
+	cacheDir := t.TempDir()
+	writeACMECacheEntry(t, cacheDir, "ups.example.com")
+
+	config := &Config{tlsPort: 3494, acmeDomains: "ups.example.com", acmeCacheDir: cacheDir}
+	if !assert.NoError(t, config.loadTLS()) || !assert.NotNil(t, config.tlsConfig) {
+		return
+	}
+	assert.Equal(t, uint16(tls.VersionTLS12), config.tlsConfig.MinVersion)
+
+	l, err := tls.Listen("tcp4", "127.0.0.1:0", config.tlsConfig)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer l.Close()
+	go func() {
+		c, err := l.Accept()
+		if err == nil {
+			_ = c.(*tls.Conn).Handshake()
+			c.Close()
+		}
+	}()
+
+	// the client doesn't send a server name, it gets the certificate of the first domain
+	c, err := tls.Dial("tcp4", l.Addr().String(), &tls.Config{InsecureSkipVerify: true})
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer c.Close()
+
+	assert.Equal(t, "ups.example.com", c.ConnectionState().PeerCertificates[0].Subject.CommonName)
+}
+
+func TestConfig_loadACME_UnknownHost(t *testing.T) {
+	config := &Config{acmeDomains: "ups.example.com", acmeCacheDir: t.TempDir()}
+
+	tlsConfig, err := config.loadACME()
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	// the certificate isn't cached and the host policy denies obtaining one, so the CA is never contacted
+	_, err = tlsConfig.GetCertificate(&tls.ClientHelloInfo{ServerName: "other.example.com"})
+	assert.Error(t, err)
+}
+
+func TestConfig_loadACME_NoDomains(t *testing.T) {
+	config := &Config{acmeDomains: ",", acmeCacheDir: t.TempDir()}
+
+	_, err := config.loadACME()
+	assert.EqualError(t, err, "ACME requires at least one domain")
+}
+
+func TestServe_ACME(t *testing.T) {
+	cacheDir := t.TempDir()
+	writeACMECacheEntry(t, cacheDir, "ups.example.com")
+
+	config := &Config{
+		upsName:       "test",
+		timeout:       time.Second,
+		maxLineLength: 1024,
+		acmeDomains:   "ups.example.com",
+		acmeCacheDir:  cacheDir,
+	}
+	listener := &Listener{network: "tcp4", address: "127.0.0.1:0", tls: true}
+	config.listeners = []*Listener{listener}
+	if !assert.NoError(t, config.loadTLS()) {
+		return
+	}
+
+	l, err := listen(listener, config)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer l.Close()
+	go serve(l, listener, config, NewSessionRegistry())
+
+	c, err := tls.Dial("tcp4", l.Addr().String(), &tls.Config{InsecureSkipVerify: true})
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer c.Close()
+
+	_, err = c.Write([]byte("LIST UPS\n"))
+	assert.NoError(t, err)
+
+	line, err := bufio.NewReader(c).ReadString('\n')
+	assert.NoError(t, err)
+	assert.Equal(t, "BEGIN LIST UPS\n", line)
+	assert.Equal(t, "ups.example.com", c.ConnectionState().PeerCertificates[0].Subject.CommonName)
+}
@@ -0,0 +1,189 @@
+// Copyright [2021] [Christian Bandowski]
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/pkg/errors"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// status flags of apcupsd combined by the aggregate source, in the order they are reported
+var aggregateStatusFlags = []string{
+	"ONLINE", "ONBATT", "LOWBATT", "CAL", "OVERLOAD", "TRIM", "BOOST", "REPLACEBATT", "SHUTTING DOWN", "COMMLOST",
+}
+
+// apc values of the aggregate that are the minimum of the members, e.g. the rack runs out of battery once the first
+// UPS does
+var aggregateMinKeys = []string{"BCHARGE", "TIMELEFT", "BATTV", "LINEV", "OUTPUTV"}
+
+// apc values of the aggregate that are the maximum of the members, e.g. the rack is shut down once the first UPS
+// reaches its shutdown limits
+var aggregateMaxKeys = []string{"LOADPCT", "ITEMP", "MBATTCHG", "MINTIMEL"}
+
+// AggregateDataSource computes the values of a virtual UPS from the values of several real ones, so a single UPS
+// can be monitored for a rack. The status combines the worst-case status of all of them, other values are taken
+// from the first one.
+type AggregateDataSource struct {
+	upsName string
+	members []*Config
+}
+
+// NewAggregateDataSource creates a new instance of AggregateDataSource
+func NewAggregateDataSource(upsName string, members []*Config) *AggregateDataSource {
+	return &AggregateDataSource{upsName: upsName, members: members}
+}
+
+// load loads the values of all members concurrently, the aggregate fails if any of them fails.
+func (s *AggregateDataSource) load(ctx context.Context) (map[string]string, error) {
+	memberValues := make([]map[string]string, len(s.members))
+	errs := make([]error, len(s.members))
+
+	var wg sync.WaitGroup
+	for i, member := range s.members {
+		wg.Add(1)
+		go func(i int, member *Config) {
+			defer wg.Done()
+			memberValues[i], errs[i] = member.source.load(ctx)
+		}(i, member)
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			return nil, errors.Wrapf(err, "Couldn't load the values of member %s", s.members[i].upsName)
+		}
+	}
+
+	return aggregateValues(memberValues), nil
+}
+
+// String returns the names of the members.
+func (s *AggregateDataSource) String() string {
+	names := make([]string, len(s.members))
+	for i, member := range s.members {
+		names[i] = member.upsName
+	}
+
+	return fmt.Sprintf("aggregate of %s", strings.Join(names, ", "))
+}
+
+// aggregateValues combines the values of the members, which must not be empty.
+func aggregateValues(memberValues []map[string]string) map[string]string {
+	values := make(map[string]string, len(memberValues[0]))
+	for key, value := range memberValues[0] {
+		values[key] = value
+	}
+
+	for _, key := range aggregateMinKeys {
+		aggregateNumber(values, memberValues, key, func(a float64, b float64) bool { return a < b })
+	}
+	for _, key := range aggregateMaxKeys {
+		aggregateNumber(values, memberValues, key, func(a float64, b float64) bool { return a > b })
+	}
+
+	var statuses []string
+	for _, memberValue := range memberValues {
+		if status, ok := memberValue["STATUS"]; ok {
+			statuses = append(statuses, status)
+		}
+	}
+	if len(statuses) > 0 {
+		values["STATUS"] = aggregateStatus(statuses)
+	}
+
+	return values
+}
+
+// aggregateNumber sets the value of the key to the one of the member that is preferred over all others, values
+// that aren't numbers are ignored.
+func aggregateNumber(values map[string]string, memberValues []map[string]string, key string,
+	preferred func(float64, float64) bool) {
+
+	found := false
+	var result float64
+	for _, memberValue := range memberValues {
+		value, ok := memberValue[key]
+		if !ok {
+			continue
+		}
+		number, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			continue
+		}
+
+		if !found || preferred(number, result) {
+			found = true
+			result = number
+			values[key] = value
+		}
+	}
+}
+
+// aggregateStatus combines the status flags of the members, the aggregate is only online if no member is on battery.
+func aggregateStatus(statuses []string) string {
+	flags := map[string]bool{}
+	for _, status := range statuses {
+		for _, flag := range aggregateStatusFlags {
+			if strings.Contains(status, flag) {
+				flags[flag] = true
+			}
+		}
+	}
+	if flags["ONBATT"] {
+		flags["ONLINE"] = false
+	}
+
+	var result []string
+	for _, flag := range aggregateStatusFlags {
+		if flags[flag] {
+			result = append(result, flag)
+		}
+	}
+
+	return strings.Join(result, " ")
+}
+
+// loadAggregateSource creates the aggregate source of the members, which have to be configured as UPSes themselves.
+func (c *Config) loadAggregateSource(upses []*Config) error {
+	var members []*Config
+	for _, name := range c.aggregateMemberList() {
+		var member *Config
+		for _, ups := range upses {
+			if ups.upsName == name {
+				member = ups
+			}
+		}
+
+		if member == nil {
+			return errors.Errorf("Unknown member %s of UPS %s", name, c.upsName)
+		}
+		if member.dataSource == DataSourceAggregate {
+			return errors.Errorf("The member %s of UPS %s must not be an aggregate itself", name, c.upsName)
+		}
+		members = append(members, member)
+	}
+
+	c.source = NewAggregateDataSource(c.upsName, members)
+	return nil
+}
+
+// aggregateMemberList returns the names of the members, they may be separated by commas or whitespace.
+func (c *Config) aggregateMemberList() []string {
+	return strings.Fields(strings.ReplaceAll(c.aggregateMembers, ",", " "))
+}
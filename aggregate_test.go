@@ -0,0 +1,105 @@
+// Copyright [2021] [Christian Bandowski]
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"testing"
+)
+
+func TestAggregateStatus(t *testing.T) {
+	statusesToResult := map[string]struct {
+		statuses  []string
+		expStatus string
+	}{
+		"online":         {[]string{"ONLINE", "ONLINE"}, "ONLINE"},
+		"on battery":     {[]string{"ONLINE", "ONBATT"}, "ONBATT"},
+		"low battery":    {[]string{"ONBATT LOWBATT", "ONLINE TRIM"}, "ONBATT LOWBATT TRIM"},
+		"replace":        {[]string{"ONLINE REPLACEBATT", "ONLINE"}, "ONLINE REPLACEBATT"},
+		"shutting down":  {[]string{"SHUTTING DOWN", "ONLINE"}, "ONLINE SHUTTING DOWN"},
+		"lost":           {[]string{"COMMLOST", "ONLINE"}, "ONLINE COMMLOST"},
+		"unknown status": {[]string{"FOO"}, ""},
+	}
+
+	for name, test := range statusesToResult {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, test.expStatus, aggregateStatus(test.statuses))
+		})
+	}
+}
+
+func TestAggregateValues(t *testing.T) {
+	values := aggregateValues([]map[string]string{
+		{"STATUS": "ONLINE", "MODEL": "Back-UPS", "BCHARGE": "100.0", "TIMELEFT": "30.0", "LOADPCT": "10.0"},
+		{"STATUS": "ONBATT", "MODEL": "Smart-UPS", "BCHARGE": "80.0", "TIMELEFT": "45.0", "LOADPCT": "n/a"},
+		{"STATUS": "ONLINE", "BCHARGE": "90.0", "TIMELEFT": "60.0", "LOADPCT": "20.0"},
+	})
+
+	assert.Equal(t, map[string]string{
+		"STATUS":   "ONBATT",
+		"MODEL":    "Back-UPS",
+		"BCHARGE":  "80.0",
+		"TIMELEFT": "30.0",
+		"LOADPCT":  "20.0",
+	}, values)
+}
+
+func TestAggregateDataSource_load(t *testing.T) {
+	first := &mockDataSource{}
+	first.On("load", mock.Anything).Return(map[string]string{"STATUS": "ONLINE", "BCHARGE": "100.0"}, nil)
+	second := &mockDataSource{}
+	second.On("load", mock.Anything).Return(map[string]string{"STATUS": "ONBATT", "BCHARGE": "50.0"}, nil)
+	source := NewAggregateDataSource("rack", []*Config{{upsName: "first", source: first},
+		{upsName: "second", source: second}})
+
+	values, err := source.load(context.Background())
+
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"STATUS": "ONBATT", "BCHARGE": "50.0"}, values)
+	assert.Equal(t, "aggregate of first, second", source.String())
+}
+
+func TestAggregateDataSource_load_Error(t *testing.T) {
+	first := &mockDataSource{}
+	first.On("load", mock.Anything).Return(map[string]string{"STATUS": "ONLINE"}, nil)
+	second := &mockDataSource{}
+	second.On("load", mock.Anything).Return(nil, errors.New("unreachable"))
+	source := NewAggregateDataSource("rack", []*Config{{upsName: "first", source: first},
+		{upsName: "second", source: second}})
+
+	_, err := source.load(context.Background())
+
+	assert.EqualError(t, err, "Couldn't load the values of member second: unreachable")
+}
+
+func TestConfig_loadAggregateSource(t *testing.T) {
+	first := &Config{upsName: "first", dataSource: DataSourceNis}
+	second := &Config{upsName: "second", dataSource: DataSourceNis}
+	rack := &Config{upsName: "rack", dataSource: DataSourceAggregate, aggregateMembers: "first second"}
+	upses := []*Config{first, second, rack}
+
+	if assert.NoError(t, rack.loadAggregateSource(upses)) {
+		assert.Equal(t, NewAggregateDataSource("rack", []*Config{first, second}), rack.source)
+	}
+
+	rack.aggregateMembers = "first third"
+	assert.EqualError(t, rack.loadAggregateSource(upses), "Unknown member third of UPS rack")
+	rack.aggregateMembers = "first rack"
+	assert.EqualError(t, rack.loadAggregateSource(upses),
+		"The member rack of UPS rack must not be an aggregate itself")
+}
@@ -17,7 +17,9 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"github.com/pkg/errors"
+	"os"
 	"os/exec"
 	"strings"
 	"time"
@@ -26,8 +28,13 @@ import (
 // IApcValues are used to store values returned by apcaccess
 // It provides the functionality to reload these values and retrieve them.
 type IApcValues interface {
-	// reload will load the apc values for the given config by using the given exec function.
-	reload(config *Config) error
+	// reload will load the apc values from the data source of the given config.
+	// The given context can be used to cancel the reload, e.g. if the client isn't waiting for the response anymore.
+	reload(ctx context.Context, config *Config) error
+	// invalidate forces the next reload to load the values, even within the poll interval.
+	invalidate()
+	// fresh returns whether the values were loaded from the data source of the given config within the poll interval.
+	fresh(config *Config) bool
 
 	// get retrieves the value by name, returns an empty string if the value was not found
 	get(name string) string
@@ -40,8 +47,6 @@ func NewApcValues() *ApcValues {
 	return &ApcValues{
 		values:      make(map[string]string),
 		refreshTime: time.Unix(0, 0),
-
-		exec: execCommand,
 	}
 }
 
@@ -53,67 +58,95 @@ type ApcValues struct {
 	// last time the values were refreshed
 	refreshTime time.Time
 
-	// will be used to invoke the apcaccess command
-	exec execCmd
+	// source the values were loaded from
+	source DataSource
 }
 
 // function signature for executing a command
-type execCmd func(string, ...string) ([]byte, error)
+type execCmd func(context.Context, string, ...string) ([]byte, error)
+
+// executes a command by using exec.CommandContext, the command will be killed once the context is done
+func execCommand(ctx context.Context, name string, arg ...string) ([]byte, error) {
+	return execCommandWithInput(ctx, "", name, arg...)
+}
+
+// executes a command like execCommand, but writes the given input to the standard input of the command
+func execCommandWithInput(ctx context.Context, input string, name string, arg ...string) ([]byte, error) {
+	return runCommand(ctx, input, nil, name, arg...)
+}
 
-// executes a command by using exec.Command
-func execCommand(name string, arg ...string) ([]byte, error) {
+// returns a function executing a command like execCommand, which adds the given variables to the environment
+func execCommandWithEnv(env []string) execCmd {
+	return func(ctx context.Context, name string, arg ...string) ([]byte, error) {
+		return runCommand(ctx, "", env, name, arg...)
+	}
+}
+
+// executes a command with the given input and further environment variables
+func runCommand(ctx context.Context, input string, env []string, name string, arg ...string) ([]byte, error) {
 	var out bytes.Buffer
 	writer := bufio.NewWriter(&out)
 
-	cmd := exec.Command(name, arg...)
+	cmd := exec.CommandContext(ctx, name, arg...)
+	cmd.Stdin = strings.NewReader(input)
 	cmd.Stdout = writer
+	if len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
 
 	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return nil, errors.Wrapf(ctx.Err(), "Invoking %s was cancelled", name)
+		}
 		return nil, errors.Wrapf(err, "Error invoking %s", name)
 	}
 
 	return out.Bytes(), nil
 }
 
-// reloads the apc values
-func (ar *ApcValues) reload(config *Config) error {
-	out, err := ar.exec(config.apcAccessExecutable, "-h", config.targetAddress, "-u")
-	if err != nil {
-		return errors.Wrapf(err, "Error invoking apcaccess")
+// reloads the apc values, unless they were loaded from the same source within the poll interval
+func (ar *ApcValues) reload(ctx context.Context, config *Config) error {
+	if config.source == nil {
+		return errors.New("No data source configured")
+	}
+	if ar.fresh(config) {
+		return nil
 	}
 
-	ar.values = make(map[string]string)
-
-	scanner := bufio.NewScanner(bytes.NewReader(out))
-	for scanner.Scan() {
-		err := scanner.Err()
-		if err != nil {
-			return errors.Wrapf(err, "Error reading apcaccess output")
-		}
-
-		line := scanner.Text()
-
-		if strings.TrimSpace(line) == "" {
-			// skip empty lines
-			continue
-		}
-
-		pos := strings.Index(line, ":")
-		if pos == -1 {
-			return errors.New("Invalid line in apcaccess output")
-		}
-
-		key := strings.TrimSpace(line[:pos])
-		value := strings.TrimSpace(line[(pos + 1):])
-
-		ar.values[key] = value
+	values, err := config.source.load(ctx)
+	config.state.recordSourceLoad(err)
+	if err == nil {
+		config.state.setSnapshot(values)
+	}
+	if lastKnown, ok := staleValues(err); ok {
+		logWarnf("Using the last known values of UPS %s: %v", config.upsName, err)
+		values, err = lastKnown, nil
+	}
+	if err != nil {
+		return errors.WithStack(err)
 	}
 
+	ar.values = values
 	ar.refreshTime = time.Now()
+	ar.source = config.source
+
+	logDebugf("Reloaded %d apc values from %s", len(ar.values), config.source)
 
 	return nil
 }
 
+// invalidates the apc values, so they are loaded by the next reload
+func (ar *ApcValues) invalidate() {
+	ar.source = nil
+}
+
+// returns whether the apc values were loaded from the source of the config within the poll interval and weren't
+// invalidated since
+func (ar *ApcValues) fresh(config *Config) bool {
+	return ar.source == config.source && time.Since(ar.refreshTime) < config.pollInterval &&
+		!config.state.invalidatedSince(ar.refreshTime)
+}
+
 // get retrieves the value by name, returns an empty string if the value was not found
 func (av *ApcValues) get(name string) string {
 	return av.values[name]
@@ -125,3 +158,100 @@ func (av *ApcValues) getOk(name string) (string, bool) {
 
 	return val, found
 }
+
+// MemoizedApcValues is an implementation of IApcValues that remembers the results of the VarLoaders wrapped by
+// MemoizedValue, so variables derived from the same values are evaluated once per request.
+type MemoizedApcValues struct {
+	IApcValues
+
+	results map[string]string
+}
+
+// newMemoizedApcValues creates a new instance of MemoizedApcValues
+func newMemoizedApcValues(apcValues IApcValues) *MemoizedApcValues {
+	return &MemoizedApcValues{IApcValues: apcValues, results: make(map[string]string)}
+}
+
+// memoize returns the remembered result for the given key, or loads and remembers it. Errors aren't remembered.
+func (mv *MemoizedApcValues) memoize(key string, load func() (string, error)) (string, error) {
+	if result, ok := mv.results[key]; ok {
+		return result, nil
+	}
+
+	result, err := load()
+	if err != nil {
+		return "", err
+	}
+	mv.results[key] = result
+
+	return result, nil
+}
+
+// SingleApcValues is an implementation of IApcValues that requests each value separately from the data source once it
+// is retrieved, so only the values that are used are loaded.
+type SingleApcValues struct {
+	ctx    context.Context
+	source SingleValueDataSource
+
+	// values loaded so far, nil if the value wasn't found
+	values map[string]*string
+
+	// first error that occurred while loading a value
+	err error
+}
+
+// newSingleApcValues creates a new instance of SingleApcValues if single value requests are enabled, supported by the
+// data source and the given values aren't fresh, otherwise it returns nil.
+func newSingleApcValues(ctx context.Context, config *Config, apcValues IApcValues) *SingleApcValues {
+	if !config.singleValueRequests || apcValues.fresh(config) {
+		return nil
+	}
+
+	source, ok := config.source.(SingleValueDataSource)
+	if !ok {
+		return nil
+	}
+
+	return &SingleApcValues{ctx: ctx, source: source, values: make(map[string]*string)}
+}
+
+// reload isn't needed, as the values are loaded once they are retrieved
+func (sv *SingleApcValues) reload(ctx context.Context, config *Config) error {
+	return nil
+}
+
+// invalidates the values loaded so far
+func (sv *SingleApcValues) invalidate() {
+	sv.values = make(map[string]*string)
+}
+
+// the values are loaded once they are retrieved, so they are always fresh
+func (sv *SingleApcValues) fresh(config *Config) bool {
+	return true
+}
+
+// get retrieves the value by name, returns an empty string if the value was not found
+func (sv *SingleApcValues) get(name string) string {
+	val, _ := sv.getOk(name)
+	return val
+}
+
+// getOk retrieves the value by name and loads it if needed, returns a false flag if the value was not found
+func (sv *SingleApcValues) getOk(name string) (string, bool) {
+	val, loaded := sv.values[name]
+	if !loaded {
+		value, found, err := sv.source.loadValue(sv.ctx, name)
+		if err != nil && sv.err == nil {
+			sv.err = err
+		}
+		if found {
+			val = &value
+		}
+		sv.values[name] = val
+	}
+
+	if val == nil {
+		return "", false
+	}
+	return *val, true
+}
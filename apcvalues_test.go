@@ -15,16 +15,30 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 )
 
 func testExecCommand(response string) execCmd {
-	return func(name string, args ...string) ([]byte, error) {
+	return func(ctx context.Context, name string, args ...string) ([]byte, error) {
 		return []byte(response), nil
 	}
 }
 
+func TestExecCommandWithEnv(t *testing.T) {
+	out, err := execCommandWithEnv([]string{"APCACCESS_TEST=foo"})(context.Background(), "sh", "-c",
+		"echo $APCACCESS_TEST")
+
+	assert.NoError(t, err)
+	assert.Equal(t, "foo\n", string(out))
+}
+
 func TestNewApcValues(t *testing.T) {
 	apcValues := NewApcValues()
 
@@ -35,15 +49,14 @@ func TestNewApcValues(t *testing.T) {
 
 func TestApcValue_reload(t *testing.T) {
 	apcValues := NewApcValues()
-	config := Config{}
 
 	output := `
  STATUS : ONLINE
  UPSNAME : name
 `
+	config := Config{source: &ExecDataSource{exec: testExecCommand(output)}}
 
-	apcValues.exec = testExecCommand(output)
-	err := apcValues.reload(&config)
+	err := apcValues.reload(context.Background(), &config)
 	assert.NoError(t, err)
 
 	assert.Len(t, apcValues.values, 2)
@@ -55,6 +68,160 @@ func TestApcValue_reload(t *testing.T) {
 	}
 }
 
+func TestApcValue_reload_Footer(t *testing.T) {
+	apcValues := NewApcValues()
+
+	output := `APC      : 001,036,0866
+DATE     : 2021-03-14 12:00:00 +0100
+STATUS   : ONLINE
+BCHARGE  : 100.0
+END APC  : 2021-03-14 12:00:05 +0100
+END APC
+`
+	config := Config{source: &ExecDataSource{exec: testExecCommand(output)}}
+
+	err := apcValues.reload(context.Background(), &config)
+	assert.NoError(t, err)
+
+	assert.Len(t, apcValues.values, 5)
+	assert.Equal(t, "2021-03-14 12:00:05 +0100", apcValues.values["END APC"])
+	assert.Equal(t, "001,036,0866", apcValues.values["APC"])
+	assert.Equal(t, "2021-03-14 12:00:00 +0100", apcValues.values["DATE"])
+	assert.Equal(t, "ONLINE", apcValues.values["STATUS"])
+	assert.Equal(t, "100.0", apcValues.values["BCHARGE"])
+}
+
+// slowExecCommand simulates an apcaccess invocation that doesn't finish before the context is done
+func slowExecCommand(ctx context.Context, name string, args ...string) ([]byte, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-time.After(10 * time.Second):
+		return []byte("STATUS : ONLINE"), nil
+	}
+}
+
+func TestApcValue_reload_Deadline(t *testing.T) {
+	apcValues := NewApcValues()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	err := apcValues.reload(ctx, &Config{source: &ExecDataSource{exec: slowExecCommand}})
+
+	assert.Error(t, err)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	assert.Less(t, int64(time.Since(start)), int64(time.Second))
+	assert.Empty(t, apcValues.values)
+}
+
+func TestApcValue_reload_NoSource(t *testing.T) {
+	apcValues := NewApcValues()
+
+	err := apcValues.reload(context.Background(), &Config{})
+
+	assert.EqualError(t, err, "No data source configured")
+}
+
+func TestApcValue_reload_Error(t *testing.T) {
+	apcValues := NewApcValues()
+	source := &mockDataSource{}
+	source.On("load", mock.Anything).Return(nil, errors.New("failure"))
+
+	config := &Config{source: source, state: NewUpsState()}
+
+	err := apcValues.reload(context.Background(), config)
+
+	assert.EqualError(t, err, "failure")
+	assert.Empty(t, apcValues.values)
+	source.AssertExpectations(t)
+	loaded, sourceError, _ := config.state.getSourceHealth()
+	assert.True(t, loaded)
+	assert.Equal(t, "failure", sourceError)
+}
+
+func TestApcValue_reload_PollInterval(t *testing.T) {
+	apcValues := NewApcValues()
+	source := &mockDataSource{}
+	source.On("load", mock.Anything).Return(map[string]string{"STATUS": "ONLINE"}, nil)
+	otherSource := &mockDataSource{}
+	otherSource.On("load", mock.Anything).Return(map[string]string{"STATUS": "ONBATT"}, nil).Once()
+	config := &Config{source: source, pollInterval: time.Minute}
+
+	// the second reload uses the values loaded before
+	assert.NoError(t, apcValues.reload(context.Background(), config))
+	assert.NoError(t, apcValues.reload(context.Background(), config))
+
+	// values of another UPS are always loaded
+	assert.NoError(t, apcValues.reload(context.Background(), &Config{source: otherSource, pollInterval: time.Minute}))
+	assert.Equal(t, "ONBATT", apcValues.get("STATUS"))
+
+	assert.NoError(t, apcValues.reload(context.Background(), config))
+	apcValues.invalidate()
+	assert.NoError(t, apcValues.reload(context.Background(), config))
+
+	source.AssertNumberOfCalls(t, "load", 3)
+	otherSource.AssertExpectations(t)
+}
+
+func TestNewSingleApcValues(t *testing.T) {
+	execSource := NewExecDataSource("apcaccess", "127.0.0.1")
+	freshValues := NewApcValues()
+	freshValues.source = execSource
+	freshValues.refreshTime = time.Now()
+
+	tests := []struct {
+		name      string
+		config    *Config
+		apcValues IApcValues
+		expNil    bool
+	}{
+		{"enabled", &Config{source: execSource, singleValueRequests: true}, NewApcValues(), false},
+		{"disabled", &Config{source: execSource}, NewApcValues(), true},
+		{"unsupported source", &Config{source: NewNisDataSource("127.0.0.1"), singleValueRequests: true},
+			NewApcValues(), true},
+		{"fresh values", &Config{source: execSource, singleValueRequests: true, pollInterval: time.Minute},
+			freshValues, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			singleValues := newSingleApcValues(context.Background(), test.config, test.apcValues)
+
+			assert.Equal(t, test.expNil, singleValues == nil)
+		})
+	}
+}
+
+func TestSingleApcValues_getOk(t *testing.T) {
+	var keys []string
+	source := NewExecDataSource("apcaccess", "127.0.0.1")
+	source.exec = func(ctx context.Context, name string, args ...string) ([]byte, error) {
+		keys = append(keys, args[3])
+		if args[3] == "STATUS" {
+			return []byte("ONLINE\n"), nil
+		}
+		return nil, nil
+	}
+	singleValues := newSingleApcValues(context.Background(), &Config{source: source, singleValueRequests: true},
+		NewApcValues())
+
+	value, ok := singleValues.getOk("STATUS")
+	assert.True(t, ok)
+	assert.Equal(t, "ONLINE", value)
+	assert.Equal(t, "ONLINE", singleValues.get("STATUS"))
+
+	value, ok = singleValues.getOk("BCHARGE")
+	assert.False(t, ok)
+	assert.Empty(t, value)
+	assert.Empty(t, singleValues.get("BCHARGE"))
+
+	// every value is requested only once
+	assert.Equal(t, []string{"STATUS", "BCHARGE"}, keys)
+	assert.NoError(t, singleValues.err)
+}
+
 func TestApcValue_get(t *testing.T) {
 	apcValues := ApcValues{
 		values: map[string]string{
@@ -84,3 +251,38 @@ func TestApcValue_getOk(t *testing.T) {
 	assert.Equal(t, "", result)
 	assert.False(t, found)
 }
+
+func TestApcValue_reload_File(t *testing.T) {
+	statusFile := filepath.Join(t.TempDir(), "apcupsd.status")
+	content := `APC      : 001,036,0866
+STATUS   : ONLINE
+BCHARGE  : 100.0 Percent
+TIMELEFT : 42.5 Minutes
+END APC  : 2021-03-14 12:00:05 +0100
+`
+	if !assert.NoError(t, os.WriteFile(statusFile, []byte(content), 0600)) {
+		return
+	}
+	apcValues := NewApcValues()
+
+	err := apcValues.reload(context.Background(), &Config{source: NewFileDataSource(statusFile)})
+
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"APC":      "001,036,0866",
+		"STATUS":   "ONLINE",
+		"BCHARGE":  "100.0",
+		"TIMELEFT": "42.5",
+		"END APC":  "2021-03-14 12:00:05 +0100",
+	}, apcValues.values)
+}
+
+func TestApcValue_reload_MissingFile(t *testing.T) {
+	apcValues := NewApcValues()
+
+	err := apcValues.reload(context.Background(), &Config{source: NewFileDataSource("does-not-exist")})
+
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "Error reading status file does-not-exist")
+	}
+}
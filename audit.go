@@ -0,0 +1,99 @@
+// Copyright [2021] [Christian Bandowski]
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// target of the audit log that writes to the local syslog daemon instead of a file
+const auditLogSyslog = "syslog"
+
+// commands that change the state of the UPS or act on behalf of a user, they are recorded in the audit log
+var auditedCommands = map[commandRoute]bool{
+	{"LOGIN", ""}:   true,
+	{"FSD", ""}:     true,
+	{"INSTCMD", ""}: true,
+	{"SET", "VAR"}:  true,
+}
+
+// AuditLog records the audited commands of all clients with their user, address and result, one line per command:
+//
+//	2021-06-01T12:00:00Z remote=192.168.0.1 user="admin" command="INSTCMD ups beeper.mute" result="OK"
+//
+// It is shared by all connections and safe for concurrent use.
+type AuditLog struct {
+	mutex sync.Mutex
+
+	writer io.Writer
+
+	// returns the current time, replaceable for tests
+	now func() time.Time
+}
+
+// NewAuditLog creates a new instance of AuditLog
+func NewAuditLog(writer io.Writer) *AuditLog {
+	return &AuditLog{writer: writer, now: time.Now}
+}
+
+// loadAuditLog opens the configured audit log, either a file the records are appended to or the syslog.
+func (c *Config) loadAuditLog() error {
+	switch c.auditLogTarget {
+	case "":
+		return nil
+	case auditLogSyslog:
+		writer, err := newSyslogWriter()
+		if err != nil {
+			return errors.Wrap(err, "Couldn't connect to syslog for the audit log")
+		}
+		c.auditLog = NewAuditLog(writer)
+	default:
+		file, err := os.OpenFile(c.auditLogTarget, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+		if err != nil {
+			return errors.Wrapf(err, "Couldn't open audit log %s", c.auditLogTarget)
+		}
+		c.auditLog = NewAuditLog(file)
+	}
+
+	return nil
+}
+
+// record records a command of the client and its result. Commands that failed with an error are recorded with the
+// error instead of the response. A nil audit log doesn't record anything.
+func (a *AuditLog) record(session *Session, command string, response string, err error) {
+	if a == nil {
+		return
+	}
+
+	result := strings.TrimSpace(response)
+	if err != nil && result == "" {
+		result = "ERR " + err.Error()
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	line := fmt.Sprintf("%s remote=%s user=%q command=%q result=%q\n", a.now().UTC().Format(time.RFC3339),
+		session.remoteAddr, session.identity(), command, result)
+	if _, err := io.WriteString(a.writer, line); err != nil {
+		logErrorf("Writing the audit log failed: %+v", errors.WithStack(err))
+	}
+}
@@ -0,0 +1,98 @@
+// Copyright [2021] [Christian Bandowski]
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestAuditLog(out *bytes.Buffer) *AuditLog {
+	auditLog := NewAuditLog(out)
+	auditLog.now = func() time.Time {
+		return time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	}
+
+	return auditLog
+}
+
+func TestAuditLog_record(t *testing.T) {
+	var out bytes.Buffer
+	auditLog := newTestAuditLog(&out)
+	session := newAuthenticatedSession("192.168.0.1", NewSessionRegistry())
+
+	auditLog.record(session, "INSTCMD ups beeper.mute", "OK\n", nil)
+	auditLog.record(session, "FSD ups", "", errors.New("failed"))
+
+	assert.Equal(t,
+		"2021-06-01T12:00:00Z remote=192.168.0.1 user=\"user\" command=\"INSTCMD ups beeper.mute\" result=\"OK\"\n"+
+			"2021-06-01T12:00:00Z remote=192.168.0.1 user=\"user\" command=\"FSD ups\" result=\"ERR failed\"\n",
+		out.String())
+}
+
+func TestAuditLog_Nil(t *testing.T) {
+	var auditLog *AuditLog
+
+	auditLog.record(NewSession("192.168.0.1", NewSessionRegistry()), "FSD ups", "OK", nil)
+}
+
+func TestConfig_loadAuditLog(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "audit.log")
+	config := &Config{auditLogTarget: file}
+
+	if !assert.NoError(t, config.loadAuditLog()) || !assert.NotNil(t, config.auditLog) {
+		return
+	}
+	config.auditLog.record(NewSession("192.168.0.1", NewSessionRegistry()), "FSD ups", "OK", nil)
+
+	content, err := os.ReadFile(file)
+	assert.NoError(t, err)
+	assert.Contains(t, string(content), "remote=192.168.0.1 user=\"\" command=\"FSD ups\" result=\"OK\"\n")
+}
+
+func TestConfig_loadAuditLog_Disabled(t *testing.T) {
+	config := &Config{}
+
+	assert.NoError(t, config.loadAuditLog())
+	assert.Nil(t, config.auditLog)
+}
+
+func TestCommandReceived_AuditLog(t *testing.T) {
+	var out bytes.Buffer
+	config := &Config{
+		upsName:  "test",
+		auditLog: newTestAuditLog(&out),
+		users: map[string]*User{
+			"user": {name: "user", password: "password", actions: map[string]bool{"LOGIN": true}},
+		},
+	}
+
+	session := newAuthenticatedSession("192.168.0.1", NewSessionRegistry())
+	for _, command := range []string{"LIST UPS", "LOGIN test", "FSD test"} {
+		_, _, err := commandReceived(context.Background(), command, config, session, &mockApcValues{})
+		assert.NoError(t, err)
+	}
+
+	assert.Equal(t,
+		"2021-06-01T12:00:00Z remote=192.168.0.1 user=\"user\" command=\"LOGIN test\" result=\"OK\"\n"+
+			"2021-06-01T12:00:00Z remote=192.168.0.1 user=\"user\" command=\"FSD test\" result=\"ERR ACCESS-DENIED\"\n",
+		out.String())
+}
@@ -0,0 +1,178 @@
+// Copyright [2021] [Christian Bandowski]
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// upper bound of the delay after a failed authentication
+const maxAuthFailureDelay = 30 * time.Second
+
+// time after which the failures of an address are forgotten, unless it is still banned
+const authFailureWindow = 15 * time.Minute
+
+// AuthGuard protects against brute-forcing credentials. It tracks failed authentications per client address, delays
+// the responses exponentially and bans addresses temporarily once they failed too often.
+// It is shared by all connections and safe for concurrent use.
+type AuthGuard struct {
+	mutex sync.Mutex
+
+	// number of failures in a row after which an address is banned, 0 disables banning
+	threshold int
+	// duration of a ban
+	banDuration time.Duration
+	// delay after the first failure, doubled with each further failure
+	delay time.Duration
+
+	// failures by client address
+	failures map[string]*authFailures
+	// time at which the expired failures are removed next
+	nextPrune time.Time
+
+	// returns the current time, replaceable for tests
+	now func() time.Time
+}
+
+// authFailures contains the failed authentications of a single client address.
+type authFailures struct {
+	count       int
+	lastFailure time.Time
+	bannedUntil time.Time
+}
+
+// expired checks whether the failures are outside of the failure window and the address isn't banned anymore.
+func (f *authFailures) expired(now time.Time) bool {
+	return !now.Before(f.lastFailure.Add(authFailureWindow)) && !now.Before(f.bannedUntil)
+}
+
+// NewAuthGuard creates a new instance of AuthGuard
+func NewAuthGuard(threshold int, banDuration time.Duration, delay time.Duration) *AuthGuard {
+	return &AuthGuard{
+		threshold:   threshold,
+		banDuration: banDuration,
+		delay:       delay,
+		failures:    make(map[string]*authFailures),
+		now:         time.Now,
+	}
+}
+
+// recordFailure records a failed authentication of the given address and returns how long the response should be
+// delayed. The address is banned once it reached the threshold.
+func (g *AuthGuard) recordFailure(address string) time.Duration {
+	if g == nil {
+		return 0
+	}
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	now := g.now()
+	g.prune(now)
+
+	failures, ok := g.failures[address]
+	if !ok || failures.expired(now) {
+		failures = &authFailures{}
+		g.failures[address] = failures
+	}
+	failures.count++
+	failures.lastFailure = now
+	metricAuthFailures.Add(1)
+
+	if g.threshold > 0 && failures.count >= g.threshold {
+		failures.bannedUntil = now.Add(g.banDuration)
+		failures.count = 0
+		metricAuthBans.Add(1)
+		logWarnf("Banned client %s for %s after %d failed authentications", address, g.banDuration, g.threshold)
+	}
+
+	delay := g.delay
+	for i := 1; i < failures.count && delay < maxAuthFailureDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxAuthFailureDelay {
+		delay = maxAuthFailureDelay
+	}
+
+	return delay
+}
+
+// prune removes the expired failures once per failure window, so addresses that failed only a few times don't
+// accumulate. The mutex has to be held.
+func (g *AuthGuard) prune(now time.Time) {
+	if now.Before(g.nextPrune) {
+		return
+	}
+	g.nextPrune = now.Add(authFailureWindow)
+
+	for address, failures := range g.failures {
+		if failures.expired(now) {
+			delete(g.failures, address)
+		}
+	}
+}
+
+// recordSuccess forgets the failed authentications of the given address.
+func (g *AuthGuard) recordSuccess(address string) {
+	if g == nil {
+		return
+	}
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	if failures, ok := g.failures[address]; ok && !g.now().Before(failures.bannedUntil) {
+		delete(g.failures, address)
+	}
+}
+
+// isBanned checks whether the given address is banned currently, nothing is banned by a nil guard.
+func (g *AuthGuard) isBanned(address string) bool {
+	if g == nil {
+		return false
+	}
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	failures, ok := g.failures[address]
+	return ok && g.now().Before(failures.bannedUntil)
+}
+
+// waitDelay waits for the given delay, but not longer than the context allows.
+func waitDelay(ctx context.Context, delay time.Duration) {
+	if delay <= 0 {
+		return
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+}
+
+// logAuthFailure logs a failed authentication in a stable single line format, so tools like fail2ban can match it:
+//
+//	WARN Authentication failure: remote=192.168.0.1 user="monuser" reason=invalid-credentials
+//
+// The user name is quoted, as it is sent by the client and could otherwise be used to forge log lines.
+func logAuthFailure(remoteAddr string, username string, reason string) {
+	logWarnf("Authentication failure: remote=%s user=%q reason=%s", remoteAddr, username, reason)
+}
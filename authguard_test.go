@@ -0,0 +1,132 @@
+// Copyright [2021] [Christian Bandowski]
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"github.com/stretchr/testify/assert"
+	"log"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestAuthGuard_Delay(t *testing.T) {
+	guard := NewAuthGuard(0, time.Minute, time.Second)
+
+	assert.Equal(t, time.Second, guard.recordFailure("192.168.0.1"))
+	assert.Equal(t, 2*time.Second, guard.recordFailure("192.168.0.1"))
+	assert.Equal(t, 4*time.Second, guard.recordFailure("192.168.0.1"))
+	assert.Equal(t, time.Second, guard.recordFailure("192.168.0.2"))
+
+	for i := 0; i < 10; i++ {
+		guard.recordFailure("192.168.0.1")
+	}
+	assert.Equal(t, maxAuthFailureDelay, guard.recordFailure("192.168.0.1"))
+	assert.False(t, guard.isBanned("192.168.0.1"))
+
+	guard.recordSuccess("192.168.0.1")
+	assert.Equal(t, time.Second, guard.recordFailure("192.168.0.1"))
+}
+
+func TestAuthGuard_Ban(t *testing.T) {
+	now := time.Now()
+	guard := NewAuthGuard(3, time.Minute, 0)
+	guard.now = func() time.Time { return now }
+
+	guard.recordFailure("192.168.0.1")
+	guard.recordFailure("192.168.0.1")
+	assert.False(t, guard.isBanned("192.168.0.1"))
+
+	guard.recordFailure("192.168.0.1")
+	assert.True(t, guard.isBanned("192.168.0.1"))
+	assert.False(t, guard.isBanned("192.168.0.2"))
+
+	// a successful authentication doesn't lift the ban
+	guard.recordSuccess("192.168.0.1")
+	assert.True(t, guard.isBanned("192.168.0.1"))
+
+	now = now.Add(time.Minute)
+	assert.False(t, guard.isBanned("192.168.0.1"))
+}
+
+func TestAuthGuard_Prune(t *testing.T) {
+	now := time.Now()
+	guard := NewAuthGuard(3, time.Hour, 0)
+	guard.now = func() time.Time { return now }
+
+	guard.recordFailure("192.168.0.1")
+	for i := 0; i < 3; i++ {
+		guard.recordFailure("192.168.0.2")
+	}
+	assert.Len(t, guard.failures, 2)
+
+	// the failures of the first address expired, the second one is still banned
+	now = now.Add(authFailureWindow)
+	guard.recordFailure("192.168.0.3")
+	assert.Len(t, guard.failures, 2)
+	assert.NotContains(t, guard.failures, "192.168.0.1")
+	assert.True(t, guard.isBanned("192.168.0.2"))
+
+	// the ban ended
+	now = now.Add(time.Hour)
+	guard.recordFailure("192.168.0.3")
+	assert.Equal(t, map[string]*authFailures{"192.168.0.3": {count: 1, lastFailure: now}}, guard.failures)
+}
+
+func TestAuthGuard_ExpiredFailures(t *testing.T) {
+	now := time.Now()
+	guard := NewAuthGuard(3, time.Minute, 0)
+	guard.now = func() time.Time { return now }
+
+	guard.recordFailure("192.168.0.1")
+	guard.recordFailure("192.168.0.1")
+	now = now.Add(authFailureWindow)
+	guard.recordFailure("192.168.0.1")
+
+	// the failures before the window aren't counted anymore
+	assert.False(t, guard.isBanned("192.168.0.1"))
+}
+
+func TestAuthGuard_Nil(t *testing.T) {
+	var guard *AuthGuard
+
+	assert.Equal(t, time.Duration(0), guard.recordFailure("192.168.0.1"))
+	guard.recordSuccess("192.168.0.1")
+	assert.False(t, guard.isBanned("192.168.0.1"))
+}
+
+func TestWaitDelay_Cancelled(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	waitDelay(ctx, time.Minute)
+
+	assert.Less(t, int64(time.Since(start)), int64(time.Second))
+}
+
+func TestLogAuthFailure(t *testing.T) {
+	var out bytes.Buffer
+	log.SetOutput(&out)
+	defer log.SetOutput(os.Stderr)
+
+	logAuthFailure("192.168.0.1", "mon\nuser", "invalid-credentials")
+
+	assert.Contains(t, out.String(),
+		"WARN Authentication failure: remote=192.168.0.1 user=\"mon\\nuser\" reason=invalid-credentials\n")
+	assert.Equal(t, 1, bytes.Count(out.Bytes(), []byte("\n")))
+}
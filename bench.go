@@ -0,0 +1,295 @@
+// Copyright [2021] [Christian Bandowski]
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"github.com/pkg/errors"
+	"io"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// BenchConfig is the configuration of the bench subcommand, which simulates upsmon clients polling the proxy.
+type BenchConfig struct {
+	address      string
+	upsName      string
+	user         string
+	password     string
+	passwordFile string
+
+	clients  int
+	interval time.Duration
+	duration time.Duration
+	timeout  time.Duration
+}
+
+// BenchResult contains the latencies of the successful requests and the number of failed ones.
+type BenchResult struct {
+	latencies []time.Duration
+	failures  int
+	elapsed   time.Duration
+}
+
+// parseBenchArgs parses the arguments of the bench subcommand.
+func parseBenchArgs(args []string, output io.Writer) (*BenchConfig, error) {
+	c := &BenchConfig{}
+
+	flags := flag.NewFlagSet("bench", flag.ContinueOnError)
+	flags.SetOutput(output)
+	flags.StringVar(&c.address, "address", "127.0.0.1:3493",
+		"Address of the proxy")
+	flags.StringVar(&c.upsName, "ups", "ups",
+		"Name of the UPS whose status is polled")
+	flags.StringVar(&c.user, "user", "",
+		"User the clients log in with like upsmon, they don't log in if it is empty")
+	addPasswordFlags(flags, c)
+	flags.IntVar(&c.clients, "clients", 10,
+		"Number of simulated clients, each using its own connection")
+	flags.DurationVar(&c.interval, "interval", 5*time.Second,
+		"Interval in which every client polls the status, upsmon uses 5s by default")
+	flags.DurationVar(&c.duration, "duration", 30*time.Second,
+		"Duration of the benchmark")
+	flags.DurationVar(&c.timeout, "timeout", 5*time.Second,
+		"Timeout of a single request")
+
+	if err := flags.Parse(args); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if err := c.loadPassword(); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if c.clients <= 0 {
+		return nil, errors.Errorf("Invalid number of clients %d, must be positive", c.clients)
+	}
+	if c.interval <= 0 || c.duration <= 0 || c.timeout <= 0 {
+		return nil, errors.New("The interval, the duration and the timeout must be positive")
+	}
+
+	return c, nil
+}
+
+// addPasswordFlags adds the flags setting the password of the user.
+func addPasswordFlags(flags *flag.FlagSet, c *BenchConfig) {
+	flags.StringVar(&c.password, "password", "",
+		"Password of the user, prefer -password-file as the command line is visible to all users of the host")
+	flags.StringVar(&c.passwordFile, "password-file", "",
+		"File containing the password of the user")
+}
+
+// loadPassword reads the password from the password file, if any.
+func (c *BenchConfig) loadPassword() error {
+	if c.passwordFile == "" {
+		return nil
+	}
+	if c.password != "" {
+		return errors.New("Only one of -password and -password-file may be set")
+	}
+
+	password, err := readSecretFile(c.passwordFile)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	c.password = password
+
+	return nil
+}
+
+// runBench runs the bench subcommand with the given arguments and writes the report to the output.
+func runBench(args []string, output io.Writer) error {
+	config, err := parseBenchArgs(args, output)
+	if errors.Is(err, flag.ErrHelp) {
+		return nil
+	} else if err != nil {
+		return errors.Wrap(err, "Benchmark failed")
+	}
+
+	fmt.Fprintf(output, "Polling UPS %s on %s with %d clients every %s for %s\n", config.upsName, config.address,
+		config.clients, config.interval, config.duration)
+
+	result := bench(config)
+	fmt.Fprint(output, result.report())
+
+	return nil
+}
+
+// bench runs the simulated clients for the configured duration and collects their results.
+func bench(config *BenchConfig) *BenchResult {
+	ctx, cancel := context.WithTimeout(context.Background(), config.duration)
+	defer cancel()
+
+	var mutex sync.Mutex
+	result := &BenchResult{}
+	record := func(latency time.Duration, err error) {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		if err != nil {
+			logDebugf("Benchmark request failed: %v", err)
+			result.failures++
+			return
+		}
+		result.latencies = append(result.latencies, latency)
+	}
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < config.clients; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			// spread the clients over the interval like independent upsmon instances
+			delay := config.interval * time.Duration(i) / time.Duration(config.clients)
+			benchClient(ctx, config, delay, record)
+		}(i)
+	}
+	wg.Wait()
+	result.elapsed = time.Since(start)
+
+	return result
+}
+
+// benchClient polls the status like upsmon after the initial delay until the context is done. It reconnects after
+// failures.
+func benchClient(ctx context.Context, config *BenchConfig, delay time.Duration,
+	record func(time.Duration, error)) {
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	var client *benchConnection
+	defer func() {
+		if client != nil {
+			client.Close()
+		}
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+		timer.Reset(config.interval)
+
+		if client == nil {
+			var err error
+			if client, err = dialBench(config); err != nil {
+				record(0, err)
+				continue
+			}
+		}
+
+		start := time.Now()
+		_, err := client.request(fmt.Sprintf("GET VAR %s ups.status", formatArg(config.upsName)), "VAR ")
+		if err != nil && ctx.Err() == nil {
+			record(0, err)
+			client.Close()
+			client = nil
+			continue
+		}
+		if err == nil {
+			record(time.Since(start), nil)
+		}
+	}
+}
+
+// benchConnection is the connection of a simulated client.
+type benchConnection struct {
+	net.Conn
+
+	reader  *bufio.Reader
+	timeout time.Duration
+}
+
+// dialBench connects to the proxy and logs in like upsmon, if a user is configured.
+func dialBench(config *BenchConfig) (*benchConnection, error) {
+	c, err := net.DialTimeout("tcp", config.address, config.timeout)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Couldn't connect to %s", config.address)
+	}
+	client := &benchConnection{Conn: c, reader: bufio.NewReader(c), timeout: config.timeout}
+
+	if config.user != "" {
+		commands := []string{
+			"USERNAME " + formatArg(config.user),
+			"PASSWORD " + formatArg(config.password),
+			"LOGIN " + formatArg(config.upsName),
+		}
+		for _, command := range commands {
+			if _, err := client.request(command, "OK"); err != nil {
+				client.Close()
+				return nil, err
+			}
+		}
+	}
+
+	return client, nil
+}
+
+// request sends the command and returns the response, which has to start with the expected prefix.
+func (c *benchConnection) request(command string, expPrefix string) (string, error) {
+	if err := c.SetDeadline(time.Now().Add(c.timeout)); err != nil {
+		return "", errors.WithStack(err)
+	}
+	if _, err := c.Write([]byte(command + "\n")); err != nil {
+		return "", errors.Wrapf(err, "Couldn't send %s", strings.Fields(command)[0])
+	}
+
+	response, err := c.reader.ReadString('\n')
+	if err != nil {
+		return "", errors.Wrapf(err, "Couldn't read the response to %s", strings.Fields(command)[0])
+	}
+	response = strings.TrimSpace(response)
+	if !strings.HasPrefix(response, expPrefix) {
+		return "", errors.Errorf("Unexpected response to %s: %s", strings.Fields(command)[0], response)
+	}
+
+	return response, nil
+}
+
+// report returns the number of requests and the percentiles of their latencies.
+func (r *BenchResult) report() string {
+	latencies := append([]time.Duration(nil), r.latencies...)
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("Requests: %d succeeded, %d failed in %s\n", len(latencies), r.failures,
+		r.elapsed.Round(time.Millisecond)))
+	if len(latencies) == 0 {
+		return sb.String()
+	}
+
+	sb.WriteString(fmt.Sprintf("Latency: p50=%s p90=%s p99=%s max=%s\n", percentile(latencies, 50),
+		percentile(latencies, 90), percentile(latencies, 99), latencies[len(latencies)-1]))
+
+	return sb.String()
+}
+
+// percentile returns the given percentile of the sorted latencies, using the nearest rank.
+func percentile(sorted []time.Duration, p int) time.Duration {
+	rank := (p*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+
+	return sorted[rank-1]
+}
@@ -0,0 +1,106 @@
+// Copyright [2021] [Christian Bandowski]
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunBench(t *testing.T) {
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	config := &Config{upsName: "ups", timeout: 10 * time.Second, maxLineLength: 1024, vars: defaultVars(),
+		source: &countingDataSource{}, state: NewUpsState()}
+	go serve(l, &Listener{}, config, NewSessionRegistry())
+
+	var output bytes.Buffer
+	err = runBench([]string{"-address", l.Addr().String(), "-clients", "2", "-interval", "20ms",
+		"-duration", "200ms"}, &output)
+
+	assert.NoError(t, err)
+	lines := strings.Split(output.String(), "\n")
+	if assert.Len(t, lines, 4) {
+		assert.Equal(t, "Polling UPS ups on "+l.Addr().String()+" with 2 clients every 20ms for 200ms", lines[0])
+		assert.Contains(t, lines[1], " succeeded, 0 failed in ")
+		assert.True(t, strings.HasPrefix(lines[2], "Latency: p50="))
+	}
+}
+
+func TestRunBench_Unreachable(t *testing.T) {
+	var output bytes.Buffer
+	err := runBench([]string{"-address", "127.0.0.1:1", "-clients", "1", "-interval", "20ms", "-duration", "50ms"},
+		&output)
+
+	assert.NoError(t, err)
+	assert.Contains(t, output.String(), "Requests: 0 succeeded, ")
+	assert.NotContains(t, output.String(), "Latency")
+}
+
+func TestParseBenchArgs_Invalid(t *testing.T) {
+	var output bytes.Buffer
+
+	_, err := parseBenchArgs([]string{"-clients", "0"}, &output)
+	assert.EqualError(t, err, "Invalid number of clients 0, must be positive")
+	_, err = parseBenchArgs([]string{"-interval", "0s"}, &output)
+	assert.EqualError(t, err, "The interval, the duration and the timeout must be positive")
+	_, err = parseBenchArgs([]string{"-unknown"}, &output)
+	assert.Error(t, err)
+}
+
+func TestParseBenchArgs_PasswordFile(t *testing.T) {
+	var output bytes.Buffer
+	passwordFile := filepath.Join(t.TempDir(), "password")
+	assert.NoError(t, os.WriteFile(passwordFile, []byte("secret\n"), 0600))
+
+	config, err := parseBenchArgs([]string{"-user", "monuser", "-password-file", passwordFile}, &output)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "secret", config.password)
+	}
+
+	_, err = parseBenchArgs([]string{"-password", "other", "-password-file", passwordFile}, &output)
+	assert.EqualError(t, err, "Only one of -password and -password-file may be set")
+	_, err = parseBenchArgs([]string{"-password-file", filepath.Join(t.TempDir(), "missing")}, &output)
+	assert.Error(t, err)
+}
+
+func TestPercentile(t *testing.T) {
+	latencies := make([]time.Duration, 100)
+	for i := range latencies {
+		latencies[i] = time.Duration(i+1) * time.Millisecond
+	}
+
+	assert.Equal(t, 50*time.Millisecond, percentile(latencies, 50))
+	assert.Equal(t, 99*time.Millisecond, percentile(latencies, 99))
+	assert.Equal(t, time.Millisecond, percentile(latencies[:2], 50))
+	assert.Equal(t, time.Millisecond, percentile(latencies[:1], 1))
+}
+
+func TestBenchResult_report(t *testing.T) {
+	result := &BenchResult{latencies: []time.Duration{3 * time.Millisecond, time.Millisecond, 2 * time.Millisecond},
+		failures: 1, elapsed: time.Second}
+
+	assert.Equal(t, "Requests: 3 succeeded, 1 failed in 1s\nLatency: p50=2ms p90=3ms p99=3ms max=3ms\n",
+		result.report())
+}
@@ -0,0 +1,82 @@
+// Copyright [2021] [Christian Bandowski]
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"sync"
+)
+
+const (
+	// estimated size of a line of a list response, used to preallocate the buffer
+	listLineSize = 64
+	// buffers that grew larger aren't returned to the pool, so a single huge response isn't kept in memory forever
+	maxPooledBufferSize = 64 * 1024
+)
+
+// pools of buffers reused by all connections, to reduce the garbage created by many concurrent clients
+var (
+	responseBufferPool = sync.Pool{New: func() interface{} { return new(bytes.Buffer) }}
+	readerPool         = sync.Pool{New: func() interface{} { return bufio.NewReader(nil) }}
+	writerPool         = sync.Pool{New: func() interface{} { return bufio.NewWriter(nil) }}
+)
+
+// getResponseBuffer returns an empty buffer from the pool with room for the given number of lines.
+func getResponseBuffer(lines int) *bytes.Buffer {
+	buffer := responseBufferPool.Get().(*bytes.Buffer)
+	buffer.Reset()
+	buffer.Grow(lines * listLineSize)
+
+	return buffer
+}
+
+// putResponseBuffer returns the buffer to the pool, it must not be used afterwards.
+func putResponseBuffer(buffer *bytes.Buffer) {
+	if buffer.Cap() > maxPooledBufferSize {
+		return
+	}
+
+	responseBufferPool.Put(buffer)
+}
+
+// getReader returns a buffered reader from the pool reading from the given reader.
+func getReader(r io.Reader) *bufio.Reader {
+	reader := readerPool.Get().(*bufio.Reader)
+	reader.Reset(r)
+
+	return reader
+}
+
+// putReader returns the buffered reader to the pool, it must not be used afterwards.
+func putReader(reader *bufio.Reader) {
+	reader.Reset(nil)
+	readerPool.Put(reader)
+}
+
+// getWriter returns a buffered writer from the pool writing to the given writer.
+func getWriter(w io.Writer) *bufio.Writer {
+	writer := writerPool.Get().(*bufio.Writer)
+	writer.Reset(w)
+
+	return writer
+}
+
+// putWriter returns the buffered writer to the pool, it must not be used afterwards.
+func putWriter(writer *bufio.Writer) {
+	writer.Reset(nil)
+	writerPool.Put(writer)
+}
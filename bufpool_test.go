@@ -0,0 +1,61 @@
+// Copyright [2021] [Christian Bandowski]
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func TestGetResponseBuffer(t *testing.T) {
+	buffer := getResponseBuffer(10)
+	buffer.WriteString("BEGIN LIST VAR ups\n")
+	putResponseBuffer(buffer)
+
+	buffer = getResponseBuffer(10)
+	defer putResponseBuffer(buffer)
+
+	// reused buffers are empty
+	assert.Equal(t, 0, buffer.Len())
+	assert.GreaterOrEqual(t, buffer.Cap(), 10*listLineSize)
+}
+
+func TestGetReader(t *testing.T) {
+	reader := getReader(strings.NewReader("VER\n"))
+	line, err := reader.ReadString('\n')
+	assert.NoError(t, err)
+	assert.Equal(t, "VER\n", line)
+	putReader(reader)
+
+	reader = getReader(strings.NewReader("LIST UPS\n"))
+	defer putReader(reader)
+	line, err = reader.ReadString('\n')
+	assert.NoError(t, err)
+	assert.Equal(t, "LIST UPS\n", line)
+}
+
+func TestGetWriter(t *testing.T) {
+	var out bytes.Buffer
+	writer := getWriter(&out)
+	defer putWriter(writer)
+
+	_, err := writer.WriteString("OK\n")
+	assert.NoError(t, err)
+	assert.NoError(t, writer.Flush())
+
+	assert.Equal(t, "OK\n", out.String())
+}
@@ -0,0 +1,167 @@
+// Copyright [2021] [Christian Bandowski]
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// CachingDataSource shares the values loaded from another source between all connections for the TTL, so several
+// clients polling the UPS don't multiply the load on apcupsd. Concurrent loads wait for the one in progress.
+//
+// If a maximum staleness is set, expired values are still returned up to that age, while they are refreshed in the
+// background.
+type CachingDataSource struct {
+	source DataSource
+	ttl    time.Duration
+
+	// age up to which expired values are returned, zero if they are never returned
+	maxStaleness time.Duration
+
+	// timeout of the refresh in the background
+	refreshTimeout time.Duration
+
+	mutex sync.Mutex
+
+	// whether the values are refreshed in the background
+	refreshing bool
+
+	// values loaded the last time, nil if they have to be loaded, they must not be modified
+	values   map[string]string
+	loadTime time.Time
+
+	// returns the current time, replaced by tests
+	now func() time.Time
+}
+
+// NewCachingDataSource creates a new instance of CachingDataSource
+func NewCachingDataSource(source DataSource, ttl time.Duration) *CachingDataSource {
+	return &CachingDataSource{source: source, ttl: ttl, now: time.Now}
+}
+
+// load returns the cached values, unless they are older than the TTL or the maximum staleness. Errors aren't cached.
+func (s *CachingDataSource) load(ctx context.Context) (map[string]string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.values != nil {
+		age := s.now().Sub(s.loadTime)
+		if age < s.ttl {
+			return s.values, nil
+		}
+		if age < s.maxStaleness {
+			s.refreshInBackground()
+			return s.values, nil
+		}
+	}
+
+	values, err := s.source.load(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	s.values = values
+	s.loadTime = s.now()
+	return values, nil
+}
+
+// refreshInBackground loads the values without blocking the loads meanwhile, unless they are refreshed already. The
+// caller must hold the lock.
+func (s *CachingDataSource) refreshInBackground() {
+	if s.refreshing {
+		return
+	}
+	s.refreshing = true
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), s.refreshTimeout)
+		defer cancel()
+
+		values, err := s.source.load(ctx)
+		if err != nil {
+			logWarnf("Refreshing the values from %s failed: %v", s.source, err)
+		}
+
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+
+		s.refreshing = false
+		if err == nil {
+			s.values = values
+			s.loadTime = s.now()
+		}
+	}()
+}
+
+// refresh loads the values from the source, regardless of their age.
+func (s *CachingDataSource) refresh(ctx context.Context) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	values, err := s.source.load(ctx)
+	if err != nil {
+		return err
+	}
+
+	s.values = values
+	s.loadTime = s.now()
+	return nil
+}
+
+// invalidate drops the cached values, so they are loaded by the next load.
+func (s *CachingDataSource) invalidate() {
+	s.mutex.Lock()
+	s.values = nil
+	s.mutex.Unlock()
+
+	invalidateSource(s.source)
+}
+
+// String returns the cached source.
+func (s *CachingDataSource) String() string {
+	return fmt.Sprintf("%s (cached for %s)", s.source, s.ttl)
+}
+
+// cachingSource returns the cache of the values shared by all connections, if the source has one.
+func cachingSource(source DataSource) (*CachingDataSource, bool) {
+	if lastKnown, ok := source.(*LastKnownDataSource); ok {
+		source = lastKnown.source
+	}
+	cache, ok := source.(*CachingDataSource)
+
+	return cache, ok
+}
+
+// An InvalidatableDataSource keeps the values it loaded, they can be dropped so they are loaded again.
+type InvalidatableDataSource interface {
+	// invalidate drops the values kept by the source and the sources it wraps.
+	invalidate()
+}
+
+// invalidateSource drops the values kept by the source, if it keeps any.
+func invalidateSource(source DataSource) {
+	if invalidatable, ok := source.(InvalidatableDataSource); ok {
+		invalidatable.invalidate()
+	}
+}
+
+// invalidateCache drops the values cached for all connections, e.g. because they were changed.
+func (c *Config) invalidateCache() {
+	invalidateSource(c.source)
+	c.state.invalidateValues()
+}
@@ -0,0 +1,199 @@
+// Copyright [2021] [Christian Bandowski]
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCachingDataSource_load(t *testing.T) {
+	source := &mockDataSource{}
+	source.On("load", mock.Anything).Return(map[string]string{"STATUS": "ONLINE"}, nil)
+	cache := NewCachingDataSource(source, time.Minute)
+	now := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	cache.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		values, err := cache.load(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]string{"STATUS": "ONLINE"}, values)
+	}
+	source.AssertNumberOfCalls(t, "load", 1)
+
+	now = now.Add(time.Minute)
+	_, err := cache.load(context.Background())
+	assert.NoError(t, err)
+	source.AssertNumberOfCalls(t, "load", 2)
+
+	cache.invalidate()
+	_, err = cache.load(context.Background())
+	assert.NoError(t, err)
+	source.AssertNumberOfCalls(t, "load", 3)
+}
+
+func TestCachingDataSource_load_Error(t *testing.T) {
+	source := &mockDataSource{}
+	source.On("load", mock.Anything).Return(nil, errors.New("unreachable")).Once()
+	source.On("load", mock.Anything).Return(map[string]string{"STATUS": "ONLINE"}, nil)
+	cache := NewCachingDataSource(source, time.Minute)
+
+	_, err := cache.load(context.Background())
+	assert.EqualError(t, err, "unreachable")
+
+	// errors aren't cached
+	values, err := cache.load(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"STATUS": "ONLINE"}, values)
+}
+
+func TestCachingDataSource_load_Concurrent(t *testing.T) {
+	source := &mockDataSource{}
+	source.On("load", mock.Anything).Return(map[string]string{"STATUS": "ONLINE"}, nil).
+		After(10 * time.Millisecond)
+	cache := NewCachingDataSource(source, time.Minute)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := cache.load(context.Background())
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+
+	// the connections wait for the load in progress
+	source.AssertNumberOfCalls(t, "load", 1)
+}
+
+func TestCachingDataSource_load_Stale(t *testing.T) {
+	source := &mockDataSource{}
+	source.On("load", mock.Anything).Return(map[string]string{"STATUS": "ONLINE"}, nil).Once()
+	refreshed := make(chan time.Time)
+	source.On("load", mock.Anything).Return(map[string]string{"STATUS": "ONBATT"}, nil).WaitUntil(refreshed)
+	cache := NewCachingDataSource(source, time.Minute)
+	cache.maxStaleness = 5 * time.Minute
+	cache.refreshTimeout = time.Second
+	var mutex sync.Mutex
+	now := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	cache.now = func() time.Time {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return now
+	}
+	advance := func(d time.Duration) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		now = now.Add(d)
+	}
+
+	values, err := cache.load(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "ONLINE", values["STATUS"])
+
+	// the expired values are returned while they are refreshed
+	advance(2 * time.Minute)
+	for i := 0; i < 2; i++ {
+		values, err = cache.load(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, "ONLINE", values["STATUS"])
+	}
+	close(refreshed)
+
+	assert.Eventually(t, func() bool {
+		values, err := cache.load(context.Background())
+		return err == nil && values["STATUS"] == "ONBATT"
+	}, time.Second, 5*time.Millisecond)
+	// a single refresh was started
+	source.AssertNumberOfCalls(t, "load", 2)
+}
+
+func TestCachingDataSource_load_TooStale(t *testing.T) {
+	source := &mockDataSource{}
+	source.On("load", mock.Anything).Return(map[string]string{"STATUS": "ONLINE"}, nil).Once()
+	source.On("load", mock.Anything).Return(nil, errors.New("unreachable"))
+	cache := NewCachingDataSource(source, time.Minute)
+	cache.maxStaleness = 5 * time.Minute
+	now := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	cache.now = func() time.Time { return now }
+
+	_, err := cache.load(context.Background())
+	assert.NoError(t, err)
+
+	// values older than the maximum staleness are loaded before answering
+	now = now.Add(5 * time.Minute)
+	_, err = cache.load(context.Background())
+	assert.EqualError(t, err, "unreachable")
+}
+
+func TestConfig_invalidateCache(t *testing.T) {
+	source := &mockDataSource{}
+	source.On("load", mock.Anything).Return(map[string]string{"STATUS": "ONLINE"}, nil)
+	config := &Config{cacheTTL: time.Minute, dataSource: DataSourceNis}
+	config.loadDataSource()
+	cache := config.source.(*CachingDataSource)
+	cache.source = source
+
+	_, err := config.source.load(context.Background())
+	assert.NoError(t, err)
+	config.invalidateCache()
+	_, err = config.source.load(context.Background())
+	assert.NoError(t, err)
+
+	source.AssertNumberOfCalls(t, "load", 2)
+	// other sources aren't affected
+	(&Config{source: source}).invalidateCache()
+}
+
+func TestConfig_invalidateCache_Wrapped(t *testing.T) {
+	source := &mockDataSource{}
+	source.On("load", mock.Anything).Return(map[string]string{"STATUS": "ONLINE"}, nil)
+	config := &Config{source: NewLastKnownDataSource(NewCachingDataSource(NewThrottledDataSource(source, time.Minute),
+		time.Minute), time.Minute), state: NewUpsState()}
+	config.state.setSnapshot(map[string]string{"STATUS": "ONLINE"})
+
+	_, err := config.source.load(context.Background())
+	assert.NoError(t, err)
+	config.invalidateCache()
+	_, err = config.source.load(context.Background())
+	assert.NoError(t, err)
+
+	// the values kept by all wrapped sources are dropped
+	source.AssertNumberOfCalls(t, "load", 2)
+	_, ok := config.state.getSnapshot(time.Minute)
+	assert.False(t, ok)
+}
+
+func TestCachingSource(t *testing.T) {
+	cache := NewCachingDataSource(&mockDataSource{}, time.Minute)
+
+	found, ok := cachingSource(cache)
+	assert.True(t, ok)
+	assert.Same(t, cache, found)
+
+	found, ok = cachingSource(NewLastKnownDataSource(cache, time.Minute))
+	assert.True(t, ok)
+	assert.Same(t, cache, found)
+
+	_, ok = cachingSource(&mockDataSource{})
+	assert.False(t, ok)
+}
@@ -0,0 +1,264 @@
+// Copyright [2021] [Christian Bandowski]
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/pkg/errors"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// A Subcommand is a mode of the program selected by the first argument, like serving the proxy or checking its
+// health. Every subcommand parses its own flags.
+type Subcommand struct {
+	description string
+	run         func(args []string, output io.Writer) error
+}
+
+// subcommands by name, initialized by init as the help refers to them
+var subcommands map[string]Subcommand
+
+func init() {
+	subcommands = map[string]Subcommand{
+		"serve":   {"Serve the UPSes to NUT clients, the default if no subcommand is given", runServe},
+		"check":   {"Check the health of a running proxy, e.g. as health check of a container", runCheck},
+		"dump":    {"Load the values once and print the variables of the UPSes like upsc", runDump},
+		"config":  {"Validate the configuration without starting the proxy by using \"config validate\"", runConfig},
+		"bench":   {"Simulate polling upsmon clients against a running proxy", runBench},
+		"version": {"Print the version", runVersion},
+		"help":    {"Print this help", runHelp},
+	}
+}
+
+// runSubcommand runs the subcommand selected by the first argument with the remaining ones. The proxy is served if
+// no subcommand is given, including if the arguments start with a flag like before there were subcommands.
+func runSubcommand(args []string, output io.Writer) error {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return runServe(args, output)
+	}
+
+	subcommand, ok := subcommands[args[0]]
+	if !ok {
+		_ = runHelp(nil, output)
+		return errors.Errorf("Unknown subcommand %s", args[0])
+	}
+
+	return subcommand.run(args[1:], output)
+}
+
+// runHelp prints the available subcommands.
+func runHelp(args []string, output io.Writer) error {
+	names := make([]string, 0, len(subcommands))
+	for name := range subcommands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintln(output, "Usage: apcupsd-nut-proxy [<subcommand>] [<flags>]")
+	fmt.Fprintln(output, "Subcommands:")
+	for _, name := range names {
+		fmt.Fprintf(output, "  %-8s %s\n", name, subcommands[name].description)
+	}
+	fmt.Fprintln(output, "Use \"<subcommand> -h\" to list the flags of a subcommand.")
+
+	return nil
+}
+
+// runServe runs the serve subcommand, it starts the proxy and returns once it was stopped.
+func runServe(args []string, output io.Writer) error {
+	config := newConfig()
+	if err := config.loadProgramArgs("serve", args, output); errors.Is(err, flag.ErrHelp) {
+		return nil
+	} else if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if config.showVersion {
+		return runVersion(nil, output)
+	}
+
+	return errors.Wrap(startProxy(config), "Proxy failed")
+}
+
+// runVersion prints the version of the proxy.
+func runVersion(args []string, output io.Writer) error {
+	fmt.Fprintln(output, versionString())
+	return nil
+}
+
+// runConfig runs the config subcommand, its only subcommand is "validate".
+func runConfig(args []string, output io.Writer) error {
+	if len(args) == 0 || args[0] != "validate" {
+		return errors.New("Unknown config subcommand, use \"config validate\" followed by the flags of the proxy")
+	}
+
+	return errors.Wrap(runConfigValidate(args[1:], output), "Invalid configuration")
+}
+
+// runCheck runs the check subcommand. It requests the status of a UPS from a running proxy and fails unless it is
+// reported, so e.g. a container can be restarted once the proxy stops answering.
+func runCheck(args []string, output io.Writer) error {
+	config := &BenchConfig{}
+
+	flags := flag.NewFlagSet("check", flag.ContinueOnError)
+	flags.SetOutput(output)
+	flags.StringVar(&config.address, "address", "127.0.0.1:3493",
+		"Address of the proxy")
+	flags.StringVar(&config.upsName, "ups", "ups",
+		"Name of the UPS whose status is requested")
+	flags.StringVar(&config.user, "user", "",
+		"User logging in before the status is requested, it doesn't log in if it is empty")
+	addPasswordFlags(flags, config)
+	flags.DurationVar(&config.timeout, "timeout", 5*time.Second,
+		"Timeout of a single request")
+
+	if err := flags.Parse(args); errors.Is(err, flag.ErrHelp) {
+		return nil
+	} else if err != nil {
+		return errors.WithStack(err)
+	}
+	if err := config.loadPassword(); err != nil {
+		return errors.WithStack(err)
+	}
+
+	status, err := checkStatus(config)
+	if err != nil {
+		return errors.Wrap(err, "Health check failed")
+	}
+	fmt.Fprintf(output, "UPS %s on %s is %s\n", config.upsName, config.address, status)
+
+	return nil
+}
+
+// checkStatus requests the status of the UPS from the proxy.
+func checkStatus(config *BenchConfig) (string, error) {
+	client, err := dialBench(config)
+	if err != nil {
+		return "", err
+	}
+	defer client.Close()
+
+	response, err := client.request(fmt.Sprintf("GET VAR %s ups.status", formatArg(config.upsName)), "VAR ")
+	if err != nil {
+		return "", err
+	}
+	// the client logs out to keep the log of the proxy clean, its response doesn't matter anymore
+	_, _ = client.request("LOGOUT", "OK")
+
+	tokens, err := tokenize(response)
+	if err != nil || len(tokens) != 4 {
+		return "", errors.Errorf("Invalid response %s", response)
+	}
+
+	return tokens[3], nil
+}
+
+// flags of the proxy taken by the dump subcommand, the ones about serving the clients don't apply to it
+var dumpFlags = map[string]bool{
+	"target-address": true, "fallback-targets": true, "source": true, "scenario-file": true, "replay-dir": true,
+	"ssh-user": true, "ssh-key": true, "ssh-known-hosts": true, "status-file": true, "upstream-ups": true,
+	"snmp-community": true, "snmp-community-file": true, "ups-name": true, "ups-description": true, "ups": true,
+	"ups-file": true, "discover": true, "discover-port": true, "discover-timeout": true, "source-retries": true,
+	"source-retry-backoff": true, "resolve-ttl": true, "single-value-requests": true, "timeout": true,
+	"apcaccess-executable": true, "apcaccess-args": true, "apcaccess-env": true, "apcaccess-strip-units": true,
+	"exec-timeout": true, "var-map": true, "static-vars": true, "static-var": true, "exclude-vars": true,
+	"log-level": true,
+}
+
+// runDump runs the dump subcommand. It takes the flags of the proxy about loading the values, loads the values of
+// every UPS once and prints their variables without serving them.
+func runDump(args []string, output io.Writer) error {
+	config := newConfig()
+
+	// all flags are registered, so the config holds the defaults of the ones not taken
+	allFlags := flag.NewFlagSet("dump", flag.ContinueOnError)
+	config.addFlags(allFlags)
+	flags := flag.NewFlagSet("dump", flag.ContinueOnError)
+	flags.SetOutput(output)
+	allFlags.VisitAll(func(f *flag.Flag) {
+		if dumpFlags[f.Name] {
+			flags.Var(f.Value, f.Name, f.Usage)
+		}
+	})
+	if err := config.parseFlags(flags, args); errors.Is(err, flag.ErrHelp) {
+		return nil
+	} else if err != nil {
+		return errors.WithStack(err)
+	}
+	setLogLevel(config.logLevel)
+
+	if err := config.validate(); err != nil {
+		return errors.Wrap(err, "Invalid configuration")
+	}
+	if err := config.loadState(); err != nil {
+		return errors.WithStack(err)
+	}
+	if err := config.loadUpses(); err != nil {
+		return errors.WithStack(err)
+	}
+
+	upses := config.upsConfigs()
+	for i, ups := range upses {
+		if len(upses) > 1 {
+			if i > 0 {
+				fmt.Fprintln(output)
+			}
+			fmt.Fprintf(output, "[%s]\n", ups.upsName)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), config.timeout)
+		err := dumpVars(ctx, ups, output)
+		cancel()
+		if err != nil {
+			return errors.Wrap(err, "Dump failed")
+		}
+	}
+
+	return nil
+}
+
+// dumpVars loads the values of the UPS and prints its non-empty variables in the format of upsc, like
+// "battery.charge: 100". Variables that fail to load are skipped like by LIST VAR.
+func dumpVars(ctx context.Context, config *Config, output io.Writer) error {
+	if err := config.ensureUpstreamVars(ctx); err != nil {
+		return errors.WithStack(err)
+	}
+
+	apcValues := NewApcValues()
+	if err := apcValues.reload(ctx, config); err != nil {
+		return errors.Wrapf(err, "Couldn't load the values of UPS %s", config.upsName)
+	}
+	memoized := newMemoizedApcValues(apcValues)
+
+	for _, name := range config.varNames() {
+		value, err := config.vars[name](name, config, memoized)
+		if err != nil {
+			logWarnf("Couldn't load variable %s, skipping it: %+v", name, err)
+			continue
+		}
+		if value == "" {
+			continue
+		}
+
+		fmt.Fprintf(output, "%s: %s\n", name, value)
+	}
+
+	return nil
+}
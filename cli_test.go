@@ -0,0 +1,99 @@
+// Copyright [2021] [Christian Bandowski]
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestRunSubcommand(t *testing.T) {
+	var output bytes.Buffer
+	assert.NoError(t, runSubcommand([]string{"version"}, &output))
+	assert.Equal(t, versionString()+"\n", output.String())
+
+	output.Reset()
+	assert.EqualError(t, runSubcommand([]string{"start"}, &output), "Unknown subcommand start")
+	assert.Contains(t, output.String(), "Usage: apcupsd-nut-proxy [<subcommand>] [<flags>]")
+	assert.Contains(t, output.String(), "  serve    Serve the UPSes to NUT clients")
+
+	assert.EqualError(t, runSubcommand([]string{"config", "check"}, &output),
+		"Unknown config subcommand, use \"config validate\" followed by the flags of the proxy")
+}
+
+func TestRunDump_Flags(t *testing.T) {
+	var output bytes.Buffer
+	assert.NoError(t, runDump([]string{"-h"}, &output))
+	assert.Contains(t, output.String(), "-source")
+	assert.NotContains(t, output.String(), "-tls-port")
+
+	// the flags about serving the clients aren't taken
+	output.Reset()
+	err := runDump([]string{"-port", "3494"}, &output)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "flag provided but not defined: -port")
+	}
+}
+
+func TestRunConfigValidate_Flags(t *testing.T) {
+	var output bytes.Buffer
+	assert.NoError(t, runConfigValidate([]string{"-h"}, &output))
+	assert.Contains(t, output.String(), "-source")
+	assert.Contains(t, output.String(), "-tls-port")
+}
+
+func TestRunCheck(t *testing.T) {
+	source := &mockDataSource{}
+	source.On("load", mock.Anything).Return(map[string]string{"STATUS": "ONBATT"}, nil)
+	config := &Config{upsName: "rack", timeout: 10 * time.Second, maxLineLength: 1024, source: source,
+		vars: defaultVars()}
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go serve(l, &Listener{}, config, NewSessionRegistry())
+
+	var output bytes.Buffer
+	assert.NoError(t, runCheck([]string{"-address", l.Addr().String(), "-ups", "rack"}, &output))
+	assert.Equal(t, "UPS rack on "+l.Addr().String()+" is OB DISCHRG ONBATT\n", output.String())
+
+	err = runCheck([]string{"-address", l.Addr().String(), "-ups", "unknown"}, &output)
+	assert.EqualError(t, err, "Health check failed: Unexpected response to GET: ERR UNKNOWN-UPS")
+}
+
+func TestDumpVars(t *testing.T) {
+	source := &mockDataSource{}
+	source.On("load", mock.Anything).Return(map[string]string{"BCHARGE": "85.0", "LOADPCT": "27.0"}, nil).Once()
+	source.On("load", mock.Anything).Return(nil, errors.New("unreachable"))
+	config := &Config{upsName: "rack", source: source, vars: map[string]VarLoader{
+		"battery.charge": ApcValue("BCHARGE", IgnoreValue),
+		"ups.load":       ApcValue("LOADPCT", IgnoreValue),
+		"ups.serial":     ApcValue("SERIALNO", IgnoreValue),
+	}}
+
+	var output bytes.Buffer
+	assert.NoError(t, dumpVars(context.Background(), config, &output))
+	assert.Equal(t, "battery.charge: 85.0\nups.load: 27.0\n", output.String())
+
+	assert.EqualError(t, dumpVars(context.Background(), config, &output),
+		"Couldn't load the values of UPS rack: unreachable")
+}
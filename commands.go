@@ -15,105 +15,704 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"github.com/pkg/errors"
+	"sort"
 	"strings"
 )
 
 // commandReceived handles a command that was received.
-func commandReceived(command string, config *Config, apcValues IApcValues) (string, bool, error) {
-	if strings.HasPrefix(command, "LOGIN ") {
-		upsName := command[6:]
-		if upsName != config.upsName {
+// The given context limits how long loading the apc values may take.
+func commandReceived(ctx context.Context, command string, config *Config, session *Session,
+	apcValues IApcValues) (response string, closeConnection bool, err error) {
+
+	tokens, err := tokenize(command)
+	if err != nil {
+		return "ERR INVALID-ARGUMENT", false, nil
+	}
+	if len(tokens) == 0 {
+		return "ERR UNKNOWN-COMMAND", false, nil
+	}
+
+	// commands are matched case-insensitive, arguments like the UPS name are kept as they are
+	verb := strings.ToUpper(tokens[0])
+	args := tokens[1:]
+
+	// like upsd, commands that act on behalf of a user require the credentials before anything else is checked,
+	// clients identified by a certificate don't need to send any
+	if credentialsRequired[verb] && session.certUser == "" {
+		if session.username == "" {
+			return "ERR USERNAME-REQUIRED", false, nil
+		}
+		if session.password == "" {
+			return "ERR PASSWORD-REQUIRED", false, nil
+		}
+	}
+
+	route := commandRoute{verb: verb}
+	if subVerbCommands[verb] {
+		if len(args) == 0 {
+			return "ERR INVALID-ARGUMENT", false, nil
+		}
+
+		route.subVerb = strings.ToUpper(args[0])
+		args = args[1:]
+	}
+
+	handler, ok := commandRoutes[route]
+	if !ok {
+		if subVerbCommands[verb] {
+			// the command is known, but not the requested sub command
+			return "ERR INVALID-ARGUMENT", false, nil
+		}
+
+		return "ERR UNKNOWN-COMMAND", false, nil
+	}
+
+	// state changing commands are audited, including the denied ones
+	if auditedCommands[route] {
+		defer func() {
+			config.auditLog.record(session, redactCommand(command), response, err)
+		}()
+	}
+
+	// permissions are checked here for all commands, so handlers don't have to care about them
+	if session.limited && !limitedCommands[route] {
+		return "ERR ACCESS-DENIED", false, nil
+	}
+	if credentialsRequired[verb] && len(config.users) > 0 {
+		if config.authGuard.isBanned(session.remoteAddr) {
+			logAuthFailure(session.remoteAddr, session.identity(), "banned")
+			return "ERR ACCESS-DENIED", false, nil
+		}
+		if config.authenticatedUser(session) == nil {
+			logAuthFailure(session.remoteAddr, session.identity(), "invalid-credentials")
+
+			// slow down clients guessing credentials
+			waitDelay(ctx, config.authGuard.recordFailure(session.remoteAddr))
+			return "ERR ACCESS-DENIED", false, nil
+		}
+		config.authGuard.recordSuccess(session.remoteAddr)
+	}
+	if !config.isPermitted(session, route, args) {
+		return "ERR ACCESS-DENIED", false, nil
+	}
+
+	// commands addressing a UPS are handled with its configuration, UPSes that aren't served are rejected right away
+	upsConfig := config
+	if upsCommands[route] && len(args) > 0 {
+		if upsConfig = config.upsConfig(args[0]); upsConfig == nil {
 			return "ERR UNKNOWN-UPS", false, nil
 		}
+		if err := upsConfig.ensureUpstreamVars(ctx); err != nil {
+			return "ERR DATA-STALE", false, errors.WithStack(err)
+		}
+	}
+
+	return handler(ctx, args, upsConfig, session, apcValues)
+}
+
+// A CommandHandler handles a single command. It receives the arguments following the verb, or following the sub verb
+// for commands that have one, the configuration and the apc values, which have to be reloaded before they are used.
+// It returns the response and whether the connection should be closed afterwards.
+type CommandHandler func(ctx context.Context, args []string, config *Config, session *Session,
+	apcValues IApcValues) (string, bool, error)
+
+// commandRoute identifies a command by its verb and sub verb, e.g. LIST and VAR. The sub verb is empty for commands
+// that don't have one.
+type commandRoute struct {
+	verb    string
+	subVerb string
+}
+
+// verbs that are always followed by a sub verb
+var subVerbCommands = map[string]bool{
+	"LIST": true,
+	"GET":  true,
+	"SET":  true,
+}
+
+// handlers of all supported commands
+var commandRoutes = map[commandRoute]CommandHandler{
+	{"USERNAME", ""}: commandUsername,
+	{"PASSWORD", ""}: commandPassword,
+	{"LOGIN", ""}:    commandLogin,
+	{"LOGOUT", ""}:   commandLogout,
+	{"MASTER", ""}:   commandMaster,
+	{"PRIMARY", ""}:  commandPrimary,
+	{"FSD", ""}:      commandFsd,
+	{"VER", ""}:      commandVer,
+	{"NETVER", ""}:   commandNetVer,
+	{"PROTVER", ""}:  commandNetVer,
+	{"STARTTLS", ""}: commandStartTLS,
+	{"INSTCMD", ""}:  commandInstCmd,
+
+	{"LIST", "UPS"}:    commandListUps,
+	{"LIST", "VAR"}:    commandListVar,
+	{"LIST", "RW"}:     commandListRw,
+	{"LIST", "ENUM"}:   commandListEnum,
+	{"LIST", "RANGE"}:  commandListRange,
+	{"LIST", "CLIENT"}: commandListClient,
+	{"LIST", "CMD"}:    commandListCmd,
+	{"LIST", "EVENTS"}: commandListEvents,
+
+	{"GET", "VAR"}:       commandGetVar,
+	{"GET", "TYPE"}:      commandGetType,
+	{"GET", "DESC"}:      commandGetDesc,
+	{"GET", "UPSDESC"}:   commandGetUpsDesc,
+	{"GET", "NUMLOGINS"}: commandGetNumLogins,
+	{"GET", "TRACKING"}:  commandGetTracking,
+
+	{"SET", "VAR"}:      commandSetVar,
+	{"SET", "TRACKING"}: commandSetTracking,
+}
+
+// prefix of the variables describing the proxy itself instead of the UPS
+const proxyVarPrefix = "proxy."
+
+// variable polled by upsmon, answered from the values loaded last by any connection
+const upsStatusVar = "ups.status"
+
+// commands controlling the session, they are answered right away, even if a command waits for the data source
+var sessionCommands = map[commandRoute]bool{
+	{"USERNAME", ""}: true,
+	{"PASSWORD", ""}: true,
+	{"LOGIN", ""}:    true,
+	{"LOGOUT", ""}:   true,
+	{"VER", ""}:      true,
+	{"NETVER", ""}:   true,
+	{"PROTVER", ""}:  true,
+}
+
+// commands loading the apc values, they are handled in the background as they may wait for a slow data source
+var sourceCommands = map[commandRoute]bool{
+	{"LIST", "VAR"}: true,
+	{"LIST", "RW"}:  true,
+	{"GET", "VAR"}:  true,
+}
+
+// routeOf returns the route of the command, without checking whether it exists. The route is empty if the command
+// can't be tokenized.
+func routeOf(command string) commandRoute {
+	tokens, err := tokenize(command)
+	if err != nil || len(tokens) == 0 {
+		return commandRoute{}
+	}
+
+	route := commandRoute{verb: strings.ToUpper(tokens[0])}
+	if subVerbCommands[route.verb] && len(tokens) > 1 {
+		route.subVerb = strings.ToUpper(tokens[1])
+	}
+
+	return route
+}
+
+// commands whose first argument is the name of the UPS they address
+var upsCommands = map[commandRoute]bool{
+	{"LOGIN", ""}:   true,
+	{"MASTER", ""}:  true,
+	{"PRIMARY", ""}: true,
+	{"FSD", ""}:     true,
+	{"INSTCMD", ""}: true,
+
+	{"LIST", "VAR"}:    true,
+	{"LIST", "RW"}:     true,
+	{"LIST", "ENUM"}:   true,
+	{"LIST", "RANGE"}:  true,
+	{"LIST", "CLIENT"}: true,
+	{"LIST", "CMD"}:    true,
+	{"LIST", "EVENTS"}: true,
+
+	{"GET", "VAR"}:       true,
+	{"GET", "TYPE"}:      true,
+	{"GET", "DESC"}:      true,
+	{"GET", "UPSDESC"}:   true,
+	{"GET", "NUMLOGINS"}: true,
+
+	{"SET", "VAR"}: true,
+}
+
+// actions a user needs to be granted to execute a command, commands without an entry only need valid credentials
+var requiredActions = map[commandRoute]string{
+	{"LOGIN", ""}:   "LOGIN",
+	{"MASTER", ""}:  "MASTER",
+	{"PRIMARY", ""}: "MASTER",
+	{"FSD", ""}:     "FSD",
+	{"INSTCMD", ""}: "INSTCMD",
+	{"SET", "VAR"}:  "SET",
+}
+
+// RegisterCommand adds a command to the ones supported by the proxy. It allows adding commands, like vendor-specific
+// ones, by calling it in the init function of an additional file, e.g.
+//
+//	func init() {
+//		_ = RegisterCommand("DUMP", "", false, commandDump)
+//	}
+//
+// The sub verb has to be empty, unless the verb is always followed by one like LIST, GET and SET. Commands that act on
+// behalf of a user can require the client to send its credentials first. Registering isn't safe for concurrent use,
+// so all commands must be registered before the proxy is started.
+func RegisterCommand(verb string, subVerb string, requireCredentials bool, handler CommandHandler) error {
+	verb = strings.ToUpper(verb)
+	subVerb = strings.ToUpper(subVerb)
+	route := commandRoute{verb: verb, subVerb: subVerb}
+
+	if verb == "" || handler == nil {
+		return errors.New("A command needs a verb and a handler")
+	}
+	if _, ok := commandRoutes[route]; ok {
+		return errors.Errorf("The command %s %s is already registered", verb, subVerb)
+	}
+	for existingRoute := range commandRoutes {
+		if existingRoute.verb == verb && (existingRoute.subVerb == "") != (subVerb == "") {
+			return errors.Errorf("The command %s is registered with and without a sub verb", verb)
+		}
+	}
+
+	commandRoutes[route] = handler
+	if subVerb != "" {
+		subVerbCommands[verb] = true
+	}
+	if requireCredentials {
+		credentialsRequired[verb] = true
+	}
+
+	return nil
+}
+
+// commands that require the client to send USERNAME and PASSWORD first
+var credentialsRequired = map[string]bool{
+	"LOGIN":   true,
+	"MASTER":  true,
+	"PRIMARY": true,
+	"FSD":     true,
+	"INSTCMD": true,
+	"SET":     true,
+}
+
+// commands whose arguments are credentials, they must never be logged
+var redactedCommands = map[string]bool{
+	"USERNAME": true,
+	"PASSWORD": true,
+}
+
+// redactCommand returns the command for logging, with the arguments of commands sending credentials masked. The
+// verb is kept, so the log still shows that the client authenticated. The command is split like it is dispatched.
+func redactCommand(command string) string {
+	fields, err := tokenize(command)
+	if err != nil {
+		// the command is rejected, but a quoted verb must still be recognized
+		fields = strings.Fields(strings.ReplaceAll(command, "\"", " "))
+	}
+	if len(fields) > 1 && redactedCommands[strings.ToUpper(fields[0])] {
+		return fields[0] + " ***"
+	}
+
+	return command
+}
+
+// commandUsername handles the USERNAME command.
+// The username can only be set once, it will be checked once the client uses a command that requires credentials.
+func commandUsername(ctx context.Context, args []string, config *Config, session *Session,
+	apcValues IApcValues) (string, bool, error) {
 
-		return "OK", false, nil
-	} else if strings.HasPrefix(command, "USERNAME ") {
-		// accept all usernames
-		return "OK", false, nil
-	} else if strings.HasPrefix(command, "PASSWORD ") {
-		// accept all passwords
-		return "OK", false, nil
-	} else if command == "LOGOUT" {
-		// close the stream
-		return "OK Goodbye", true, nil
-	} else if command == "STARTTLS" {
+	if len(args) != 1 {
+		return "ERR INVALID-ARGUMENT", false, nil
+	}
+	if session.username != "" {
+		return "ERR ALREADY-SET-USERNAME", false, nil
+	}
+
+	session.username = args[0]
+
+	return "OK", false, nil
+}
+
+// commandPassword handles the PASSWORD command.
+// The password can only be set once, it will be checked once the client uses a command that requires credentials.
+func commandPassword(ctx context.Context, args []string, config *Config, session *Session,
+	apcValues IApcValues) (string, bool, error) {
+
+	if len(args) != 1 {
+		return "ERR INVALID-ARGUMENT", false, nil
+	}
+	if session.password != "" {
+		return "ERR ALREADY-SET-PASSWORD", false, nil
+	}
+
+	session.password = args[0]
+
+	return "OK", false, nil
+}
+
+// commandLogin handles the LOGIN command.
+func commandLogin(ctx context.Context, args []string, config *Config, session *Session,
+	apcValues IApcValues) (string, bool, error) {
+
+	if len(args) != 1 {
+		return "ERR INVALID-ARGUMENT", false, nil
+	}
+	if session.isLoggedIn() {
+		return "ERR ALREADY-LOGGED-IN", false, nil
+	}
+	if args[0] != config.upsName {
+		return "ERR UNKNOWN-UPS", false, nil
+	}
+
+	session.login(args[0])
+
+	return "OK", false, nil
+}
+
+// commandLogout handles the LOGOUT command, the connection will be closed afterwards.
+func commandLogout(ctx context.Context, args []string, config *Config, session *Session,
+	apcValues IApcValues) (string, bool, error) {
+
+	session.logout()
+
+	return "OK Goodbye", true, nil
+}
+
+// commandVer handles the VER command.
+func commandVer(ctx context.Context, args []string, config *Config, session *Session,
+	apcValues IApcValues) (string, bool, error) {
+
+	return versionString(), false, nil
+}
+
+// commandNetVer handles the NETVER command and its alias PROTVER.
+func commandNetVer(ctx context.Context, args []string, config *Config, session *Session,
+	apcValues IApcValues) (string, bool, error) {
+
+	return networkProtocolVersion, false, nil
+}
+
+// commandStartTLS handles the STARTTLS command, it switches the connection to TLS by using the certificate of the TLS
+// listener once the response was sent.
+func commandStartTLS(ctx context.Context, args []string, config *Config, session *Session,
+	apcValues IApcValues) (string, bool, error) {
+
+	if config.tlsConfig == nil {
 		return "ERR FEATURE-NOT-CONFIGURED", false, nil
-	} else if command == "LIST UPS" {
-		return commandListUps(config)
-	} else if strings.HasPrefix(command, "LIST VAR ") {
-		return commandListVar(command, config, apcValues)
-	} else if strings.HasPrefix(command, "GET VAR ") {
-		return commandGetVar(command, config, apcValues)
-	} else if strings.HasPrefix(command, "SET VAR ") {
-		return commandSetVar(command, config)
-	} else {
-		return "ERR UNKNOWN-COMMAND", false, nil
 	}
+	if session.tls {
+		return "ERR ALREADY-SSL-MODE", false, nil
+	}
+	session.startTLS = true
+
+	return "OK STARTTLS", false, nil
+}
+
+// commandMaster handles the MASTER command, the name of the PRIMARY command before NUT 2.8.
+func commandMaster(ctx context.Context, args []string, config *Config, session *Session,
+	apcValues IApcValues) (string, bool, error) {
+
+	return grantPrimary("MASTER", args, config, session)
+}
+
+// commandPrimary handles the PRIMARY command.
+func commandPrimary(ctx context.Context, args []string, config *Config, session *Session,
+	apcValues IApcValues) (string, bool, error) {
+
+	return grantPrimary("PRIMARY", args, config, session)
+}
+
+// grantPrimary grants primary status to all clients that sent valid credentials, the response uses the given verb, so
+// clients before NUT 2.8 get the MASTER-GRANTED they expect.
+func grantPrimary(verb string, args []string, config *Config, session *Session) (string, bool, error) {
+
+	if len(args) != 1 {
+		return "ERR INVALID-ARGUMENT", false, nil
+	}
+	if args[0] != config.upsName {
+		return "ERR UNKNOWN-UPS", false, nil
+	}
+
+	session.primary = true
+
+	return fmt.Sprintf("OK %s-GRANTED", verb), false, nil
+}
+
+// commandFsd handles the FSD command.
+// Only clients with primary status may set the forced shutdown flag, the configured FSD command will be executed
+// afterwards.
+func commandFsd(ctx context.Context, args []string, config *Config, session *Session,
+	apcValues IApcValues) (string, bool, error) {
+
+	if len(args) != 1 {
+		return "ERR INVALID-ARGUMENT", false, nil
+	}
+	if args[0] != config.upsName {
+		return "ERR UNKNOWN-UPS", false, nil
+	}
+	if !session.primary {
+		return "ERR ACCESS-DENIED", false, nil
+	}
+
+	config.state.setForcedShutdown()
+	logInfof("Forced shutdown of UPS %s requested by client %s", config.upsName, session.remoteAddr)
+
+	// a command consisting of whitespace only is rejected by validate, but mustn't crash the connection either
+	if cmdArgs := strings.Fields(config.fsdCommand); len(cmdArgs) > 0 {
+		if _, err := execCommand(ctx, cmdArgs[0], cmdArgs[1:]...); err != nil {
+			// the flag is set anyway, so clients will still notice the forced shutdown
+			return "OK FSD-SET", false, errors.Wrapf(err, "Executing the FSD command failed")
+		}
+	}
+
+	return "OK FSD-SET", false, nil
 }
 
-// commandListUps handles the LIST UPS command.
-func commandListUps(config *Config) (string, bool, error) {
+// commandListUps handles the LIST UPS command, it lists all UPSes served by the proxy.
+func commandListUps(ctx context.Context, args []string, config *Config, session *Session,
+	apcValues IApcValues) (string, bool, error) {
+
 	var resp strings.Builder
 
 	resp.WriteString("BEGIN LIST UPS\n")
-	resp.WriteString(fmt.Sprintf("UPS %s \"%s\"\n", config.upsName, config.upsDescription))
+	for _, ups := range config.upsConfigs() {
+		resp.WriteString(fmt.Sprintf("UPS %s %s\n", formatArg(ups.upsName), quote(ups.upsDescription)))
+	}
 	resp.WriteString("END LIST UPS\n")
 
 	return resp.String(), false, nil
 }
 
 // commandListVar handles the LIST VAR command.
-// It reloads the apc values to ensure the values are up-to-date.
-func commandListVar(command string, config *Config, apcValues IApcValues) (string, bool, error) {
-	upsName := command[9:]
-	if upsName != config.upsName {
+// It reloads the apc values to ensure the values are up-to-date, variables that fail to load are skipped.
+func commandListVar(ctx context.Context, args []string, config *Config, session *Session,
+	apcValues IApcValues) (string, bool, error) {
+
+	if len(args) != 1 {
+		return "ERR INVALID-ARGUMENT", false, nil
+	}
+	if args[0] != config.upsName {
 		return "ERR UNKNOWN-UPS", false, nil
 	}
+	upsName := formatArg(config.upsName)
 
-	err := apcValues.reload(config)
+	err := apcValues.reload(ctx, config)
 	if err != nil {
-		return "", false, errors.WithStack(err)
+		// let the client know the values couldn't be refreshed, e.g. upsmon will treat the UPS as not reachable
+		return "ERR DATA-STALE", false, errors.WithStack(err)
 	}
 
-	var sb strings.Builder
-	sb.WriteString(fmt.Sprintf("BEGIN LIST VAR %s\n", config.upsName))
+	// variables derived from the same values are evaluated once
+	memoized := newMemoizedApcValues(apcValues)
 
-	for name, loader := range config.vars {
-		value, err := loader(name, config, apcValues)
+	names := config.varNames()
+	response := getResponseBuffer(len(names) + 2)
+	defer putResponseBuffer(response)
+	fmt.Fprintf(response, "BEGIN LIST VAR %s\n", upsName)
+
+	for _, name := range names {
+		value, err := config.vars[name](name, config, memoized)
 		if err != nil {
-			return "", false, errors.Wrapf(err, "Couldn't load variable %s", name)
+			// skip the variable, the client would wait forever for the end of a truncated list
+			logWarnf("Couldn't load variable %s, skipping it: %+v", name, err)
+			continue
 		}
 		if value == "" {
 			// skip empty values
 			continue
 		}
 
-		sb.WriteString(fmt.Sprintf("VAR %s %s \"%s\"\n", config.upsName, name, value))
+		fmt.Fprintf(response, "VAR %s %s %s\n", upsName, name, quote(value))
+	}
+
+	fmt.Fprintf(response, "END LIST VAR %s\n", upsName)
+
+	return response.String(), false, nil
+}
+
+// commandListRw handles the LIST RW command.
+// Only variables marked as writable in their VarInfo will be listed, values are reloaded if there are any.
+// Variables that fail to load are skipped.
+func commandListRw(ctx context.Context, args []string, config *Config, session *Session,
+	apcValues IApcValues) (string, bool, error) {
+
+	if len(args) != 1 {
+		return "ERR INVALID-ARGUMENT", false, nil
+	}
+	if args[0] != config.upsName {
+		return "ERR UNKNOWN-UPS", false, nil
+	}
+	upsName := formatArg(config.upsName)
+
+	names := config.writableVarNames()
+	if len(names) > 0 {
+		if err := apcValues.reload(ctx, config); err != nil {
+			return "ERR DATA-STALE", false, errors.WithStack(err)
+		}
+	}
+
+	response := getResponseBuffer(len(names) + 2)
+	defer putResponseBuffer(response)
+	fmt.Fprintf(response, "BEGIN LIST RW %s\n", upsName)
+
+	for _, name := range names {
+		value, err := config.vars[name](name, config, apcValues)
+		if err != nil {
+			logWarnf("Couldn't load variable %s, skipping it: %+v", name, err)
+			continue
+		}
+
+		fmt.Fprintf(response, "RW %s %s %s\n", upsName, name, quote(value))
+	}
+
+	fmt.Fprintf(response, "END LIST RW %s\n", upsName)
+
+	return response.String(), false, nil
+}
+
+// commandListEnum handles the LIST ENUM command.
+// The list will be empty for variables that aren't enumerated.
+func commandListEnum(ctx context.Context, args []string, config *Config, session *Session,
+	apcValues IApcValues) (string, bool, error) {
+
+	if len(args) != 2 {
+		return "ERR INVALID-ARGUMENT", false, nil
+	}
+	if args[0] != config.upsName {
+		return "ERR UNKNOWN-UPS", false, nil
+	}
+	upsName := formatArg(config.upsName)
+	varName := args[1]
+
+	if _, ok := config.vars[varName]; !ok {
+		return "ERR VAR-NOT-SUPPORTED", false, nil
+	}
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("BEGIN LIST ENUM %s %s\n", upsName, varName))
+
+	for _, value := range config.varInfos[varName].enum {
+		sb.WriteString(fmt.Sprintf("ENUM %s %s %s\n", upsName, varName, quote(value)))
+	}
+
+	sb.WriteString(fmt.Sprintf("END LIST ENUM %s %s\n", upsName, varName))
+
+	return sb.String(), false, nil
+}
+
+// commandListRange handles the LIST RANGE command.
+// The list will be empty for variables without a known range.
+func commandListRange(ctx context.Context, args []string, config *Config, session *Session,
+	apcValues IApcValues) (string, bool, error) {
+
+	if len(args) != 2 {
+		return "ERR INVALID-ARGUMENT", false, nil
+	}
+	if args[0] != config.upsName {
+		return "ERR UNKNOWN-UPS", false, nil
+	}
+	upsName := formatArg(config.upsName)
+	varName := args[1]
+
+	if _, ok := config.vars[varName]; !ok {
+		return "ERR VAR-NOT-SUPPORTED", false, nil
+	}
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("BEGIN LIST RANGE %s %s\n", upsName, varName))
+
+	for _, r := range config.varInfos[varName].ranges {
+		sb.WriteString(fmt.Sprintf("RANGE %s %s \"%d\" \"%d\"\n", upsName, varName, r.min, r.max))
+	}
+
+	sb.WriteString(fmt.Sprintf("END LIST RANGE %s %s\n", upsName, varName))
+
+	return sb.String(), false, nil
+}
+
+// commandListClient handles the LIST CLIENT command.
+// It lists the addresses of all clients that are logged in to the UPS.
+func commandListClient(ctx context.Context, args []string, config *Config, session *Session,
+	apcValues IApcValues) (string, bool, error) {
+
+	if len(args) != 1 {
+		return "ERR INVALID-ARGUMENT", false, nil
+	}
+	if args[0] != config.upsName {
+		return "ERR UNKNOWN-UPS", false, nil
+	}
+	upsName := formatArg(config.upsName)
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("BEGIN LIST CLIENT %s\n", upsName))
+
+	for _, address := range session.registry.clients(config.upsName) {
+		sb.WriteString(fmt.Sprintf("CLIENT %s %s\n", upsName, address))
+	}
+
+	sb.WriteString(fmt.Sprintf("END LIST CLIENT %s\n", upsName))
+
+	return sb.String(), false, nil
+}
+
+// commandListCmd handles the LIST CMD command.
+func commandListCmd(ctx context.Context, args []string, config *Config, session *Session,
+	apcValues IApcValues) (string, bool, error) {
+
+	if len(args) != 1 {
+		return "ERR INVALID-ARGUMENT", false, nil
+	}
+	if args[0] != config.upsName {
+		return "ERR UNKNOWN-UPS", false, nil
+	}
+	upsName := formatArg(config.upsName)
+
+	cmdNames := make([]string, 0, len(config.cmds))
+	for name := range config.cmds {
+		cmdNames = append(cmdNames, name)
+	}
+	sort.Strings(cmdNames)
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("BEGIN LIST CMD %s\n", upsName))
+
+	for _, name := range cmdNames {
+		sb.WriteString(fmt.Sprintf("CMD %s %s\n", upsName, name))
 	}
 
-	sb.WriteString(fmt.Sprintf("END LIST VAR %s\n", config.upsName))
+	sb.WriteString(fmt.Sprintf("END LIST CMD %s\n", upsName))
 
 	return sb.String(), false, nil
 }
 
 // commandGetVar handles the GET VAR command.
 // It reloads the apc values to ensure the values are up-to-date.
-func commandGetVar(command string, config *Config, apcValues IApcValues) (string, bool, error) {
-	upsAndVarName := strings.Split(command[8:], " ")
+func commandGetVar(ctx context.Context, args []string, config *Config, session *Session,
+	apcValues IApcValues) (string, bool, error) {
 
-	if len(upsAndVarName) != 2 {
+	if len(args) != 2 {
 		return "ERR INVALID-ARGUMENT", false, nil
 	}
-	if upsAndVarName[0] != config.upsName {
+	if args[0] != config.upsName {
 		return "ERR UNKNOWN-UPS", false, nil
 	}
-	varName := upsAndVarName[1]
+	varName := args[1]
 
-	err := apcValues.reload(config)
-	if err != nil {
-		return "", false, errors.WithStack(err)
+	if varName == upsStatusVar {
+		// upsmon polls the status frequently, it's answered from the values loaded last by any connection
+		if response, ok := getVarFromSnapshot(varName, config); ok {
+			return response, false, nil
+		}
+	}
+
+	// the variables of the proxy itself don't need any values, so they are available while the source fails. A
+	// single variable doesn't need all values either, unless they are loaded anyway.
+	var values IApcValues = apcValues
+	var singleValues *SingleApcValues
+	if !strings.HasPrefix(varName, proxyVarPrefix) {
+		singleValues = newSingleApcValues(ctx, config, apcValues)
+		if singleValues != nil {
+			values = singleValues
+		} else if err := apcValues.reload(ctx, config); err != nil {
+			// let the client know the values couldn't be refreshed, e.g. upsmon will treat the UPS as not reachable
+			return "ERR DATA-STALE", false, errors.WithStack(err)
+		}
 	}
 
 	loader, ok := config.vars[varName]
@@ -121,26 +720,231 @@ func commandGetVar(command string, config *Config, apcValues IApcValues) (string
 		return "ERR VAR-NOT-SUPPORTED", false, nil
 	}
 
-	value, err := loader(varName, config, apcValues)
+	value, err := loader(varName, config, values)
+	if singleValues != nil && singleValues.err != nil {
+		return "ERR DATA-STALE", false, errors.WithStack(singleValues.err)
+	}
 	if err != nil {
 		return "", false, errors.Wrapf(err, "Couldn't load variable %s", varName)
 	}
 
-	return fmt.Sprintf("VAR %s %s \"%s\"\n", config.upsName, varName, value), false, nil
+	return fmt.Sprintf("VAR %s %s %s\n", formatArg(config.upsName), varName, quote(value)), false, nil
+}
+
+// getVarFromSnapshot returns the GET VAR response based on the values loaded last by any connection, if they were
+// loaded within the reload interval, so it's used with the default minimum reload interval.
+func getVarFromSnapshot(varName string, config *Config) (string, bool) {
+	loader, ok := config.vars[varName]
+	if !ok {
+		return "", false
+	}
+	snapshot, ok := config.state.getSnapshot(reloadInterval(config))
+	if !ok {
+		return "", false
+	}
+
+	value, err := loader(varName, config, &ApcValues{values: snapshot})
+	if err != nil {
+		return "", false
+	}
+
+	return fmt.Sprintf("VAR %s %s %s\n", formatArg(config.upsName), varName, quote(value)), true
+}
+
+// commandGetType handles the GET TYPE command.
+func commandGetType(ctx context.Context, args []string, config *Config, session *Session,
+	apcValues IApcValues) (string, bool, error) {
+
+	if len(args) != 2 {
+		return "ERR INVALID-ARGUMENT", false, nil
+	}
+	if args[0] != config.upsName {
+		return "ERR UNKNOWN-UPS", false, nil
+	}
+	varName := args[1]
+
+	if _, ok := config.vars[varName]; !ok {
+		return "ERR VAR-NOT-SUPPORTED", false, nil
+	}
+
+	return fmt.Sprintf("TYPE %s %s %s\n", formatArg(config.upsName), varName,
+		config.varInfos[varName].typeDescription()), false, nil
+}
+
+// commandGetDesc handles the GET DESC command.
+func commandGetDesc(ctx context.Context, args []string, config *Config, session *Session,
+	apcValues IApcValues) (string, bool, error) {
+
+	if len(args) != 2 {
+		return "ERR INVALID-ARGUMENT", false, nil
+	}
+	if args[0] != config.upsName {
+		return "ERR UNKNOWN-UPS", false, nil
+	}
+	varName := args[1]
+
+	if _, ok := config.vars[varName]; !ok {
+		return "ERR VAR-NOT-SUPPORTED", false, nil
+	}
+
+	return fmt.Sprintf("DESC %s %s %s\n", formatArg(config.upsName), varName,
+		quote(config.varInfos[varName].descriptionOrDefault())), false, nil
+}
+
+// commandGetUpsDesc handles the GET UPSDESC command.
+func commandGetUpsDesc(ctx context.Context, args []string, config *Config, session *Session,
+	apcValues IApcValues) (string, bool, error) {
+
+	if len(args) != 1 {
+		return "ERR INVALID-ARGUMENT", false, nil
+	}
+	if args[0] != config.upsName {
+		return "ERR UNKNOWN-UPS", false, nil
+	}
+
+	return fmt.Sprintf("UPSDESC %s %s\n", formatArg(config.upsName), quote(config.upsDescription)), false, nil
+}
+
+// commandGetNumLogins handles the GET NUMLOGINS command.
+// It counts the clients of all connections that are logged in to the UPS.
+func commandGetNumLogins(ctx context.Context, args []string, config *Config, session *Session,
+	apcValues IApcValues) (string, bool, error) {
+
+	if len(args) != 1 {
+		return "ERR INVALID-ARGUMENT", false, nil
+	}
+	if args[0] != config.upsName {
+		return "ERR UNKNOWN-UPS", false, nil
+	}
+
+	return fmt.Sprintf("NUMLOGINS %s %d\n", formatArg(config.upsName),
+		session.registry.numLogins(config.upsName)), false, nil
 }
 
 // commandSetVar handles the SET VAR command.
-// This command is not supported and thus all values are readonly and the corresponding error will always be returned.
-func commandSetVar(command string, config *Config) (string, bool, error) {
-	upsAndVarName := strings.Split(command[8:], " ")
+// Only variables that were configured to be writable can be changed. Their values are either stored by the proxy itself
+// or changed in the EEPROM of the UPS, in the latter case the new value must be confirmed by apcupsd afterwards.
+func commandSetVar(ctx context.Context, args []string, config *Config, session *Session,
+	apcValues IApcValues) (string, bool, error) {
+
+	// the value is expected to follow the variable name, but the readonly error is returned without it too
+	if len(args) != 2 && len(args) != 3 {
+		return "ERR INVALID-ARGUMENT", false, nil
+	}
+	if args[0] != config.upsName {
+		return "ERR UNKNOWN-UPS", false, nil
+	}
+	varName := args[1]
+
+	if _, ok := config.vars[varName]; !ok {
+		return "ERR VAR-NOT-SUPPORTED", false, nil
+	}
+	info := config.varInfos[varName]
+	if !info.writable {
+		return "ERR READONLY", false, nil
+	}
+	if len(args) != 3 {
+		return "ERR INVALID-ARGUMENT", false, nil
+	}
+	value := args[2]
+
+	if valueError := info.valueError(value); valueError != "" {
+		return valueError, false, nil
+	}
+
+	var err error
+	if varWriter, ok := config.varWriters[varName]; ok {
+		err = varWriter(ctx, varName, value, config)
+		if err == nil {
+			err = confirmVarValue(ctx, varName, value, config, apcValues)
+		}
+	} else {
+		err = config.state.setVar(varName, value)
+	}
+	if err == nil {
+		logInfof("Client %s changed variable %s to %s", session.remoteAddr, varName, value)
+	}
+
+	return commandResult(session, "ERR SET-FAILED", err)
+}
 
-	if len(upsAndVarName) != 2 {
+// commandInstCmd handles the INSTCMD command.
+// Only instant commands that were enabled in the configuration can be executed.
+func commandInstCmd(ctx context.Context, args []string, config *Config, session *Session,
+	apcValues IApcValues) (string, bool, error) {
+
+	// an optional value may follow the command name
+	if len(args) != 2 && len(args) != 3 {
 		return "ERR INVALID-ARGUMENT", false, nil
 	}
-	if upsAndVarName[0] != config.upsName {
+	if args[0] != config.upsName {
 		return "ERR UNKNOWN-UPS", false, nil
 	}
+	cmdName := args[1]
+
+	instCmd, ok := config.cmds[cmdName]
+	if !ok {
+		return "ERR CMD-NOT-SUPPORTED", false, nil
+	}
+
+	return commandResult(session, "ERR INSTCMD-FAILED", instCmd(ctx, cmdName, config))
+}
+
+// commandResult returns the response to an executed SET VAR or INSTCMD command. Clients that enabled tracking get a
+// tracking ID instead, which they can use to query the result by using GET TRACKING.
+func commandResult(session *Session, failure string, err error) (string, bool, error) {
+	if session.tracking {
+		return "OK TRACKING " + session.track(err == nil), false, errors.WithStack(err)
+	}
+	if err != nil {
+		return failure, false, errors.WithStack(err)
+	}
+
+	return "OK", false, nil
+}
+
+// commandSetTracking handles the SET TRACKING command, which was introduced with NUT 2.8.
+func commandSetTracking(ctx context.Context, args []string, config *Config, session *Session,
+	apcValues IApcValues) (string, bool, error) {
+
+	if len(args) != 1 {
+		return "ERR INVALID-ARGUMENT", false, nil
+	}
+
+	switch strings.ToUpper(args[0]) {
+	case "ON":
+		session.tracking = true
+	case "OFF":
+		session.tracking = false
+	default:
+		return "ERR INVALID-ARGUMENT", false, nil
+	}
+	return "OK", false, nil
+}
+
+// commandGetTracking handles the GET TRACKING command, which was introduced with NUT 2.8.
+// Without arguments it returns whether tracking is enabled, otherwise the result of the command with the given ID.
+// The proxy executes all commands synchronously, so results are never pending.
+func commandGetTracking(ctx context.Context, args []string, config *Config, session *Session,
+	apcValues IApcValues) (string, bool, error) {
+
+	if len(args) > 1 {
+		return "ERR INVALID-ARGUMENT", false, nil
+	}
+	if len(args) == 0 {
+		if session.tracking {
+			return "ON", false, nil
+		}
+		return "OFF", false, nil
+	}
+
+	success, ok := session.trackingResult(args[0])
+	if !ok {
+		return "ERR UNKNOWN", false, nil
+	}
+	if !success {
+		return "ERR FAILED", false, nil
+	}
 
-	// we don't support writing any kind of values
-	return "ERR READONLY", false, nil
+	return "SUCCESS", false, nil
 }
@@ -15,20 +15,32 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"strings"
 	"testing"
+	"time"
 )
 
 type mockApcValues struct {
 	mock.Mock
 }
 
-func (m *mockApcValues) reload(config *Config) error {
-	args := m.Called(config)
+func (m *mockApcValues) reload(ctx context.Context, config *Config) error {
+	args := m.Called(ctx, config)
 	return args.Error(0)
 }
 
+func (m *mockApcValues) invalidate() {
+}
+
+func (m *mockApcValues) fresh(config *Config) bool {
+	return true
+}
+
 func (m *mockApcValues) get(name string) string {
 	args := m.Called(name)
 	return args.String(0)
@@ -39,6 +51,31 @@ func (m *mockApcValues) getOk(name string) (string, bool) {
 	return args.String(0), args.Bool(1)
 }
 
+func SucceedingInstCmd(_ context.Context, _ string, _ *Config) error {
+	return nil
+}
+
+func FailingInstCmd(_ context.Context, _ string, _ *Config) error {
+	return errors.New("FailingInstCmd")
+}
+
+func SucceedingVarWriter(_ context.Context, _ string, _ string, _ *Config) error {
+	return nil
+}
+
+func FailingVarWriter(_ context.Context, _ string, _ string, _ *Config) error {
+	return errors.New("FailingVarWriter")
+}
+
+// newAuthenticatedSession creates a session of a client that already sent its credentials
+func newAuthenticatedSession(remoteAddr string, registry *SessionRegistry) *Session {
+	session := NewSession(remoteAddr, registry)
+	session.username = "user"
+	session.password = "password"
+
+	return session
+}
+
 type responseInfo struct {
 	response        string
 	closeConnection bool
@@ -49,15 +86,63 @@ func TestCommandReceived(t *testing.T) {
 	okNoError := responseInfo{response: "OK"}
 
 	commandToResponse := map[string]responseInfo{
-		"LOGIN test":         okNoError,
-		"USERNAME user":      okNoError,
-		"PASSWORD password":  okNoError,
-		"LOGOUT":             {response: "OK Goodbye", closeConnection: true},
-		"STARTTLS":           {response: "ERR FEATURE-NOT-CONFIGURED"},
-		"LIST UPS":           {response: "BEGIN LIST UPS\nUPS test \"testcase\"\nEND LIST UPS\n"},
-		"LIST VAR test":      {response: "BEGIN LIST VAR test\nVAR test foo \"bar\"\nEND LIST VAR test\n"},
-		"GET VAR test foo":   {response: "VAR test foo \"bar\"\n"},
-		"SET VAR test model": {response: "ERR READONLY"},
+		"LOGIN test":        okNoError,
+		"USERNAME user":     {response: "ERR ALREADY-SET-USERNAME"},
+		"PASSWORD password": {response: "ERR ALREADY-SET-PASSWORD"},
+		"LOGOUT":            {response: "OK Goodbye", closeConnection: true},
+		"VER":               {response: versionString()},
+		"NETVER":            {response: "1.3"},
+		"PROTVER":           {response: "1.3"},
+		"STARTTLS":          {response: "ERR FEATURE-NOT-CONFIGURED"},
+		"LIST UPS":          {response: "BEGIN LIST UPS\nUPS test \"testcase\"\nEND LIST UPS\n"},
+		"LIST VAR test": {response: "BEGIN LIST VAR test\nVAR test enum \"a\"\nVAR test foo \"bar\"\n" +
+			"END LIST VAR test\n"},
+		"LIST CLIENT test":  {response: "BEGIN LIST CLIENT test\nCLIENT test 192.168.0.1\nEND LIST CLIENT test\n"},
+		"LIST CLIENT other": {response: "ERR UNKNOWN-UPS"},
+		"LIST RW test":      {response: "BEGIN LIST RW test\nRW test foo \"bar\"\nEND LIST RW test\n"},
+		"LIST RW other":     {response: "ERR UNKNOWN-UPS"},
+		"LIST ENUM test enum": {response: "BEGIN LIST ENUM test enum\nENUM test enum \"a\"\nENUM test enum \"b\"\n" +
+			"END LIST ENUM test enum\n"},
+		"LIST ENUM test foo":     {response: "BEGIN LIST ENUM test foo\nEND LIST ENUM test foo\n"},
+		"LIST ENUM test unknown": {response: "ERR VAR-NOT-SUPPORTED"},
+		"LIST ENUM other foo":    {response: "ERR UNKNOWN-UPS"},
+		"LIST ENUM test":         {response: "ERR INVALID-ARGUMENT"},
+		"LIST RANGE test foo": {response: "BEGIN LIST RANGE test foo\nRANGE test foo \"0\" \"10\"\n" +
+			"RANGE test foo \"20\" \"30\"\nEND LIST RANGE test foo\n"},
+		"LIST RANGE test enum":    {response: "BEGIN LIST RANGE test enum\nEND LIST RANGE test enum\n"},
+		"LIST RANGE test unknown": {response: "ERR VAR-NOT-SUPPORTED"},
+		"LIST RANGE other foo":    {response: "ERR UNKNOWN-UPS"},
+		"LIST RANGE test":         {response: "ERR INVALID-ARGUMENT"},
+		"LIST CMD test": {response: "BEGIN LIST CMD test\nCMD test beeper.mute\nCMD test test.battery.start\n" +
+			"END LIST CMD test\n"},
+		"LIST CMD other":                  {response: "ERR UNKNOWN-UPS"},
+		"GET VAR test foo":                {response: "VAR test foo \"bar\"\n"},
+		"GET TYPE test foo":               {response: "TYPE test foo RW RANGE\n"},
+		"GET TYPE test enum":              {response: "TYPE test enum ENUM\n"},
+		"GET TYPE test unknown":           {response: "ERR VAR-NOT-SUPPORTED"},
+		"GET TYPE other foo":              {response: "ERR UNKNOWN-UPS"},
+		"GET TYPE test":                   {response: "ERR INVALID-ARGUMENT"},
+		"GET DESC test foo":               {response: "DESC test foo \"Foo description\"\n"},
+		"GET DESC test enum":              {response: "DESC test enum \"Description unavailable\"\n"},
+		"GET DESC test unknown":           {response: "ERR VAR-NOT-SUPPORTED"},
+		"GET DESC other foo":              {response: "ERR UNKNOWN-UPS"},
+		"GET DESC test":                   {response: "ERR INVALID-ARGUMENT"},
+		"GET UPSDESC test":                {response: "UPSDESC test \"testcase\"\n"},
+		"GET UPSDESC other":               {response: "ERR UNKNOWN-UPS"},
+		"GET NUMLOGINS test":              {response: "NUMLOGINS test 1\n"},
+		"GET NUMLOGINS unknown":           {response: "ERR UNKNOWN-UPS"},
+		"SET VAR test model":              {response: "ERR VAR-NOT-SUPPORTED"},
+		"SET VAR test enum b":             {response: "ERR READONLY"},
+		"SET VAR test foo 25":             okNoError,
+		"SET VAR test foo 15":             {response: "ERR INVALID-VALUE"},
+		"SET VAR test foo abc":            {response: "ERR INVALID-VALUE"},
+		"SET VAR test foo":                {response: "ERR INVALID-ARGUMENT"},
+		"SET VAR other foo 5":             {response: "ERR UNKNOWN-UPS"},
+		"INSTCMD test beeper.mute":        okNoError,
+		"INSTCMD test shutdown.stayoff":   {response: "ERR CMD-NOT-SUPPORTED"},
+		"INSTCMD other beeper.mute":       {response: "ERR UNKNOWN-UPS"},
+		"INSTCMD test":                    {response: "ERR INVALID-ARGUMENT"},
+		"INSTCMD test test.battery.start": {response: "ERR INSTCMD-FAILED", errorMessage: "FailingInstCmd"},
 	}
 
 	apcValuesMock := &mockApcValues{}
@@ -66,13 +151,28 @@ func TestCommandReceived(t *testing.T) {
 
 	for command, expResponse := range commandToResponse {
 		t.Run("command="+command, func(t *testing.T) {
-			response, closeConnection, err := commandReceived(command, &Config{
+			registry := NewSessionRegistry()
+			NewSession("192.168.0.1", registry).login("test")
+			NewSession("192.168.0.2", registry).login("other")
+
+			response, closeConnection, err := commandReceived(context.Background(), command, &Config{
 				upsName:        "test",
 				upsDescription: "testcase",
 				vars: map[string]VarLoader{
-					"foo": FixedValue("bar"),
+					"foo":  FixedValue("bar"),
+					"enum": FixedValue("a"),
 				},
-			}, apcValuesMock)
+				varInfos: map[string]VarInfo{
+					"foo":     {writable: true, ranges: []VarRange{{0, 10}, {20, 30}}, description: "Foo description"},
+					"enum":    {enum: []string{"a", "b"}},
+					"unknown": {writable: true},
+				},
+				cmds: map[string]InstCmd{
+					"test.battery.start": FailingInstCmd,
+					"beeper.mute":        SucceedingInstCmd,
+				},
+				state: NewUpsState(),
+			}, newAuthenticatedSession("127.0.0.1", registry), apcValuesMock)
 
 			if expResponse.errorMessage == "" {
 				assert.NoError(t, err)
@@ -84,3 +184,734 @@ func TestCommandReceived(t *testing.T) {
 		})
 	}
 }
+
+func TestCommandReceived_Deadline(t *testing.T) {
+	apcValues := NewApcValues()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	response, closeConnection, err := commandReceived(ctx, "GET VAR test foo", &Config{
+		upsName: "test",
+		source:  &ExecDataSource{exec: slowExecCommand},
+		vars: map[string]VarLoader{
+			"foo": ApcValue("STATUS", IgnoreValue),
+		},
+	}, NewSession("127.0.0.1", NewSessionRegistry()), apcValues)
+
+	assert.Error(t, err)
+	assert.Equal(t, "ERR DATA-STALE", response)
+	assert.False(t, closeConnection)
+	assert.Less(t, int64(time.Since(start)), int64(time.Second))
+}
+
+func TestCommandReceived_Login(t *testing.T) {
+	registry := NewSessionRegistry()
+	session := newAuthenticatedSession("192.168.0.1", registry)
+
+	response, _, err := commandReceived(context.Background(), "LOGIN test", &Config{
+		upsName: "test",
+	}, session, &mockApcValues{})
+
+	assert.NoError(t, err)
+	assert.Equal(t, "OK", response)
+	assert.Equal(t, []string{"192.168.0.1"}, registry.clients("test"))
+}
+
+func TestCommandReceived_MultipleUps(t *testing.T) {
+	commandToResponse := map[string]string{
+		"LIST UPS":           "BEGIN LIST UPS\nUPS first \"rack\"\nUPS second \"office\"\nEND LIST UPS\n",
+		"GET VAR first foo":  "VAR first foo \"ONLINE\"\n",
+		"GET VAR second foo": "VAR second foo \"ONBATT\"\n",
+		"GET VAR third foo":  "ERR UNKNOWN-UPS",
+		"GET UPSDESC second": "UPSDESC second \"office\"\n",
+		"LIST VAR second":    "BEGIN LIST VAR second\nVAR second foo \"ONBATT\"\nEND LIST VAR second\n",
+		"LOGIN second":       "OK",
+	}
+
+	vars := map[string]VarLoader{
+		"foo": ApcValue("STATUS", IgnoreValue),
+	}
+	config := &Config{vars: vars}
+	config.upses = []*Config{
+		{upsName: "first", upsDescription: "rack", vars: vars,
+			source: &ExecDataSource{exec: testExecCommand("STATUS : ONLINE")}},
+		{upsName: "second", upsDescription: "office", vars: vars,
+			source: &ExecDataSource{exec: testExecCommand("STATUS : ONBATT")}},
+	}
+
+	for command, expResponse := range commandToResponse {
+		t.Run("command="+command, func(t *testing.T) {
+			response, _, err := commandReceived(context.Background(), command, config,
+				newAuthenticatedSession("127.0.0.1", NewSessionRegistry()), NewApcValues())
+
+			assert.NoError(t, err)
+			assert.Equal(t, expResponse, response)
+		})
+	}
+}
+
+func TestCommandReceived_UpsSpecs_UnservedName(t *testing.T) {
+	config := &Config{
+		upsName:    "ups",
+		dataSource: DataSourceFile,
+		statusFile: "apcupsd.status",
+		upsSpecs:   stringListFlag{"first", "second"},
+		state:      NewUpsState(),
+	}
+	if !assert.NoError(t, config.loadUpses()) {
+		return
+	}
+
+	// the global configuration is named "ups" as well, but it isn't served
+	for _, command := range []string{"GET VAR ups ups.status", "LIST VAR ups", "LOGIN ups", "FSD ups",
+		"GET UPSDESC ups"} {
+		t.Run("command="+command, func(t *testing.T) {
+			session := newAuthenticatedSession("127.0.0.1", NewSessionRegistry())
+			session.primary = true
+
+			response, _, err := commandReceived(context.Background(), command, config, session, NewApcValues())
+
+			assert.NoError(t, err)
+			assert.Equal(t, "ERR UNKNOWN-UPS", response)
+			assert.False(t, config.state.isForcedShutdown())
+		})
+	}
+}
+
+func TestCommandReceived_QuotedArguments(t *testing.T) {
+	commandToResponse := map[string]string{
+		`LOGIN "my ups"`:             "OK",
+		`LOGIN my ups`:               "ERR UNKNOWN-UPS",
+		`GET VAR "my ups" foo`:       "VAR \"my ups\" foo \"bar \\\"baz\\\"\"\n",
+		`GET VAR "my ups" "foo"`:     "VAR \"my ups\" foo \"bar \\\"baz\\\"\"\n",
+		`GET UPSDESC "my ups"`:       "UPSDESC \"my ups\" \"description\"\n",
+		`SET VAR "my ups" foo "a b"`: "ERR READONLY",
+		`GET VAR "my ups foo`:        "ERR INVALID-ARGUMENT",
+		`LIST UPS`:                   "BEGIN LIST UPS\nUPS \"my ups\" \"description\"\nEND LIST UPS\n",
+	}
+
+	apcValuesMock := &mockApcValues{}
+	apcValuesMock.On("reload", mock.Anything, mock.Anything).Return(nil)
+
+	for command, expResponse := range commandToResponse {
+		t.Run("command="+command, func(t *testing.T) {
+			response, _, err := commandReceived(context.Background(), command, &Config{
+				upsName:        "my ups",
+				upsDescription: "description",
+				vars: map[string]VarLoader{
+					"foo": FixedValue(`bar "baz"`),
+				},
+			}, newAuthenticatedSession("127.0.0.1", NewSessionRegistry()), apcValuesMock)
+
+			assert.NoError(t, err)
+			assert.Equal(t, expResponse, response)
+		})
+	}
+}
+
+func TestCommandReceived_CaseAndWhitespace(t *testing.T) {
+	commandToResponse := map[string]string{
+		"get var test  foo":  "VAR test foo \"bar\"\n",
+		"Get Var\ttest foo ": "VAR test foo \"bar\"\n",
+		"  list ups":         "BEGIN LIST UPS\nUPS test \"\"\nEND LIST UPS\n",
+		"get var TEST foo":   "ERR UNKNOWN-UPS",
+		"logout":             "OK Goodbye",
+	}
+
+	apcValuesMock := &mockApcValues{}
+	apcValuesMock.On("reload", mock.Anything, mock.Anything).Return(nil)
+
+	for command, expResponse := range commandToResponse {
+		t.Run("command="+command, func(t *testing.T) {
+			response, _, err := commandReceived(context.Background(), command, &Config{
+				upsName: "test",
+				vars: map[string]VarLoader{
+					"foo": FixedValue("bar"),
+				},
+			}, newAuthenticatedSession("127.0.0.1", NewSessionRegistry()), apcValuesMock)
+
+			assert.NoError(t, err)
+			assert.Equal(t, expResponse, response)
+		})
+	}
+}
+
+func TestCommandReceived_SingleValueRequests(t *testing.T) {
+	var requests [][]string
+	source := NewExecDataSource("apcaccess", "127.0.0.1")
+	source.exec = func(ctx context.Context, name string, args ...string) ([]byte, error) {
+		requests = append(requests, args)
+		if args[3] == "BCHARGE" {
+			return nil, errors.New("apcupsd not reachable")
+		}
+		return []byte("ONLINE\n"), nil
+	}
+	config := &Config{
+		upsName: "test",
+		vars: map[string]VarLoader{
+			"foo": ApcValue("STATUS", IgnoreValue),
+			"bar": ApcValue("BCHARGE", IgnoreValue),
+		},
+		source:              source,
+		singleValueRequests: true,
+	}
+	session := newAuthenticatedSession("127.0.0.1", NewSessionRegistry())
+
+	response, _, err := commandReceived(context.Background(), "GET VAR test foo", config, session, NewApcValues())
+	assert.NoError(t, err)
+	assert.Equal(t, "VAR test foo \"ONLINE\"\n", response)
+	assert.Equal(t, [][]string{{"-h", "127.0.0.1", "-p", "STATUS", "-u"}}, requests)
+
+	response, _, err = commandReceived(context.Background(), "GET VAR test bar", config, session, NewApcValues())
+	assert.EqualError(t, err, "Error invoking apcaccess: apcupsd not reachable")
+	assert.Equal(t, "ERR DATA-STALE", response)
+}
+
+func TestCommandReceived_ProxyVarsWithoutReload(t *testing.T) {
+	state := NewUpsState()
+	state.recordSourceLoad(errors.New("apcupsd not reachable"))
+	config := &Config{upsName: "test", vars: defaultVars(), state: state}
+
+	// the mock fails if the values are reloaded
+	response, _, err := commandReceived(context.Background(), "GET VAR test proxy.source.error", config,
+		newAuthenticatedSession("127.0.0.1", NewSessionRegistry()), &mockApcValues{})
+
+	assert.NoError(t, err)
+	assert.Equal(t, "VAR test proxy.source.error \"apcupsd not reachable\"\n", response)
+}
+
+func TestCommandReceived_UpsStatusFromSnapshot(t *testing.T) {
+	state := NewUpsState()
+	state.setSnapshot(map[string]string{"STATUS": "ONBATT"})
+	config := &Config{upsName: "test", vars: defaultVars(), state: state, minReloadInterval: time.Minute}
+	session := newAuthenticatedSession("127.0.0.1", NewSessionRegistry())
+
+	// the mock fails if the values are reloaded
+	response, _, err := commandReceived(context.Background(), "GET VAR test ups.status", config, session,
+		&mockApcValues{})
+	assert.NoError(t, err)
+	assert.Equal(t, "VAR test ups.status \"OB DISCHRG ONBATT\"\n", response)
+
+	// the cache TTL is taken into account as well
+	config.minReloadInterval = 0
+	config.cacheTTL = time.Minute
+	response, _, err = commandReceived(context.Background(), "GET VAR test ups.status", config, session,
+		&mockApcValues{})
+	assert.NoError(t, err)
+	assert.Equal(t, "VAR test ups.status \"OB DISCHRG ONBATT\"\n", response)
+
+	// outdated snapshots aren't used
+	config.cacheTTL = 0
+	apcValuesMock := &mockApcValues{}
+	apcValuesMock.On("reload", mock.Anything, mock.Anything).Return(errors.New("apcupsd not reachable"))
+	response, _, err = commandReceived(context.Background(), "GET VAR test ups.status", config, session,
+		apcValuesMock)
+	assert.Error(t, err)
+	assert.Equal(t, "ERR DATA-STALE", response)
+}
+
+func TestCommandReceived_ReloadFailed(t *testing.T) {
+	commands := []string{"GET VAR test foo", "LIST VAR test", "LIST RW test"}
+
+	apcValuesMock := &mockApcValues{}
+	apcValuesMock.On("reload", mock.Anything, mock.Anything).Return(errors.New("apcupsd not reachable"))
+
+	for _, command := range commands {
+		t.Run("command="+command, func(t *testing.T) {
+			response, closeConnection, err := commandReceived(context.Background(), command, &Config{
+				upsName: "test",
+				vars: map[string]VarLoader{
+					"foo": FixedValue("bar"),
+				},
+				varInfos: map[string]VarInfo{
+					"foo": {writable: true},
+				},
+			}, newAuthenticatedSession("127.0.0.1", NewSessionRegistry()), apcValuesMock)
+
+			assert.EqualError(t, err, "apcupsd not reachable")
+			assert.Equal(t, "ERR DATA-STALE", response)
+			assert.False(t, closeConnection)
+		})
+	}
+}
+
+func TestCommandReceived_ErrorPrecedence(t *testing.T) {
+	commandToResponse := map[string]string{
+		"LOGIN":                   "ERR USERNAME-REQUIRED",
+		"LOGIN unknown":           "ERR USERNAME-REQUIRED",
+		"INSTCMD":                 "ERR USERNAME-REQUIRED",
+		"SET VAR unknown foo bar": "ERR USERNAME-REQUIRED",
+		"FSD test":                "ERR USERNAME-REQUIRED",
+		"USERNAME":                "ERR INVALID-ARGUMENT",
+		"PASSWORD a b":            "ERR INVALID-ARGUMENT",
+		"GET":                     "ERR INVALID-ARGUMENT",
+		"GET FOO test":            "ERR INVALID-ARGUMENT",
+		"LIST FOO":                "ERR INVALID-ARGUMENT",
+		"FOO":                     "ERR UNKNOWN-COMMAND",
+	}
+
+	for command, expResponse := range commandToResponse {
+		t.Run("command="+command, func(t *testing.T) {
+			response, _, err := commandReceived(context.Background(), command, &Config{
+				upsName: "test",
+			}, NewSession("127.0.0.1", NewSessionRegistry()), &mockApcValues{})
+
+			assert.NoError(t, err)
+			assert.Equal(t, expResponse, response)
+		})
+	}
+}
+
+func TestCommandReceived_PasswordRequired(t *testing.T) {
+	session := NewSession("127.0.0.1", NewSessionRegistry())
+	session.username = "user"
+
+	response, _, err := commandReceived(context.Background(), "INSTCMD test beeper.mute", &Config{
+		upsName: "test",
+	}, session, &mockApcValues{})
+
+	assert.NoError(t, err)
+	assert.Equal(t, "ERR PASSWORD-REQUIRED", response)
+}
+
+func TestCommandReceived_SessionStates(t *testing.T) {
+	config := &Config{
+		upsName: "test",
+	}
+	session := NewSession("127.0.0.1", NewSessionRegistry())
+
+	commandsAndResponses := [][]string{
+		{"LOGIN test", "ERR USERNAME-REQUIRED"},
+		{"USERNAME user", "OK"},
+		{"USERNAME other", "ERR ALREADY-SET-USERNAME"},
+		{"LOGIN test", "ERR PASSWORD-REQUIRED"},
+		{"PASSWORD password", "OK"},
+		{"PASSWORD other", "ERR ALREADY-SET-PASSWORD"},
+		{"LOGIN test", "OK"},
+		{"LOGIN test", "ERR ALREADY-LOGGED-IN"},
+		{"LOGOUT", "OK Goodbye"},
+	}
+
+	for _, commandAndResponse := range commandsAndResponses {
+		response, _, err := commandReceived(context.Background(), commandAndResponse[0], config, session,
+			&mockApcValues{})
+
+		assert.NoError(t, err)
+		assert.Equal(t, commandAndResponse[1], response, commandAndResponse[0])
+	}
+}
+
+func TestCommandReceived_LogoutWithoutLogin(t *testing.T) {
+	response, closeConnection, err := commandReceived(context.Background(), "LOGOUT", &Config{
+		upsName: "test",
+	}, NewSession("127.0.0.1", NewSessionRegistry()), &mockApcValues{})
+
+	assert.NoError(t, err)
+	assert.Equal(t, "OK Goodbye", response)
+	assert.True(t, closeConnection)
+}
+
+func TestCommandReceived_AccessDenied(t *testing.T) {
+	config := &Config{
+		upsName: "test",
+		users: map[string]*User{
+			"user": {name: "user", password: "secret"},
+		},
+		cmds: map[string]InstCmd{
+			"beeper.mute": SucceedingInstCmd,
+		},
+	}
+
+	commands := []string{"LOGIN test", "MASTER test", "PRIMARY test", "FSD test", "INSTCMD test beeper.mute",
+		"SET VAR test foo bar"}
+
+	for _, command := range commands {
+		t.Run("command="+command, func(t *testing.T) {
+			// the session uses the wrong password
+			session := newAuthenticatedSession("127.0.0.1", NewSessionRegistry())
+			session.primary = true
+
+			response, _, err := commandReceived(context.Background(), command, config, session, &mockApcValues{})
+
+			assert.NoError(t, err)
+			assert.Equal(t, "ERR ACCESS-DENIED", response)
+		})
+	}
+}
+
+func TestCommandReceived_SetVar(t *testing.T) {
+	config := &Config{
+		upsName: "test",
+		vars: map[string]VarLoader{
+			"battery.charge.low": ApcValue("MBATTCHG", IgnoreValue),
+		},
+		varInfos: defaultVarInfos(),
+		state:    NewUpsState(),
+	}
+	config.writableVars = "battery.charge.low"
+	config.enableWritableVars()
+
+	apcValuesMock := &mockApcValues{}
+	apcValuesMock.On("reload", mock.Anything, mock.Anything).Return(nil)
+	apcValuesMock.On("getOk", "MBATTCHG").Return("10", true)
+
+	session := newAuthenticatedSession("127.0.0.1", NewSessionRegistry())
+
+	response, _, err := commandReceived(context.Background(), "GET VAR test battery.charge.low", config, session,
+		apcValuesMock)
+	assert.NoError(t, err)
+	assert.Equal(t, "VAR test battery.charge.low \"10\"\n", response)
+
+	response, _, err = commandReceived(context.Background(), "SET VAR test battery.charge.low 30", config, session,
+		apcValuesMock)
+	assert.NoError(t, err)
+	assert.Equal(t, "OK", response)
+
+	response, _, err = commandReceived(context.Background(), "GET VAR test battery.charge.low", config, session,
+		apcValuesMock)
+	assert.NoError(t, err)
+	assert.Equal(t, "VAR test battery.charge.low \"30\"\n", response)
+}
+
+func TestCommandReceived_SetVar_Eeprom(t *testing.T) {
+	testCases := []struct {
+		name          string
+		varWriter     VarWriter
+		reportedValue string
+		expResponse   string
+	}{
+		{"confirmed", SucceedingVarWriter, "High", "OK"},
+		{"not confirmed", SucceedingVarWriter, "Low", "ERR SET-FAILED"},
+		{"failing", FailingVarWriter, "High", "ERR SET-FAILED"},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			apcValuesMock := &mockApcValues{}
+			apcValuesMock.On("reload", mock.Anything, mock.Anything).Return(nil)
+			apcValuesMock.On("getOk", "SENSE").Return(testCase.reportedValue, true)
+
+			response, _, _ := commandReceived(context.Background(), "SET VAR test input.sensitivity High", &Config{
+				upsName: "test",
+				vars: map[string]VarLoader{
+					"input.sensitivity": ApcValue("SENSE", IgnoreValue),
+				},
+				varInfos: map[string]VarInfo{
+					"input.sensitivity": {writable: true, varType: VarTypeString, enum: []string{"High", "Low"}},
+				},
+				varWriters: map[string]VarWriter{"input.sensitivity": testCase.varWriter},
+			}, newAuthenticatedSession("127.0.0.1", NewSessionRegistry()), apcValuesMock)
+
+			assert.Equal(t, testCase.expResponse, response)
+		})
+	}
+}
+
+func TestCommandReceived_Tracking(t *testing.T) {
+	config := &Config{
+		upsName: "test",
+		cmds: map[string]InstCmd{
+			"beeper.mute":        SucceedingInstCmd,
+			"test.battery.start": FailingInstCmd,
+		},
+	}
+	session := newAuthenticatedSession("127.0.0.1", NewSessionRegistry())
+
+	send := func(command string) string {
+		response, _, _ := commandReceived(context.Background(), command, config, session, &mockApcValues{})
+		return response
+	}
+
+	assert.Equal(t, "OFF", send("GET TRACKING"))
+	assert.Equal(t, "OK", send("INSTCMD test beeper.mute"))
+	assert.Equal(t, "ERR INVALID-ARGUMENT", send("SET TRACKING MAYBE"))
+	assert.Equal(t, "OK", send("SET TRACKING ON"))
+	assert.Equal(t, "ON", send("GET TRACKING"))
+
+	response := send("INSTCMD test beeper.mute")
+	if assert.True(t, strings.HasPrefix(response, "OK TRACKING ")) {
+		assert.Equal(t, "SUCCESS", send("GET TRACKING "+strings.TrimPrefix(response, "OK TRACKING ")))
+	}
+
+	response = send("INSTCMD test test.battery.start")
+	if assert.True(t, strings.HasPrefix(response, "OK TRACKING ")) {
+		assert.Equal(t, "ERR FAILED", send("GET TRACKING "+strings.TrimPrefix(response, "OK TRACKING ")))
+	}
+
+	assert.Equal(t, "ERR UNKNOWN", send("GET TRACKING 1234"))
+	assert.Equal(t, "ERR CMD-NOT-SUPPORTED", send("INSTCMD test shutdown.stayoff"))
+	assert.Equal(t, "OK", send("SET TRACKING OFF"))
+	assert.Equal(t, "OK", send("INSTCMD test beeper.mute"))
+}
+
+func TestCommandRoutes(t *testing.T) {
+	verbs := make(map[string]bool)
+	for route, handler := range commandRoutes {
+		assert.NotNil(t, handler, route.verb+" "+route.subVerb)
+		assert.Equal(t, subVerbCommands[route.verb], route.subVerb != "", route.verb+" "+route.subVerb)
+
+		verbs[route.verb] = true
+	}
+
+	for verb := range credentialsRequired {
+		assert.True(t, verbs[verb], verb)
+	}
+}
+
+func TestRegisterCommand(t *testing.T) {
+	commandDump := func(ctx context.Context, args []string, config *Config, session *Session,
+		apcValues IApcValues) (string, bool, error) {
+
+		return "DUMP " + strings.Join(args, ",") + " " + config.upsName, false, nil
+	}
+
+	assert.NoError(t, RegisterCommand("dump", "", true, commandDump))
+	t.Cleanup(func() {
+		delete(commandRoutes, commandRoute{verb: "DUMP"})
+		delete(credentialsRequired, "DUMP")
+	})
+
+	config := &Config{upsName: "test"}
+
+	response, _, err := commandReceived(context.Background(), "DUMP a b", config,
+		NewSession("127.0.0.1", NewSessionRegistry()), &mockApcValues{})
+	assert.NoError(t, err)
+	assert.Equal(t, "ERR USERNAME-REQUIRED", response)
+
+	response, _, err = commandReceived(context.Background(), "DUMP a b", config,
+		newAuthenticatedSession("127.0.0.1", NewSessionRegistry()), &mockApcValues{})
+	assert.NoError(t, err)
+	assert.Equal(t, "DUMP a,b test", response)
+}
+
+func TestRegisterCommand_Invalid(t *testing.T) {
+	assert.EqualError(t, RegisterCommand("GET", "VAR", false, commandGetVar),
+		"The command GET VAR is already registered")
+	assert.EqualError(t, RegisterCommand("GET", "", false, commandGetVar),
+		"The command GET is registered with and without a sub verb")
+	assert.EqualError(t, RegisterCommand("LOGIN", "FOO", false, commandLogin),
+		"The command LOGIN is registered with and without a sub verb")
+	assert.EqualError(t, RegisterCommand("", "", false, commandLogin), "A command needs a verb and a handler")
+}
+
+func TestCommandReceived_ListWithFailingLoader(t *testing.T) {
+	failingLoader := func(name string, config *Config, av IApcValues) (string, error) {
+		return "", errors.New("failing loader")
+	}
+	commandToResponse := map[string]string{
+		"LIST VAR test": "BEGIN LIST VAR test\nVAR test foo \"bar\"\nEND LIST VAR test\n",
+		"LIST RW test":  "BEGIN LIST RW test\nRW test foo \"bar\"\nEND LIST RW test\n",
+	}
+
+	apcValuesMock := &mockApcValues{}
+	apcValuesMock.On("reload", mock.Anything, mock.Anything).Return(nil)
+
+	for command, expResponse := range commandToResponse {
+		t.Run("command="+command, func(t *testing.T) {
+			response, _, err := commandReceived(context.Background(), command, &Config{
+				upsName: "test",
+				vars: map[string]VarLoader{
+					"foo":     FixedValue("bar"),
+					"failing": failingLoader,
+				},
+				varInfos: map[string]VarInfo{
+					"foo":     {writable: true},
+					"failing": {writable: true},
+				},
+			}, newAuthenticatedSession("127.0.0.1", NewSessionRegistry()), apcValuesMock)
+
+			assert.NoError(t, err)
+			assert.Equal(t, expResponse, response)
+		})
+	}
+}
+
+func TestCommandReceived_LimitedSession(t *testing.T) {
+	commandToResponse := map[string]string{
+		"LIST UPS":         "BEGIN LIST UPS\nUPS test \"\"\nEND LIST UPS\n",
+		"VER":              versionString(),
+		"GET VAR test foo": "ERR ACCESS-DENIED",
+		"LIST VAR test":    "ERR ACCESS-DENIED",
+		"LOGIN test":       "ERR ACCESS-DENIED",
+		"FOO":              "ERR UNKNOWN-COMMAND",
+	}
+
+	for command, expResponse := range commandToResponse {
+		t.Run("command="+command, func(t *testing.T) {
+			session := newAuthenticatedSession("127.0.0.1", NewSessionRegistry())
+			session.limited = true
+
+			response, _, err := commandReceived(context.Background(), command, &Config{
+				upsName: "test",
+				vars: map[string]VarLoader{
+					"foo": FixedValue("bar"),
+				},
+			}, session, &mockApcValues{})
+
+			assert.NoError(t, err)
+			assert.Equal(t, expResponse, response)
+		})
+	}
+}
+
+func TestCommandReceived_AuthGuard(t *testing.T) {
+	config := &Config{
+		upsName: "test",
+		users: map[string]*User{
+			"user": {name: "user", password: "secret", actions: map[string]bool{"LOGIN": true}},
+		},
+		authGuard: NewAuthGuard(2, time.Minute, 0),
+	}
+
+	wrongSession := newAuthenticatedSession("127.0.0.1", NewSessionRegistry())
+	validSession := NewSession("127.0.0.1", NewSessionRegistry())
+	validSession.username = "user"
+	validSession.password = "secret"
+
+	for i := 0; i < 2; i++ {
+		response, _, err := commandReceived(context.Background(), "LOGIN test", config, wrongSession,
+			&mockApcValues{})
+		assert.NoError(t, err)
+		assert.Equal(t, "ERR ACCESS-DENIED", response)
+	}
+
+	// the address is banned now, even valid credentials are rejected
+	response, _, err := commandReceived(context.Background(), "LOGIN test", config, validSession, &mockApcValues{})
+	assert.NoError(t, err)
+	assert.Equal(t, "ERR ACCESS-DENIED", response)
+	assert.True(t, config.authGuard.isBanned("127.0.0.1"))
+}
+
+func TestRedactCommand(t *testing.T) {
+	commandToResult := map[string]string{
+		"PASSWORD secret":        "PASSWORD ***",
+		"password \"my secret\"": "password ***",
+		`"PASSWORD" "my secret"`: "PASSWORD ***",
+		`PASSWORD "my secret`:    "PASSWORD ***",
+		`"PASSWORD my secret`:    "PASSWORD ***",
+		"USERNAME monuser":       "USERNAME ***",
+		"PASSWORD":               "PASSWORD",
+		"LOGIN ups":              "LOGIN ups",
+		"SET VAR ups foo bar":    "SET VAR ups foo bar",
+	}
+
+	for command, expResult := range commandToResult {
+		t.Run(command, func(t *testing.T) {
+			assert.Equal(t, expResult, redactCommand(command))
+		})
+	}
+}
+
+func TestCommandReceived_Primary(t *testing.T) {
+	config := &Config{
+		upsName: "test",
+	}
+
+	for _, verb := range []string{"MASTER", "PRIMARY"} {
+		t.Run(verb, func(t *testing.T) {
+			session := NewSession("192.168.0.1", NewSessionRegistry())
+
+			response, _, err := commandReceived(context.Background(), verb+" test", config, session, &mockApcValues{})
+			assert.NoError(t, err)
+			assert.Equal(t, "ERR USERNAME-REQUIRED", response)
+
+			_, _, _ = commandReceived(context.Background(), "USERNAME user", config, session, &mockApcValues{})
+
+			response, _, err = commandReceived(context.Background(), verb+" test", config, session, &mockApcValues{})
+			assert.NoError(t, err)
+			assert.Equal(t, "ERR PASSWORD-REQUIRED", response)
+
+			_, _, _ = commandReceived(context.Background(), "PASSWORD pass", config, session, &mockApcValues{})
+
+			response, _, err = commandReceived(context.Background(), verb+" other", config, session, &mockApcValues{})
+			assert.NoError(t, err)
+			assert.Equal(t, "ERR UNKNOWN-UPS", response)
+			assert.False(t, session.primary)
+
+			response, _, err = commandReceived(context.Background(), verb+" test", config, session, &mockApcValues{})
+			assert.NoError(t, err)
+			assert.Equal(t, "OK "+verb+"-GRANTED", response)
+			assert.True(t, session.primary)
+		})
+	}
+}
+
+func TestCommandReceived_Fsd(t *testing.T) {
+	config := &Config{
+		upsName:    "test",
+		fsdCommand: "true --killpower",
+		state:      NewUpsState(),
+	}
+	session := NewSession("192.168.0.1", NewSessionRegistry())
+	session.username = "user"
+	session.password = "pass"
+
+	response, _, err := commandReceived(context.Background(), "FSD test", config, session, &mockApcValues{})
+	assert.NoError(t, err)
+	assert.Equal(t, "ERR ACCESS-DENIED", response)
+	assert.False(t, config.state.isForcedShutdown())
+
+	session.primary = true
+
+	response, _, err = commandReceived(context.Background(), "FSD other", config, session, &mockApcValues{})
+	assert.NoError(t, err)
+	assert.Equal(t, "ERR UNKNOWN-UPS", response)
+
+	response, _, err = commandReceived(context.Background(), "FSD test", config, session, &mockApcValues{})
+	assert.NoError(t, err)
+	assert.Equal(t, "OK FSD-SET", response)
+	assert.True(t, config.state.isForcedShutdown())
+}
+
+func TestCommandReceived_Fsd_FailingCommand(t *testing.T) {
+	config := &Config{
+		upsName:    "test",
+		fsdCommand: "false",
+		state:      NewUpsState(),
+	}
+	session := NewSession("192.168.0.1", NewSessionRegistry())
+	session.username = "user"
+	session.password = "pass"
+	session.primary = true
+
+	response, _, err := commandReceived(context.Background(), "FSD test", config, session, &mockApcValues{})
+	assert.Error(t, err)
+	assert.Equal(t, "OK FSD-SET", response)
+	assert.True(t, config.state.isForcedShutdown())
+}
+
+func TestCommandReceived_StartTLS(t *testing.T) {
+	config := &Config{upsName: "test", tlsConfig: &tls.Config{}}
+	session := NewSession("127.0.0.1", NewSessionRegistry())
+
+	response, _, err := commandReceived(context.Background(), "STARTTLS", config, session, &mockApcValues{})
+	assert.NoError(t, err)
+	assert.Equal(t, "OK STARTTLS", response)
+	assert.True(t, session.startTLS)
+
+	session.startTLS = false
+	session.tls = true
+	response, _, err = commandReceived(context.Background(), "STARTTLS", config, session, &mockApcValues{})
+	assert.NoError(t, err)
+	assert.Equal(t, "ERR ALREADY-SSL-MODE", response)
+	assert.False(t, session.startTLS)
+}
+
+func TestCommandReceived_Fsd_BlankCommand(t *testing.T) {
+	config := &Config{
+		upsName:    "test",
+		fsdCommand: "  ",
+		state:      NewUpsState(),
+	}
+	session := NewSession("192.168.0.1", NewSessionRegistry())
+	session.username = "user"
+	session.password = "pass"
+	session.primary = true
+
+	response, _, err := commandReceived(context.Background(), "FSD test", config, session, &mockApcValues{})
+	assert.NoError(t, err)
+	assert.Equal(t, "OK FSD-SET", response)
+	assert.True(t, config.state.isForcedShutdown())
+}
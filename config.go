@@ -15,8 +15,13 @@
 package main
 
 import (
+	"crypto/tls"
 	"flag"
 	"fmt"
+	"github.com/pkg/errors"
+	"io"
+	"net"
+	"strings"
 	"time"
 )
 
@@ -25,47 +30,918 @@ type Config struct {
 	address string
 	port    int
 
-	targetAddress string
+	tlsPort     int
+	tlsCertFile string
+	tlsKeyFile  string
+
+	tlsClientCAFile string
+
+	listenerSpecs stringListFlag
+
+	acmeDomains      string
+	acmeEmail        string
+	acmeCacheDir     string
+	acmeHTTPAddress  string
+	acmeDirectoryURL string
+
+	targetAddress   string
+	fallbackTargets string
+	dataSource      string
+	statusFile      string
 
 	upsName        string
 	upsDescription string
 
+	upstreamUpsName   string
+	snmpCommunity     string
+	snmpCommunityFile string
+
+	aggregateMembers string
+	scenarioFile     string
+	replayDir        string
+	recordDir        string
+
+	sshUser           string
+	sshKeyFile        string
+	sshKnownHostsFile string
+
+	eventsFile string
+	maxEvents  int
+
+	upsSpecs stringListFlag
+	upsFile  string
+
+	pollInterval        time.Duration
+	resolveTTL          time.Duration
+	cacheTTL            time.Duration
+	cacheMaxStaleness   time.Duration
+	sourceRetries       int
+	sourceRetryBackoff  time.Duration
+	minReloadInterval   time.Duration
+	maxDataAge          time.Duration
+	snapshotDir         string
+	singleValueRequests bool
+
+	backgroundPollInterval time.Duration
+	backgroundPollJitter   time.Duration
+	eventsWatchInterval    time.Duration
+
+	discoverNetworks string
+	discoverPort     string
+	discoverTimeout  time.Duration
+
 	apcAccessExecutable string
+	apcAccessArgs       string
+	apcAccessEnv        string
+	apcAccessStripUnits bool
+	execTimeout         time.Duration
+	maxExecutions       int
+	maxQueuedExecutions int
+	apcupsdExecutable   string
+	apctestExecutable   string
+
+	timeout       time.Duration
+	maxLineLength int
+
+	firstCommandTimeout time.Duration
+	idleTimeout         time.Duration
+	readTimeout         time.Duration
+	writeTimeout        time.Duration
+	byteTimeout         time.Duration
+	maxSessionDuration  time.Duration
+	sessionCommandWait  time.Duration
+	shutdownGracePeriod time.Duration
+	reapIdleAfter       time.Duration
+	selfCheckInterval   time.Duration
+
+	enabledCmds string
+	fsdCommand  string
+
+	usersFile string
+
+	allowedNetworksList string
+	unlistedClients     string
+
+	proxyProtocol            bool
+	proxyProtocolTrustedList string
+
+	maxClientConnections int
+	maxConnections       int
+	connectionOverflow   string
+
+	tcpKeepAlive  time.Duration
+	tcpNoDelay    bool
+	listenBacklog int
+
+	authFailureThreshold int
+	authBanDuration      time.Duration
+	authFailureDelay     time.Duration
+
+	metricsAddress string
+
+	runAsUser  string
+	runAsGroup string
+
+	auditLogTarget string
+
+	writableVars string
+	stateFile    string
+
+	// comma separated mappings of variables to their loaders, see parseVarMapping
+	varMappings string
+	// comma separated variables with fixed values, see parseStaticVar
+	staticVars string
+	// single variables with fixed values, their values may contain commas
+	staticVarSpecs stringListFlag
+	// comma separated variables that are hidden from the clients
+	excludedVars string
+	// options of the UPS changing its variables in the order they were given, see setUpsOption
+	varOptions []varOption
+
+	eepromVars    string
+	eepromCommand string
+
+	logLevel LogLevel
+
+	showVersion bool
+
+	vars     map[string]VarLoader
+	varInfos map[string]VarInfo
+	cmds     map[string]InstCmd
+
+	// writers of variables that are changed on the UPS itself instead of being stored by the proxy
+	varWriters map[string]VarWriter
+
+	// users that may authenticate, authentication is disabled if there are none
+	users map[string]*User
+
+	// networks clients may connect from by default, all clients are allowed if there are none
+	allowedNetworks []*net.IPNet
+
+	// networks of the load balancers whose PROXY protocol headers are accepted, all are accepted if there are none
+	proxyProtocolTrusted []*net.IPNet
+
+	// addresses the proxy listens on and their policies
+	listeners []*Listener
+
+	// source the values of the UPS are loaded from
+	source DataSource
+
+	// resolves the host names of the target addresses
+	resolver *AddressResolver
+
+	// configurations of all UPSes served by the proxy, each one is a copy of the global configuration
+	upses []*Config
+
+	// configuration of the TLS listener, nil if it's disabled
+	tlsConfig *tls.Config
+
+	// protection against brute-forcing credentials shared by all connections, nil if it's disabled
+	authGuard *AuthGuard
+
+	// limits of simultaneous connections shared by all listeners, nil if there are no limits
+	connectionLimiter *ConnectionLimiter
+
+	// limits the simultaneous executions of apcaccess of all UPSes, nil if they are unlimited
+	workQueue *WorkQueue
+
+	// open connections of all listeners drained on shutdown, nil if they aren't tracked
+	connectionTracker *ConnectionTracker
+
+	// records the state changing commands of all connections, nil if it's disabled
+	auditLog *AuditLog
+
+	// runtime state of the UPS shared by all connections
+	state *UpsState
+
+	// whether the variables of the upstream UPS were loaded, nil unless the UPS is served from upsd
+	upstreamVars *upstreamVarsState
+}
 
-	timeout time.Duration
+// loadProgramArgs loads the given arguments of the subcommand with the given name, without the subcommand name, and
+// stores them in the config. The usage is printed to the output if requested or if the arguments are invalid.
+func (c *Config) loadProgramArgs(name string, args []string, output io.Writer) error {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.SetOutput(output)
+	c.addFlags(flags)
 
-	vars map[string]VarLoader
+	return c.parseFlags(flags, args)
 }
 
-// loadProgramArgs loads the program arguments and stores them in the config.
-func (c *Config) loadProgramArgs() {
-	flag.StringVar(&c.address, "address", "127.0.0.1",
+// addFlags registers the flags of the proxy, the config holds their defaults right away.
+func (c *Config) addFlags(flags *flag.FlagSet) {
+	flags.StringVar(&c.address, "address", "127.0.0.1",
 		"Address on which the server should listen "+
 			"(use \"0.0.0.0\" to listen on all connections)")
-	flag.IntVar(&c.port, "port", 3493,
+	flags.IntVar(&c.port, "port", 3493,
 		"Port number on which this server should listen")
 
-	flag.StringVar(&c.targetAddress, "target-address", "127.0.0.1",
-		"Address on which apcupsd is running")
+	flags.IntVar(&c.tlsPort, "tls-port", 0,
+		"Port number on which this server should listen for TLS connections, e.g. 3494. Its certificate is also "+
+			"used by clients switching to TLS with STARTTLS on the other listeners (disabled by default)")
+	flags.StringVar(&c.tlsCertFile, "tls-cert", "",
+		"PEM encoded certificate file for the TLS listener, may contain intermediate certificates")
+	flags.StringVar(&c.tlsKeyFile, "tls-key", "",
+		"PEM encoded private key file of the certificate for the TLS listener")
+	flags.StringVar(&c.tlsClientCAFile, "tls-client-ca", "",
+		"PEM encoded CA certificates, if set clients of the TLS listener have to present a certificate signed by "+
+			"one of them. The common name of the certificate is used as user name instead of USERNAME and PASSWORD")
+	flags.Var(&c.listenerSpecs, "listen",
+		"Address the proxy listens on followed by comma separated options, may be used multiple times instead of "+
+			"-address, -port and -tls-port. Options are \"tls\", \"proxy-protocol\", \"allow=<network>\" "+
+			"(may be repeated) and \"unlisted-clients=<reject|limited>\", e.g. "+
+			"\"0.0.0.0:3494,tls,allow=192.168.0.0/24\". Options not given default to the global flags")
+	flags.StringVar(&c.acmeDomains, "acme-domains", "",
+		"Comma separated host names the certificate of the TLS listener and STARTTLS is obtained for from Let's Encrypt, "+
+			"instead of using -tls-cert and -tls-key. It is renewed automatically. Setting it means accepting the "+
+			"terms of service of the CA")
+	flags.StringVar(&c.acmeEmail, "acme-email", "",
+		"Contact email address for the ACME account, used by the CA to notify about problems with certificates")
+	flags.StringVar(&c.acmeCacheDir, "acme-cache-dir", "acme",
+		"Directory in which the ACME account key and the certificates are stored, it has to be writable by the "+
+			"user the proxy runs as")
+	flags.StringVar(&c.acmeHTTPAddress, "acme-http-address", ":80",
+		"Address on which the HTTP challenges of the CA are answered, the CA connects to it on port 80. If empty "+
+			"only TLS-ALPN challenges are answered, which requires the TLS listener to be reachable on port 443")
+	flags.StringVar(&c.acmeDirectoryURL, "acme-directory-url", "",
+		"Directory URL of the ACME CA, e.g. of the staging environment of Let's Encrypt (defaults to Let's Encrypt)")
+
+	flags.StringVar(&c.targetAddress, "target-address", "127.0.0.1",
+		"Address on which apcupsd is running, optionally followed by the port of its network information server")
+	flags.StringVar(&c.fallbackTargets, "fallback-targets", "",
+		"Comma separated addresses the values are loaded from once the target address isn't reachable, in the "+
+			"given order. The target address is tried again once a minute (no fallback by default)")
+	flags.StringVar(&c.dataSource, "source", DataSourceApcaccess,
+		"How the values are loaded from apcupsd, either \"apcaccess\" to invoke the apcaccess executable or "+
+			"\"nis\" to request them from the network information server of apcupsd directly or \"file\" to read "+
+			"the status file of apcupsd or \"nut\" to pass through the variables of a UPS of another NUT server, "+
+			"whose upsd runs at the target address, or \"snmp\" to request them from the network management card "+
+			"at the target address, or \"aggregate\" to combine the values of the UPSes configured as members "+
+			"(per UPS only), or \"dummy\" to simulate a UPS by the scenario file, or \"replay\" to feed the "+
+			"snapshots of the replay directory back, or \"ssh\" to invoke apcaccess on the target host by using SSH")
+	flags.StringVar(&c.scenarioFile, "scenario-file", "",
+		"Scenario file simulated by the \"dummy\" source, containing \"KEY: value\" lines with the keys of "+
+			"apcupsd and \"TIMER <seconds>\" lines to report the values given before for that time, like the "+
+			".dev files of the dummy-ups driver of NUT. If it ends with a timer, the scenario repeats")
+	flags.StringVar(&c.replayDir, "replay-dir", "",
+		"Directory containing the snapshots replayed by the \"replay\" source, one per reload in the order they "+
+			"were recorded, e.g. a subdirectory of -record")
+	flags.StringVar(&c.sshUser, "ssh-user", "",
+		"User logging in to the target host by the \"ssh\" source")
+	flags.StringVar(&c.sshKeyFile, "ssh-key", "",
+		"Private key the \"ssh\" source authenticates with")
+	flags.StringVar(&c.sshKnownHostsFile, "ssh-known-hosts", "",
+		"Known hosts file containing the host key of the target host, verified by the \"ssh\" source")
+	flags.StringVar(&c.recordDir, "record", "",
+		"Directory every snapshot loaded from the source is saved to, in a subdirectory per UPS, to replay it later "+
+			"on (disabled by default)")
+	flags.StringVar(&c.statusFile, "status-file", "/var/log/apcupsd.status",
+		"Status file of apcupsd read by the \"file\" source, configured by STATFILE in apcupsd.conf")
 
-	flag.StringVar(&c.upsName, "ups-name", "ups",
+	flags.StringVar(&c.upstreamUpsName, "upstream-ups", "",
+		"Name of the UPS on the NUT server read by the \"nut\" source (defaults to the name of the UPS)")
+	flags.StringVar(&c.snmpCommunity, "snmp-community", "public",
+		"SNMP community used by the \"snmp\" source, prefer -snmp-community-file as the command line is visible "+
+			"to all users of the host")
+	flags.StringVar(&c.snmpCommunityFile, "snmp-community-file", "",
+		"File containing the SNMP community used by the \"snmp\" source, it replaces -snmp-community")
+	flags.StringVar(&c.eventsFile, "events-file", "",
+		"Events file of apcupsd, configured by EVENTSFILE in apcupsd.conf. The most recent events are listed by the "+
+			"vendor command LIST EVENTS and served at /events by the metrics listener (disabled by default)")
+	flags.DurationVar(&c.eventsWatchInterval, "events-watch-interval", 0,
+		"Interval in which the events files are checked for new events of apcupsd, which refresh the values of the "+
+			"UPS right away, e.g. \"500ms\" (disabled by default)")
+	flags.IntVar(&c.maxEvents, "max-events", 50,
+		"Maximum number of the most recent events that are listed")
+	flags.StringVar(&c.upsName, "ups-name", "ups",
 		"Name of the UPS")
-	flag.StringVar(&c.upsDescription, "ups-description",
+	flags.StringVar(&c.upsDescription, "ups-description",
 		"apcupsd NUT proxy", "Short description of the UPS")
+	flags.Var(&c.upsSpecs, "ups",
+		"Name of a UPS followed by comma separated options, may be used multiple times to serve several UPSes "+
+			"instead of -ups-name. Options are \"target=<address>\", \"fallback=<addresses>\", "+
+			"\"source=<apcaccess|nis|file|nut|snmp|aggregate|dummy|replay|ssh>\", \"members=<names>\", "+
+			"\"scenario-file=<path>\", \"replay-dir=<path>\", \"ssh-user=<user>\", \"ssh-key=<path>\", "+
+			"\"ssh-known-hosts=<path>\", "+
+			"\"status-file=<path>\", \"upstream-ups=<name>\", \"community=<community>\", "+
+			"\"events-file=<path>\", \"description=<text>\", \"poll-interval=<duration>\" and "+
+			"\"var.<name>=<value>\" to replace a variable with a fixed value, e.g. "+
+			"\"rack,target=192.168.0.10,source=nis\". Options not given default to the global flags")
+	flags.StringVar(&c.upsFile, "ups-file", "",
+		"File containing further UPSes in a format similar to the ups.conf file of NUT, one section per UPS with "+
+			"the options of -ups as settings, e.g. \"target = 192.168.0.10\"")
+	flags.StringVar(&c.discoverNetworks, "discover", "",
+		"Comma separated list of networks, like \"192.168.0.0/24\", scanned for apcupsd instances on startup. Each "+
+			"network information server found is served as additional UPS, named like apcupsd names it "+
+			"(disabled by default)")
+	flags.StringVar(&c.discoverPort, "discover-port", nisDefaultPort,
+		"Port of the network information servers scanned for by -discover")
+	flags.DurationVar(&c.discoverTimeout, "discover-timeout", time.Second,
+		"Time a host may take to answer while scanning for apcupsd instances")
+	flags.DurationVar(&c.pollInterval, "poll-interval", 0,
+		"Minimum time between loading the values from apcupsd, commands within this time use the values loaded "+
+			"before by the same connection (by default they are loaded for every command)")
+	flags.DurationVar(&c.cacheTTL, "cache-ttl", 0,
+		"Time the values loaded from the source are shared by all connections, so several clients don't load them "+
+			"repeatedly (by default every connection loads them itself)")
+	flags.IntVar(&c.sourceRetries, "source-retries", 0,
+		"Number of times a failed load from the source is retried before the values are reported as stale")
+	flags.DurationVar(&c.sourceRetryBackoff, "source-retry-backoff", 100*time.Millisecond,
+		"Delay before the first retry of a failed load, it doubles after every retry")
+	flags.StringVar(&c.snapshotDir, "snapshot-dir", "",
+		"Directory in which the last known values of every UPS are persisted, so they are answered after a restart "+
+			"while the source fails. Requires -max-data-age (disabled by default)")
+	flags.DurationVar(&c.minReloadInterval, "min-reload-interval", 2*time.Second,
+		"Minimum time between two loads from the source, clients reloading within it get the values of the last "+
+			"load (0 disables it, not applied with -single-value-requests)")
+	flags.DurationVar(&c.maxDataAge, "max-data-age", 0,
+		"Age up to which the last values loaded successfully are answered while the source fails, the variable "+
+			"proxy.data.stale tells clients about it (by default the values are reported as stale immediately)")
+	flags.DurationVar(&c.cacheMaxStaleness, "cache-max-staleness", 0,
+		"Age up to which expired cached values are still answered immediately, while they are refreshed in the "+
+			"background. Older values are loaded before answering (by default expired values are never answered)")
+	flags.DurationVar(&c.backgroundPollInterval, "background-poll", 0,
+		"Interval in which the cached values are refreshed in the background, independent of the clients, so "+
+			"commands are answered from memory. Requires a longer -cache-ttl (disabled by default)")
+	flags.DurationVar(&c.backgroundPollJitter, "background-poll-jitter", 0,
+		"Maximum random delay added to every background poll interval, so proxies polling the same apcupsd don't "+
+			"do so at the same time. The -cache-ttl has to be longer than the interval plus the jitter (disabled by "+
+			"default)")
+	flags.BoolVar(&c.singleValueRequests, "single-value-requests", false,
+		"Load only the values needed by GET VAR by invoking \"apcaccess -p\" once per value, unless all values "+
+			"were loaded within the poll interval anyway (\"apcaccess\" source only, can't be combined with "+
+			"-fallback-targets, -source-retries, -record, -cache-ttl and -max-data-age)")
+	flags.DurationVar(&c.resolveTTL, "resolve-ttl", 0,
+		"Time the IP a target host name resolved to is reused, the host name is resolved again afterwards or once "+
+			"the target isn't reachable (by default it is resolved on every reload)")
 
-	flag.DurationVar(&c.timeout, "timeout", time.Duration(30)*time.Second,
+	flags.DurationVar(&c.timeout, "timeout", time.Duration(30)*time.Second,
 		"Timeout in seconds waiting for a response or sending the response. "+
 			"For example \"30s\". Valid time units are \"ns\", \"us\" (or \"µs\"), \"ms\", \"s\", \"m\", \"h\".")
 
-	flag.StringVar(&c.apcAccessExecutable, "apcaccess-executable", "apcaccess",
+	flags.DurationVar(&c.firstCommandTimeout, "first-command-timeout", 10*time.Second,
+		"Time a client may take to send its first command after connecting (0 uses -timeout)")
+	flags.DurationVar(&c.idleTimeout, "idle-timeout", 0,
+		"Time a client may stay idle between its commands (0 uses -timeout)")
+	flags.DurationVar(&c.readTimeout, "read-timeout", 0,
+		"Time a client may take to send a command, once its first byte was received (0 uses -timeout)")
+	flags.DurationVar(&c.writeTimeout, "write-timeout", 0,
+		"Time a client may take to receive a response (0 uses -timeout)")
+	flags.DurationVar(&c.byteTimeout, "byte-timeout", 0,
+		"Time a client may pause while sending a command, once its first byte was received. This closes connections "+
+			"of clients sending commands byte by byte (disabled by default)")
+	flags.DurationVar(&c.maxSessionDuration, "max-session-duration", 0,
+		"Maximum duration of a connection, it is closed afterwards and the client has to reconnect "+
+			"(unlimited by default)")
+	flags.DurationVar(&c.sessionCommandWait, "session-command-wait", 2*time.Second,
+		"Time a session command like LOGOUT waits for the pending data command of the client, which is answered "+
+			"with ERR DATA-STALE afterwards, so a slow data source doesn't block the session. NUT clients match the "+
+			"responses by their order, so the session command is always answered after the data command, use e.g. "+
+			"1ms to answer both right away (0 waits until the data command times out)")
+
+	flags.DurationVar(&c.reapIdleAfter, "reap-idle-after", 0,
+		"Time after which connections not sending any command are closed, even if their timeouts failed, at least "+
+			"1s (disabled by default)")
+	flags.DurationVar(&c.selfCheckInterval, "self-check-interval", 0,
+		"Interval in which the number of goroutines and connections is logged, to notice leaks (disabled by default)")
+	flags.DurationVar(&c.shutdownGracePeriod, "shutdown-grace-period", 10*time.Second,
+		"Time connections may take to finish their command on shutdown (SIGINT or SIGTERM), they are closed "+
+			"afterwards")
+
+	flags.IntVar(&c.maxLineLength, "max-line-length", 1024,
+		"Maximum length of a command in bytes, longer commands will be rejected")
+
+	flags.StringVar(&c.apcAccessExecutable, "apcaccess-executable", "apcaccess",
 		"APC Access executable")
+	flags.StringVar(&c.apcAccessArgs, "apcaccess-args", "",
+		"Further arguments passed to apcaccess, separated by spaces, e.g. \"-f /etc/apcupsd/apcupsd.conf\"")
+	flags.StringVar(&c.apcAccessEnv, "apcaccess-env", "",
+		"Comma separated environment variables set for apcaccess in addition to the ones of the proxy, e.g. "+
+			"\"LANG=C\"")
+	flags.DurationVar(&c.execTimeout, "exec-timeout", 0,
+		"Time after which a hanging apcaccess is killed (by default it is only limited by -timeout)")
+	flags.BoolVar(&c.apcAccessStripUnits, "apcaccess-strip-units", false,
+		"Strip the units of the values by the proxy instead of passing -u to apcaccess, for older apcupsd versions "+
+			"that don't support it")
+
+	flags.IntVar(&c.maxExecutions, "max-executions", 4,
+		"Maximum number of apcaccess processes running at the same time for all UPSes, further ones wait for a "+
+			"free worker (0 means unlimited)")
+	flags.IntVar(&c.maxQueuedExecutions, "max-queued-executions", 32,
+		"Maximum number of apcaccess executions waiting for a free worker, further ones fail right away")
+	flags.StringVar(&c.apcupsdExecutable, "apcupsd-executable", "apcupsd",
+		"apcupsd executable used to execute instant commands")
+	flags.StringVar(&c.apctestExecutable, "apctest-executable", "apctest",
+		"apctest executable used to execute the self test instant commands, apctest can't access the UPS while "+
+			"apcupsd is running, so this has to be a wrapper which stops apcupsd around the call")
+	flags.StringVar(&c.enabledCmds, "instcmds", "",
+		"Comma separated list of instant commands that may be executed by clients, supported are "+
+			"\"shutdown.return\", \"test.battery.start\", \"test.panel.start\", \"beeper.enable\", "+
+			"\"beeper.disable\" and \"beeper.mute\" "+
+			"(none are enabled by default)")
+	flags.StringVar(&c.fsdCommand, "fsd-command", "",
+		"Command that will be executed once a client requested a forced shutdown by using FSD, "+
+			"e.g. \"apcupsd --killpower\" (nothing is executed by default)")
+	flags.StringVar(&c.usersFile, "users-file", "",
+		"File containing the users that may authenticate and the actions they may perform, using the format of "+
+			"the upsd.users file of NUT (if not set all credentials are accepted and all actions are allowed)")
+	flags.StringVar(&c.allowedNetworksList, "allowed-networks", "",
+		"Comma separated list of networks clients may connect from, in CIDR notation like \"192.168.0.0/24\" or "+
+			"as single addresses (if not set all clients are allowed)")
+	flags.StringVar(&c.unlistedClients, "unlisted-clients", UnlistedClientsReject,
+		"How to handle clients that are not within the allowed networks, either \"reject\" to close their "+
+			"connections or \"limited\" to only allow discovering the UPS by using LIST UPS")
+	flags.BoolVar(&c.proxyProtocol, "proxy-protocol", false,
+		"Expect a PROXY protocol v1 or v2 header on every connection, as sent by load balancers like HAProxy, and "+
+			"use the client address it contains. Only enable it if clients can't connect without the load balancer")
+	flags.StringVar(&c.proxyProtocolTrustedList, "proxy-protocol-trusted", "",
+		"Comma separated list of networks of the load balancers whose PROXY protocol headers are accepted, in CIDR "+
+			"notation or as single addresses, connections from other peers are rejected (if not set the headers of "+
+			"all peers are accepted, which allows any client to spoof its address)")
+	flags.IntVar(&c.maxClientConnections, "max-client-connections", 0,
+		"Maximum number of simultaneous connections from a single client address, further connections are "+
+			"rejected (0 means unlimited)")
+	flags.IntVar(&c.maxConnections, "max-connections", 0,
+		"Maximum number of simultaneous connections of all clients (0 means unlimited)")
+	flags.StringVar(&c.connectionOverflow, "connection-overflow", ConnectionOverflowReject,
+		"How to handle connections exceeding the maximum number of connections, either \"reject\" to close them "+
+			"right away or \"queue\" to accept them once another connection was closed")
+	flags.DurationVar(&c.tcpKeepAlive, "tcp-keepalive", 0,
+		"Interval of the TCP keepalive probes detecting vanished clients (0 uses the default of 15s, a negative "+
+			"value disables them)")
+	flags.BoolVar(&c.tcpNoDelay, "tcp-nodelay", true,
+		"Send responses right away instead of combining small ones into fewer packets (TCP_NODELAY)")
+	flags.IntVar(&c.listenBacklog, "listen-backlog", 0,
+		"Maximum number of connections waiting to be accepted by a listener (0 uses the system default)")
+	flags.IntVar(&c.authFailureThreshold, "auth-failure-threshold", 5,
+		"Number of failed authentications in a row after which the client address is banned (0 disables bans)")
+	flags.DurationVar(&c.authBanDuration, "auth-ban-duration", 10*time.Minute,
+		"Duration for which client addresses are banned after too many failed authentications")
+	flags.DurationVar(&c.authFailureDelay, "auth-failure-delay", time.Second,
+		"Delay of the response to a failed authentication, doubled with each further failure up to 30s")
+	flags.StringVar(&c.metricsAddress, "metrics-address", "",
+		"Address on which metrics are served in the expvar JSON format at /debug/vars, e.g. \"127.0.0.1:9101\" "+
+			"(disabled by default)")
+	flags.StringVar(&c.runAsUser, "user", "",
+		"User, by name or id, the proxy switches to once it started listening, e.g. \"nut\". This allows binding "+
+			"privileged ports as root. The state file has to be writable by this user")
+	flags.StringVar(&c.runAsGroup, "group", "",
+		"Group, by name or id, the proxy switches to once it started listening (defaults to the group of the user)")
+	flags.StringVar(&c.auditLogTarget, "audit-log", "",
+		"File to which LOGIN, FSD, INSTCMD and SET VAR commands are appended with their user, client address and "+
+			"result, or \"syslog\" to send them to the local syslog daemon (disabled by default)")
+	flags.StringVar(&c.writableVars, "writable-vars", "",
+		"Comma separated list of variables clients may change by using SET VAR, the values are stored by the proxy "+
+			"and override the values reported by apcupsd, supported are \"battery.charge.low\", "+
+			"\"battery.runtime.low\" and \"ups.delay.shutdown\" (none are writable by default)")
+	flags.StringVar(&c.varMappings, "var-map", "",
+		"Comma separated mappings of variables to the values they report, overriding the built-in ones, e.g. "+
+			"\"ups.load=apc:LOADPCT,ups.realpower.nominal=apc:NOMPOWER|fixed:600,battery.runtime=minutes:TIMELEFT\". "+
+			"Supported are \"apc:<key>[|<fallback>]\", \"minutes:<key>[|<fallback>]\" converting minutes to "+
+			"seconds, \"fixed:<value>\", \"var:<name>\" using the loader of another variable and "+
+			"\"expr:<expression>\" computing a number from apc keys, e.g. \"ups.realpower=expr:LOADPCT/100*NOMPOWER\". "+
+			"Conversion rules may follow separated by \";\", e.g. \"ups.temperature=apc:ITEMP;celsius-to-fahrenheit;"+
+			"precision:1\", supported are \"minutes-to-seconds\", \"fahrenheit-to-celsius\", "+
+			"\"celsius-to-fahrenheit\", \"scale:<factor>\", \"precision:<decimals>\" and \"strip:<suffix>\"")
+	flags.StringVar(&c.staticVars, "static-vars", "",
+		"Comma separated variables with fixed values, which are listed along with the ones reported by apcupsd, "+
+			"e.g. \"device.location=Server room,device.contact=admin@example.com\". They replace the synthetic values of "+
+			"the variables apcupsd doesn't report, like \"battery.charge.warning=50\", \"ups.id=APC\" or "+
+			"\"driver.name=usbhid-ups\", and can't be changed by clients. Use -static-var for values containing "+
+			"commas")
+	flags.Var(&c.staticVarSpecs, "static-var",
+		"Variable with a fixed value like the ones of -static-vars, may be used multiple times. The value is taken "+
+			"as is, so it may contain commas, e.g. \"device.location=Server room, Rack 2\"")
+	flags.StringVar(&c.excludedVars, "exclude-vars", "",
+		"Comma separated list of variables hidden from the clients, e.g. \"device.serial,ups.serial\" or "+
+			"\"ups.temperature\" for models reporting garbage. They aren't listed and reading them fails with "+
+			"VAR-NOT-SUPPORTED (none are excluded by default)")
+	flags.StringVar(&c.stateFile, "state-file", "",
+		"File in which the values written by clients and the beeper status are persisted "+
+			"(if not set they are lost on restart)")
+	flags.StringVar(&c.eepromVars, "eeprom-vars", "",
+		"Comma separated list of variables clients may change in the EEPROM of the UPS by using SET VAR, supported are "+
+			"\"input.sensitivity\", \"input.transfer.high\", \"input.transfer.low\", \"battery.runtime.low\" and "+
+			"\"ups.delay.shutdown\" (none are writable by default, requires -eeprom-command)")
+	flags.StringVar(&c.eepromCommand, "eeprom-command", "",
+		"Command that will be executed to change a value in the EEPROM, e.g. a wrapper around apctest like "+
+			"\"apc-eeprom {var} {value}\", the placeholders are replaced by the variable name and the new value. "+
+			"The new value must be reported by apcupsd afterwards, otherwise SET VAR fails (disabled by default)")
+
+	c.logLevel = LogLevelInfo
+	flags.Var(&c.logLevel, "log-level",
+		"Verbosity of the log output, one of \"error\", \"warn\", \"info\" or \"debug\"")
+
+	flags.BoolVar(&c.showVersion, "version", false,
+		"Print the version and exit")
+}
+
+// parseFlags parses the arguments with the given flags and prepares the variables and instant commands accordingly.
+func (c *Config) parseFlags(flags *flag.FlagSet, args []string) error {
+	if err := flags.Parse(args); err != nil {
+		return errors.WithStack(err)
+	}
+
+	c.filterEnabledCmds()
+	c.applyVarMappings()
+	c.applyStaticVars()
+	c.enableWritableVars()
+	c.enableEepromVars()
+	c.excludeVars(splitList(c.excludedVars))
+
+	return nil
+}
+
+// filterEnabledCmds removes all instant commands that were not explicitly enabled.
+func (c *Config) filterEnabledCmds() {
+	enabled := make(map[string]bool)
+	for _, name := range strings.Split(c.enabledCmds, ",") {
+		enabled[strings.TrimSpace(name)] = true
+	}
+
+	for name := range c.cmds {
+		if !enabled[name] {
+			delete(c.cmds, name)
+		}
+	}
+}
+
+// variables that can be made writable, their values are stored by the proxy
+var localWritableVars = map[string]bool{
+	"battery.charge.low":  true,
+	"battery.runtime.low": true,
+	"ups.delay.shutdown":  true,
+}
+
+// enableWritableVars marks the configured variables as writable and lets their values be overridden by the values
+// written by clients. Unsupported variables are skipped, they are reported by validate.
+func (c *Config) enableWritableVars() {
+	for _, name := range splitList(c.writableVars) {
+		loader, ok := c.vars[name]
+		if !ok || !localWritableVars[name] {
+			continue
+		}
+
+		c.vars[name] = LocalValue(loader)
+
+		info := c.varInfos[name]
+		info.writable = true
+		c.varInfos[name] = info
+	}
+}
+
+// splitList splits a comma separated list and removes empty entries.
+func splitList(list string) []string {
+	var entries []string
+	for _, entry := range strings.Split(list, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			entries = append(entries, entry)
+		}
+	}
+
+	return entries
+}
+
+// enableEepromVars marks the configured variables as writable and changes their values in the EEPROM of the UPS once
+// written. Nothing is enabled without an EEPROM command, unsupported variables are reported by validate.
+func (c *Config) enableEepromVars() {
+	if c.eepromCommand == "" {
+		return
+	}
+
+	for _, name := range splitList(c.eepromVars) {
+		if _, ok := c.vars[name]; !ok || !eepromVars[name] {
+			continue
+		}
+
+		if c.varWriters == nil {
+			c.varWriters = make(map[string]VarWriter)
+		}
+		c.varWriters[name] = EepromVarWriter
+
+		info := c.varInfos[name]
+		info.writable = true
+		c.varInfos[name] = info
+	}
+}
+
+// loadState loads the values written by clients from the configured state file, if any.
+func (c *Config) loadState() error {
+	if c.stateFile == "" {
+		return nil
+	}
+
+	return errors.WithStack(c.state.load(c.stateFile))
+}
+
+// loadAllowedNetworks parses the configured networks clients may connect from and the networks of the trusted load
+// balancers.
+func (c *Config) loadAllowedNetworks() error {
+	networks, err := parseNetworks(c.allowedNetworksList)
+	if err != nil {
+		return errors.Wrap(err, "Invalid allowed networks")
+	}
+	c.allowedNetworks = networks
+
+	trusted, err := parseNetworks(c.proxyProtocolTrustedList)
+	if err != nil {
+		return errors.Wrap(err, "Invalid trusted PROXY protocol networks")
+	}
+	c.proxyProtocolTrusted = trusted
+
+	return nil
+}
+
+// loadSnmpCommunity reads the SNMP community from the configured file, if any.
+func (c *Config) loadSnmpCommunity() error {
+	if c.snmpCommunityFile == "" {
+		return nil
+	}
+
+	community, err := readSecretFile(c.snmpCommunityFile)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	c.snmpCommunity = community
+
+	return nil
+}
+
+// loadUsers loads the users from the configured users file, if any.
+func (c *Config) loadUsers() error {
+	if c.usersFile == "" {
+		return nil
+	}
+
+	users, err := loadUsersFile(c.usersFile)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	c.users = users
+
+	return nil
+}
+
+// validate checks the configuration and returns a descriptive error for the first invalid value.
+func (c *Config) validate() error {
+	if c.port < 1 || c.port > 65535 {
+		return errors.Errorf("Invalid port %d, must be between 1 and 65535", c.port)
+	}
+	if c.tlsPort < 0 || c.tlsPort > 65535 {
+		return errors.Errorf("Invalid TLS port %d, must be between 1 and 65535 or 0 to disable it", c.tlsPort)
+	}
+	if c.tlsPort == c.port {
+		return errors.Errorf("The TLS port %d must differ from the port", c.tlsPort)
+	}
+	if len(c.listenerSpecs) > 0 && c.tlsPort != 0 {
+		return errors.New("The TLS port can't be used together with listeners, use the tls option instead")
+	}
+	listeners, err := c.parseListeners()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	tlsRequired := false
+	for _, listener := range listeners {
+		tlsRequired = tlsRequired || listener.tls
+	}
+	if tlsRequired && c.acmeDomains == "" && (c.tlsCertFile == "" || c.tlsKeyFile == "") {
+		return errors.New("The TLS listener requires a certificate and a key, or ACME domains")
+	}
+	if c.acmeDomains != "" && len(splitList(c.acmeDomains)) == 0 {
+		return errors.Errorf("Invalid ACME domains %q, at least one domain is required", c.acmeDomains)
+	}
+	if c.acmeDomains != "" && !tlsRequired {
+		return errors.New("ACME certificates require the TLS listener")
+	}
+	if c.acmeDomains != "" && (c.tlsCertFile != "" || c.tlsKeyFile != "") {
+		return errors.New("A certificate and key can't be used together with ACME")
+	}
+	if c.acmeDomains != "" && c.acmeCacheDir == "" {
+		return errors.New("ACME requires a cache directory")
+	}
+	if c.tlsClientCAFile != "" && !tlsRequired {
+		return errors.New("Client certificates require the TLS listener")
+	}
+	if c.timeout <= 0 {
+		return errors.Errorf("Invalid timeout %s, must be positive", c.timeout)
+	}
+	if c.firstCommandTimeout < 0 {
+		return errors.Errorf("Invalid first command timeout %s, must not be negative", c.firstCommandTimeout)
+	}
+	if c.idleTimeout < 0 {
+		return errors.Errorf("Invalid idle timeout %s, must not be negative", c.idleTimeout)
+	}
+	if c.sessionCommandWait < 0 {
+		return errors.Errorf("Invalid session command wait %s, must not be negative", c.sessionCommandWait)
+	}
+	if c.readTimeout < 0 {
+		return errors.Errorf("Invalid read timeout %s, must not be negative", c.readTimeout)
+	}
+	if c.writeTimeout < 0 {
+		return errors.Errorf("Invalid write timeout %s, must not be negative", c.writeTimeout)
+	}
+	if c.byteTimeout < 0 {
+		return errors.Errorf("Invalid byte timeout %s, must not be negative", c.byteTimeout)
+	}
+	if c.fsdCommand != "" && len(strings.Fields(c.fsdCommand)) == 0 {
+		return errors.Errorf("Invalid FSD command %q, must not consist of whitespace only", c.fsdCommand)
+	}
+	for _, variable := range splitList(c.apcAccessEnv) {
+		if !strings.Contains(variable, "=") || strings.HasPrefix(variable, "=") {
+			return errors.Errorf("Invalid apcaccess environment variable %s, must be like NAME=value", variable)
+		}
+	}
+	if c.maxExecutions < 0 {
+		return errors.Errorf("Invalid maximum executions %d, must not be negative", c.maxExecutions)
+	}
+	if c.maxQueuedExecutions < 0 {
+		return errors.Errorf("Invalid maximum queued executions %d, must not be negative", c.maxQueuedExecutions)
+	}
+	if c.execTimeout < 0 {
+		return errors.Errorf("Invalid exec timeout %s, must not be negative", c.execTimeout)
+	}
+	if c.sourceRetries < 0 {
+		return errors.Errorf("Invalid number of source retries %d, must not be negative", c.sourceRetries)
+	}
+	if c.sourceRetries > 0 && c.sourceRetryBackoff <= 0 {
+		return errors.Errorf("Invalid source retry backoff %s, must be positive", c.sourceRetryBackoff)
+	}
+	if c.snapshotDir != "" && c.maxDataAge <= 0 {
+		return errors.New("Persisting the last known values requires a maximum data age")
+	}
+	if c.minReloadInterval < 0 {
+		return errors.Errorf("Invalid minimum reload interval %s, must not be negative", c.minReloadInterval)
+	}
+	if c.maxDataAge < 0 {
+		return errors.Errorf("Invalid maximum data age %s, must not be negative", c.maxDataAge)
+	}
+	if c.cacheTTL < 0 {
+		return errors.Errorf("Invalid cache TTL %s, must not be negative", c.cacheTTL)
+	}
+	if c.cacheMaxStaleness != 0 && c.cacheMaxStaleness <= c.cacheTTL {
+		return errors.Errorf("Invalid maximum staleness %s, must be longer than the cache TTL %s",
+			c.cacheMaxStaleness, c.cacheTTL)
+	}
+	if c.eventsWatchInterval < 0 {
+		return errors.Errorf("Invalid events watch interval %s, must not be negative", c.eventsWatchInterval)
+	}
+	if c.backgroundPollInterval < 0 {
+		return errors.Errorf("Invalid background poll interval %s, must not be negative", c.backgroundPollInterval)
+	}
+	if c.backgroundPollInterval > 0 && c.cacheTTL <= c.backgroundPollInterval {
+		return errors.Errorf("Invalid cache TTL %s, must be longer than the background poll interval %s",
+			c.cacheTTL, c.backgroundPollInterval)
+	}
+	if c.backgroundPollJitter < 0 {
+		return errors.Errorf("Invalid background poll jitter %s, must not be negative", c.backgroundPollJitter)
+	}
+	if c.backgroundPollInterval > 0 && c.cacheTTL <= c.backgroundPollInterval+c.backgroundPollJitter {
+		return errors.Errorf("Invalid cache TTL %s, must be longer than the background poll interval %s plus "+
+			"its jitter %s", c.cacheTTL, c.backgroundPollInterval, c.backgroundPollJitter)
+	}
+	if c.resolveTTL < 0 {
+		return errors.Errorf("Invalid resolve TTL %s, must not be negative", c.resolveTTL)
+	}
+	if c.maxSessionDuration < 0 {
+		return errors.Errorf("Invalid maximum session duration %s, must not be negative", c.maxSessionDuration)
+	}
+	if c.reapIdleAfter < 0 || c.reapIdleAfter > 0 && c.reapIdleAfter < minReapIdleAfter {
+		return errors.Errorf("Invalid idle reaping time %s, must be at least %s or 0 to disable it",
+			c.reapIdleAfter, minReapIdleAfter)
+	}
+	if c.selfCheckInterval < 0 {
+		return errors.Errorf("Invalid self-check interval %s, must not be negative", c.selfCheckInterval)
+	}
+	if c.shutdownGracePeriod < 0 {
+		return errors.Errorf("Invalid shutdown grace period %s, must not be negative", c.shutdownGracePeriod)
+	}
+	if c.maxLineLength <= 0 {
+		return errors.Errorf("Invalid maximum line length %d, must be positive", c.maxLineLength)
+	}
+	if c.unlistedClients != UnlistedClientsReject && c.unlistedClients != UnlistedClientsLimited {
+		return errors.Errorf("Invalid handling of unlisted clients %s, must be \"%s\" or \"%s\"",
+			c.unlistedClients, UnlistedClientsReject, UnlistedClientsLimited)
+	}
+	if c.maxClientConnections < 0 {
+		return errors.Errorf("Invalid maximum connections per client %d, must not be negative", c.maxClientConnections)
+	}
+	if c.maxConnections < 0 {
+		return errors.Errorf("Invalid maximum connections %d, must not be negative", c.maxConnections)
+	}
+	if c.listenBacklog < 0 {
+		return errors.Errorf("Invalid listen backlog %d, must not be negative", c.listenBacklog)
+	}
+	if c.connectionOverflow != ConnectionOverflowReject && c.connectionOverflow != ConnectionOverflowQueue {
+		return errors.Errorf("Invalid handling of connection overflows %s, must be \"%s\" or \"%s\"",
+			c.connectionOverflow, ConnectionOverflowReject, ConnectionOverflowQueue)
+	}
+	if c.authFailureThreshold < 0 {
+		return errors.Errorf("Invalid auth failure threshold %d, must not be negative", c.authFailureThreshold)
+	}
+	if c.authFailureThreshold > 0 && c.authBanDuration <= 0 {
+		return errors.Errorf("Invalid auth ban duration %s, must be positive", c.authBanDuration)
+	}
+	if c.authFailureDelay < 0 {
+		return errors.Errorf("Invalid auth failure delay %s, must not be negative", c.authFailureDelay)
+	}
+	for _, mapping := range splitList(c.varMappings) {
+		if _, _, err := parseVarMapping(mapping, c.vars); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	staticVars := make(map[string]bool)
+	for _, staticVar := range c.staticVarList() {
+		name, _, err := parseStaticVar(staticVar)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		staticVars[name] = true
+	}
+	localVars := make(map[string]bool)
+	for _, name := range splitList(c.writableVars) {
+		if !localWritableVars[name] {
+			return errors.Errorf("The variable %s can't be made writable", name)
+		}
+		if staticVars[name] {
+			return errors.Errorf("The static variable %s can't be made writable", name)
+		}
+		localVars[name] = true
+	}
+	eepromVarNames := splitList(c.eepromVars)
+	if len(eepromVarNames) > 0 && c.eepromCommand == "" {
+		return errors.New("Changing variables in the EEPROM requires an EEPROM command")
+	}
+	for _, name := range eepromVarNames {
+		if !eepromVars[name] {
+			return errors.Errorf("The variable %s can't be changed in the EEPROM", name)
+		}
+		if localVars[name] {
+			return errors.Errorf("The variable %s can't be stored locally and in the EEPROM", name)
+		}
+		if staticVars[name] {
+			return errors.Errorf("The static variable %s can't be changed in the EEPROM", name)
+		}
+	}
+	if c.discoverNetworks != "" {
+		networks, err := parseNetworks(c.discoverNetworks)
+		if err != nil {
+			return errors.Wrap(err, "Invalid discovery networks")
+		}
+		if _, err := discoveryHosts(networks); err != nil {
+			return errors.WithStack(err)
+		}
+		if c.discoverTimeout <= 0 {
+			return errors.Errorf("Invalid discovery timeout %s, must be positive", c.discoverTimeout)
+		}
+	}
+	upses, err := c.parseUpses()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	for _, ups := range upses {
+		if err := ups.validateUps(); err != nil {
+			return errors.WithStack(err)
+		}
+	}
 
-	flag.Parse()
+	return nil
 }
 
 // String returns the configuration as a string.
 func (c Config) String() string {
-	return fmt.Sprintf("Config(address=%s, port=%d, targetAddress=%s, "+
-		"upsName=\"%s\", upsDescription=\"%s\", apcAccessExecutable=%s, timeout=%s)",
-		c.address, c.port, c.targetAddress, c.upsName, c.upsDescription, c.timeout, c.apcAccessExecutable)
+	return fmt.Sprintf("Config(address=%s, port=%d, tlsPort=%d, tlsCert=%s, tlsKey=%s, tlsClientCA=%s, listen=%s, "+
+		"acmeDomains=%s, acmeEmail=%s, acmeCacheDir=%s, acmeHTTPAddress=%s, acmeDirectoryURL=%s, targetAddress=%s, "+
+		"fallbackTargets=%s, source=%s, statusFile=%s, scenarioFile=%s, replayDir=%s, record=%s, sshUser=%s, "+
+		"sshKey=%s, sshKnownHosts=%s, upstreamUps=%s, snmpCommunity=%s, snmpCommunityFile=%s, eventsFile=%s, "+
+		"maxEvents=%d, upsName=\"%s\", upsDescription=\"%s\", ups=%s, upsFile=%s, pollInterval=%s, resolveTTL=%s, "+
+		"cacheTTL=%s, cacheMaxStaleness=%s, sourceRetries=%d, sourceRetryBackoff=%s, minReloadInterval=%s, "+
+		"maxDataAge=%s, snapshotDir=%s, backgroundPoll=%s, backgroundPollJitter=%s, eventsWatchInterval=%s, "+
+		"singleValueRequests=%t, discover=%s, discoverPort=%s, discoverTimeout=%s, apcAccessExecutable=%s, "+
+		"apcAccessArgs=%s, apcAccessEnv=%s, apcAccessStripUnits=%t, execTimeout=%s, maxExecutions=%d, "+
+		"maxQueuedExecutions=%d, apcupsdExecutable=%s, apctestExecutable=%s, instcmds=%s, fsdCommand=%s, "+
+		"usersFile=%s, allowedNetworks=%s, unlistedClients=%s, proxyProtocol=%t, proxyProtocolTrusted=%s, "+
+		"maxClientConnections=%d, maxConnections=%d, connectionOverflow=%s, tcpKeepAlive=%s, tcpNoDelay=%t, "+
+		"listenBacklog=%d, authFailureThreshold=%d, authBanDuration=%s, authFailureDelay=%s, metricsAddress=%s, "+
+		"user=%s, group=%s, auditLog=%s, writableVars=%s, varMappings=%s, staticVars=%s, staticVar=%s, "+
+		"excludedVars=%s, stateFile=%s, eepromVars=%s, eepromCommand=%s, timeout=%s, firstCommandTimeout=%s, "+
+		"idleTimeout=%s, readTimeout=%s, writeTimeout=%s, byteTimeout=%s, maxSessionDuration=%s, "+
+		"sessionCommandWait=%s, shutdownGracePeriod=%s, reapIdleAfter=%s, selfCheckInterval=%s, maxLineLength=%d, "+
+		"logLevel=%s)",
+		c.address, c.port, c.tlsPort, c.tlsCertFile, c.tlsKeyFile, c.tlsClientCAFile, c.listenerSpecs.String(),
+		c.acmeDomains, c.acmeEmail, c.acmeCacheDir, c.acmeHTTPAddress, c.acmeDirectoryURL, c.targetAddress,
+		c.fallbackTargets, c.dataSource, c.statusFile, c.scenarioFile, c.replayDir, c.recordDir, c.sshUser,
+		c.sshKeyFile, c.sshKnownHostsFile, c.upstreamUpsName, redactSecret(c.snmpCommunity), c.snmpCommunityFile,
+		c.eventsFile, c.maxEvents, c.upsName, c.upsDescription, redactUpsSpecs(c.upsSpecs), c.upsFile, c.pollInterval,
+		c.resolveTTL, c.cacheTTL, c.cacheMaxStaleness, c.sourceRetries, c.sourceRetryBackoff, c.minReloadInterval,
+		c.maxDataAge, c.snapshotDir, c.backgroundPollInterval, c.backgroundPollJitter, c.eventsWatchInterval,
+		c.singleValueRequests, c.discoverNetworks, c.discoverPort, c.discoverTimeout, c.apcAccessExecutable,
+		c.apcAccessArgs, c.apcAccessEnv, c.apcAccessStripUnits, c.execTimeout, c.maxExecutions, c.maxQueuedExecutions,
+		c.apcupsdExecutable, c.apctestExecutable, c.enabledCmds, c.fsdCommand, c.usersFile, c.allowedNetworksList,
+		c.unlistedClients, c.proxyProtocol, c.proxyProtocolTrustedList, c.maxClientConnections, c.maxConnections,
+		c.connectionOverflow, c.tcpKeepAlive, c.tcpNoDelay, c.listenBacklog, c.authFailureThreshold, c.authBanDuration,
+		c.authFailureDelay, c.metricsAddress, c.runAsUser, c.runAsGroup, c.auditLogTarget, c.writableVars,
+		c.varMappings, c.staticVars, c.staticVarSpecs.String(), c.excludedVars, c.stateFile, c.eepromVars,
+		c.eepromCommand, c.timeout, c.firstCommandTimeout, c.idleTimeout, c.readTimeout, c.writeTimeout, c.byteTimeout,
+		c.maxSessionDuration, c.sessionCommandWait, c.shutdownGracePeriod, c.reapIdleAfter, c.selfCheckInterval,
+		c.maxLineLength, c.logLevel)
 }
@@ -16,24 +16,461 @@ package main
 
 import (
 	"github.com/stretchr/testify/assert"
+	"io"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 )
 
 func TestConfig_loadProgramArgs(t *testing.T) {
 	config := &Config{}
-	config.loadProgramArgs()
+	assert.NoError(t, config.loadProgramArgs("serve", nil, io.Discard))
 
 	assert.Equal(t, "127.0.0.1", config.address)
 	assert.Equal(t, 3493, config.port)
 	assert.Equal(t, "127.0.0.1", config.targetAddress)
+	assert.Equal(t, "apcaccess", config.dataSource)
+	assert.Equal(t, "/var/log/apcupsd.status", config.statusFile)
 	assert.Equal(t, "ups", config.upsName)
 	assert.Equal(t, "apcupsd NUT proxy", config.upsDescription)
 	assert.Equal(t, "apcaccess", config.apcAccessExecutable)
+	assert.Equal(t, "apcupsd", config.apcupsdExecutable)
+	assert.Equal(t, "apctest", config.apctestExecutable)
+	assert.Equal(t, "", config.enabledCmds)
+	assert.Equal(t, "", config.fsdCommand)
+	assert.Equal(t, "", config.usersFile)
+	assert.Equal(t, 0, config.tlsPort)
+	assert.Empty(t, config.listenerSpecs)
+	assert.Equal(t, "", config.acmeDomains)
+	assert.Equal(t, "", config.acmeEmail)
+	assert.Equal(t, "acme", config.acmeCacheDir)
+	assert.Equal(t, ":80", config.acmeHTTPAddress)
+	assert.Equal(t, "", config.acmeDirectoryURL)
+	assert.Equal(t, "", config.allowedNetworksList)
+	assert.Equal(t, "reject", config.unlistedClients)
+	assert.False(t, config.proxyProtocol)
+	assert.Equal(t, "", config.proxyProtocolTrustedList)
+	assert.Equal(t, 0, config.maxClientConnections)
+	assert.Equal(t, 0, config.maxConnections)
+	assert.Equal(t, "reject", config.connectionOverflow)
+	assert.Equal(t, 5, config.authFailureThreshold)
+	assert.Equal(t, 10*time.Minute, config.authBanDuration)
+	assert.Equal(t, time.Second, config.authFailureDelay)
+	assert.Equal(t, "", config.metricsAddress)
+	assert.Equal(t, "", config.runAsUser)
+	assert.Equal(t, "", config.runAsGroup)
+	assert.Equal(t, "", config.auditLogTarget)
+	assert.Equal(t, "", config.writableVars)
+	assert.Equal(t, "", config.varMappings)
+	assert.Equal(t, "", config.staticVars)
+	assert.Equal(t, "", config.excludedVars)
+	assert.Equal(t, "", config.stateFile)
 	assert.Equal(t, time.Duration(30) * time.Second, config.timeout)
+	assert.Equal(t, 10*time.Second, config.firstCommandTimeout)
+	assert.Equal(t, time.Duration(0), config.idleTimeout)
+	assert.Equal(t, 2*time.Second, config.sessionCommandWait)
+	assert.Equal(t, time.Duration(0), config.readTimeout)
+	assert.Equal(t, time.Duration(0), config.writeTimeout)
+	assert.Equal(t, time.Duration(0), config.byteTimeout)
+	assert.Equal(t, time.Duration(0), config.maxSessionDuration)
+	assert.Equal(t, 10*time.Second, config.shutdownGracePeriod)
+	assert.Equal(t, time.Duration(0), config.tcpKeepAlive)
+	assert.True(t, config.tcpNoDelay)
+	assert.Equal(t, 0, config.listenBacklog)
+	assert.Equal(t, 2*time.Second, config.minReloadInterval)
+	assert.Equal(t, 4, config.maxExecutions)
+	assert.Equal(t, 32, config.maxQueuedExecutions)
+	assert.Equal(t, 1024, config.maxLineLength)
+	assert.Equal(t, LogLevelInfo, config.logLevel)
+	assert.False(t, config.showVersion)
 	assert.Nil(t, config.vars)
 }
 
+func TestConfig_validate(t *testing.T) {
+	validConfig := func() *Config {
+		return &Config{
+			port:                3493,
+			upsName:             "ups",
+			timeout:             time.Second,
+			maxLineLength:       1024,
+			unlistedClients:     UnlistedClientsReject,
+			connectionOverflow:  ConnectionOverflowReject,
+			apcAccessExecutable: os.Args[0],
+			dataSource:          DataSourceApcaccess,
+		}
+	}
+
+	testCases := []struct {
+		name         string
+		modify       func(c *Config)
+		errorMessage string
+	}{
+		{"valid", func(c *Config) {}, ""},
+		{"port too low", func(c *Config) { c.port = 0 }, "Invalid port 0, must be between 1 and 65535"},
+		{"port too high", func(c *Config) { c.port = 65536 }, "Invalid port 65536, must be between 1 and 65535"},
+		{"tls port", func(c *Config) {
+			c.tlsPort = 3494
+			c.tlsCertFile = "cert.pem"
+			c.tlsKeyFile = "key.pem"
+		}, ""},
+		{"tls port without certificate", func(c *Config) { c.tlsPort = 3494 },
+			"The TLS listener requires a certificate and a key"},
+		{"acme", func(c *Config) {
+			c.tlsPort = 3494
+			c.acmeDomains = "ups.example.com"
+			c.acmeCacheDir = "acme"
+		}, ""},
+		{"acme without domains", func(c *Config) {
+			c.tlsPort = 3494
+			c.acmeDomains = ","
+			c.acmeCacheDir = "acme"
+		}, "Invalid ACME domains \",\", at least one domain is required"},
+		{"acme without tls port", func(c *Config) {
+			c.acmeDomains = "ups.example.com"
+			c.acmeCacheDir = "acme"
+		}, "ACME certificates require the TLS listener"},
+		{"acme with certificate", func(c *Config) {
+			c.tlsPort = 3494
+			c.tlsCertFile = "cert.pem"
+			c.acmeDomains = "ups.example.com"
+			c.acmeCacheDir = "acme"
+		}, "A certificate and key can't be used together with ACME"},
+		{"acme without cache dir", func(c *Config) {
+			c.tlsPort = 3494
+			c.acmeDomains = "ups.example.com"
+		}, "ACME requires a cache directory"},
+		{"tls port same as port", func(c *Config) { c.tlsPort = 3493 }, "The TLS port 3493 must differ from the port"},
+		{"tls listener", func(c *Config) {
+			c.listenerSpecs = stringListFlag{"127.0.0.1:3493", "0.0.0.0:3494,tls"}
+			c.tlsCertFile = "cert.pem"
+			c.tlsKeyFile = "key.pem"
+		}, ""},
+		{"tls listener without certificate", func(c *Config) { c.listenerSpecs = stringListFlag{"0.0.0.0:3494,tls"} },
+			"The TLS listener requires a certificate and a key"},
+		{"listeners with tls port", func(c *Config) {
+			c.listenerSpecs = stringListFlag{"127.0.0.1:3493"}
+			c.tlsPort = 3494
+		}, "The TLS port can't be used together with listeners"},
+		{"invalid listener", func(c *Config) { c.listenerSpecs = stringListFlag{"127.0.0.1"} },
+			"Invalid listener 127.0.0.1"},
+		{"client CA without tls port", func(c *Config) { c.tlsClientCAFile = "ca.pem" },
+			"Client certificates require the TLS listener"},
+		{"tls port too high", func(c *Config) { c.tlsPort = 65536 },
+			"Invalid TLS port 65536, must be between 1 and 65535 or 0 to disable it"},
+		{"empty UPS name", func(c *Config) { c.upsName = "" }, "The UPS name must not be empty"},
+		{"UPS name with spaces", func(c *Config) { c.upsName = "my ups" }, ""},
+		{"UPS name with quotes", func(c *Config) { c.upsName = "my \"ups\"" },
+			"Invalid UPS name my \"ups\", it must not contain quotes or backslashes"},
+		{"zero timeout", func(c *Config) { c.timeout = 0 }, "Invalid timeout 0s, must be positive"},
+		{"negative timeout", func(c *Config) { c.timeout = -time.Second }, "Invalid timeout -1s, must be positive"},
+		{"slowloris protection", func(c *Config) {
+			c.firstCommandTimeout = time.Second
+			c.byteTimeout = time.Second
+			c.maxSessionDuration = time.Hour
+		}, ""},
+		{"negative first command timeout", func(c *Config) { c.firstCommandTimeout = -time.Second },
+			"Invalid first command timeout -1s, must not be negative"},
+		{"negative idle timeout", func(c *Config) { c.idleTimeout = -time.Second },
+			"Invalid idle timeout -1s, must not be negative"},
+		{"negative session command wait", func(c *Config) { c.sessionCommandWait = -time.Second },
+			"Invalid session command wait -1s, must not be negative"},
+		{"negative read timeout", func(c *Config) { c.readTimeout = -time.Second },
+			"Invalid read timeout -1s, must not be negative"},
+		{"negative write timeout", func(c *Config) { c.writeTimeout = -time.Second },
+			"Invalid write timeout -1s, must not be negative"},
+		{"negative byte timeout", func(c *Config) { c.byteTimeout = -time.Second },
+			"Invalid byte timeout -1s, must not be negative"},
+		{"negative max session duration", func(c *Config) { c.maxSessionDuration = -time.Second },
+			"Invalid maximum session duration -1s, must not be negative"},
+		{"negative listen backlog", func(c *Config) { c.listenBacklog = -1 },
+			"Invalid listen backlog -1, must not be negative"},
+		{"negative idle reaping time", func(c *Config) { c.reapIdleAfter = -time.Second },
+			"Invalid idle reaping time -1s, must be at least 1s or 0 to disable it"},
+		{"too short idle reaping time", func(c *Config) { c.reapIdleAfter = time.Nanosecond },
+			"Invalid idle reaping time 1ns, must be at least 1s or 0 to disable it"},
+		{"idle reaping time", func(c *Config) { c.reapIdleAfter = time.Second }, ""},
+		{"negative self-check interval", func(c *Config) { c.selfCheckInterval = -time.Second },
+			"Invalid self-check interval -1s, must not be negative"},
+		{"negative shutdown grace period", func(c *Config) { c.shutdownGracePeriod = -time.Second },
+			"Invalid shutdown grace period -1s, must not be negative"},
+		{"negative resolve ttl", func(c *Config) { c.resolveTTL = -time.Second },
+			"Invalid resolve TTL -1s, must not be negative"},
+		{"negative exec timeout", func(c *Config) { c.execTimeout = -time.Second },
+			"Invalid exec timeout -1s, must not be negative"},
+		{"source retries", func(c *Config) {
+			c.sourceRetries = 3
+			c.sourceRetryBackoff = time.Second
+		}, ""},
+		{"negative source retries", func(c *Config) { c.sourceRetries = -1 },
+			"Invalid number of source retries -1, must not be negative"},
+		{"source retries without backoff", func(c *Config) { c.sourceRetries = 3 },
+			"Invalid source retry backoff 0s, must be positive"},
+		{"negative max executions", func(c *Config) { c.maxExecutions = -1 },
+			"Invalid maximum executions -1, must not be negative"},
+		{"negative max queued executions", func(c *Config) { c.maxQueuedExecutions = -1 },
+			"Invalid maximum queued executions -1, must not be negative"},
+		{"negative min reload interval", func(c *Config) { c.minReloadInterval = -time.Second },
+			"Invalid minimum reload interval -1s, must not be negative"},
+		{"negative events watch interval", func(c *Config) { c.eventsWatchInterval = -time.Second },
+			"Invalid events watch interval -1s, must not be negative"},
+		{"snapshot dir without max data age", func(c *Config) { c.snapshotDir = "/var/lib/apcupsd-nut-proxy" },
+			"Persisting the last known values requires a maximum data age"},
+		{"negative max data age", func(c *Config) { c.maxDataAge = -time.Second },
+			"Invalid maximum data age -1s, must not be negative"},
+		{"negative cache ttl", func(c *Config) { c.cacheTTL = -time.Second },
+			"Invalid cache TTL -1s, must not be negative"},
+		{"background poll", func(c *Config) {
+			c.backgroundPollInterval = 10 * time.Second
+			c.cacheTTL = 30 * time.Second
+		}, ""},
+		{"background poll without cache", func(c *Config) { c.backgroundPollInterval = 10 * time.Second },
+			"Invalid cache TTL 0s, must be longer than the background poll interval 10s"},
+		{"negative background poll jitter", func(c *Config) { c.backgroundPollJitter = -time.Second },
+			"Invalid background poll jitter -1s, must not be negative"},
+		{"background poll jitter exceeding the cache", func(c *Config) {
+			c.backgroundPollInterval = 10 * time.Second
+			c.backgroundPollJitter = 20 * time.Second
+			c.cacheTTL = 30 * time.Second
+		}, "Invalid cache TTL 30s, must be longer than the background poll interval 10s plus its jitter 20s"},
+		{"cache max staleness", func(c *Config) {
+			c.cacheTTL = 10 * time.Second
+			c.cacheMaxStaleness = time.Minute
+		}, ""},
+		{"cache max staleness within ttl", func(c *Config) {
+			c.cacheTTL = 10 * time.Second
+			c.cacheMaxStaleness = 5 * time.Second
+		}, "Invalid maximum staleness 5s, must be longer than the cache TTL 10s"},
+		{"apcaccess environment", func(c *Config) { c.apcAccessEnv = "LANG=C, TZ=UTC" }, ""},
+		{"fsd command", func(c *Config) { c.fsdCommand = "/usr/local/bin/notify-fsd --all" }, ""},
+		{"blank fsd command", func(c *Config) { c.fsdCommand = "  " },
+			"Invalid FSD command \"  \", must not consist of whitespace only"},
+		{"invalid apcaccess environment", func(c *Config) { c.apcAccessEnv = "LANG" },
+			"Invalid apcaccess environment variable LANG, must be like NAME=value"},
+		{"zero max line length", func(c *Config) { c.maxLineLength = 0 },
+			"Invalid maximum line length 0, must be positive"},
+		{"limited unlisted clients", func(c *Config) { c.unlistedClients = "limited" }, ""},
+		{"invalid unlisted clients", func(c *Config) { c.unlistedClients = "drop" },
+			"Invalid handling of unlisted clients drop, must be \"reject\" or \"limited\""},
+		{"max client connections", func(c *Config) { c.maxClientConnections = 4 }, ""},
+		{"negative max client connections", func(c *Config) { c.maxClientConnections = -1 },
+			"Invalid maximum connections per client -1, must not be negative"},
+		{"queued connections", func(c *Config) {
+			c.maxConnections = 16
+			c.connectionOverflow = "queue"
+		}, ""},
+		{"negative max connections", func(c *Config) { c.maxConnections = -1 },
+			"Invalid maximum connections -1, must not be negative"},
+		{"invalid connection overflow", func(c *Config) { c.connectionOverflow = "drop" },
+			"Invalid handling of connection overflows drop, must be \"reject\" or \"queue\""},
+		{"auth bans", func(c *Config) {
+			c.authFailureThreshold = 5
+			c.authBanDuration = time.Minute
+		}, ""},
+		{"negative auth failure threshold", func(c *Config) { c.authFailureThreshold = -1 },
+			"Invalid auth failure threshold -1, must not be negative"},
+		{"auth bans without duration", func(c *Config) { c.authFailureThreshold = 5 },
+			"Invalid auth ban duration 0s, must be positive"},
+		{"negative auth failure delay", func(c *Config) { c.authFailureDelay = -time.Second },
+			"Invalid auth failure delay -1s, must not be negative"},
+		{"var mappings", func(c *Config) { c.varMappings = "ups.load=apc:LOADPCT, ups.power=apc:NOMPOWER|fixed:600" },
+			""},
+		{"invalid var mapping", func(c *Config) { c.varMappings = "ups.load=snmp:1.3.6" },
+			"Invalid mapping of variable ups.load: Unknown loader type snmp, must be \"apc\", \"minutes\", " +
+				"\"fixed\", \"var\" or \"expr\""},
+		{"static vars", func(c *Config) { c.staticVars = "device.location=Basement, device.contact=admin" }, ""},
+		{"static var with comma", func(c *Config) {
+			c.staticVarSpecs = stringListFlag{"device.location=Server room, Rack 2"}
+		}, ""},
+		{"invalid static var spec", func(c *Config) { c.staticVarSpecs = stringListFlag{"device.location"} },
+			"Invalid static variable device.location, must be <name>=<value>"},
+		{"invalid static var", func(c *Config) { c.staticVars = "device.location" },
+			"Invalid static variable device.location, must be <name>=<value>"},
+		{"writable static var", func(c *Config) {
+			c.staticVars = "battery.charge.low=20"
+			c.writableVars = "battery.charge.low"
+		}, "The static variable battery.charge.low can't be made writable"},
+		{"writable vars", func(c *Config) { c.writableVars = "battery.charge.low, ups.delay.shutdown" }, ""},
+		{"unsupported writable var", func(c *Config) { c.writableVars = "ups.status" },
+			"The variable ups.status can't be made writable"},
+		{"eeprom vars", func(c *Config) {
+			c.eepromVars = "input.sensitivity"
+			c.eepromCommand = "apc-eeprom {var} {value}"
+		}, ""},
+		{"eeprom vars without command", func(c *Config) { c.eepromVars = "input.sensitivity" },
+			"Changing variables in the EEPROM requires an EEPROM command"},
+		{"unsupported eeprom var", func(c *Config) {
+			c.eepromVars = "ups.status"
+			c.eepromCommand = "apc-eeprom {var} {value}"
+		}, "The variable ups.status can't be changed in the EEPROM"},
+		{"eeprom var stored locally", func(c *Config) {
+			c.writableVars = "ups.delay.shutdown"
+			c.eepromVars = "ups.delay.shutdown"
+			c.eepromCommand = "apc-eeprom {var} {value}"
+		}, "The variable ups.delay.shutdown can't be stored locally and in the EEPROM"},
+		{"nis source without apcaccess", func(c *Config) {
+			c.dataSource = "nis"
+			c.apcAccessExecutable = "apcaccess-does-not-exist"
+		}, ""},
+		{"invalid source", func(c *Config) { c.dataSource = "modbus" },
+			"Invalid source modbus, must be \"apcaccess\", \"nis\", \"file\", \"nut\", \"snmp\", \"aggregate\", " +
+				"\"dummy\", \"replay\" or \"ssh\""},
+		{"file source", func(c *Config) {
+			c.dataSource = "file"
+			c.statusFile = "/var/log/apcupsd.status"
+		}, ""},
+		{"file source without status file", func(c *Config) { c.dataSource = "file" },
+			"The file source requires a status file"},
+		{"dummy source", func(c *Config) {
+			c.dataSource = "dummy"
+			c.scenarioFile = "scenario.dev"
+		}, ""},
+		{"dummy source without scenario file", func(c *Config) { c.dataSource = "dummy" },
+			"The dummy source requires a scenario file"},
+		{"replay source without replay directory", func(c *Config) { c.dataSource = "replay" },
+			"The replay source requires a replay directory"},
+		{"ssh source", func(c *Config) {
+			c.dataSource = "ssh"
+			c.sshUser = "apcupsd"
+			c.sshKeyFile = "id_ed25519"
+			c.sshKnownHostsFile = "known_hosts"
+		}, ""},
+		{"ssh source without key", func(c *Config) {
+			c.dataSource = "ssh"
+			c.sshUser = "apcupsd"
+			c.sshKnownHostsFile = "known_hosts"
+		}, "The ssh source requires a user, a key and a known hosts file"},
+		{"file source with fallback targets", func(c *Config) {
+			c.dataSource = "file"
+			c.statusFile = "apcupsd.status"
+			c.fallbackTargets = "10.0.0.2"
+		}, "The file source doesn't support fallback targets"},
+		{"single value requests", func(c *Config) { c.singleValueRequests = true }, ""},
+		{"single value requests with nis source", func(c *Config) {
+			c.singleValueRequests = true
+			c.dataSource = DataSourceNis
+		}, "The nis source doesn't support single value requests"},
+		{"single value requests with cache", func(c *Config) {
+			c.singleValueRequests = true
+			c.cacheTTL = time.Second
+		}, "Single value requests can't be combined with fallback targets"},
+		{"single value requests with retries", func(c *Config) {
+			c.singleValueRequests = true
+			c.sourceRetries = 2
+			c.sourceRetryBackoff = time.Second
+		}, "Single value requests can't be combined with fallback targets"},
+		{"unknown executable", func(c *Config) { c.apcAccessExecutable = "apcaccess-does-not-exist" },
+			"The apcaccess executable \"apcaccess-does-not-exist\" couldn't be found"},
+		{"multiple upses", func(c *Config) {
+			c.upsSpecs = stringListFlag{"first", "second,target=192.168.0.10,source=nis"}
+		}, ""},
+		{"duplicate ups", func(c *Config) { c.upsSpecs = stringListFlag{"first", "first"} },
+			"The UPS first is configured more than once"},
+		{"invalid ups name", func(c *Config) { c.upsSpecs = stringListFlag{"\"quoted\""} },
+			"Invalid UPS name \"quoted\", it must not contain quotes or backslashes"},
+		{"ups with invalid source", func(c *Config) { c.upsSpecs = stringListFlag{"first,source=modbus"} },
+			"Invalid source modbus"},
+		{"aggregate ups", func(c *Config) {
+			c.upsSpecs = stringListFlag{"first", "second", "rack,source=aggregate,members=first second"}
+		}, ""},
+		{"aggregate ups without members", func(c *Config) { c.upsSpecs = stringListFlag{"rack,source=aggregate"} },
+			"The aggregate source requires members"},
+		{"discovery", func(c *Config) {
+			c.discoverNetworks = "192.168.0.0/24"
+			c.discoverTimeout = time.Second
+		}, ""},
+		{"invalid discovery network", func(c *Config) {
+			c.discoverNetworks = "192.168.0.300/24"
+			c.discoverTimeout = time.Second
+		}, "Invalid discovery networks"},
+		{"discovery network too large", func(c *Config) {
+			c.discoverNetworks = "10.0.0.0/8"
+			c.discoverTimeout = time.Second
+		}, "Too many addresses to discover"},
+		{"discovery without timeout", func(c *Config) { c.discoverNetworks = "192.168.0.0/24" },
+			"Invalid discovery timeout 0s, must be positive"},
+		{"events file", func(c *Config) {
+			c.eventsFile = "/var/log/apcupsd.events"
+			c.maxEvents = 10
+		}, ""},
+		{"events file without maximum", func(c *Config) { c.eventsFile = "/var/log/apcupsd.events" },
+			"Invalid maximum number of events 0, must be positive"},
+		{"negative poll interval", func(c *Config) { c.pollInterval = -time.Second },
+			"Invalid poll interval -1s of UPS ups, must not be negative"},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			config := validConfig()
+			testCase.modify(config)
+
+			err := config.validate()
+
+			if testCase.errorMessage == "" {
+				assert.NoError(t, err)
+			} else if assert.Error(t, err) {
+				assert.Contains(t, err.Error(), testCase.errorMessage)
+			}
+		})
+	}
+}
+
+func TestConfig_filterEnabledCmds(t *testing.T) {
+	config := &Config{
+		enabledCmds: "shutdown.return, beeper.mute",
+		cmds: map[string]InstCmd{
+			"shutdown.return":    ApcupsdInstCmd(),
+			"beeper.mute":        ApcupsdInstCmd(),
+			"test.battery.start": ApcupsdInstCmd(),
+		},
+	}
+
+	config.filterEnabledCmds()
+
+	assert.Len(t, config.cmds, 2)
+	assert.Contains(t, config.cmds, "shutdown.return")
+	assert.Contains(t, config.cmds, "beeper.mute")
+}
+
+func TestConfig_enableWritableVars(t *testing.T) {
+	config := &Config{
+		writableVars: "battery.charge.low, ups.status",
+		vars:         defaultVars(),
+		varInfos:     defaultVarInfos(),
+	}
+
+	config.enableWritableVars()
+
+	assert.Equal(t, []string{"battery.charge.low"}, config.writableVarNames())
+}
+
+func TestConfig_enableEepromVars(t *testing.T) {
+	config := &Config{
+		eepromVars:    "input.sensitivity, ups.status",
+		eepromCommand: "apc-eeprom {var} {value}",
+		vars:          defaultVars(),
+		varInfos:      defaultVarInfos(),
+	}
+
+	config.enableEepromVars()
+
+	assert.Equal(t, []string{"input.sensitivity"}, config.writableVarNames())
+	assert.Len(t, config.varWriters, 1)
+	assert.Contains(t, config.varWriters, "input.sensitivity")
+}
+
+func TestConfig_enableEepromVars_NoCommand(t *testing.T) {
+	config := &Config{
+		eepromVars: "input.sensitivity",
+		vars:       defaultVars(),
+		varInfos:   defaultVarInfos(),
+	}
+
+	config.enableEepromVars()
+
+	assert.Empty(t, config.writableVarNames())
+}
+
 func TestConfig_String(t *testing.T) {
 	config := &Config{
 		address:             "address",
@@ -56,3 +493,28 @@ func TestConfig_String(t *testing.T) {
 	assert.Contains(t, result, "apcAccessExecutable")
 	assert.Contains(t, result, "42")
 }
+
+func TestConfig_loadSnmpCommunity(t *testing.T) {
+	communityFile := filepath.Join(t.TempDir(), "community")
+	assert.NoError(t, os.WriteFile(communityFile, []byte("secret\n"), 0600))
+	config := &Config{snmpCommunity: "public", snmpCommunityFile: communityFile}
+
+	assert.NoError(t, config.loadSnmpCommunity())
+	assert.Equal(t, "secret", config.snmpCommunity)
+
+	config.snmpCommunityFile = filepath.Join(t.TempDir(), "missing")
+	assert.Error(t, config.loadSnmpCommunity())
+}
+
+func TestConfig_String_Secrets(t *testing.T) {
+	config := &Config{
+		snmpCommunity: "secret",
+		upsSpecs:      stringListFlag{"first,source=snmp,community=other-secret"},
+	}
+
+	result := config.String()
+
+	assert.NotContains(t, result, "secret")
+	assert.Contains(t, result, "snmpCommunity=***")
+	assert.Contains(t, result, "ups=first,source=snmp,community=***")
+}
@@ -0,0 +1,171 @@
+// Copyright [2021] [Christian Bandowski]
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/pkg/errors"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// keys reported by apcupsd, variable mappings using other keys are most likely misspelled
+var knownApcKeys = map[string]bool{
+	"APC": true, "DATE": true, "HOSTNAME": true, "VERSION": true, "UPSNAME": true, "CABLE": true, "DRIVER": true,
+	"UPSMODE": true, "STARTTIME": true, "MODEL": true, "APCMODEL": true, "STATUS": true, "LINEV": true,
+	"LOADPCT": true, "LOADAPNT": true, "BCHARGE": true, "TIMELEFT": true, "MBATTCHG": true, "MINTIMEL": true,
+	"MAXTIME": true, "MAXLINEV": true, "MINLINEV": true, "OUTPUTV": true, "SENSE": true, "DWAKE": true,
+	"DSHUTD": true, "DLOWBATT": true, "LOTRANS": true, "HITRANS": true, "RETPCT": true, "ITEMP": true,
+	"ALARMDEL": true, "BATTV": true, "LINEFREQ": true, "LASTXFER": true, "NUMXFERS": true, "XONBATT": true,
+	"TONBATT": true, "CUMONBATT": true, "XOFFBATT": true, "LASTSTEST": true, "SELFTEST": true, "STESTI": true,
+	"STATFLAG": true, "DIPSW": true, "REG1": true, "REG2": true, "REG3": true, "MANDATE": true, "SERIALNO": true,
+	"BATTDATE": true, "NOMOUTV": true, "NOMINV": true, "NOMBATTV": true, "NOMPOWER": true, "NOMAPNT": true,
+	"HUMIDITY": true, "AMBTEMP": true, "EXTBATTS": true, "BADBATTS": true, "FIRMWARE": true, "MASTERUPD": true,
+	"MASTER": true, "END APC": true,
+}
+
+// runConfigValidate runs the config validate subcommand. It parses the same arguments as the proxy, reports all
+// problems found to the output and fails if there are any, without starting the proxy.
+func runConfigValidate(args []string, output io.Writer) error {
+	config := newConfig()
+	if err := config.loadProgramArgs("config validate", args, output); errors.Is(err, flag.ErrHelp) {
+		return nil
+	} else if err != nil {
+		return errors.WithStack(err)
+	}
+	setLogLevel(config.logLevel)
+
+	problems := config.check()
+	for _, problem := range problems {
+		fmt.Fprintf(output, "ERROR %s\n", problem)
+	}
+	if len(problems) > 0 {
+		return errors.Errorf("Found %d problems in the configuration", len(problems))
+	}
+
+	fmt.Fprintln(output, "The configuration is valid")
+	return nil
+}
+
+// check checks the configuration more thoroughly than validate, it also loads the referenced files. All problems
+// found are returned, as far as they can be found after the previous ones.
+func (c *Config) check() []error {
+	if err := c.validate(); err != nil {
+		// the remaining checks rely on a valid configuration
+		return []error{err}
+	}
+
+	var problems []error
+	if err := c.loadUsers(); err != nil {
+		problems = append(problems, err)
+	}
+	if err := c.loadListeners(); err != nil {
+		problems = append(problems, err)
+	} else if c.acmeDomains == "" {
+		// certificates requested by ACME are only available once the proxy runs
+		if err := c.loadTLS(); err != nil {
+			problems = append(problems, err)
+		}
+	}
+
+	// the UPSes were parsed by validate already
+	upses, _ := c.parseUpses()
+	for _, ups := range upses {
+		for _, file := range ups.sourceFiles() {
+			if _, err := os.Stat(file.path); err != nil {
+				problems = append(problems, errors.Errorf("Couldn't access the %s of UPS %s: %s", file.description,
+					ups.upsName, err))
+			}
+		}
+	}
+
+	for _, mapping := range splitList(c.varMappings) {
+		name, spec := mapping, ""
+		if i := strings.Index(mapping, "="); i >= 0 {
+			name, spec = strings.TrimSpace(mapping[:i]), mapping[i+1:]
+		}
+
+		for _, key := range varLoaderApcKeys(spec) {
+			if !knownApcKeys[key] {
+				problems = append(problems, errors.Errorf("The mapping of variable %s uses the apc key %s, which "+
+					"isn't reported by apcupsd, check its spelling in the output of apcaccess", name, key))
+			}
+		}
+	}
+
+	return problems
+}
+
+// sourceFile is a file read by the data source of a UPS.
+type sourceFile struct {
+	description string
+	path        string
+}
+
+// sourceFiles returns the files the data source of the UPS reads.
+func (c *Config) sourceFiles() []sourceFile {
+	var files []sourceFile
+	switch c.dataSource {
+	case DataSourceFile:
+		files = append(files, sourceFile{"status file", c.statusFile})
+	case DataSourceDummy:
+		files = append(files, sourceFile{"scenario file", c.scenarioFile})
+	case DataSourceSsh:
+		files = append(files, sourceFile{"SSH key", c.sshKeyFile})
+		if c.sshKnownHostsFile != "" {
+			files = append(files, sourceFile{"SSH known hosts file", c.sshKnownHostsFile})
+		}
+	}
+
+	return files
+}
+
+// varLoaderApcKeys returns the apc keys used by the loader of a variable mapping, including its fallbacks, sorted
+// by name. Invalid loaders are reported by validate, they may miss keys.
+func varLoaderApcKeys(spec string) []string {
+	keys := make(map[string]bool)
+	spec = strings.TrimSpace(strings.Split(spec, ";")[0])
+	for spec != "" {
+		loaderType, arg := spec, ""
+		if i := strings.Index(spec, ":"); i >= 0 {
+			loaderType, arg = spec[:i], spec[i+1:]
+		}
+		spec = ""
+
+		switch loaderType {
+		case "apc", "minutes":
+			key := arg
+			if i := strings.Index(arg, "|"); i >= 0 {
+				key, spec = arg[:i], arg[i+1:]
+			}
+			keys[key] = true
+		case "expr":
+			for _, key := range expressionApcKeys(arg) {
+				keys[key] = true
+			}
+		}
+	}
+
+	sortedKeys := make([]string, 0, len(keys))
+	for key := range keys {
+		sortedKeys = append(sortedKeys, key)
+	}
+	sort.Strings(sortedKeys)
+
+	return sortedKeys
+}
@@ -0,0 +1,92 @@
+// Copyright [2021] [Christian Bandowski]
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestConfig_check(t *testing.T) {
+	scenarioFile := filepath.Join(t.TempDir(), "scenario.dev")
+	assert.NoError(t, os.WriteFile(scenarioFile, []byte("STATUS: ONLINE\n"), 0600))
+	validConfig := func() *Config {
+		return &Config{
+			port:               3493,
+			upsName:            "ups",
+			timeout:            time.Second,
+			maxLineLength:      1024,
+			unlistedClients:    UnlistedClientsReject,
+			connectionOverflow: ConnectionOverflowReject,
+			dataSource:         DataSourceDummy,
+			scenarioFile:       scenarioFile,
+		}
+	}
+
+	testCases := []struct {
+		name     string
+		modify   func(c *Config)
+		problems []string
+	}{
+		{"valid", func(c *Config) {}, nil},
+		{"invalid", func(c *Config) { c.port = 0 }, []string{"Invalid port 0, must be between 1 and 65535"}},
+		{"missing files", func(c *Config) {
+			c.scenarioFile = "scenario-does-not-exist.dev"
+			c.usersFile = "users-does-not-exist.conf"
+		}, []string{
+			"Couldn't open users file users-does-not-exist.conf: open users-does-not-exist.conf: " +
+				"no such file or directory",
+			"Couldn't access the scenario file of UPS ups: stat scenario-does-not-exist.dev: no such file or directory",
+		}},
+		{"missing certificate", func(c *Config) {
+			c.tlsPort = 3494
+			c.tlsCertFile = "cert-does-not-exist.pem"
+			c.tlsKeyFile = "key-does-not-exist.pem"
+		}, []string{"Couldn't load TLS certificate cert-does-not-exist.pem and key key-does-not-exist.pem: " +
+			"open cert-does-not-exist.pem: no such file or directory"}},
+		{"unknown apc keys", func(c *Config) {
+			c.varMappings = "ups.load=apc:LOADPC|apc:LOADPCT, ups.realpower=expr:LOADPCT / 100 * NOMPOWR"
+		}, []string{
+			"The mapping of variable ups.load uses the apc key LOADPC, which isn't reported by apcupsd, check its " +
+				"spelling in the output of apcaccess",
+			"The mapping of variable ups.realpower uses the apc key NOMPOWR, which isn't reported by apcupsd, check " +
+				"its spelling in the output of apcaccess",
+		}},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			config := validConfig()
+			testCase.modify(config)
+
+			var problems []string
+			for _, problem := range config.check() {
+				problems = append(problems, problem.Error())
+			}
+
+			assert.Equal(t, testCase.problems, problems)
+		})
+	}
+}
+
+func TestVarLoaderApcKeys(t *testing.T) {
+	assert.Equal(t, []string{"NOMPOWER"}, varLoaderApcKeys("apc:NOMPOWER|fixed:600;scale:2"))
+	assert.Equal(t, []string{"NOMPOWER", "TIMELEFT"}, varLoaderApcKeys("minutes:TIMELEFT|apc:NOMPOWER"))
+	assert.Equal(t, []string{"LOADPCT", "NOMPOWER"}, varLoaderApcKeys("expr:(LOADPCT / 100) * NOMPOWER * 1.5"))
+	assert.Empty(t, varLoaderApcKeys("var:ups.load"))
+}
@@ -0,0 +1,119 @@
+// Copyright [2021] [Christian Bandowski]
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"sync"
+)
+
+// ways of handling connections exceeding the maximum number of simultaneous connections
+const (
+	// connections are closed right after they were accepted
+	ConnectionOverflowReject = "reject"
+	// connections are not accepted until another connection was closed
+	ConnectionOverflowQueue = "queue"
+)
+
+// ConnectionLimiter limits the number of simultaneous connections in total and per client address.
+// It is shared by all listeners and safe for concurrent use.
+type ConnectionLimiter struct {
+	mutex sync.Mutex
+
+	// maximum number of connections of a single address, 0 means unlimited
+	perClient int
+
+	// number of open connections by client address
+	connections map[string]int
+
+	// one entry per open connection, nil if the total number of connections is unlimited
+	slots chan struct{}
+}
+
+// NewConnectionLimiter creates a new instance of ConnectionLimiter
+func NewConnectionLimiter(perClient int, total int) *ConnectionLimiter {
+	l := &ConnectionLimiter{
+		perClient:   perClient,
+		connections: make(map[string]int),
+	}
+	if total > 0 {
+		l.slots = make(chan struct{}, total)
+	}
+
+	return l
+}
+
+// acquireSlot reserves one of the connection slots and returns whether one was free. If wait is set it waits until a
+// slot is free instead. Reserved slots have to be released once the connection is closed. A nil limiter has
+// unlimited slots.
+func (l *ConnectionLimiter) acquireSlot(wait bool) bool {
+	if l == nil || l.slots == nil {
+		return true
+	}
+
+	if wait {
+		l.slots <- struct{}{}
+		return true
+	}
+
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+// releaseSlot releases a slot reserved by acquireSlot.
+func (l *ConnectionLimiter) releaseSlot() {
+	if l == nil || l.slots == nil {
+		return
+	}
+
+	<-l.slots
+}
+
+// acquire registers a new connection of the given address and returns whether it is within the limit. Accepted
+// connections have to be released once they are closed. A nil limiter accepts all connections.
+func (l *ConnectionLimiter) acquire(address string) bool {
+	if l == nil || l.perClient == 0 {
+		return true
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.connections[address] >= l.perClient {
+		return false
+	}
+	l.connections[address]++
+
+	return true
+}
+
+// release unregisters a closed connection of the given address.
+func (l *ConnectionLimiter) release(address string) {
+	if l == nil || l.perClient == 0 {
+		return
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.connections[address]--
+	if l.connections[address] <= 0 {
+		// don't keep entries of clients that disconnected
+		delete(l.connections, address)
+	}
+}
@@ -0,0 +1,198 @@
+// Copyright [2021] [Christian Bandowski]
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestConnectionLimiter(t *testing.T) {
+	limiter := NewConnectionLimiter(2, 0)
+
+	assert.True(t, limiter.acquire("192.168.0.1"))
+	assert.True(t, limiter.acquire("192.168.0.1"))
+	assert.False(t, limiter.acquire("192.168.0.1"))
+	assert.True(t, limiter.acquire("192.168.0.2"))
+
+	limiter.release("192.168.0.1")
+	assert.True(t, limiter.acquire("192.168.0.1"))
+
+	limiter.release("192.168.0.1")
+	limiter.release("192.168.0.1")
+	limiter.release("192.168.0.2")
+	assert.Empty(t, limiter.connections)
+}
+
+func TestConnectionLimiter_Slots(t *testing.T) {
+	limiter := NewConnectionLimiter(0, 2)
+
+	assert.True(t, limiter.acquireSlot(false))
+	assert.True(t, limiter.acquireSlot(true))
+	assert.False(t, limiter.acquireSlot(false))
+
+	acquired := make(chan bool)
+	go func() {
+		acquired <- limiter.acquireSlot(true)
+	}()
+
+	select {
+	case <-acquired:
+		assert.Fail(t, "Acquired a slot although none was free")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	limiter.releaseSlot()
+	assert.True(t, <-acquired)
+
+	// the per client limit is disabled
+	assert.True(t, limiter.acquire("192.168.0.1"))
+	assert.Empty(t, limiter.connections)
+}
+
+func TestConnectionLimiter_Nil(t *testing.T) {
+	var limiter *ConnectionLimiter
+
+	assert.True(t, limiter.acquireSlot(false))
+	limiter.releaseSlot()
+	assert.True(t, limiter.acquire("192.168.0.1"))
+	limiter.release("192.168.0.1")
+}
+
+func TestServe_MaxClientConnections(t *testing.T) {
+	config := &Config{
+		upsName:           "test",
+		timeout:           time.Second,
+		maxLineLength:     1024,
+		connectionLimiter: NewConnectionLimiter(1, 0),
+	}
+
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer l.Close()
+	go serve(l, &Listener{}, config, NewSessionRegistry())
+
+	first, err := net.Dial("tcp4", l.Addr().String())
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer first.Close()
+
+	// ensure the first connection was accepted before opening the second one
+	_, err = first.Write([]byte("VER\n"))
+	assert.NoError(t, err)
+	_, err = bufio.NewReader(first).ReadString('\n')
+	assert.NoError(t, err)
+
+	second, err := net.Dial("tcp4", l.Addr().String())
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer second.Close()
+
+	line, err := bufio.NewReader(second).ReadString('\n')
+	assert.NoError(t, err)
+	assert.Equal(t, "ERR ACCESS-DENIED\n", line)
+}
+
+func TestServe_MaxConnections(t *testing.T) {
+	config := &Config{
+		upsName:            "test",
+		timeout:            time.Second,
+		maxLineLength:      1024,
+		connectionOverflow: ConnectionOverflowReject,
+		connectionLimiter:  NewConnectionLimiter(0, 1),
+	}
+
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer l.Close()
+	go serve(l, &Listener{}, config, NewSessionRegistry())
+
+	first, err := net.Dial("tcp4", l.Addr().String())
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	_, err = first.Write([]byte("VER\n"))
+	assert.NoError(t, err)
+	_, err = bufio.NewReader(first).ReadString('\n')
+	assert.NoError(t, err)
+
+	second, err := net.Dial("tcp4", l.Addr().String())
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer second.Close()
+
+	line, err := bufio.NewReader(second).ReadString('\n')
+	assert.NoError(t, err)
+	assert.Equal(t, "ERR ACCESS-DENIED\n", line)
+
+	// the slot is free again once the first connection was closed
+	assert.NoError(t, first.Close())
+	assert.Eventually(t, func() bool {
+		return len(config.connectionLimiter.slots) == 0
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestServe_QueuedConnections(t *testing.T) {
+	config := &Config{
+		upsName:            "test",
+		timeout:            time.Second,
+		maxLineLength:      1024,
+		connectionOverflow: ConnectionOverflowQueue,
+		connectionLimiter:  NewConnectionLimiter(0, 1),
+	}
+
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer l.Close()
+	go serve(l, &Listener{}, config, NewSessionRegistry())
+
+	first, err := net.Dial("tcp4", l.Addr().String())
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	_, err = first.Write([]byte("VER\n"))
+	assert.NoError(t, err)
+	_, err = bufio.NewReader(first).ReadString('\n')
+	assert.NoError(t, err)
+
+	second, err := net.Dial("tcp4", l.Addr().String())
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer second.Close()
+
+	// the second connection is served once the first one was closed
+	_, err = second.Write([]byte("LIST UPS\n"))
+	assert.NoError(t, err)
+	assert.NoError(t, first.Close())
+
+	line, err := bufio.NewReader(second).ReadString('\n')
+	assert.NoError(t, err)
+	assert.Equal(t, "BEGIN LIST UPS\n", line)
+}
@@ -0,0 +1,126 @@
+// Copyright [2021] [Christian Bandowski]
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"github.com/pkg/errors"
+	"strconv"
+	"strings"
+)
+
+// A conversion converts the value of a variable, e.g. from minutes to seconds.
+type conversion func(value string) (string, error)
+
+// ConvertedValue is a function that creates a VarLoader which applies the conversions in the given order to the
+// result of the given VarLoader. Empty values are kept as they are.
+func ConvertedValue(loader VarLoader, conversions ...conversion) func(name string, config *Config,
+	av IApcValues) (string, error) {
+
+	return func(name string, config *Config, av IApcValues) (string, error) {
+		value, err := loader(name, config, av)
+		if err != nil || value == "" {
+			return value, err
+		}
+
+		for _, convert := range conversions {
+			if value, err = convert(value); err != nil {
+				return "", errors.Wrapf(err, "Couldn't convert variable %s", name)
+			}
+		}
+
+		return value, nil
+	}
+}
+
+// parseConversion parses a conversion rule of a variable mapping. Supported rules are:
+//
+//	minutes-to-seconds      multiplies the value by 60
+//	fahrenheit-to-celsius   converts a temperature from degrees Fahrenheit to Celsius
+//	celsius-to-fahrenheit   converts a temperature from degrees Celsius to Fahrenheit
+//	scale:<factor>          multiplies the value by the factor, e.g. "0.001"
+//	precision:<decimals>    rounds the value to the number of decimals
+//	strip:<suffix>          removes the suffix, e.g. " Percent"
+//
+// The numeric rules use the leading number of the value, so units like in "27.0 Percent" are dropped.
+func parseConversion(rule string) (conversion, error) {
+	name, arg := rule, ""
+	if i := strings.Index(rule, ":"); i >= 0 {
+		name, arg = rule[:i], rule[i+1:]
+	}
+
+	switch name {
+	case "minutes-to-seconds":
+		return numericConversion(func(value float64) float64 { return value * 60 }), nil
+	case "fahrenheit-to-celsius":
+		return numericConversion(func(value float64) float64 { return (value - 32) * 5 / 9 }), nil
+	case "celsius-to-fahrenheit":
+		return numericConversion(func(value float64) float64 { return value*9/5 + 32 }), nil
+	case "scale":
+		factor, err := strconv.ParseFloat(arg, 64)
+		if err != nil {
+			return nil, errors.Errorf("Invalid scale factor %s", arg)
+		}
+
+		return numericConversion(func(value float64) float64 { return value * factor }), nil
+	case "precision":
+		decimals, err := strconv.Atoi(arg)
+		if err != nil || decimals < 0 {
+			return nil, errors.Errorf("Invalid precision %s, must be a number of decimals", arg)
+		}
+
+		return func(value string) (string, error) {
+			number, err := leadingNumber(value)
+			if err != nil {
+				return "", errors.WithStack(err)
+			}
+
+			return strconv.FormatFloat(number, 'f', decimals, 64), nil
+		}, nil
+	case "strip":
+		return func(value string) (string, error) {
+			return strings.TrimSpace(strings.TrimSuffix(value, arg)), nil
+		}, nil
+	default:
+		return nil, errors.Errorf("Unknown conversion %s, must be \"minutes-to-seconds\", \"fahrenheit-to-celsius\", "+
+			"\"celsius-to-fahrenheit\", \"scale\", \"precision\" or \"strip\"", name)
+	}
+}
+
+// numericConversion returns a conversion applying the function to the leading number of the value.
+func numericConversion(convert func(value float64) float64) conversion {
+	return func(value string) (string, error) {
+		number, err := leadingNumber(value)
+		if err != nil {
+			return "", errors.WithStack(err)
+		}
+
+		return strconv.FormatFloat(convert(number), 'f', -1, 64), nil
+	}
+}
+
+// leadingNumber parses the number the value starts with, ignoring a unit following it.
+func leadingNumber(value string) (float64, error) {
+	fields := strings.Fields(value)
+	if len(fields) == 0 {
+		return 0, errors.Errorf("The value %s is not a number", value)
+	}
+
+	number, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return 0, errors.Errorf("The value %s is not a number", value)
+	}
+
+	return number, nil
+}
@@ -0,0 +1,80 @@
+// Copyright [2021] [Christian Bandowski]
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestParseConversion(t *testing.T) {
+	testCases := []struct {
+		name  string
+		rule  string
+		value string
+		// expected result, if the rule is valid and the value can be converted
+		result string
+		err    string
+	}{
+		{"minutes to seconds", "minutes-to-seconds", "12.5 Minutes", "750", ""},
+		{"fahrenheit to celsius", "fahrenheit-to-celsius", "95.0 F", "35", ""},
+		{"celsius to fahrenheit", "celsius-to-fahrenheit", "35.0 C", "95", ""},
+		{"scale", "scale:0.001", "2500 mAh", "2.5", ""},
+		{"precision", "precision:1", "27.26 Percent", "27.3", ""},
+		{"no decimals", "precision:0", "229.8", "230", ""},
+		{"strip suffix", "strip: Volts", "230.0 Volts", "230.0", ""},
+		{"no number", "scale:2", "N/A", "", "The value N/A is not a number"},
+		{"invalid scale factor", "scale:half", "1", "", "Invalid scale factor half"},
+		{"invalid precision", "precision:-1", "1", "", "Invalid precision -1, must be a number of decimals"},
+		{"unknown conversion", "hours-to-seconds", "1", "", "Unknown conversion hours-to-seconds, must be " +
+			"\"minutes-to-seconds\", \"fahrenheit-to-celsius\", \"celsius-to-fahrenheit\", \"scale\", \"precision\" " +
+			"or \"strip\""},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			convert, err := parseConversion(testCase.rule)
+			var result string
+			if err == nil {
+				result, err = convert(testCase.value)
+			}
+
+			if testCase.err != "" {
+				assert.EqualError(t, err, testCase.err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, testCase.result, result)
+		})
+	}
+}
+
+func TestConvertedValue(t *testing.T) {
+	values := &ApcValues{values: map[string]string{"ITEMP": "29.5 C", "MODEL": "Back-UPS"}}
+	convert, err := parseConversion("celsius-to-fahrenheit")
+	assert.NoError(t, err)
+
+	value, err := ConvertedValue(ApcValue("ITEMP", IgnoreValue), convert)("ups.temperature", &Config{}, values)
+	assert.NoError(t, err)
+	assert.Equal(t, "85.1", value)
+
+	// missing values aren't converted
+	value, err = ConvertedValue(ApcValue("AMBTEMP", IgnoreValue), convert)("ambient.temperature", &Config{}, values)
+	assert.NoError(t, err)
+	assert.Equal(t, "", value)
+
+	_, err = ConvertedValue(ApcValue("MODEL", IgnoreValue), convert)("ups.model", &Config{}, values)
+	assert.EqualError(t, err, "Couldn't convert variable ups.model: The value Back-UPS is not a number")
+}
@@ -0,0 +1,372 @@
+// Copyright [2021] [Christian Bandowski]
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/pkg/errors"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ways of loading the values from apcupsd
+const (
+	// invoking the apcaccess executable
+	DataSourceApcaccess = "apcaccess"
+	// requesting them from the network information server of apcupsd directly
+	DataSourceNis = "nis"
+	// reading the status file apcupsd writes periodically
+	DataSourceFile = "file"
+	// passing through the variables of a UPS served by the upsd of another NUT server
+	DataSourceNut = "nut"
+	// requesting them from the network management card of the UPS by using SNMP
+	DataSourceSnmp = "snmp"
+	// computing them from the values of other UPSes
+	DataSourceAggregate = "aggregate"
+	// simulating them by a scenario file
+	DataSourceDummy = "dummy"
+	// replaying snapshots recorded before
+	DataSourceReplay = "replay"
+	// invoking apcaccess on a remote host by using SSH
+	DataSourceSsh = "ssh"
+)
+
+// A DataSource loads the status of the UPS, new backends only have to implement this interface.
+type DataSource interface {
+	// load loads the current values by their apcupsd names, like STATUS, without units. Sources passing through the
+	// values of another NUT server use the NUT variable names instead.
+	// The given context can be used to cancel loading, e.g. if the client isn't waiting for the response anymore.
+	load(ctx context.Context) (map[string]string, error)
+
+	// String describes the source for logging.
+	String() string
+}
+
+// loadDataSource creates the configured data source. If fallback targets are configured, it fails over to them once
+// the target isn't reachable. Failed loads are retried as configured. If a record directory is configured, the
+// snapshots are saved in a subdirectory per UPS. If a cache TTL is configured, the values are shared by all
+// connections. If a maximum data age is configured, the last known values are used while the source fails.
+func (c *Config) loadDataSource() {
+	if c.resolveTTL > 0 {
+		c.resolver = NewAddressResolver(c.resolveTTL)
+	}
+	c.source = c.newDataSource(c.targetAddress)
+
+	fallbackTargets := c.fallbackTargetList()
+	if len(fallbackTargets) > 0 {
+		sources := []DataSource{c.source}
+		for _, address := range fallbackTargets {
+			sources = append(sources, c.newDataSource(address))
+		}
+		c.source = NewFailoverDataSource(c.upsName, sources)
+	}
+
+	if c.sourceRetries > 0 {
+		c.source = NewRetryingDataSource(c.source, c.sourceRetries, c.sourceRetryBackoff)
+	}
+	if c.recordDir != "" {
+		c.source = NewRecordingDataSource(c.source, filepath.Join(c.recordDir, upsFileName(c.upsName)))
+	}
+	if c.minReloadInterval > 0 && !c.singleValueRequests {
+		// single value requests load only the values needed and can't reuse the values of a previous load
+		c.source = NewThrottledDataSource(c.source, c.minReloadInterval)
+	}
+	if c.cacheTTL > 0 {
+		cache := NewCachingDataSource(c.source, c.cacheTTL)
+		cache.maxStaleness = c.cacheMaxStaleness
+		cache.refreshTimeout = c.timeout
+		c.source = cache
+	}
+	if c.maxDataAge > 0 {
+		lastKnown := NewLastKnownDataSource(c.source, c.maxDataAge)
+		if c.snapshotDir != "" {
+			// the values persisted before a restart are answered while the source fails, too
+			snapshotTime, err := lastKnown.loadSnapshot(filepath.Join(c.snapshotDir, upsFileName(c.upsName)+".json"))
+			if err != nil {
+				logWarnf("Couldn't load the last known values of UPS %s: %+v", c.upsName, err)
+			}
+			c.state.restoreSourceLastSuccess(snapshotTime)
+		}
+		c.source = lastKnown
+	}
+}
+
+// upsFileName escapes the UPS name for use as a file name. Discovered names are reported by remote hosts, so they must
+// neither contain path separators nor refer to a parent directory.
+func upsFileName(upsName string) string {
+	name := url.PathEscape(upsName)
+	if name == "." || name == ".." {
+		return strings.ReplaceAll(name, ".", "%2E")
+	}
+
+	return name
+}
+
+// newDataSource creates the configured data source for the given target address.
+func (c *Config) newDataSource(address string) DataSource {
+	switch c.dataSource {
+	case DataSourceNis:
+		source := NewNisDataSource(address)
+		source.resolver = c.resolver
+		return source
+	case DataSourceFile:
+		return NewFileDataSource(c.statusFile)
+	case DataSourceNut:
+		upstreamUpsName := c.upstreamUpsName
+		if upstreamUpsName == "" {
+			upstreamUpsName = c.upsName
+		}
+		return NewNutDataSource(address, upstreamUpsName)
+	case DataSourceSnmp:
+		return NewSnmpDataSource(address, c.snmpCommunity)
+	case DataSourceDummy:
+		return NewDummyDataSource(c.scenarioFile)
+	case DataSourceReplay:
+		return NewReplayDataSource(c.replayDir)
+	case DataSourceSsh:
+		return NewSshDataSource(address, c.sshUser, c.sshKeyFile, c.sshKnownHostsFile, c.apcAccessExecutable,
+			strings.Fields(c.apcAccessArgs), c.apcAccessStripUnits)
+	default:
+		source := NewExecDataSource(c.apcAccessExecutable, address)
+		source.args = strings.Fields(c.apcAccessArgs)
+		source.stripUnits = c.apcAccessStripUnits
+		source.timeout = c.execTimeout
+		source.resolver = c.resolver
+		if env := splitList(c.apcAccessEnv); len(env) > 0 {
+			source.exec = execCommandWithEnv(env)
+		}
+		// a burst of commands must not start dozens of apcaccess processes at once
+		source.exec = c.workQueue.wrap(source.exec)
+		return source
+	}
+}
+
+// fallbackTargetList returns the fallback target addresses, they may be separated by commas or whitespace.
+func (c *Config) fallbackTargetList() []string {
+	return strings.Fields(strings.ReplaceAll(c.fallbackTargets, ",", " "))
+}
+
+// A SingleValueDataSource can load a single value, which is faster than loading all values.
+type SingleValueDataSource interface {
+	DataSource
+
+	// loadValue loads the value by its key, returns a false flag if the value was not found.
+	loadValue(ctx context.Context, key string) (string, bool, error)
+}
+
+// ExecDataSource loads the values by invoking apcaccess.
+type ExecDataSource struct {
+	executable string
+	address    string
+
+	// further arguments passed before the address
+	args []string
+
+	// whether the units are stripped by the proxy, as apcaccess doesn't support -u
+	stripUnits bool
+
+	// time after which apcaccess is killed, zero if it is only limited by the context
+	timeout time.Duration
+
+	// resolves the host name of the address, may be nil
+	resolver *AddressResolver
+
+	// will be used to invoke the apcaccess command
+	exec execCmd
+}
+
+// NewExecDataSource creates a new instance of ExecDataSource
+func NewExecDataSource(executable string, address string) *ExecDataSource {
+	return &ExecDataSource{executable: executable, address: address, exec: execCommand}
+}
+
+// load invokes apcaccess, which strips the units itself unless it doesn't support -u.
+func (s *ExecDataSource) load(ctx context.Context) (map[string]string, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	address, err := s.resolver.resolve(ctx, s.address)
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := s.exec(ctx, s.executable, s.arguments(address)...)
+	if err != nil {
+		s.resolver.forget(s.address)
+		return nil, errors.Wrapf(err, "Error invoking apcaccess")
+	}
+
+	return parseApcOutput(out, s.stripUnits)
+}
+
+// loadValue invokes apcaccess -p, which prints only the value without the key.
+func (s *ExecDataSource) loadValue(ctx context.Context, key string) (string, bool, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	address, err := s.resolver.resolve(ctx, s.address)
+	if err != nil {
+		return "", false, err
+	}
+
+	out, err := s.exec(ctx, s.executable, s.arguments(address, "-p", key)...)
+	if err != nil {
+		s.resolver.forget(s.address)
+		return "", false, errors.Wrapf(err, "Error invoking apcaccess")
+	}
+
+	value := strings.TrimSpace(string(out))
+	if s.stripUnits {
+		value = stripApcUnit(value)
+	}
+	return value, value != "", nil
+}
+
+// withTimeout limits the context to the timeout of apcaccess, if any.
+func (s *ExecDataSource) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if s.timeout <= 0 {
+		return ctx, func() {}
+	}
+
+	return context.WithTimeout(ctx, s.timeout)
+}
+
+// arguments returns the arguments of apcaccess for the resolved address, followed by the given ones.
+func (s *ExecDataSource) arguments(address string, further ...string) []string {
+	args := append([]string{}, s.args...)
+	args = append(args, "-h", address)
+	args = append(args, further...)
+	if !s.stripUnits {
+		args = append(args, "-u")
+	}
+
+	return args
+}
+
+// String returns the address of apcupsd.
+func (s *ExecDataSource) String() string {
+	return fmt.Sprintf("apcaccess %s", s.address)
+}
+
+// FileDataSource loads the values from the status file of apcupsd.
+type FileDataSource struct {
+	path string
+}
+
+// NewFileDataSource creates a new instance of FileDataSource
+func NewFileDataSource(path string) *FileDataSource {
+	return &FileDataSource{path: path}
+}
+
+// load reads the status file, which contains the units.
+func (s *FileDataSource) load(_ context.Context) (map[string]string, error) {
+	out, err := os.ReadFile(s.path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error reading status file %s", s.path)
+	}
+
+	return parseApcOutput(out, true)
+}
+
+// String returns the path of the status file.
+func (s *FileDataSource) String() string {
+	return fmt.Sprintf("status file %s", s.path)
+}
+
+// parseApcOutput parses the status output of apcupsd, one "KEY : value" pair per line, invalid lines are skipped. If
+// stripUnits is set, the units are removed from numeric values like apcaccess -u does.
+func parseApcOutput(out []byte, stripUnits bool) (map[string]string, error) {
+	values := make(map[string]string)
+
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		if strings.TrimSpace(line) == "" {
+			// skip empty lines
+			continue
+		}
+
+		if isApcMarkerLine(line) {
+			// skip non-data marker lines like a bare "END APC" footer
+			continue
+		}
+
+		pos := strings.Index(line, ":")
+		key := ""
+		if pos != -1 {
+			key = strings.TrimSpace(line[:pos])
+		}
+		if key == "" {
+			// a single odd line, e.g. of a localized output, must not discard all other values
+			logWarnf("Skipping invalid line in apcaccess output: %q", line)
+			continue
+		}
+
+		value := strings.TrimSpace(line[(pos + 1):])
+		if stripUnits {
+			value = stripApcUnit(value)
+		}
+
+		values[key] = value
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrapf(err, "Error reading apcaccess output")
+	}
+
+	return values, nil
+}
+
+// isApcMarkerLine checks whether the given line of the apcaccess output is a marker line (like a bare "END APC"
+// footer) that doesn't contain any data. The "END APC : <timestamp>" line is data, it has a ':' separator.
+func isApcMarkerLine(line string) bool {
+	return strings.HasPrefix(strings.TrimSpace(line), "END APC") && !strings.Contains(line, ":")
+}
+
+// units apcupsd appends to numeric values, longer units have to come first
+var apcUnits = []string{
+	" Percent Load Capacity",
+	" Percent",
+	" Minutes",
+	" Seconds",
+	" Volts",
+	" Watts",
+	" Hz",
+	" VA",
+	" C",
+}
+
+// stripApcUnit removes the unit of a numeric value, like "230.0 Volts". Other values are returned unchanged.
+func stripApcUnit(value string) string {
+	for _, unit := range apcUnits {
+		if !strings.HasSuffix(value, unit) {
+			continue
+		}
+
+		number := strings.TrimSuffix(value, unit)
+		if _, err := strconv.ParseFloat(number, 64); err == nil {
+			return number
+		}
+	}
+
+	return value
+}
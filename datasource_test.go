@@ -0,0 +1,247 @@
+// Copyright [2021] [Christian Bandowski]
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type mockDataSource struct {
+	mock.Mock
+}
+
+func (m *mockDataSource) load(ctx context.Context) (map[string]string, error) {
+	args := m.Called(ctx)
+	values, _ := args.Get(0).(map[string]string)
+	return values, args.Error(1)
+}
+
+func (m *mockDataSource) String() string {
+	return "mock"
+}
+
+func TestConfig_loadDataSource(t *testing.T) {
+	dataSourceToResult := map[string]DataSource{
+		DataSourceApcaccess: &ExecDataSource{executable: "apcaccess", address: "127.0.0.1", args: []string{}},
+		DataSourceNis:       &NisDataSource{address: "127.0.0.1"},
+		DataSourceFile:      &FileDataSource{path: "apcupsd.status"},
+		DataSourceNut:       &NutDataSource{address: "127.0.0.1", upsName: "ups"},
+		DataSourceSnmp:      &SnmpDataSource{address: "127.0.0.1", community: "public"},
+	}
+
+	for dataSource, expSource := range dataSourceToResult {
+		t.Run(dataSource, func(t *testing.T) {
+			config := Config{
+				dataSource:          dataSource,
+				upsName:             "ups",
+				snmpCommunity:       "public",
+				targetAddress:       "127.0.0.1",
+				statusFile:          "apcupsd.status",
+				apcAccessExecutable: "apcaccess",
+			}
+
+			config.loadDataSource()
+
+			if execSource, ok := config.source.(*ExecDataSource); ok {
+				assert.NotNil(t, execSource.exec)
+				execSource.exec = nil
+			}
+			assert.Equal(t, expSource, config.source)
+		})
+	}
+}
+
+func TestConfig_loadDataSource_Dummy(t *testing.T) {
+	config := Config{dataSource: DataSourceDummy, scenarioFile: "scenario.dev"}
+
+	config.loadDataSource()
+
+	if source, ok := config.source.(*DummyDataSource); assert.True(t, ok) {
+		assert.Equal(t, "scenario.dev", source.path)
+	}
+}
+
+func TestConfig_loadDataSource_ResolveTTL(t *testing.T) {
+	config := Config{dataSource: DataSourceNis, targetAddress: "apcupsd", resolveTTL: time.Minute}
+
+	config.loadDataSource()
+
+	if assert.NotNil(t, config.resolver) {
+		assert.Equal(t, time.Minute, config.resolver.ttl)
+		assert.Same(t, config.resolver, config.source.(*NisDataSource).resolver)
+	}
+}
+
+func TestConfig_loadDataSource_FallbackTargets(t *testing.T) {
+	config := Config{dataSource: DataSourceNis, upsName: "ups", targetAddress: "10.0.0.1",
+		fallbackTargets: "10.0.0.2, 10.0.0.3"}
+
+	config.loadDataSource()
+
+	source, ok := config.source.(*FailoverDataSource)
+	if assert.True(t, ok) {
+		assert.Equal(t, "ups", source.upsName)
+		assert.Equal(t, []DataSource{&NisDataSource{address: "10.0.0.1"}, &NisDataSource{address: "10.0.0.2"},
+			&NisDataSource{address: "10.0.0.3"}}, source.sources)
+	}
+}
+
+func TestUpsFileName(t *testing.T) {
+	testCases := []struct {
+		upsName string
+		expName string
+	}{
+		{"ups", "ups"},
+		{"smart-ups_1500", "smart-ups_1500"},
+		{"../../somewhere/x", "..%2F..%2Fsomewhere%2Fx"},
+		{`back\slash`, "back%5Cslash"},
+		{"..", "%2E%2E"},
+		{".", "%2E"},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.upsName, func(t *testing.T) {
+			assert.Equal(t, testCase.expName, upsFileName(testCase.upsName))
+		})
+	}
+}
+
+func TestExecDataSource_load_Resolved(t *testing.T) {
+	source := NewExecDataSource("apcaccess", "apcupsd:3551")
+	source.resolver = NewAddressResolver(time.Minute)
+	source.resolver.lookup = func(ctx context.Context, host string) ([]net.IPAddr, error) {
+		return []net.IPAddr{{IP: net.ParseIP("10.0.0.1")}}, nil
+	}
+	source.exec = func(ctx context.Context, name string, args ...string) ([]byte, error) {
+		assert.Equal(t, []string{"-h", "10.0.0.1:3551", "-u"}, args)
+		return nil, errors.New("unreachable")
+	}
+
+	_, err := source.load(context.Background())
+
+	assert.Error(t, err)
+	// the address is resolved again after a failure
+	assert.Empty(t, source.resolver.hosts)
+}
+
+func TestConfig_loadDataSource_ApcaccessOptions(t *testing.T) {
+	config := Config{
+		targetAddress:       "127.0.0.1",
+		apcAccessExecutable: "apcaccess",
+		apcAccessArgs:       "-f  /etc/apcupsd/ups2.conf",
+		apcAccessEnv:        "LANG=C",
+		apcAccessStripUnits: true,
+	}
+
+	config.loadDataSource()
+
+	if source, ok := config.source.(*ExecDataSource); assert.True(t, ok) {
+		assert.Equal(t, []string{"-f", "/etc/apcupsd/ups2.conf"}, source.args)
+		assert.True(t, source.stripUnits)
+		assert.NotNil(t, source.exec)
+	}
+}
+
+func TestExecDataSource_load_StripUnits(t *testing.T) {
+	source := NewExecDataSource("apcaccess", "127.0.0.1:3551")
+	source.args = []string{"-f", "/etc/apcupsd/ups2.conf"}
+	source.stripUnits = true
+	source.exec = func(ctx context.Context, name string, args ...string) ([]byte, error) {
+		assert.Equal(t, []string{"-f", "/etc/apcupsd/ups2.conf", "-h", "127.0.0.1:3551"}, args)
+		return []byte("STATUS : ONLINE\nBCHARGE : 100.0 Percent\n"), nil
+	}
+
+	values, err := source.load(context.Background())
+
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"STATUS": "ONLINE", "BCHARGE": "100.0"}, values)
+}
+
+func TestExecDataSource_load_Timeout(t *testing.T) {
+	source := NewExecDataSource("sleep", "127.0.0.1")
+	source.timeout = 50 * time.Millisecond
+	source.exec = func(ctx context.Context, name string, args ...string) ([]byte, error) {
+		// the arguments of apcaccess aren't understood by sleep
+		return execCommand(ctx, name, "10")
+	}
+
+	start := time.Now()
+	_, err := source.load(context.Background())
+
+	assert.Error(t, err)
+	assert.Less(t, int64(time.Since(start)), int64(5*time.Second))
+}
+
+func TestExecDataSource_loadValue(t *testing.T) {
+	source := NewExecDataSource("apcaccess", "127.0.0.1:3551")
+	source.exec = func(ctx context.Context, name string, args ...string) ([]byte, error) {
+		assert.Equal(t, []string{"-h", "127.0.0.1:3551", "-p", "BCHARGE", "-u"}, args)
+		return []byte("100.0\n"), nil
+	}
+
+	value, ok, err := source.loadValue(context.Background(), "BCHARGE")
+
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "100.0", value)
+}
+
+func TestExecDataSource_load(t *testing.T) {
+	source := NewExecDataSource("apcaccess", "127.0.0.1:3551")
+	source.exec = func(ctx context.Context, name string, args ...string) ([]byte, error) {
+		assert.Equal(t, "apcaccess", name)
+		assert.Equal(t, []string{"-h", "127.0.0.1:3551", "-u"}, args)
+		return []byte("STATUS : ONLINE\nBCHARGE : 100.0\n"), nil
+	}
+
+	values, err := source.load(context.Background())
+
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"STATUS": "ONLINE", "BCHARGE": "100.0"}, values)
+	assert.Equal(t, "apcaccess 127.0.0.1:3551", source.String())
+}
+
+func TestFileDataSource_load(t *testing.T) {
+	statusFile := filepath.Join(t.TempDir(), "apcupsd.status")
+	if !assert.NoError(t, os.WriteFile(statusFile, []byte("LINEV : 230.0 Volts\n"), 0600)) {
+		return
+	}
+	source := NewFileDataSource(statusFile)
+
+	values, err := source.load(context.Background())
+
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"LINEV": "230.0"}, values)
+	assert.Equal(t, "status file "+statusFile, source.String())
+}
+
+func TestParseApcOutput_InvalidLines(t *testing.T) {
+	out := "APC      : 001,036,0857\nSTATUS   : ONLINE\nStatut en ligne\n : no key\nBCHARGE  : 100.0 Percent\n" +
+		"END APC  : 2024-01-01\n"
+
+	values, err := parseApcOutput([]byte(out), true)
+
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"APC": "001,036,0857", "STATUS": "ONLINE", "BCHARGE": "100.0",
+		"END APC": "2024-01-01"}, values)
+}
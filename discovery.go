@@ -0,0 +1,167 @@
+// Copyright [2021] [Christian Bandowski]
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maximum number of addresses that are scanned for apcupsd instances
+const discoveryMaxHosts = 4096
+
+// number of addresses that are scanned at the same time
+const discoveryConcurrency = 64
+
+// discoveredHost is an apcupsd instance answering on its network information server.
+type discoveredHost struct {
+	address string
+	values  map[string]string
+}
+
+// discoveryHosts returns all addresses within the given networks, without the network and broadcast addresses of
+// IPv4 networks.
+func discoveryHosts(networks []*net.IPNet) ([]net.IP, error) {
+	var hosts []net.IP
+	for _, network := range networks {
+		ones, bits := network.Mask.Size()
+		if bits-ones > 16 || len(hosts)+(1<<(bits-ones)) > discoveryMaxHosts {
+			return nil, errors.Errorf("Too many addresses to discover, at most %d are supported", discoveryMaxHosts)
+		}
+
+		var networkHosts []net.IP
+		for ip := network.IP.Mask(network.Mask); network.Contains(ip); ip = nextIP(ip) {
+			networkHosts = append(networkHosts, ip)
+		}
+		if network.IP.To4() != nil && bits-ones > 1 {
+			networkHosts = networkHosts[1 : len(networkHosts)-1]
+		}
+		hosts = append(hosts, networkHosts...)
+	}
+
+	return hosts, nil
+}
+
+// nextIP returns the address following the given one.
+func nextIP(ip net.IP) net.IP {
+	next := make(net.IP, len(ip))
+	copy(next, ip)
+	for i := len(next) - 1; i >= 0; i-- {
+		next[i]++
+		if next[i] != 0 {
+			break
+		}
+	}
+
+	return next
+}
+
+// discoverNisHosts requests the status from the network information server at the given port of all addresses within
+// the given networks, hosts that don't answer within the timeout are skipped. The found hosts are returned in the order
+// of their addresses.
+func discoverNisHosts(ctx context.Context, networks []*net.IPNet, port string,
+	timeout time.Duration) ([]discoveredHost, error) {
+
+	hosts, err := discoveryHosts(networks)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	found := make([]*discoveredHost, len(hosts))
+	slots := make(chan struct{}, discoveryConcurrency)
+	var wg sync.WaitGroup
+	for i, ip := range hosts {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(i int, address string) {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			hostCtx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+
+			out, err := nisStatus(hostCtx, address)
+			if err != nil {
+				logDebugf("No apcupsd found at %s: %v", address, err)
+				return
+			}
+			values, err := parseApcOutput(out, true)
+			if err != nil {
+				logWarnf("Invalid status of apcupsd at %s: %v", address, err)
+				return
+			}
+			found[i] = &discoveredHost{address: address, values: values}
+		}(i, net.JoinHostPort(ip.String(), port))
+	}
+	wg.Wait()
+
+	var result []discoveredHost
+	for _, host := range found {
+		if host != nil {
+			result = append(result, *host)
+		}
+	}
+
+	return result, nil
+}
+
+// discoverUpses scans the configured networks for apcupsd instances and creates a UPS using the "nis" source for each
+// of them. The UPS is named like apcupsd names it, or after its address if that name is taken already.
+func (c *Config) discoverUpses(ctx context.Context, upses []*Config) ([]*Config, error) {
+	networks, err := parseNetworks(c.discoverNetworks)
+	if err != nil {
+		return nil, errors.Wrap(err, "Invalid discovery networks")
+	}
+
+	hosts, err := discoverNisHosts(ctx, networks, c.discoverPort, c.discoverTimeout)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	names := make(map[string]bool)
+	for _, ups := range upses {
+		names[ups.upsName] = true
+	}
+
+	var discovered []*Config
+	for _, host := range hosts {
+		name := host.values["UPSNAME"]
+		if name == "" || names[name] || strings.ContainsAny(name, "\"\\") {
+			hostName, _, _ := net.SplitHostPort(host.address)
+			name = "ups-" + strings.NewReplacer(".", "-", ":", "-").Replace(hostName)
+		}
+		if names[name] {
+			logWarnf("Skipping apcupsd discovered at %s, the UPS %s is configured already", host.address, name)
+			continue
+		}
+		names[name] = true
+
+		ups := c.newUpsConfig(name)
+		ups.targetAddress = host.address
+		ups.dataSource = DataSourceNis
+		if model := host.values["MODEL"]; model != "" {
+			ups.upsDescription = model
+		}
+		discovered = append(discovered, ups)
+
+		logInfof("Discovered UPS %s at %s", name, host.address)
+	}
+
+	return discovered, nil
+}
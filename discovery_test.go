@@ -0,0 +1,98 @@
+// Copyright [2021] [Christian Bandowski]
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestDiscoveryHosts(t *testing.T) {
+	networksToHosts := map[string][]string{
+		"192.168.0.0/30":                {"192.168.0.1", "192.168.0.2"},
+		"192.168.0.4/31":                {"192.168.0.4", "192.168.0.5"},
+		"192.168.0.5":                   {"192.168.0.5"},
+		"192.168.0.5, 10.0.0.255/30":    {"192.168.0.5", "10.0.0.253", "10.0.0.254"},
+		"fd00::/127":                    {"fd00::", "fd00::1"},
+		"192.168.1.255/31, 192.168.2.0": {"192.168.1.254", "192.168.1.255", "192.168.2.0"},
+	}
+
+	for list, expHosts := range networksToHosts {
+		t.Run(list, func(t *testing.T) {
+			networks, err := parseNetworks(list)
+			if !assert.NoError(t, err) {
+				return
+			}
+
+			hosts, err := discoveryHosts(networks)
+
+			assert.NoError(t, err)
+			var result []string
+			for _, host := range hosts {
+				result = append(result, host.String())
+			}
+			assert.Equal(t, expHosts, result)
+		})
+	}
+}
+
+func TestDiscoveryHosts_TooMany(t *testing.T) {
+	for _, list := range []string{"10.0.0.0/8", "fd00::/64", "10.0.0.0/20, 10.1.0.0/30"} {
+		t.Run(list, func(t *testing.T) {
+			networks, err := parseNetworks(list)
+			if !assert.NoError(t, err) {
+				return
+			}
+
+			_, err = discoveryHosts(networks)
+
+			assert.EqualError(t, err, "Too many addresses to discover, at most 4096 are supported")
+		})
+	}
+}
+
+func TestConfig_discoverUpses(t *testing.T) {
+	address := startTestNisServer(t,
+		"UPSNAME  : garage",
+		"MODEL    : Back-UPS XS 700U",
+		"STATUS   : ONLINE")
+	_, port, _ := net.SplitHostPort(address)
+	config := &Config{
+		discoverNetworks: "127.0.0.1, 127.0.0.2",
+		discoverPort:     port,
+		discoverTimeout:  time.Second,
+		upsDescription:   "apcupsd NUT proxy",
+		dataSource:       DataSourceApcaccess,
+	}
+
+	upses, err := config.discoverUpses(context.Background(), []*Config{{upsName: "rack"}})
+
+	if assert.NoError(t, err) && assert.Len(t, upses, 1) {
+		assert.Equal(t, "garage", upses[0].upsName)
+		assert.Equal(t, "Back-UPS XS 700U", upses[0].upsDescription)
+		assert.Equal(t, address, upses[0].targetAddress)
+		assert.Equal(t, DataSourceNis, upses[0].dataSource)
+	}
+
+	// a configured UPS with the same name keeps it
+	upses, err = config.discoverUpses(context.Background(), []*Config{{upsName: "garage"}})
+
+	if assert.NoError(t, err) && assert.Len(t, upses, 1) {
+		assert.Equal(t, "ups-127-0-0-1", upses[0].upsName)
+	}
+}
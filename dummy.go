@@ -0,0 +1,158 @@
+// Copyright [2021] [Christian Bandowski]
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"github.com/pkg/errors"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// a step of a scenario, the values are reported for the duration
+type scenarioStep struct {
+	values map[string]string
+
+	// zero if the values are reported forever
+	duration time.Duration
+}
+
+// DummyDataSource simulates a UPS like the dummy-ups driver of NUT, so the shutdown behavior of clients can be tested
+// without touching real hardware. The values are read from a scenario file, which contains "KEY: value" lines using
+// the keys of apcupsd and "TIMER <seconds>" lines, which report the values given so far for that time before the
+// following lines change them. If the file ends with a timer, the scenario repeats.
+type DummyDataSource struct {
+	path string
+
+	// time the scenario started
+	start time.Time
+
+	// returns the current time, replaced by tests
+	now func() time.Time
+}
+
+// NewDummyDataSource creates a new instance of DummyDataSource, the scenario starts immediately.
+func NewDummyDataSource(path string) *DummyDataSource {
+	return &DummyDataSource{path: path, start: time.Now(), now: time.Now}
+}
+
+// load reads the scenario file, so it may be changed while the proxy is running, and returns the values of the
+// current step.
+func (s *DummyDataSource) load(_ context.Context) (map[string]string, error) {
+	file, err := os.Open(s.path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error reading scenario file %s", s.path)
+	}
+	defer file.Close()
+
+	steps, err := parseScenario(file)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Invalid scenario file %s", s.path)
+	}
+
+	return scenarioValues(steps, s.now().Sub(s.start)), nil
+}
+
+// String returns the path of the scenario file.
+func (s *DummyDataSource) String() string {
+	return fmt.Sprintf("scenario file %s", s.path)
+}
+
+// parseScenario parses the steps of a scenario, the values of each step contain the ones of the steps before.
+func parseScenario(reader io.Reader) ([]scenarioStep, error) {
+	var steps []scenarioStep
+	values := make(map[string]string)
+	changed := false
+
+	scanner := bufio.NewScanner(reader)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if fields := strings.Fields(line); fields[0] == "TIMER" {
+			if len(fields) != 2 {
+				return nil, errors.Errorf("Invalid timer in line %d", lineNumber)
+			}
+			seconds, err := strconv.ParseFloat(fields[1], 64)
+			if err != nil || seconds <= 0 {
+				return nil, errors.Errorf("Invalid timer in line %d, must be a positive number of seconds",
+					lineNumber)
+			}
+
+			steps = append(steps, scenarioStep{
+				values:   copyValues(values),
+				duration: time.Duration(seconds * float64(time.Second)),
+			})
+			changed = false
+			continue
+		}
+
+		pos := strings.Index(line, ":")
+		if pos == -1 {
+			return nil, errors.Errorf("Invalid line %d", lineNumber)
+		}
+		values[strings.TrimSpace(line[:pos])] = stripApcUnit(strings.TrimSpace(line[pos+1:]))
+		changed = true
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if changed || len(steps) == 0 {
+		// the values after the last timer are reported forever
+		steps = append(steps, scenarioStep{values: values})
+	}
+
+	return steps, nil
+}
+
+// scenarioValues returns the values of the step that is reported after the elapsed time.
+func scenarioValues(steps []scenarioStep, elapsed time.Duration) map[string]string {
+	last := steps[len(steps)-1]
+	if last.duration > 0 {
+		// every step has a timer, so the scenario repeats
+		var total time.Duration
+		for _, step := range steps {
+			total += step.duration
+		}
+		elapsed %= total
+	}
+
+	for _, step := range steps {
+		if step.duration == 0 || elapsed < step.duration {
+			return step.values
+		}
+		elapsed -= step.duration
+	}
+
+	return last.values
+}
+
+// copyValues returns a copy of the values.
+func copyValues(values map[string]string) map[string]string {
+	result := make(map[string]string, len(values))
+	for key, value := range values {
+		result[key] = value
+	}
+
+	return result
+}
@@ -0,0 +1,108 @@
+// Copyright [2021] [Christian Bandowski]
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testScenario = `# power failure
+STATUS: ONLINE
+BCHARGE: 100.0 Percent
+TIMELEFT: 30.0
+TIMER 60
+
+STATUS: ONBATT
+TIMER 30
+BCHARGE: 20.0
+STATUS: ONBATT LOWBATT
+`
+
+func TestParseScenario(t *testing.T) {
+	steps, err := parseScenario(strings.NewReader(testScenario))
+
+	assert.NoError(t, err)
+	assert.Equal(t, []scenarioStep{
+		{values: map[string]string{"STATUS": "ONLINE", "BCHARGE": "100.0", "TIMELEFT": "30.0"},
+			duration: time.Minute},
+		{values: map[string]string{"STATUS": "ONBATT", "BCHARGE": "100.0", "TIMELEFT": "30.0"},
+			duration: 30 * time.Second},
+		{values: map[string]string{"STATUS": "ONBATT LOWBATT", "BCHARGE": "20.0", "TIMELEFT": "30.0"}},
+	}, steps)
+}
+
+func TestParseScenario_Invalid(t *testing.T) {
+	scenarioToError := map[string]string{
+		"STATUS ONLINE":         "Invalid line 1",
+		"STATUS: ONLINE\nTIMER": "Invalid timer in line 2",
+		"TIMER soon":            "Invalid timer in line 1, must be a positive number of seconds",
+		"TIMER -1":              "Invalid timer in line 1, must be a positive number of seconds",
+	}
+
+	for scenario, expError := range scenarioToError {
+		t.Run(scenario, func(t *testing.T) {
+			_, err := parseScenario(strings.NewReader(scenario))
+
+			assert.EqualError(t, err, expError)
+		})
+	}
+}
+
+func TestScenarioValues(t *testing.T) {
+	online := map[string]string{"STATUS": "ONLINE"}
+	onBattery := map[string]string{"STATUS": "ONBATT"}
+	repeated := []scenarioStep{{values: online, duration: time.Minute}, {values: onBattery, duration: time.Minute}}
+	final := []scenarioStep{{values: online, duration: time.Minute}, {values: onBattery}}
+
+	assert.Equal(t, online, scenarioValues(repeated, 0))
+	assert.Equal(t, onBattery, scenarioValues(repeated, 90*time.Second))
+	assert.Equal(t, online, scenarioValues(repeated, 150*time.Second))
+	assert.Equal(t, online, scenarioValues(final, 59*time.Second))
+	assert.Equal(t, onBattery, scenarioValues(final, time.Hour))
+}
+
+func TestDummyDataSource_load(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "scenario.dev")
+	if !assert.NoError(t, os.WriteFile(path, []byte(testScenario), 0600)) {
+		return
+	}
+	source := NewDummyDataSource(path)
+	now := source.start
+	source.now = func() time.Time { return now }
+
+	values, err := source.load(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "ONLINE", values["STATUS"])
+
+	now = now.Add(time.Hour)
+	values, err = source.load(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "ONBATT LOWBATT", values["STATUS"])
+	assert.Equal(t, "scenario file "+path, source.String())
+}
+
+func TestDummyDataSource_load_MissingFile(t *testing.T) {
+	source := NewDummyDataSource(filepath.Join(t.TempDir(), "scenario.dev"))
+
+	_, err := source.load(context.Background())
+
+	assert.Error(t, err)
+}
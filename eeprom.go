@@ -0,0 +1,84 @@
+// Copyright [2021] [Christian Bandowski]
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"strconv"
+	"strings"
+)
+
+// A VarWriter is a function that will be attached to writable NUT variables and changes their value on the UPS.
+type VarWriter func(ctx context.Context, name string, value string, config *Config) error
+
+// variables that can be changed in the EEPROM of the UPS, e.g. by using apctest
+var eepromVars = map[string]bool{
+	"input.sensitivity":   true,
+	"input.transfer.high": true,
+	"input.transfer.low":  true,
+	"battery.runtime.low": true,
+	"ups.delay.shutdown":  true,
+}
+
+// EepromVarWriter is a VarWriter that invokes the configured EEPROM command to change the value in the EEPROM of the
+// UPS. The placeholders {var} and {value} in the arguments of the command are replaced by the name of the variable and
+// the new value. The command is executed directly and not by a shell, so the value can't inject other commands.
+func EepromVarWriter(ctx context.Context, name string, value string, config *Config) error {
+	fields := strings.Fields(config.eepromCommand)
+	if len(fields) == 0 {
+		return errors.Errorf("No EEPROM command configured to change %s", name)
+	}
+
+	args := make([]string, len(fields)-1)
+	for i, field := range fields[1:] {
+		field = strings.ReplaceAll(field, "{var}", name)
+		args[i] = strings.ReplaceAll(field, "{value}", value)
+	}
+
+	if _, err := execCommand(ctx, fields[0], args...); err != nil {
+		return errors.Wrapf(err, "Couldn't change %s in the EEPROM", name)
+	}
+
+	return nil
+}
+
+// confirmVarValue checks whether the value reported for the variable matches the expected value. Numbers are compared
+// numerically, as apcupsd reports them with decimals, e.g. "253.0".
+func confirmVarValue(ctx context.Context, name string, expValue string, config *Config, av IApcValues) error {
+	// the values loaded before the change are outdated
+	config.invalidateCache()
+	av.invalidate()
+	if err := av.reload(ctx, config); err != nil {
+		return errors.Wrapf(err, "Couldn't confirm the new value of %s", name)
+	}
+
+	value, err := config.vars[name](name, config, av)
+	if err != nil {
+		return errors.Wrapf(err, "Couldn't confirm the new value of %s", name)
+	}
+
+	expNumber, expErr := strconv.ParseFloat(expValue, 64)
+	number, err := strconv.ParseFloat(value, 64)
+	if expErr == nil && err == nil {
+		if expNumber == number {
+			return nil
+		}
+	} else if strings.EqualFold(expValue, value) {
+		return nil
+	}
+
+	return errors.Errorf("The UPS reports %s for %s instead of the requested value %s", value, name, expValue)
+}
@@ -0,0 +1,80 @@
+// Copyright [2021] [Christian Bandowski]
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"testing"
+)
+
+func TestEepromVarWriter(t *testing.T) {
+	// test only succeeds if the placeholders were replaced
+	err := EepromVarWriter(context.Background(), "input.sensitivity", "High", &Config{
+		eepromCommand: "test {var}={value} = input.sensitivity=High",
+	})
+
+	assert.NoError(t, err)
+}
+
+func TestEepromVarWriter_Failing(t *testing.T) {
+	err := EepromVarWriter(context.Background(), "input.sensitivity", "High", &Config{
+		eepromCommand: "false",
+	})
+
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "Couldn't change input.sensitivity in the EEPROM")
+	}
+}
+
+func TestEepromVarWriter_NoCommand(t *testing.T) {
+	err := EepromVarWriter(context.Background(), "input.sensitivity", "High", &Config{})
+
+	assert.EqualError(t, err, "No EEPROM command configured to change input.sensitivity")
+}
+
+func TestConfirmVarValue(t *testing.T) {
+	testCases := []struct {
+		apcValue string
+		expValue string
+		success  bool
+	}{
+		{"253.0", "253", true},
+		{"253.0", "254", false},
+		{"High", "high", true},
+		{"High", "Low", false},
+	}
+
+	for _, testCase := range testCases {
+		t.Run("value="+testCase.expValue, func(t *testing.T) {
+			apcValuesMock := &mockApcValues{}
+			apcValuesMock.On("reload", mock.Anything, mock.Anything).Return(nil)
+			apcValuesMock.On("getOk", "KEY").Return(testCase.apcValue, true)
+
+			err := confirmVarValue(context.Background(), "foo", testCase.expValue, &Config{
+				vars: map[string]VarLoader{
+					"foo": ApcValue("KEY", IgnoreValue),
+				},
+			}, apcValuesMock)
+
+			if testCase.success {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+}
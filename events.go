@@ -0,0 +1,150 @@
+// Copyright [2021] [Christian Bandowski]
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// format of the timestamps in the events file of apcupsd
+const apcEventTimeFormat = "2006-01-02 15:04:05 -0700"
+
+// UpsEvent is an event logged by apcupsd, like a power failure, a self test or a transfer to battery.
+type UpsEvent struct {
+	Time    time.Time `json:"time"`
+	Message string    `json:"message"`
+}
+
+// loadEvents loads the most recent events from the given events file of apcupsd, at most the given number.
+func loadEvents(path string, max int) ([]UpsEvent, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Couldn't open events file %s", path)
+	}
+	defer file.Close()
+
+	events, err := parseEvents(file, max)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Couldn't read events file %s", path)
+	}
+
+	return events, nil
+}
+
+// parseEvents parses the events logged by apcupsd, one per line like
+//
+//	2021-03-14 12:00:00 +0100  Power failure.
+//
+// Only the given number of most recent events are returned, oldest first. Lines without a timestamp are skipped.
+func parseEvents(reader io.Reader, max int) ([]UpsEvent, error) {
+	var events []UpsEvent
+
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if len(line) < len(apcEventTimeFormat) {
+			continue
+		}
+
+		eventTime, err := time.Parse(apcEventTimeFormat, line[:len(apcEventTimeFormat)])
+		if err != nil {
+			continue
+		}
+
+		events = append(events, UpsEvent{
+			Time:    eventTime,
+			Message: strings.TrimSpace(line[len(apcEventTimeFormat):]),
+		})
+		if len(events) > max {
+			events = events[1:]
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return events, nil
+}
+
+// commandListEvents handles the LIST EVENTS command, a vendor command listing the most recent events logged by
+// apcupsd, oldest first.
+func commandListEvents(ctx context.Context, args []string, config *Config, session *Session,
+	apcValues IApcValues) (string, bool, error) {
+
+	if len(args) != 1 {
+		return "ERR INVALID-ARGUMENT", false, nil
+	}
+	if args[0] != config.upsName {
+		return "ERR UNKNOWN-UPS", false, nil
+	}
+	if config.eventsFile == "" {
+		return "ERR FEATURE-NOT-CONFIGURED", false, nil
+	}
+	upsName := formatArg(config.upsName)
+
+	events, err := loadEvents(config.eventsFile, config.maxEvents)
+	if err != nil {
+		return "ERR DATA-STALE", false, errors.WithStack(err)
+	}
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("BEGIN LIST EVENTS %s\n", upsName))
+
+	for _, event := range events {
+		sb.WriteString(fmt.Sprintf("EVENT %s %s %s\n", upsName, quote(event.Time.Format(time.RFC3339)),
+			quote(event.Message)))
+	}
+
+	sb.WriteString(fmt.Sprintf("END LIST EVENTS %s\n", upsName))
+
+	return sb.String(), false, nil
+}
+
+// eventsHandler serves the most recent events of all UPSes with an events file as JSON, keyed by the UPS name.
+func eventsHandler(config *Config) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upsEvents := make(map[string][]UpsEvent)
+		for _, ups := range config.upsConfigs() {
+			if ups.eventsFile == "" {
+				continue
+			}
+
+			events, err := loadEvents(ups.eventsFile, ups.maxEvents)
+			if err != nil {
+				logWarnf("Couldn't load the events of UPS %s: %+v", ups.upsName, err)
+				http.Error(w, "Couldn't load the events", http.StatusInternalServerError)
+				return
+			}
+			if events == nil {
+				events = []UpsEvent{}
+			}
+			upsEvents[ups.upsName] = events
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(upsEvents); err != nil {
+			logWarnf("Couldn't send the events: %+v", errors.WithStack(err))
+		}
+	})
+}
@@ -0,0 +1,104 @@
+// Copyright [2021] [Christian Bandowski]
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testEvents = `2021-03-14 11:58:00 +0100  apcupsd 3.14.14 (31 May 2016) debian startup succeeded
+2021-03-14 12:00:00 +0100  Power failure.
+garbage
+2021-03-14 12:00:06 +0100  Running on UPS batteries.
+2021-03-14 12:01:00 +0100  Mains returned. No longer on UPS batteries.
+`
+
+// writeTestEvents writes the test events to a temporary events file.
+func writeTestEvents(t *testing.T) string {
+	eventsFile := filepath.Join(t.TempDir(), "apcupsd.events")
+	if err := os.WriteFile(eventsFile, []byte(testEvents), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return eventsFile
+}
+
+func TestParseEvents(t *testing.T) {
+	events, err := parseEvents(strings.NewReader(testEvents), 2)
+
+	assert.NoError(t, err)
+	if assert.Len(t, events, 2) {
+		assert.Equal(t, "Running on UPS batteries.", events[0].Message)
+		assert.Equal(t, "Mains returned. No longer on UPS batteries.", events[1].Message)
+		assert.Equal(t, time.Date(2021, 3, 14, 11, 1, 0, 0, time.UTC), events[1].Time.UTC())
+	}
+}
+
+func TestCommandReceived_ListEvents(t *testing.T) {
+	eventsFile := writeTestEvents(t)
+	commandToResponse := map[string]string{
+		"LIST EVENTS test": "BEGIN LIST EVENTS test\n" +
+			"EVENT test \"2021-03-14T12:00:06+01:00\" \"Running on UPS batteries.\"\n" +
+			"EVENT test \"2021-03-14T12:01:00+01:00\" \"Mains returned. No longer on UPS batteries.\"\n" +
+			"END LIST EVENTS test\n",
+		"LIST EVENTS":         "ERR INVALID-ARGUMENT",
+		"LIST EVENTS unknown": "ERR UNKNOWN-UPS",
+	}
+
+	for command, expResponse := range commandToResponse {
+		t.Run(command, func(t *testing.T) {
+			response, _, err := commandReceived(context.Background(), command, &Config{
+				upsName:    "test",
+				eventsFile: eventsFile,
+				maxEvents:  2,
+			}, NewSession("127.0.0.1", NewSessionRegistry()), &mockApcValues{})
+
+			assert.NoError(t, err)
+			assert.Equal(t, expResponse, response)
+		})
+	}
+}
+
+func TestCommandReceived_ListEvents_NotConfigured(t *testing.T) {
+	response, _, err := commandReceived(context.Background(), "LIST EVENTS test", &Config{upsName: "test"},
+		NewSession("127.0.0.1", NewSessionRegistry()), &mockApcValues{})
+
+	assert.NoError(t, err)
+	assert.Equal(t, "ERR FEATURE-NOT-CONFIGURED", response)
+}
+
+func TestEventsHandler(t *testing.T) {
+	config := &Config{}
+	config.upses = []*Config{
+		{upsName: "first", eventsFile: writeTestEvents(t), maxEvents: 1},
+		{upsName: "second"},
+	}
+	recorder := httptest.NewRecorder()
+
+	eventsHandler(config).ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/events", nil))
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "application/json", recorder.Header().Get("Content-Type"))
+	assert.JSONEq(t, `{"first": [{"time": "2021-03-14T12:01:00+01:00",
+		"message": "Mains returned. No longer on UPS batteries."}]}`, recorder.Body.String())
+}
@@ -0,0 +1,95 @@
+// Copyright [2021] [Christian Bandowski]
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"os"
+	"time"
+)
+
+// startEventWatchers watches the events files of the UPSes until the context is done and refreshes their values once
+// apcupsd logs a new event, so e.g. a transfer to battery reaches the clients without waiting for the poll interval.
+func (c *Config) startEventWatchers(ctx context.Context) {
+	if c.eventsWatchInterval <= 0 {
+		return
+	}
+
+	for _, ups := range c.upsConfigs() {
+		if ups.eventsFile != "" {
+			go watchEvents(ctx, ups, c.eventsWatchInterval)
+		}
+	}
+}
+
+// eventsFileState is the state of an events file used to notice new events, apcupsd appends them to the file.
+type eventsFileState struct {
+	size    int64
+	modTime time.Time
+}
+
+// statEventsFile returns the state of the events file, a missing file has the zero state.
+func statEventsFile(path string) eventsFileState {
+	info, err := os.Stat(path)
+	if err != nil {
+		return eventsFileState{}
+	}
+
+	return eventsFileState{size: info.Size(), modTime: info.ModTime()}
+}
+
+// watchEvents checks the events file of the UPS after every interval and refreshes the values once it changed.
+func watchEvents(ctx context.Context, config *Config, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	state := statEventsFile(config.eventsFile)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		newState := statEventsFile(config.eventsFile)
+		if newState == state {
+			continue
+		}
+		state = newState
+
+		logInfof("New event of UPS %s logged, refreshing its values", config.upsName)
+		refreshValues(ctx, config)
+	}
+}
+
+// refreshValues drops the values kept for the UPS, the cached values are loaded again right away.
+func refreshValues(ctx context.Context, config *Config) {
+	config.invalidateCache()
+
+	cache, ok := cachingSource(config.source)
+	if !ok {
+		// the values are loaded again by the next command of a client
+		return
+	}
+
+	loadCtx, cancel := context.WithTimeout(ctx, config.timeout)
+	defer cancel()
+
+	err := cache.refresh(loadCtx)
+	config.state.recordSourceLoad(err)
+	if err != nil && ctx.Err() == nil {
+		logWarnf("Refreshing the values of UPS %s after an event failed: %v", config.upsName, err)
+	}
+}
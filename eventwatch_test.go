@@ -0,0 +1,63 @@
+// Copyright [2021] [Christian Bandowski]
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWatchEvents(t *testing.T) {
+	eventsFile := filepath.Join(t.TempDir(), "apcupsd.events")
+	if !assert.NoError(t, os.WriteFile(eventsFile, []byte("2021-03-14 12:00:00 +0100  Power is back.\n"), 0600)) {
+		return
+	}
+	source := &countingDataSource{}
+	config := &Config{upsName: "ups", eventsFile: eventsFile, timeout: time.Second, state: NewUpsState(),
+		source: NewCachingDataSource(source, time.Hour)}
+	_, err := config.source.load(context.Background())
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go watchEvents(ctx, config, 10*time.Millisecond)
+
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&source.loads))
+
+	// a new event refreshes the cached values right away
+	events := "2021-03-14 12:00:00 +0100  Power is back.\n2021-03-14 13:00:00 +0100  Power failure.\n"
+	assert.NoError(t, os.WriteFile(eventsFile, []byte(events), 0600))
+	assert.Eventually(t, func() bool { return atomic.LoadInt32(&source.loads) == 2 }, time.Second,
+		10*time.Millisecond)
+	assert.True(t, config.state.invalidatedSince(time.Now().Add(-time.Second)))
+}
+
+func TestApcValues_fresh_Invalidated(t *testing.T) {
+	config := &Config{source: &countingDataSource{}, pollInterval: time.Minute, state: NewUpsState()}
+	apcValues := NewApcValues()
+	assert.NoError(t, apcValues.reload(context.Background(), config))
+	assert.True(t, apcValues.fresh(config))
+
+	time.Sleep(time.Millisecond)
+	config.invalidateCache()
+
+	assert.False(t, apcValues.fresh(config))
+}
@@ -0,0 +1,244 @@
+// Copyright [2021] [Christian Bandowski]
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"github.com/pkg/errors"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// An expression computes a number from the apc values. It returns false if an apc value it uses isn't reported or the
+// result is undefined, like a division by zero while the UPS is idle.
+type expression func(av IApcValues) (float64, bool, error)
+
+// ComputedValue is a function that creates a VarLoader which evaluates the given expression, see parseExpression. The
+// result is rounded to two decimals, it is empty if an apc value used by the expression isn't reported.
+func ComputedValue(expr expression) func(name string, config *Config, av IApcValues) (string, error) {
+	return func(name string, config *Config, av IApcValues) (string, error) {
+		value, ok, err := expr(av)
+		if err != nil {
+			return "", errors.Wrapf(err, "Couldn't compute variable %s", name)
+		}
+		if !ok {
+			return "", nil
+		}
+
+		return strconv.FormatFloat(math.Round(value*100)/100, 'f', -1, 64), nil
+	}
+}
+
+// parseExpression parses an arithmetic expression over the apc values, like "LOADPCT / 100 * NOMPOWER". It supports
+// numbers, apc keys, the operators +, -, * and / and parentheses. Apc values are used by their leading number, so
+// units like in "27.0 Percent" are ignored.
+func parseExpression(input string) (expression, error) {
+	p := &expressionParser{input: input}
+	p.next()
+
+	expr, err := p.parseSum()
+	if err != nil {
+		return nil, errors.Wrapf(err, "Invalid expression %s", input)
+	}
+	if p.token != "" {
+		return nil, errors.Errorf("Invalid expression %s, unexpected %s", input, p.token)
+	}
+
+	return expr, nil
+}
+
+// expressionApcKeys returns the apc keys used by the expression, in the order they are used.
+func expressionApcKeys(input string) []string {
+	var keys []string
+	p := &expressionParser{input: input}
+	for p.next(); p.token != ""; p.next() {
+		c := rune(p.token[0])
+		if isExpressionNameChar(c) && !unicode.IsDigit(c) && c != '.' {
+			keys = append(keys, p.token)
+		}
+	}
+
+	return keys
+}
+
+// expressionParser is a recursive descent parser of expressions, it reads the input token by token.
+type expressionParser struct {
+	input string
+	// position of the next token in the input
+	pos int
+	// current token, empty at the end of the input
+	token string
+}
+
+// next reads the next token.
+func (p *expressionParser) next() {
+	for p.pos < len(p.input) && unicode.IsSpace(rune(p.input[p.pos])) {
+		p.pos++
+	}
+
+	start := p.pos
+	if p.pos < len(p.input) && isExpressionNameChar(rune(p.input[p.pos])) {
+		for p.pos < len(p.input) && isExpressionNameChar(rune(p.input[p.pos])) {
+			p.pos++
+		}
+	} else if p.pos < len(p.input) {
+		p.pos++
+	}
+	p.token = p.input[start:p.pos]
+}
+
+// isExpressionNameChar checks whether the character is part of a number or an apc key.
+func isExpressionNameChar(c rune) bool {
+	return unicode.IsLetter(c) || unicode.IsDigit(c) || c == '_' || c == '.'
+}
+
+// parseSum parses terms separated by + and -.
+func (p *expressionParser) parseSum() (expression, error) {
+	left, err := p.parseProduct()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.token == "+" || p.token == "-" {
+		operator := p.token
+		p.next()
+		right, err := p.parseProduct()
+		if err != nil {
+			return nil, err
+		}
+
+		left = binaryExpression(operator, left, right)
+	}
+
+	return left, nil
+}
+
+// parseProduct parses factors separated by * and /.
+func (p *expressionParser) parseProduct() (expression, error) {
+	left, err := p.parseFactor()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.token == "*" || p.token == "/" {
+		operator := p.token
+		p.next()
+		right, err := p.parseFactor()
+		if err != nil {
+			return nil, err
+		}
+
+		left = binaryExpression(operator, left, right)
+	}
+
+	return left, nil
+}
+
+// parseFactor parses a number, an apc key, a negated factor or an expression in parentheses.
+func (p *expressionParser) parseFactor() (expression, error) {
+	token := p.token
+	switch {
+	case token == "":
+		return nil, errors.New("Unexpected end")
+	case token == "-":
+		p.next()
+		operand, err := p.parseFactor()
+		if err != nil {
+			return nil, err
+		}
+
+		return binaryExpression("-", constantExpression(0), operand), nil
+	case token == "(":
+		p.next()
+		expr, err := p.parseSum()
+		if err != nil {
+			return nil, err
+		}
+		if p.token != ")" {
+			return nil, errors.New("Missing closing parenthesis")
+		}
+		p.next()
+
+		return expr, nil
+	case unicode.IsDigit(rune(token[0])) || token[0] == '.':
+		value, err := strconv.ParseFloat(token, 64)
+		if err != nil {
+			return nil, errors.Errorf("Invalid number %s", token)
+		}
+		p.next()
+
+		return constantExpression(value), nil
+	case isExpressionNameChar(rune(token[0])):
+		p.next()
+
+		return apcValueExpression(token), nil
+	default:
+		return nil, errors.Errorf("Unexpected %s", token)
+	}
+}
+
+// constantExpression returns an expression always evaluating to the given value.
+func constantExpression(value float64) expression {
+	return func(av IApcValues) (float64, bool, error) {
+		return value, true, nil
+	}
+}
+
+// apcValueExpression returns an expression evaluating to the leading number of the apc value with the given key.
+func apcValueExpression(apcKey string) expression {
+	return func(av IApcValues) (float64, bool, error) {
+		value, ok := av.getOk(apcKey)
+		if !ok || strings.TrimSpace(value) == "" {
+			return 0, false, nil
+		}
+
+		number, err := leadingNumber(value)
+		if err != nil {
+			return 0, false, errors.Wrapf(err, "Invalid %s value", apcKey)
+		}
+
+		return number, true, nil
+	}
+}
+
+// binaryExpression returns an expression applying the operator to the results of both operands.
+func binaryExpression(operator string, left expression, right expression) expression {
+	return func(av IApcValues) (float64, bool, error) {
+		leftValue, ok, err := left(av)
+		if !ok || err != nil {
+			return 0, ok, err
+		}
+		rightValue, ok, err := right(av)
+		if !ok || err != nil {
+			return 0, ok, err
+		}
+
+		switch operator {
+		case "+":
+			return leftValue + rightValue, true, nil
+		case "-":
+			return leftValue - rightValue, true, nil
+		case "*":
+			return leftValue * rightValue, true, nil
+		default:
+			if rightValue == 0 {
+				return 0, false, nil
+			}
+
+			return leftValue / rightValue, true, nil
+		}
+	}
+}
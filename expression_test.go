@@ -0,0 +1,70 @@
+// Copyright [2021] [Christian Bandowski]
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestParseExpression(t *testing.T) {
+	values := &ApcValues{values: map[string]string{
+		"LOADPCT":  "27.0 Percent",
+		"NOMPOWER": "900 Watts",
+		"BCHARGE":  "100.0",
+		"ZERO":     "0",
+		"MODEL":    "Back-UPS",
+	}}
+
+	testCases := []struct {
+		name       string
+		expression string
+		value      string
+		err        string
+	}{
+		{"apc values", "LOADPCT/100 * NOMPOWER", "243", ""},
+		{"precedence", "1 + 2 * 3", "7", ""},
+		{"parentheses", "(1 + 2) * 3", "9", ""},
+		{"negation", "-BCHARGE + 150", "50", ""},
+		{"subtraction is left associative", "10 - 4 - 3", "3", ""},
+		{"rounding", "10 / 3", "3.33", ""},
+		{"missing apc value", "NOMPOWER * LINEV", "", ""},
+		{"division by zero", "BCHARGE / ZERO", "", ""},
+		{"no number", "MODEL * 2", "",
+			"Couldn't compute variable test: Invalid MODEL value: The value Back-UPS is not a number"},
+		{"missing operand", "LOADPCT *", "", "Invalid expression LOADPCT *: Unexpected end"},
+		{"missing parenthesis", "(LOADPCT", "", "Invalid expression (LOADPCT: Missing closing parenthesis"},
+		{"trailing token", "LOADPCT )", "", "Invalid expression LOADPCT ), unexpected )"},
+		{"invalid number", "2x", "", "Invalid expression 2x: Invalid number 2x"},
+		{"invalid character", "LOADPCT % 2", "", "Invalid expression LOADPCT % 2, unexpected %"},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			expr, err := parseExpression(testCase.expression)
+			var value string
+			if err == nil {
+				value, err = ComputedValue(expr)("test", &Config{}, values)
+			}
+
+			if testCase.err != "" {
+				assert.EqualError(t, err, testCase.err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, testCase.value, value)
+		})
+	}
+}
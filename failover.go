@@ -0,0 +1,116 @@
+// Copyright [2021] [Christian Bandowski]
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/pkg/errors"
+	"sync"
+	"time"
+)
+
+// time after which a failed primary source is tried again
+const failoverRetryInterval = time.Minute
+
+// FailoverDataSource loads the values from the first of its sources that is reachable. Once the primary source fails,
+// the following ones are used until the primary is reachable again, which is checked once per retry interval.
+type FailoverDataSource struct {
+	upsName string
+	sources []DataSource
+
+	mutex sync.Mutex
+
+	// index of the source the values were loaded from last time
+	active int
+
+	// last time the primary source failed
+	primaryFailure time.Time
+
+	// returns the current time, replaced by tests
+	now func() time.Time
+}
+
+// NewFailoverDataSource creates a new instance of FailoverDataSource, the first source is the primary one.
+func NewFailoverDataSource(upsName string, sources []DataSource) *FailoverDataSource {
+	return &FailoverDataSource{upsName: upsName, sources: sources, now: time.Now}
+}
+
+// load tries the active source first, or the primary one if the retry interval passed, and the others afterwards.
+func (s *FailoverDataSource) load(ctx context.Context) (map[string]string, error) {
+	var err error
+	for _, i := range s.order() {
+		var values map[string]string
+		values, err = s.sources[i].load(ctx)
+		if err == nil {
+			s.activate(i)
+			return values, nil
+		}
+
+		logWarnf("Loading the values of UPS %s from %s failed: %v", s.upsName, s.sources[i], err)
+		if i == 0 {
+			s.mutex.Lock()
+			s.primaryFailure = s.now()
+			s.mutex.Unlock()
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+
+	return nil, errors.Wrapf(err, "All sources of UPS %s failed", s.upsName)
+}
+
+// order returns the indexes of the sources in the order they are tried.
+func (s *FailoverDataSource) order() []int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	first := s.active
+	if s.now().Sub(s.primaryFailure) >= failoverRetryInterval {
+		first = 0
+	}
+
+	order := []int{first}
+	for i := range s.sources {
+		if i != first {
+			order = append(order, i)
+		}
+	}
+
+	return order
+}
+
+// activate records the source the values were loaded from, switching to another one is logged and counted.
+func (s *FailoverDataSource) activate(i int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.active == i {
+		return
+	}
+
+	logWarnf("UPS %s switched from %s to %s", s.upsName, s.sources[s.active], s.sources[i])
+	metricSourceFailovers.Add(s.upsName, 1)
+	s.active = i
+}
+
+// String returns the active source.
+func (s *FailoverDataSource) String() string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return fmt.Sprintf("%s (source %d of %d)", s.sources[s.active], s.active+1, len(s.sources))
+}
@@ -0,0 +1,88 @@
+// Copyright [2021] [Christian Bandowski]
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestFailoverDataSource_load(t *testing.T) {
+	primary := &mockDataSource{}
+	secondary := &mockDataSource{}
+	source := NewFailoverDataSource("failover-test", []DataSource{primary, secondary})
+	now := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	source.now = func() time.Time { return now }
+	failovers := func() int64 {
+		if v := metricSourceFailovers.Get("failover-test"); v != nil {
+			return v.(interface{ Value() int64 }).Value()
+		}
+		return 0
+	}
+
+	// the primary is used while it is reachable
+	primary.On("load", context.Background()).Return(map[string]string{"STATUS": "ONLINE"}, nil).Once()
+	values, err := source.load(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"STATUS": "ONLINE"}, values)
+	assert.Equal(t, 0, source.active)
+
+	// the secondary is used once the primary fails
+	primary.On("load", context.Background()).Return(nil, errors.New("unreachable")).Once()
+	secondary.On("load", context.Background()).Return(map[string]string{"STATUS": "ONBATT"}, nil)
+	values, err = source.load(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"STATUS": "ONBATT"}, values)
+	assert.Equal(t, 1, source.active)
+	assert.Equal(t, int64(1), failovers())
+
+	// the primary isn't tried again until the retry interval passed
+	_, err = source.load(context.Background())
+	assert.NoError(t, err)
+	primary.AssertNumberOfCalls(t, "load", 2)
+
+	// the primary is used again once it is reachable
+	now = now.Add(failoverRetryInterval)
+	primary.On("load", context.Background()).Return(map[string]string{"STATUS": "ONLINE"}, nil).Once()
+	values, err = source.load(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"STATUS": "ONLINE"}, values)
+	assert.Equal(t, 0, source.active)
+	assert.Equal(t, int64(2), failovers())
+}
+
+func TestFailoverDataSource_load_AllFailed(t *testing.T) {
+	primary := &mockDataSource{}
+	primary.On("load", context.Background()).Return(nil, errors.New("unreachable"))
+	secondary := &mockDataSource{}
+	secondary.On("load", context.Background()).Return(nil, errors.New("refused"))
+	source := NewFailoverDataSource("ups", []DataSource{primary, secondary})
+
+	_, err := source.load(context.Background())
+
+	assert.EqualError(t, err, "All sources of UPS ups failed: refused")
+	assert.Equal(t, 0, source.active)
+}
+
+func TestFailoverDataSource_String(t *testing.T) {
+	source := NewFailoverDataSource("ups", []DataSource{&NisDataSource{address: "10.0.0.1"},
+		&NisDataSource{address: "10.0.0.2"}})
+	source.active = 1
+
+	assert.Equal(t, "network information server 10.0.0.2 (source 2 of 2)", source.String())
+}
@@ -0,0 +1,88 @@
+// Copyright [2021] [Christian Bandowski]
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"strings"
+)
+
+// An InstCmd is a function that will be attached to NUT instant commands and executes them. It can access the
+// configuration to find out how the command should be executed.
+type InstCmd func(ctx context.Context, name string, config *Config) error
+
+// defaultCmds returns the NUT instant commands supported by the proxy and the InstCmd used to execute each of them.
+func defaultCmds() map[string]InstCmd {
+	return map[string]InstCmd{
+		"shutdown.return": ApcupsdInstCmd("--killpower"),
+
+		// apctest is menu driven and its menu depends on the driver, only the USB and MODBUS ones offer self tests
+		"test.battery.start": ApctestInstCmd(map[string]string{"USB": "2", "MODBUS": "2"}),
+		"test.panel.start":   ApctestInstCmd(map[string]string{"USB": "11", "MODBUS": "11"}),
+
+		// apcupsd can't control the beeper, the status is maintained by the proxy and reported as ups.beeper.status
+		"beeper.enable":  BeeperInstCmd("enabled"),
+		"beeper.disable": BeeperInstCmd("disabled"),
+		"beeper.mute":    BeeperInstCmd("muted"),
+	}
+}
+
+// ApcupsdInstCmd is a function that creates an InstCmd which invokes the apcupsd executable with the given arguments.
+func ApcupsdInstCmd(args ...string) InstCmd {
+	return func(ctx context.Context, name string, config *Config) error {
+		if _, err := execCommand(ctx, config.apcupsdExecutable, args...); err != nil {
+			return errors.Wrapf(err, "Couldn't execute instant command %s", name)
+		}
+
+		return nil
+	}
+}
+
+// ApctestInstCmd is a function that creates an InstCmd which invokes the apctest executable, selects the menu entry of
+// the driver reported by apcupsd and quits apctest afterwards. The menu entries are keyed by the first word of the
+// driver, e.g. USB for "USB UPS Driver". apctest can only access the UPS while apcupsd is not running, so the apctest
+// executable has to be a wrapper which stops apcupsd around the call.
+func ApctestInstCmd(menuEntries map[string]string) InstCmd {
+	return func(ctx context.Context, name string, config *Config) error {
+		values, err := config.source.load(ctx)
+		if err != nil {
+			return errors.Wrapf(err, "Couldn't determine the driver for instant command %s", name)
+		}
+		driver := values["DRIVER"]
+		menuEntry, ok := menuEntries[strings.ToUpper(strings.SplitN(driver, " ", 2)[0])]
+		if !ok {
+			return errors.Errorf("Instant command %s isn't supported by apctest with driver %q", name, driver)
+		}
+
+		input := menuEntry + "\nQ\n"
+		if _, err := execCommandWithInput(ctx, input, config.apctestExecutable); err != nil {
+			return errors.Wrapf(err, "Couldn't execute instant command %s", name)
+		}
+
+		return nil
+	}
+}
+
+// BeeperInstCmd is a function that creates an InstCmd which stores the given beeper status in the state of the UPS.
+func BeeperInstCmd(status string) InstCmd {
+	return func(ctx context.Context, name string, config *Config) error {
+		if err := config.state.setBeeperStatus(status); err != nil {
+			return errors.Wrapf(err, "Couldn't execute instant command %s", name)
+		}
+
+		return nil
+	}
+}
@@ -0,0 +1,105 @@
+// Copyright [2021] [Christian Bandowski]
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"testing"
+)
+
+func TestApcupsdInstCmd(t *testing.T) {
+	err := ApcupsdInstCmd("--killpower")(context.Background(), "shutdown.return", &Config{
+		apcupsdExecutable: "true",
+	})
+
+	assert.NoError(t, err)
+}
+
+func TestApcupsdInstCmd_Failing(t *testing.T) {
+	err := ApcupsdInstCmd("--killpower")(context.Background(), "shutdown.return", &Config{
+		apcupsdExecutable: "false",
+	})
+
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "Couldn't execute instant command shutdown.return")
+	}
+}
+
+func TestApctestInstCmd(t *testing.T) {
+	source := &mockDataSource{}
+	source.On("load", mock.Anything).Return(map[string]string{"DRIVER": "USB UPS Driver"}, nil)
+
+	err := ApctestInstCmd(map[string]string{"USB": "2"})(context.Background(), "test.battery.start", &Config{
+		apctestExecutable: "cat",
+		source:            source,
+	})
+
+	assert.NoError(t, err)
+}
+
+func TestApctestInstCmd_Failing(t *testing.T) {
+	source := &mockDataSource{}
+	source.On("load", mock.Anything).Return(map[string]string{"DRIVER": "USB UPS Driver"}, nil)
+
+	err := ApctestInstCmd(map[string]string{"USB": "2"})(context.Background(), "test.battery.start", &Config{
+		apctestExecutable: "false",
+		source:            source,
+	})
+
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "Couldn't execute instant command test.battery.start")
+	}
+}
+
+func TestApctestInstCmd_UnsupportedDriver(t *testing.T) {
+	source := &mockDataSource{}
+	source.On("load", mock.Anything).Return(map[string]string{"DRIVER": "APC Smart UPS (any)"}, nil)
+
+	err := ApctestInstCmd(map[string]string{"USB": "2"})(context.Background(), "test.battery.start", &Config{
+		apctestExecutable: "true",
+		source:            source,
+	})
+
+	assert.EqualError(t, err,
+		"Instant command test.battery.start isn't supported by apctest with driver \"APC Smart UPS (any)\"")
+}
+
+func TestApctestInstCmd_SourceFailing(t *testing.T) {
+	source := &mockDataSource{}
+	source.On("load", mock.Anything).Return(nil, errors.New("apcupsd not reachable"))
+
+	err := ApctestInstCmd(map[string]string{"USB": "2"})(context.Background(), "test.battery.start", &Config{
+		apctestExecutable: "true",
+		source:            source,
+	})
+
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "Couldn't determine the driver for instant command test.battery.start")
+	}
+}
+
+func TestBeeperInstCmd(t *testing.T) {
+	config := &Config{state: NewUpsState()}
+
+	err := BeeperInstCmd("disabled")(context.Background(), "beeper.disable", config)
+
+	assert.NoError(t, err)
+	status, ok := config.state.getBeeperStatus()
+	assert.True(t, ok)
+	assert.Equal(t, "disabled", status)
+}
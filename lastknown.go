@@ -0,0 +1,172 @@
+// Copyright [2021] [Christian Bandowski]
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"os"
+	"sync"
+	"time"
+)
+
+// the last known values are persisted at most once per interval, unless the status changed, to spare flash storage
+const snapshotWriteInterval = time.Minute
+
+// persistedSnapshot contains the last known values persisted in the snapshot file.
+type persistedSnapshot struct {
+	Time   time.Time         `json:"time"`
+	Values map[string]string `json:"values"`
+}
+
+// StaleValuesError is returned if the values couldn't be loaded, but the last known ones may be used instead.
+type StaleValuesError struct {
+	err error
+
+	// the last values loaded successfully, they must not be modified
+	values map[string]string
+}
+
+// Error returns the error of loading the values.
+func (e *StaleValuesError) Error() string {
+	return e.err.Error()
+}
+
+// Cause returns the error of loading the values.
+func (e *StaleValuesError) Cause() error {
+	return e.err
+}
+
+// LastKnownDataSource keeps the last values loaded successfully from another source and returns them as
+// StaleValuesError while the source fails, up to the maximum age. So clients can tell a failing source from a UPS on
+// battery by the proxy.data.stale variable.
+type LastKnownDataSource struct {
+	source DataSource
+	maxAge time.Duration
+
+	mutex    sync.Mutex
+	values   map[string]string
+	loadTime time.Time
+
+	// file the last known values are persisted in, empty if they are kept in memory only
+	snapshotFile string
+	// time the values were persisted last
+	writeTime time.Time
+
+	// returns the current time, replaced by tests
+	now func() time.Time
+}
+
+// NewLastKnownDataSource creates a new instance of LastKnownDataSource
+func NewLastKnownDataSource(source DataSource, maxAge time.Duration) *LastKnownDataSource {
+	return &LastKnownDataSource{source: source, maxAge: maxAge, now: time.Now}
+}
+
+// load loads the values from the source, if it fails the last known values are returned as StaleValuesError.
+func (s *LastKnownDataSource) load(ctx context.Context) (map[string]string, error) {
+	values, err := s.source.load(ctx)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err == nil {
+		statusChanged := values["STATUS"] != s.values["STATUS"]
+		s.values = values
+		s.loadTime = s.now()
+		if statusChanged || s.now().Sub(s.writeTime) >= snapshotWriteInterval {
+			s.persist()
+		}
+		return values, nil
+	}
+	if s.values == nil || s.now().Sub(s.loadTime) >= s.maxAge {
+		return nil, err
+	}
+
+	return nil, &StaleValuesError{err: err, values: s.values}
+}
+
+// loadSnapshot loads the last known values persisted in the given file and persists further values in it. A missing
+// file is not an error, it will be created by the first successful load. The time of the persisted values is returned.
+func (s *LastKnownDataSource) loadSnapshot(path string) (time.Time, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.snapshotFile = path
+
+	content, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return time.Time{}, nil
+	} else if err != nil {
+		return time.Time{}, errors.Wrapf(err, "Couldn't read snapshot file %s", path)
+	}
+
+	var snapshot persistedSnapshot
+	if err := json.Unmarshal(content, &snapshot); err != nil {
+		return time.Time{}, errors.Wrapf(err, "Couldn't parse snapshot file %s", path)
+	}
+	s.values = snapshot.Values
+	s.loadTime = snapshot.Time
+	s.writeTime = snapshot.Time
+
+	return snapshot.Time, nil
+}
+
+// persist writes the last known values to the snapshot file, if there is one. Failures are only logged, as the values
+// are still kept in memory.
+func (s *LastKnownDataSource) persist() {
+	if s.snapshotFile == "" {
+		return
+	}
+
+	content, err := json.Marshal(persistedSnapshot{Time: s.loadTime, Values: s.values})
+	if err != nil {
+		logWarnf("Couldn't persist the last known values: %+v", errors.WithStack(err))
+		return
+	}
+
+	// write to a temporary file first, so the snapshot file is never left half written
+	tmpFile := s.snapshotFile + ".tmp"
+	if err := os.WriteFile(tmpFile, content, 0600); err != nil {
+		logWarnf("Couldn't write snapshot file %s: %s", tmpFile, err)
+		return
+	}
+	if err := os.Rename(tmpFile, s.snapshotFile); err != nil {
+		logWarnf("Couldn't replace snapshot file %s: %s", s.snapshotFile, err)
+		return
+	}
+	s.writeTime = s.loadTime
+}
+
+// invalidate drops the values kept by the source, the last known values are kept while it fails.
+func (s *LastKnownDataSource) invalidate() {
+	invalidateSource(s.source)
+}
+
+// String returns the source.
+func (s *LastKnownDataSource) String() string {
+	return fmt.Sprintf("%s (last known values kept for %s)", s.source, s.maxAge)
+}
+
+// staleValues returns the last known values if the error is a StaleValuesError.
+func staleValues(err error) (map[string]string, bool) {
+	var staleErr *StaleValuesError
+	if errors.As(err, &staleErr) {
+		return staleErr.values, true
+	}
+
+	return nil, false
+}
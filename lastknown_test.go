@@ -0,0 +1,123 @@
+// Copyright [2021] [Christian Bandowski]
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLastKnownDataSource_load(t *testing.T) {
+	source := &mockDataSource{}
+	source.On("load", mock.Anything).Return(map[string]string{"STATUS": "ONLINE"}, nil).Once()
+	source.On("load", mock.Anything).Return(nil, errors.New("unreachable"))
+	now := time.Now()
+	lastKnown := NewLastKnownDataSource(source, time.Minute)
+	lastKnown.now = func() time.Time { return now }
+
+	values, err := lastKnown.load(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"STATUS": "ONLINE"}, values)
+
+	now = now.Add(30 * time.Second)
+	_, err = lastKnown.load(context.Background())
+	assert.EqualError(t, err, "unreachable")
+	values, ok := staleValues(errors.Wrap(err, "Loading failed"))
+	assert.True(t, ok)
+	assert.Equal(t, map[string]string{"STATUS": "ONLINE"}, values)
+
+	now = now.Add(30 * time.Second)
+	_, err = lastKnown.load(context.Background())
+	assert.EqualError(t, err, "unreachable")
+	_, ok = staleValues(err)
+	assert.False(t, ok)
+}
+
+func TestLastKnownDataSource_load_NoValues(t *testing.T) {
+	source := &mockDataSource{}
+	source.On("load", mock.Anything).Return(nil, errors.New("unreachable"))
+
+	_, err := NewLastKnownDataSource(source, time.Minute).load(context.Background())
+
+	assert.EqualError(t, err, "unreachable")
+	_, ok := staleValues(err)
+	assert.False(t, ok)
+}
+
+func TestLastKnownDataSource_loadSnapshot(t *testing.T) {
+	snapshotFile := filepath.Join(t.TempDir(), "ups.json")
+	source := &mockDataSource{}
+	source.On("load", mock.Anything).Return(map[string]string{"STATUS": "ONBATT"}, nil).Once()
+	source.On("load", mock.Anything).Return(nil, errors.New("unreachable"))
+
+	lastKnown := NewLastKnownDataSource(source, time.Hour)
+	snapshotTime, err := lastKnown.loadSnapshot(snapshotFile)
+	assert.NoError(t, err)
+	assert.True(t, snapshotTime.IsZero())
+	_, err = lastKnown.load(context.Background())
+	assert.NoError(t, err)
+
+	restarted := NewLastKnownDataSource(source, time.Hour)
+	snapshotTime, err = restarted.loadSnapshot(snapshotFile)
+	assert.NoError(t, err)
+	assert.False(t, snapshotTime.IsZero())
+	_, err = restarted.load(context.Background())
+	values, ok := staleValues(err)
+	assert.True(t, ok)
+	assert.Equal(t, map[string]string{"STATUS": "ONBATT"}, values)
+}
+
+func TestLastKnownDataSource_loadSnapshot_Invalid(t *testing.T) {
+	snapshotFile := filepath.Join(t.TempDir(), "ups.json")
+	assert.NoError(t, os.WriteFile(snapshotFile, []byte("no json"), 0600))
+
+	_, err := NewLastKnownDataSource(&mockDataSource{}, time.Hour).loadSnapshot(snapshotFile)
+
+	assert.Error(t, err)
+}
+
+func TestApcValues_reload_StaleValues(t *testing.T) {
+	source := &mockDataSource{}
+	source.On("load", mock.Anything).Return(map[string]string{"STATUS": "ONLINE"}, nil).Once()
+	source.On("load", mock.Anything).Return(nil, errors.New("unreachable"))
+	config := &Config{upsName: "ups", source: NewLastKnownDataSource(source, time.Minute), state: NewUpsState()}
+	apcValues := &ApcValues{}
+
+	assert.NoError(t, apcValues.reload(context.Background(), config))
+	assert.NoError(t, apcValues.reload(context.Background(), config))
+
+	assert.Equal(t, map[string]string{"STATUS": "ONLINE"}, apcValues.values)
+	stale, err := DataStale("proxy.data.stale", config, apcValues)
+	assert.NoError(t, err)
+	assert.Equal(t, "1", stale)
+	age, err := DataAge("proxy.data.age", config, apcValues)
+	assert.NoError(t, err)
+	assert.Equal(t, "0", age)
+}
+
+func TestConfig_loadDataSource_MaxDataAge(t *testing.T) {
+	config := Config{dataSource: DataSourceNis, targetAddress: "127.0.0.1", maxDataAge: time.Minute}
+
+	config.loadDataSource()
+
+	assert.IsType(t, &LastKnownDataSource{}, config.source)
+	assert.Equal(t, &NisDataSource{address: "127.0.0.1"}, config.source.(*LastKnownDataSource).source)
+}
@@ -0,0 +1,198 @@
+// Copyright [2021] [Christian Bandowski]
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"github.com/pkg/errors"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// Listener describes an address the proxy listens on and the security policy applied to its clients.
+type Listener struct {
+	// network and address passed to net.Listen
+	network string
+	address string
+
+	// whether clients have to connect using TLS
+	tls bool
+
+	// whether connections start with a PROXY protocol header
+	proxyProtocol bool
+
+	// networks clients may connect from, all clients are allowed if there are none
+	allowedNetworks []*net.IPNet
+
+	// handling of clients that are not within the allowed networks
+	unlistedClients string
+}
+
+// stringListFlag is a flag that may be used multiple times, it collects all values.
+type stringListFlag []string
+
+// String returns the values separated by spaces.
+func (f *stringListFlag) String() string {
+	return strings.Join(*f, " ")
+}
+
+// Set adds a value.
+func (f *stringListFlag) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+// parseListener parses a listener given as address followed by comma separated options, like
+// "0.0.0.0:3494,tls,allow=192.168.0.0/24,unlisted-clients=limited". Supported options are:
+//
+//	tls                       clients have to connect using TLS
+//	proxy-protocol            connections start with a PROXY protocol header
+//	allow=<network>           network clients may connect from, may be used multiple times
+//	unlisted-clients=<mode>   handling of clients outside of the allowed networks, "reject" or "limited"
+//
+// Options that aren't given are taken from the default listener.
+func parseListener(spec string, defaults Listener) (*Listener, error) {
+	fields := splitList(spec)
+	if len(fields) == 0 {
+		return nil, errors.New("Missing address")
+	}
+
+	if _, port, err := net.SplitHostPort(fields[0]); err != nil {
+		return nil, errors.Wrapf(err, "Invalid address %s", fields[0])
+	} else if number, err := strconv.Atoi(port); err != nil || number < 1 || number > 65535 {
+		return nil, errors.Errorf("Invalid port %s, must be between 1 and 65535", port)
+	}
+
+	listener := defaults
+	listener.network = "tcp"
+	listener.address = fields[0]
+
+	var allowedNetworks []*net.IPNet
+	for _, option := range fields[1:] {
+		name, value := option, ""
+		if i := strings.Index(option, "="); i >= 0 {
+			name, value = option[:i], option[i+1:]
+		}
+
+		switch name {
+		case "tls":
+			listener.tls = true
+		case "proxy-protocol":
+			listener.proxyProtocol = true
+		case "allow":
+			networks, err := parseNetworks(value)
+			if err != nil {
+				return nil, errors.WithStack(err)
+			}
+			allowedNetworks = append(allowedNetworks, networks...)
+		case "unlisted-clients":
+			if value != UnlistedClientsReject && value != UnlistedClientsLimited {
+				return nil, errors.Errorf("Invalid handling of unlisted clients %s, must be \"%s\" or \"%s\"",
+					value, UnlistedClientsReject, UnlistedClientsLimited)
+			}
+			listener.unlistedClients = value
+		default:
+			return nil, errors.Errorf("Unknown option %s", option)
+		}
+	}
+	if len(allowedNetworks) > 0 {
+		listener.allowedNetworks = allowedNetworks
+	}
+
+	return &listener, nil
+}
+
+// parseListeners parses the configured listeners. Without any, the proxy listens on the configured address and port,
+// and on the TLS port if it is set.
+func (c *Config) parseListeners() ([]*Listener, error) {
+	defaults := Listener{
+		network:         "tcp4",
+		proxyProtocol:   c.proxyProtocol,
+		allowedNetworks: c.allowedNetworks,
+		unlistedClients: c.unlistedClients,
+	}
+
+	if len(c.listenerSpecs) == 0 {
+		listener := defaults
+		listener.address = net.JoinHostPort(c.address, strconv.Itoa(c.port))
+		listeners := []*Listener{&listener}
+
+		if c.tlsPort != 0 {
+			tlsListener := defaults
+			tlsListener.address = net.JoinHostPort(c.address, strconv.Itoa(c.tlsPort))
+			tlsListener.tls = true
+			listeners = append(listeners, &tlsListener)
+		}
+
+		return listeners, nil
+	}
+
+	var listeners []*Listener
+	for _, spec := range c.listenerSpecs {
+		listener, err := parseListener(spec, defaults)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Invalid listener %s", spec)
+		}
+		listeners = append(listeners, listener)
+	}
+
+	return listeners, nil
+}
+
+// loadListeners parses the configured listeners, the allowed networks have to be loaded before.
+func (c *Config) loadListeners() error {
+	listeners, err := c.parseListeners()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	c.listeners = listeners
+
+	return nil
+}
+
+// isTLSEnabled checks whether the TLS port is set or any of the listeners requires TLS.
+func (c *Config) isTLSEnabled() bool {
+	if c.tlsPort != 0 {
+		return true
+	}
+	for _, listener := range c.listeners {
+		if listener.tls {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isAllowedClient checks whether the client with the given address is within the allowed networks. All clients are
+// allowed if no networks are configured.
+func (l *Listener) isAllowedClient(address string) bool {
+	if len(l.allowedNetworks) == 0 {
+		return true
+	}
+
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return false
+	}
+
+	for _, network := range l.allowedNetworks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,218 @@
+// Copyright [2021] [Christian Bandowski]
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseListener(t *testing.T) {
+	defaults := Listener{unlistedClients: UnlistedClientsReject}
+
+	listener, err := parseListener("0.0.0.0:3494, tls,proxy-protocol,allow=192.168.0.0/24,allow=10.0.0.1,"+
+		"unlisted-clients=limited", defaults)
+
+	if assert.NoError(t, err) {
+		assert.Equal(t, "tcp", listener.network)
+		assert.Equal(t, "0.0.0.0:3494", listener.address)
+		assert.True(t, listener.tls)
+		assert.True(t, listener.proxyProtocol)
+		assert.Equal(t, UnlistedClientsLimited, listener.unlistedClients)
+		if assert.Len(t, listener.allowedNetworks, 2) {
+			assert.Equal(t, "192.168.0.0/24", listener.allowedNetworks[0].String())
+			assert.Equal(t, "10.0.0.1/32", listener.allowedNetworks[1].String())
+		}
+	}
+}
+
+func TestParseListener_Defaults(t *testing.T) {
+	networks, err := parseNetworks("192.168.0.0/24")
+	assert.NoError(t, err)
+	defaults := Listener{proxyProtocol: true, allowedNetworks: networks, unlistedClients: UnlistedClientsLimited}
+
+	listener, err := parseListener("[::1]:3493", defaults)
+
+	if assert.NoError(t, err) {
+		assert.Equal(t, "[::1]:3493", listener.address)
+		assert.False(t, listener.tls)
+		assert.True(t, listener.proxyProtocol)
+		assert.Equal(t, networks, listener.allowedNetworks)
+		assert.Equal(t, UnlistedClientsLimited, listener.unlistedClients)
+	}
+}
+
+func TestParseListener_Invalid(t *testing.T) {
+	specToError := map[string]string{
+		"":                                 "Missing address",
+		"localhost":                        "Invalid address localhost",
+		"localhost:0":                      "Invalid port 0, must be between 1 and 65535",
+		"localhost:3493,starttls":          "Unknown option starttls",
+		"localhost:3493,allow=foo":         "Invalid address foo",
+		"localhost:3493,unlisted-clients=": "Invalid handling of unlisted clients",
+	}
+
+	for spec, expError := range specToError {
+		t.Run(spec, func(t *testing.T) {
+			_, err := parseListener(spec, Listener{})
+
+			if assert.Error(t, err) {
+				assert.Contains(t, err.Error(), expError)
+			}
+		})
+	}
+}
+
+func TestConfig_loadListeners(t *testing.T) {
+	config := &Config{address: "127.0.0.1", port: 3493, tlsPort: 3494, unlistedClients: UnlistedClientsReject}
+
+	assert.NoError(t, config.loadListeners())
+	if assert.Len(t, config.listeners, 2) {
+		assert.Equal(t, "tcp4", config.listeners[0].network)
+		assert.Equal(t, "127.0.0.1:3493", config.listeners[0].address)
+		assert.False(t, config.listeners[0].tls)
+		assert.Equal(t, "127.0.0.1:3494", config.listeners[1].address)
+		assert.True(t, config.listeners[1].tls)
+		assert.Equal(t, UnlistedClientsReject, config.listeners[1].unlistedClients)
+	}
+	assert.True(t, config.isTLSEnabled())
+}
+
+func TestConfig_loadListeners_Specs(t *testing.T) {
+	config := &Config{
+		address:       "127.0.0.1",
+		port:          3493,
+		listenerSpecs: stringListFlag{"127.0.0.1:3493", "0.0.0.0:3495,allow=192.168.0.0/24"},
+	}
+
+	assert.NoError(t, config.loadListeners())
+	if assert.Len(t, config.listeners, 2) {
+		assert.Equal(t, "127.0.0.1:3493", config.listeners[0].address)
+		assert.Empty(t, config.listeners[0].allowedNetworks)
+		assert.Equal(t, "0.0.0.0:3495", config.listeners[1].address)
+		assert.Len(t, config.listeners[1].allowedNetworks, 1)
+	}
+	assert.False(t, config.isTLSEnabled())
+
+	config.listenerSpecs = append(config.listenerSpecs, "localhost:3493,foo")
+	err := config.loadListeners()
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "Invalid listener localhost:3493,foo: Unknown option foo")
+	}
+}
+
+func TestListener_isAllowedClient(t *testing.T) {
+	networks, err := parseNetworks("192.168.0.0/24,10.0.0.1")
+	assert.NoError(t, err)
+	listener := &Listener{allowedNetworks: networks}
+
+	assert.True(t, listener.isAllowedClient("192.168.0.42"))
+	assert.True(t, listener.isAllowedClient("10.0.0.1"))
+	assert.False(t, listener.isAllowedClient("10.0.0.2"))
+	assert.False(t, listener.isAllowedClient("192.168.1.1"))
+	assert.False(t, listener.isAllowedClient("not an address"))
+}
+
+func TestListener_isAllowedClient_NoNetworks(t *testing.T) {
+	assert.True(t, (&Listener{}).isAllowedClient("10.0.0.2"))
+}
+
+func TestServe_ListenerPolicy(t *testing.T) {
+	config := &Config{upsName: "test", timeout: time.Second, maxLineLength: 1024}
+	networks, err := parseNetworks("192.168.0.0/24")
+	assert.NoError(t, err)
+
+	testCases := []struct {
+		name        string
+		listener    *Listener
+		expResponse string
+	}{
+		{"allowed", &Listener{network: "tcp4", address: "127.0.0.1:0"}, "BEGIN LIST UPS\n"},
+		{"limited", &Listener{network: "tcp4", address: "127.0.0.1:0", allowedNetworks: networks,
+			unlistedClients: UnlistedClientsLimited}, "BEGIN LIST UPS\n"},
+		{"rejected", &Listener{network: "tcp4", address: "127.0.0.1:0", allowedNetworks: networks,
+			unlistedClients: UnlistedClientsReject}, ""},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			socket, err := listen(testCase.listener, config)
+			if !assert.NoError(t, err) {
+				return
+			}
+			l := &trackingListener{Listener: socket}
+			served := make(chan struct{})
+			go func() {
+				defer close(served)
+				_ = serve(l, testCase.listener, config, NewSessionRegistry())
+			}()
+			// the server must not log anymore once the test returned
+			t.Cleanup(func() {
+				l.Close()
+				<-served
+				l.connections.Wait()
+			})
+
+			c, err := net.Dial("tcp4", l.Addr().String())
+			if !assert.NoError(t, err) {
+				return
+			}
+			defer c.Close()
+
+			_, err = c.Write([]byte("LIST UPS\n"))
+			assert.NoError(t, err)
+
+			line, _ := bufio.NewReader(c).ReadString('\n')
+			assert.Equal(t, testCase.expResponse, line)
+		})
+	}
+}
+
+// trackingListener is a listener that tracks its accepted connections until they are closed.
+type trackingListener struct {
+	net.Listener
+
+	connections sync.WaitGroup
+}
+
+// Accept waits for the next connection and tracks it.
+func (l *trackingListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	l.connections.Add(1)
+	return &trackedConn{Conn: c, done: l.connections.Done}, nil
+}
+
+// trackedConn is a connection accepted by trackingListener.
+type trackedConn struct {
+	net.Conn
+
+	once sync.Once
+	done func()
+}
+
+// Close closes the connection and stops tracking it.
+func (c *trackedConn) Close() error {
+	defer c.once.Do(c.done)
+
+	return c.Conn.Close()
+}
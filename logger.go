@@ -0,0 +1,102 @@
+// Copyright [2021] [Christian Bandowski]
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"github.com/pkg/errors"
+	"log"
+	"strings"
+	"sync/atomic"
+)
+
+// LogLevel defines the verbosity of the log output.
+type LogLevel int
+
+// supported log levels, a higher level means more verbose output
+const (
+	LogLevelError LogLevel = iota
+	LogLevelWarn
+	LogLevelInfo
+	LogLevelDebug
+)
+
+// names of the log levels as used by the program arguments
+var logLevelNames = map[LogLevel]string{
+	LogLevelError: "error",
+	LogLevelWarn:  "warn",
+	LogLevelInfo:  "info",
+	LogLevelDebug: "debug",
+}
+
+// currently active log level, messages with a more verbose level will be dropped, accessed atomically as the
+// connections log concurrently
+var currentLogLevel = int32(LogLevelInfo)
+
+// String returns the name of the log level.
+func (l LogLevel) String() string {
+	return logLevelNames[l]
+}
+
+// Set parses the given name and sets the log level accordingly. This allows using a LogLevel as program argument.
+func (l *LogLevel) Set(name string) error {
+	name = strings.ToLower(strings.TrimSpace(name))
+	for level, levelName := range logLevelNames {
+		if levelName == name {
+			*l = level
+			return nil
+		}
+	}
+
+	return errors.Errorf("Unknown log level %s", name)
+}
+
+// setLogLevel changes the currently active log level.
+func setLogLevel(level LogLevel) {
+	atomic.StoreInt32(&currentLogLevel, int32(level))
+}
+
+// getLogLevel returns the currently active log level.
+func getLogLevel() LogLevel {
+	return LogLevel(atomic.LoadInt32(&currentLogLevel))
+}
+
+// logf logs the message with the given level if this level is enabled.
+func logf(level LogLevel, format string, v ...interface{}) {
+	if level > getLogLevel() {
+		return
+	}
+
+	log.Printf(strings.ToUpper(level.String())+" "+format, v...)
+}
+
+// logErrorf logs an error message.
+func logErrorf(format string, v ...interface{}) {
+	logf(LogLevelError, format, v...)
+}
+
+// logWarnf logs a warning message.
+func logWarnf(format string, v ...interface{}) {
+	logf(LogLevelWarn, format, v...)
+}
+
+// logInfof logs an informational message.
+func logInfof(format string, v ...interface{}) {
+	logf(LogLevelInfo, format, v...)
+}
+
+// logDebugf logs a debug message, used for messages that would flood the log output on the default level.
+func logDebugf(format string, v ...interface{}) {
+	logf(LogLevelDebug, format, v...)
+}
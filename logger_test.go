@@ -0,0 +1,54 @@
+// Copyright [2021] [Christian Bandowski]
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"log"
+	"os"
+	"testing"
+)
+
+func TestLogLevel_Set(t *testing.T) {
+	var level LogLevel
+
+	assert.NoError(t, level.Set("debug"))
+	assert.Equal(t, LogLevelDebug, level)
+	assert.NoError(t, level.Set("WARN"))
+	assert.Equal(t, LogLevelWarn, level)
+	assert.EqualError(t, level.Set("verbose"), "Unknown log level verbose")
+}
+
+func TestLogLevel_String(t *testing.T) {
+	assert.Equal(t, "error", LogLevelError.String())
+	assert.Equal(t, "info", LogLevelInfo.String())
+}
+
+func TestLogf(t *testing.T) {
+	var out bytes.Buffer
+	log.SetOutput(&out)
+	defer log.SetOutput(os.Stderr)
+	defer setLogLevel(getLogLevel())
+
+	setLogLevel(LogLevelInfo)
+	logDebugf("debug message")
+	logInfof("info message")
+	logErrorf("error message")
+
+	assert.NotContains(t, out.String(), "debug message")
+	assert.Contains(t, out.String(), "INFO info message")
+	assert.Contains(t, out.String(), "ERROR error message")
+}
@@ -14,13 +14,28 @@
 
 package main
 
-import "log"
+import (
+	"log"
+	"os"
+)
 
-// main method for starting the application / proxy.
+// main method for starting the application / proxy, or another subcommand selected by the first argument.
 func main() {
-	err := startProxy()
+	if err := runSubcommand(os.Args[1:], os.Stdout); err != nil {
+		// the stack trace only helps while debugging, otherwise it hides the message
+		if getLogLevel() >= LogLevelDebug {
+			log.Fatalf("%+v", err)
+		}
+		log.Fatalf("%s", err)
+	}
+}
 
-	if err != nil {
-		log.Fatalf("Proxy failed: %+v", err)
+// newConfig creates a new configuration with the built-in variables and instant commands.
+func newConfig() *Config {
+	return &Config{
+		vars:     defaultVars(),
+		varInfos: defaultVarInfos(),
+		cmds:     defaultCmds(),
+		state:    NewUpsState(),
 	}
 }
@@ -0,0 +1,74 @@
+// Copyright [2021] [Christian Bandowski]
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"expvar"
+	"github.com/pkg/errors"
+	"net"
+	"net/http"
+	"runtime"
+)
+
+// metrics exposed by the metrics listener, using the JSON format of expvar
+var (
+	metricAuthFailures = expvar.NewInt("auth_failures_total")
+	metricAuthBans     = expvar.NewInt("auth_bans_total")
+
+	metricConnections         = expvar.NewInt("connections_current")
+	metricRejectedConnections = expvar.NewInt("connections_rejected_total")
+	metricAcceptFailures      = expvar.NewInt("accept_failures_total")
+	metricReapedConnections   = expvar.NewInt("connections_reaped_total")
+
+	// executions of the backend, e.g. of apcaccess, limited by the work queue
+	metricRunningExecutions  = expvar.NewInt("executions_running")
+	metricQueuedExecutions   = expvar.NewInt("executions_queued")
+	metricRejectedExecutions = expvar.NewInt("executions_rejected_total")
+
+	// switches between the sources of a UPS, by UPS name
+	metricSourceFailovers = expvar.NewMap("source_failovers_total")
+)
+
+func init() {
+	// leaking goroutines are noticed by this metric growing over time
+	expvar.Publish("goroutines_current", expvar.Func(func() interface{} { return runtime.NumGoroutine() }))
+}
+
+// startMetrics serves the metrics and the events of the UPSes on the configured address in the background, if it is
+// enabled.
+func startMetrics(config *Config) error {
+	if config.metricsAddress == "" {
+		return nil
+	}
+
+	l, err := net.Listen("tcp", config.metricsAddress)
+	if err != nil {
+		return errors.Wrap(err, "Couldn't start metrics listener")
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/debug/vars", expvar.Handler())
+	mux.Handle("/events", eventsHandler(config))
+
+	go func() {
+		if err := http.Serve(l, mux); err != nil {
+			logErrorf("Serving metrics failed: %+v", errors.WithStack(err))
+		}
+	}()
+
+	logInfof("Serving metrics on address %s", config.metricsAddress)
+
+	return nil
+}
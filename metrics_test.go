@@ -0,0 +1,50 @@
+// Copyright [2021] [Christian Bandowski]
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+)
+
+func TestStartMetrics(t *testing.T) {
+	// find a free port for the metrics listener
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if !assert.NoError(t, err) {
+		return
+	}
+	address := l.Addr().String()
+	l.Close()
+
+	assert.NoError(t, startMetrics(&Config{metricsAddress: address}))
+
+	resp, err := http.Get("http://" + address + "/debug/vars")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	assert.Contains(t, string(body), "\"auth_failures_total\"")
+	assert.Contains(t, string(body), "\"connections_current\"")
+}
+
+func TestStartMetrics_Disabled(t *testing.T) {
+	assert.NoError(t, startMetrics(&Config{}))
+}
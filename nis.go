@@ -0,0 +1,132 @@
+// Copyright [2021] [Christian Bandowski]
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"github.com/pkg/errors"
+	"io"
+	"net"
+)
+
+// default port of the network information server of apcupsd
+const nisDefaultPort = "3551"
+
+// NisDataSource loads the values from the network information server of apcupsd.
+type NisDataSource struct {
+	address string
+
+	// resolves the host name of the address, may be nil
+	resolver *AddressResolver
+}
+
+// NewNisDataSource creates a new instance of NisDataSource
+func NewNisDataSource(address string) *NisDataSource {
+	return &NisDataSource{address: address}
+}
+
+// load requests the status, which contains the units unlike apcaccess -u.
+func (s *NisDataSource) load(ctx context.Context) (map[string]string, error) {
+	address, err := s.resolver.resolve(ctx, s.address)
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := nisStatus(ctx, address)
+	if err != nil {
+		s.resolver.forget(s.address)
+		return nil, errors.Wrapf(err, "Error requesting the status from apcupsd")
+	}
+
+	return parseApcOutput(out, true)
+}
+
+// String returns the address of the network information server.
+func (s *NisDataSource) String() string {
+	return fmt.Sprintf("network information server %s", s.address)
+}
+
+// nisStatus requests the status from the network information server (NIS) of apcupsd at the given address, the port
+// defaults to 3551. The output equals the one of apcaccess without -u, so values contain their units.
+//
+// Both the request and the response use frames prefixed with their length as 16-bit big-endian integer, the response
+// consists of one frame per line and ends with an empty frame.
+func nisStatus(ctx context.Context, address string) ([]byte, error) {
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		address = net.JoinHostPort(address, nisDefaultPort)
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Couldn't connect to %s", address)
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+
+	if err := writeNisFrame(conn, []byte("status")); err != nil {
+		return nil, errors.Wrapf(err, "Couldn't send request to %s", address)
+	}
+
+	var out bytes.Buffer
+	reader := bufio.NewReader(conn)
+	for {
+		frame, err := readNisFrame(reader)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Couldn't read response from %s", address)
+		}
+		if len(frame) == 0 {
+			break
+		}
+
+		out.Write(frame)
+	}
+
+	return out.Bytes(), nil
+}
+
+// writeNisFrame writes the data prefixed with its length.
+func writeNisFrame(writer io.Writer, data []byte) error {
+	frame := make([]byte, 2+len(data))
+	binary.BigEndian.PutUint16(frame, uint16(len(data)))
+	copy(frame[2:], data)
+
+	_, err := writer.Write(frame)
+	return errors.WithStack(err)
+}
+
+// readNisFrame reads a frame and returns its data without the length.
+func readNisFrame(reader io.Reader) ([]byte, error) {
+	var length uint16
+	if err := binary.Read(reader, binary.BigEndian, &length); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	data := make([]byte, length)
+	if _, err := io.ReadFull(reader, data); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return data, nil
+}
@@ -0,0 +1,135 @@
+// Copyright [2021] [Christian Bandowski]
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// startTestNisServer starts a network information server answering the status request with the given lines and
+// returns its address
+func startTestNisServer(t *testing.T, lines ...string) string {
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			reader := bufio.NewReader(c)
+			request, err := readNisFrame(reader)
+			if err == nil && string(request) == "status" {
+				for _, line := range lines {
+					_ = writeNisFrame(c, []byte(line+"\n"))
+				}
+				_ = writeNisFrame(c, nil)
+			}
+			c.Close()
+		}
+	}()
+
+	return l.Addr().String()
+}
+
+func TestNisFrame(t *testing.T) {
+	var buffer bytes.Buffer
+
+	assert.NoError(t, writeNisFrame(&buffer, []byte("status")))
+	assert.Equal(t, []byte{0, 6, 's', 't', 'a', 't', 'u', 's'}, buffer.Bytes())
+
+	frame, err := readNisFrame(&buffer)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("status"), frame)
+
+	_, err = readNisFrame(strings.NewReader("\x00\x06sta"))
+	assert.Error(t, err)
+}
+
+func TestNisStatus(t *testing.T) {
+	address := startTestNisServer(t, "STATUS   : ONLINE", "LINEV    : 230.0 Volts")
+
+	out, err := nisStatus(context.Background(), address)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "STATUS   : ONLINE\nLINEV    : 230.0 Volts\n", string(out))
+}
+
+func TestNisStatus_Unreachable(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	_, err := nisStatus(ctx, "127.0.0.1:1")
+
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "Couldn't connect to 127.0.0.1:1")
+	}
+}
+
+func TestApcValue_reload_Nis(t *testing.T) {
+	address := startTestNisServer(t,
+		"STATUS   : ONLINE",
+		"LINEV    : 230.0 Volts",
+		"LOADPCT  : 12.0 Percent",
+		"TIMELEFT : 42.5 Minutes",
+		"ITEMP    : 29.2 C",
+		"MODEL    : Back-UPS XS 700U",
+		"END APC  : 2021-03-14 12:00:05 +0100")
+	apcValues := NewApcValues()
+
+	err := apcValues.reload(context.Background(), &Config{source: NewNisDataSource(address)})
+
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"STATUS":   "ONLINE",
+		"LINEV":    "230.0",
+		"LOADPCT":  "12.0",
+		"TIMELEFT": "42.5",
+		"ITEMP":    "29.2",
+		"MODEL":    "Back-UPS XS 700U",
+		"END APC":  "2021-03-14 12:00:05 +0100",
+	}, apcValues.values)
+}
+
+func TestStripApcUnit(t *testing.T) {
+	valueToResult := map[string]string{
+		"230.0 Volts":                "230.0",
+		"12.0 Percent":               "12.0",
+		"12.0 Percent Load Capacity": "12.0",
+		"300 Watts":                  "300",
+		"50.0 Hz":                    "50.0",
+		"ONLINE":                     "ONLINE",
+		"Low Battery":                "Low Battery",
+		"Always C":                   "Always C",
+	}
+
+	for value, expResult := range valueToResult {
+		t.Run(value, func(t *testing.T) {
+			assert.Equal(t, expResult, stripApcUnit(value))
+		})
+	}
+}
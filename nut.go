@@ -0,0 +1,161 @@
+// Copyright [2021] [Christian Bandowski]
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"github.com/pkg/errors"
+	"net"
+	"strings"
+	"sync"
+)
+
+// default port of upsd
+const nutDefaultPort = "3493"
+
+// NutDataSource loads the values from the upsd of another NUT server, they are keyed by the NUT variable names.
+type NutDataSource struct {
+	address string
+	upsName string
+}
+
+// NewNutDataSource creates a new instance of NutDataSource
+func NewNutDataSource(address string, upsName string) *NutDataSource {
+	return &NutDataSource{address: address, upsName: upsName}
+}
+
+// load lists the variables of the upstream UPS.
+func (s *NutDataSource) load(ctx context.Context) (map[string]string, error) {
+	values, err := nutListVar(ctx, s.address, s.upsName)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error listing the variables of UPS %s", s.upsName)
+	}
+
+	return values, nil
+}
+
+// String returns the UPS and the address of upsd.
+func (s *NutDataSource) String() string {
+	return fmt.Sprintf("upsd %s@%s", s.upsName, s.address)
+}
+
+// nutListVar requests the variables of the given UPS from the upsd at the given address by using LIST VAR, the port
+// defaults to 3493.
+func nutListVar(ctx context.Context, address string, upsName string) (map[string]string, error) {
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		address = net.JoinHostPort(address, nutDefaultPort)
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Couldn't connect to %s", address)
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+
+	if _, err := fmt.Fprintf(conn, "LIST VAR %s\n", formatArg(upsName)); err != nil {
+		return nil, errors.Wrapf(err, "Couldn't send request to %s", address)
+	}
+
+	values := make(map[string]string)
+	reader := bufio.NewReader(conn)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, errors.Wrapf(err, "Couldn't read response from %s", address)
+		}
+		line = strings.TrimRight(line, "\r\n")
+
+		if strings.HasPrefix(line, "ERR ") {
+			return nil, errors.Errorf("upsd at %s responded with %s", address, line)
+		}
+
+		tokens, err := tokenize(line)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Invalid response line %s", line)
+		}
+		if len(tokens) >= 3 && tokens[0] == "END" && tokens[1] == "LIST" {
+			break
+		}
+		if len(tokens) == 4 && tokens[0] == "VAR" {
+			values[tokens[2]] = tokens[3]
+		}
+	}
+
+	// the upstream server doesn't have to keep the connection open any longer
+	_, _ = fmt.Fprint(conn, "LOGOUT\n")
+
+	return values, nil
+}
+
+// upstreamVarsState tracks whether the variables of an upstream UPS were loaded already.
+type upstreamVarsState struct {
+	mutex  sync.Mutex
+	loaded bool
+}
+
+// ensureUpstreamVars loads the variables of the upstream UPS unless they were loaded already, so a failure is retried
+// by the next call. It does nothing for UPSes that aren't served from upsd.
+func (c *Config) ensureUpstreamVars(ctx context.Context) error {
+	if c.upstreamVars == nil {
+		return nil
+	}
+
+	c.upstreamVars.mutex.Lock()
+	defer c.upstreamVars.mutex.Unlock()
+
+	if c.upstreamVars.loaded {
+		return nil
+	}
+	if err := c.loadUpstreamVars(ctx); err != nil {
+		return errors.Wrapf(err, "Couldn't load the variables of UPS %s", c.upsName)
+	}
+	c.upstreamVars.loaded = true
+
+	return nil
+}
+
+// loadUpstreamVars replaces the variables of the UPS with the ones of the upstream UPS, their values are passed
+// through as they are. The configured mappings, static variables and exclusions are applied to them as well.
+// Variables can't be changed and instant commands aren't supported.
+func (c *Config) loadUpstreamVars(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	values, err := c.source.load(ctx)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	vars := make(map[string]VarLoader, len(values))
+	for name := range values {
+		vars[name] = ApcValue(name, IgnoreValue)
+	}
+	c.vars = vars
+	c.varInfos = make(map[string]VarInfo)
+	c.varWriters = nil
+	c.cmds = make(map[string]InstCmd)
+	c.reapplyVarOptions()
+
+	return nil
+}
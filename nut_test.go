@@ -0,0 +1,174 @@
+// Copyright [2021] [Christian Bandowski]
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// startTestNutServer starts a server answering the given commands with the given responses, like upsd would.
+func startTestNutServer(t *testing.T, commandToResponse map[string]string) string {
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			reader := bufio.NewReader(c)
+			for {
+				command, err := reader.ReadString('\n')
+				if err != nil {
+					break
+				}
+				response, ok := commandToResponse[strings.TrimSpace(command)]
+				if !ok {
+					response = "ERR UNKNOWN-COMMAND\n"
+				}
+				_, _ = c.Write([]byte(response))
+			}
+			c.Close()
+		}
+	}()
+
+	return l.Addr().String()
+}
+
+func TestNutDataSource_load(t *testing.T) {
+	address := startTestNutServer(t, map[string]string{
+		`LIST VAR "server room"`: "BEGIN LIST VAR \"server room\"\n" +
+			"VAR \"server room\" ups.status \"OL CHRG\"\n" +
+			"VAR \"server room\" battery.charge \"95\"\n" +
+			"END LIST VAR \"server room\"\n",
+	})
+	source := NewNutDataSource(address, "server room")
+
+	values, err := source.load(context.Background())
+
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"ups.status": "OL CHRG", "battery.charge": "95"}, values)
+	assert.Equal(t, "upsd server room@"+address, source.String())
+}
+
+func TestNutDataSource_load_UnknownUps(t *testing.T) {
+	address := startTestNutServer(t, map[string]string{
+		"LIST VAR unknown": "ERR UNKNOWN-UPS\n",
+	})
+
+	_, err := NewNutDataSource(address, "unknown").load(context.Background())
+
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "responded with ERR UNKNOWN-UPS")
+	}
+}
+
+func TestConfig_loadUpstreamVars(t *testing.T) {
+	address := startTestNutServer(t, map[string]string{
+		"LIST VAR ups": "BEGIN LIST VAR ups\nVAR ups ups.status \"OB\"\nEND LIST VAR ups\n",
+	})
+	config := &Config{
+		upsName:       "rack",
+		targetAddress: address,
+		timeout:       time.Second,
+		vars:          defaultVars(),
+		varInfos:      defaultVarInfos(),
+		cmds:          defaultCmds(),
+		source:        NewNutDataSource(address, "ups"),
+	}
+
+	assert.NoError(t, config.loadUpstreamVars(context.Background()))
+
+	assert.Equal(t, []string{"ups.status"}, config.varNames())
+	assert.Empty(t, config.cmds)
+	apcValues := NewApcValues()
+	if assert.NoError(t, apcValues.reload(context.Background(), config)) {
+		value, err := config.vars["ups.status"]("ups.status", config, apcValues)
+		assert.NoError(t, err)
+		assert.Equal(t, "OB", value)
+	}
+}
+
+func TestConfig_ensureUpstreamVars_Retry(t *testing.T) {
+	address := startTestNutServer(t, map[string]string{
+		"LIST VAR ups": "BEGIN LIST VAR ups\nVAR ups ups.status \"OB\"\nEND LIST VAR ups\n",
+	})
+	config := &Config{
+		upsName:      "rack",
+		timeout:      time.Second,
+		vars:         defaultVars(),
+		varInfos:     defaultVarInfos(),
+		cmds:         defaultCmds(),
+		source:       NewNutDataSource(address, "unknown"),
+		upstreamVars: &upstreamVarsState{},
+	}
+
+	err := config.ensureUpstreamVars(context.Background())
+
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "Couldn't load the variables of UPS rack")
+	}
+	assert.Contains(t, config.varNames(), "battery.charge")
+
+	// the upstream UPS is available now
+	config.source = NewNutDataSource(address, "ups")
+	assert.NoError(t, config.ensureUpstreamVars(context.Background()))
+	assert.Equal(t, []string{"ups.status"}, config.varNames())
+
+	// the variables are loaded only once
+	config.source = NewNutDataSource(address, "unknown")
+	assert.NoError(t, config.ensureUpstreamVars(context.Background()))
+}
+
+func TestConfig_ensureUpstreamVars_Options(t *testing.T) {
+	address := startTestNutServer(t, map[string]string{
+		"LIST VAR ups": "BEGIN LIST VAR ups\nVAR ups ups.status \"OB\"\nVAR ups ups.load \"42\"\n" +
+			"VAR ups ups.serial \"AS123\"\nVAR ups device.serial \"AS123\"\nEND LIST VAR ups\n",
+	})
+	config := &Config{
+		upsName:      "rack",
+		timeout:      time.Second,
+		excludedVars: "ups.serial",
+		staticVars:   "device.location=Basement",
+		vars:         defaultVars(),
+		varInfos:     defaultVarInfos(),
+		cmds:         defaultCmds(),
+		source:       NewNutDataSource(address, "ups"),
+		upstreamVars: &upstreamVarsState{},
+	}
+	assert.NoError(t, config.setUpsOption("exclude", "ups.load"))
+	assert.NoError(t, config.setUpsOption("map.ups.power", "fixed:600"))
+
+	assert.NoError(t, config.ensureUpstreamVars(context.Background()))
+
+	assert.Equal(t, []string{"device.location", "device.serial", "ups.power", "ups.status"}, config.varNames())
+	for _, varName := range []string{"ups.load", "ups.serial"} {
+		response, _, err := commandReceived(context.Background(), "GET VAR rack "+varName, config,
+			newAuthenticatedSession("127.0.0.1", NewSessionRegistry()), NewApcValues())
+		assert.NoError(t, err)
+		assert.Equal(t, "ERR VAR-NOT-SUPPORTED", response)
+	}
+}
@@ -0,0 +1,70 @@
+// Copyright [2021] [Christian Bandowski]
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"math/rand"
+	"time"
+)
+
+// startPollers refreshes the cached values of every UPS in the background until the context is done, so commands of
+// clients are served from memory and the load on the sources doesn't depend on the number of clients.
+func (c *Config) startPollers(ctx context.Context) {
+	if c.backgroundPollInterval <= 0 {
+		return
+	}
+
+	for _, ups := range c.upsConfigs() {
+		if cache, ok := cachingSource(ups.source); ok {
+			go pollSource(ctx, ups, cache, c.backgroundPollInterval, c.backgroundPollJitter)
+		}
+	}
+}
+
+// pollSource refreshes the cached values immediately and after every interval, the health of the source is recorded
+// like for loads triggered by clients. A random delay up to the jitter is added to every interval, so proxies polling
+// the same source don't do so at the same time.
+func pollSource(ctx context.Context, config *Config, cache *CachingDataSource, interval time.Duration,
+	jitter time.Duration) {
+
+	for {
+		loadCtx, cancel := context.WithTimeout(ctx, config.timeout)
+		err := cache.refresh(loadCtx)
+		cancel()
+
+		config.state.recordSourceLoad(err)
+		if err != nil && ctx.Err() == nil {
+			logWarnf("Refreshing the values of UPS %s in the background failed: %v", config.upsName, err)
+		}
+
+		timer := time.NewTimer(jitteredInterval(interval, jitter))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+// jitteredInterval returns the interval plus a random delay up to the jitter.
+func jitteredInterval(interval time.Duration, jitter time.Duration) time.Duration {
+	if jitter <= 0 {
+		return interval
+	}
+
+	return interval + time.Duration(rand.Int63n(int64(jitter)+1))
+}
@@ -0,0 +1,90 @@
+// Copyright [2021] [Christian Bandowski]
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingDataSource counts its loads, which may run concurrently to the test
+type countingDataSource struct {
+	loads int32
+}
+
+func (s *countingDataSource) load(ctx context.Context) (map[string]string, error) {
+	atomic.AddInt32(&s.loads, 1)
+	return map[string]string{"STATUS": "ONLINE"}, nil
+}
+
+func (s *countingDataSource) String() string {
+	return "counting"
+}
+
+func TestConfig_startPollers(t *testing.T) {
+	source := &countingDataSource{}
+	cache := NewCachingDataSource(source, time.Minute)
+	config := &Config{upsName: "ups", source: cache, state: NewUpsState(), timeout: time.Second,
+		backgroundPollInterval: 10 * time.Millisecond}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	config.startPollers(ctx)
+	time.Sleep(55 * time.Millisecond)
+	cancel()
+
+	// the values are refreshed in the background and served from the cache
+	calls := atomic.LoadInt32(&source.loads)
+	assert.GreaterOrEqual(t, calls, int32(3))
+	values, err := cache.load(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"STATUS": "ONLINE"}, values)
+	loaded, sourceError, _ := config.state.getSourceHealth()
+	assert.True(t, loaded)
+	assert.Empty(t, sourceError)
+
+	// the pollers stop once the context is done
+	time.Sleep(30 * time.Millisecond)
+	assert.LessOrEqual(t, atomic.LoadInt32(&source.loads), calls+1)
+}
+
+func TestPollSource_Error(t *testing.T) {
+	source := &mockDataSource{}
+	source.On("load", mock.Anything).Return(nil, errors.New("unreachable"))
+	cache := NewCachingDataSource(source, time.Minute)
+	config := &Config{upsName: "ups", state: NewUpsState(), timeout: time.Second}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	pollSource(ctx, config, cache, time.Minute, time.Second)
+
+	_, sourceError, _ := config.state.getSourceHealth()
+	assert.Equal(t, "unreachable", sourceError)
+	assert.Nil(t, cache.values)
+}
+
+func TestJitteredInterval(t *testing.T) {
+	assert.Equal(t, time.Minute, jitteredInterval(time.Minute, 0))
+
+	for i := 0; i < 100; i++ {
+		interval := jitteredInterval(time.Minute, 10*time.Second)
+		assert.GreaterOrEqual(t, int64(interval), int64(time.Minute))
+		assert.LessOrEqual(t, int64(interval), int64(time.Minute+10*time.Second))
+	}
+}
@@ -0,0 +1,101 @@
+// Copyright [2021] [Christian Bandowski]
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"github.com/pkg/errors"
+	"os/user"
+	"strconv"
+)
+
+// privileges identifies the unprivileged account the proxy switches to after it started listening.
+type privileges struct {
+	uid int
+	gid int
+}
+
+// lookupPrivileges resolves the given user and group, both may be given by name or numeric id. Without a group the
+// primary group of the user is used. It returns nil if neither is set.
+func lookupPrivileges(userName string, groupName string) (*privileges, error) {
+	if userName == "" && groupName == "" {
+		return nil, nil
+	}
+
+	p := &privileges{uid: -1, gid: -1}
+
+	if userName != "" {
+		u, err := lookupUser(userName)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		if p.uid, err = strconv.Atoi(u.Uid); err != nil {
+			return nil, errors.Wrapf(err, "User %s has no numeric id", userName)
+		}
+		if p.gid, err = strconv.Atoi(u.Gid); err != nil {
+			return nil, errors.Wrapf(err, "User %s has no numeric group id", userName)
+		}
+	}
+
+	if groupName != "" {
+		g, err := lookupGroup(groupName)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		if p.gid, err = strconv.Atoi(g.Gid); err != nil {
+			return nil, errors.Wrapf(err, "Group %s has no numeric id", groupName)
+		}
+	}
+
+	return p, nil
+}
+
+// lookupUser looks up a user by its name or numeric id.
+func lookupUser(name string) (*user.User, error) {
+	if _, err := strconv.Atoi(name); err == nil {
+		u, err := user.LookupId(name)
+		return u, errors.Wrapf(err, "Couldn't find user %s", name)
+	}
+
+	u, err := user.Lookup(name)
+	return u, errors.Wrapf(err, "Couldn't find user %s", name)
+}
+
+// lookupGroup looks up a group by its name or numeric id.
+func lookupGroup(name string) (*user.Group, error) {
+	if _, err := strconv.Atoi(name); err == nil {
+		g, err := user.LookupGroupId(name)
+		return g, errors.Wrapf(err, "Couldn't find group %s", name)
+	}
+
+	g, err := user.LookupGroup(name)
+	return g, errors.Wrapf(err, "Couldn't find group %s", name)
+}
+
+// dropPrivileges switches to the configured user and group, if any. It has to be called after all privileged
+// ports were bound.
+func (c *Config) dropPrivileges() error {
+	p, err := lookupPrivileges(c.runAsUser, c.runAsGroup)
+	if err != nil || p == nil {
+		return errors.WithStack(err)
+	}
+
+	if err := p.apply(); err != nil {
+		return errors.Wrap(err, "Couldn't drop privileges")
+	}
+
+	logInfof("Dropped privileges, running as user %s and group %s", c.runAsUser, c.runAsGroup)
+
+	return nil
+}
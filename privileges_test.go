@@ -0,0 +1,71 @@
+// Copyright [2021] [Christian Bandowski]
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os/user"
+	"strconv"
+	"testing"
+)
+
+func TestLookupPrivileges(t *testing.T) {
+	current, err := user.Current()
+	if !assert.NoError(t, err) {
+		return
+	}
+	uid, _ := strconv.Atoi(current.Uid)
+	gid, _ := strconv.Atoi(current.Gid)
+	group, err := user.LookupGroupId(current.Gid)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	testCases := []struct {
+		name         string
+		user         string
+		group        string
+		expected     *privileges
+		errorMessage string
+	}{
+		{"nothing", "", "", nil, ""},
+		{"user by name", current.Username, "", &privileges{uid: uid, gid: gid}, ""},
+		{"user by id", current.Uid, "", &privileges{uid: uid, gid: gid}, ""},
+		{"group by name", "", group.Name, &privileges{uid: -1, gid: gid}, ""},
+		{"group by id", "", current.Gid, &privileges{uid: -1, gid: gid}, ""},
+		{"user and group", current.Username, current.Gid, &privileges{uid: uid, gid: gid}, ""},
+		{"unknown user", "user-does-not-exist", "", nil, "Couldn't find user user-does-not-exist"},
+		{"unknown group", "", "group-does-not-exist", nil, "Couldn't find group group-does-not-exist"},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			result, err := lookupPrivileges(testCase.user, testCase.group)
+
+			if testCase.errorMessage != "" {
+				if assert.Error(t, err) {
+					assert.Contains(t, err.Error(), testCase.errorMessage)
+				}
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, testCase.expected, result)
+			}
+		})
+	}
+}
+
+func TestConfig_dropPrivileges_Disabled(t *testing.T) {
+	assert.NoError(t, (&Config{}).dropPrivileges())
+}
@@ -0,0 +1,44 @@
+// Copyright [2021] [Christian Bandowski]
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"github.com/pkg/errors"
+	"syscall"
+)
+
+// apply switches the group and then the user of the process, the user has to be switched last as it loses the
+// permission to switch the group.
+func (p *privileges) apply() error {
+	if p.gid >= 0 {
+		// drop the supplementary groups of root as well
+		if err := syscall.Setgroups([]int{p.gid}); err != nil {
+			return errors.Wrapf(err, "Couldn't set supplementary groups to %d", p.gid)
+		}
+		if err := syscall.Setgid(p.gid); err != nil {
+			return errors.Wrapf(err, "Couldn't set group id to %d", p.gid)
+		}
+	}
+	if p.uid >= 0 {
+		if err := syscall.Setuid(p.uid); err != nil {
+			return errors.Wrapf(err, "Couldn't set user id to %d", p.uid)
+		}
+	}
+
+	return nil
+}
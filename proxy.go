@@ -16,159 +16,719 @@ package main
 
 import (
 	"bufio"
+	"context"
+	"crypto/tls"
 	"github.com/pkg/errors"
-	"log"
+	"io"
 	"net"
-	"strconv"
+	"os"
+	"os/signal"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 )
 
 // startProxy starts the proxy server.
-func startProxy() error {
-	config := Config{
-		vars: map[string]VarLoader{
-			"device.mfr":    UpsDescription,
-			"device.model":  UpsModel,
-			"device.serial": ApcValue("SERIALNO", IgnoreValue),
-			"device.type":   FixedValue("ups"),
-
-			"ups.mfr":               UpsDescription,
-			"ups.mfr.date":          ApcValue("MANDATE", IgnoreValue),
-			"ups.id":                FixedValue("APC"),
-			"ups.vendorid":          FixedValue("051d"),
-			"ups.model":             UpsModel,
-			"ups.status":            UpsStatus,
-			"ups.load":              ApcValue("LOADPCT", IgnoreValue),
-			"ups.serial":            ApcValue("SERIALNO", IgnoreValue),
-			"ups.firmware":          ApcValue("FIRMWARE", IgnoreValue),
-			"ups.firmware.aux":      ApcValue("FIRMWARE", IgnoreValue),
-			"ups.productid":         ApcValue("APC", IgnoreValue),
-			"ups.temperature":       ApcValue("ITEMP", IgnoreValue),
-			"ups.realpower.nominal": ApcValue("NOMPOWER", IgnoreValue),
-			"ups.test.result":       UpsSelfTest,
-			"ups.delay.start":       FixedValue("0"),
-			"ups.delay.shutdown":    ApcValue("DSHUTD", IgnoreValue),
-			"ups.timer.reboot":      FixedValue("-1"),
-			"ups.timer.start":       FixedValue("-1"),
-			"ups.timer.shutdown":    FixedValue("-1"),
-
-			"battery.runtime":         ApcValueMinInSec("TIMELEFT", IgnoreValue),
-			"battery.runtime.low":     ApcValueMinInSec("DLOWBATT", IgnoreValue),
-			"battery.charge":          ApcValue("BCHARGE", IgnoreValue),
-			"battery.charge.low":      ApcValue("MBATTCHG", IgnoreValue),
-			"battery.charge.warning":  FixedValue("50"),
-			"battery.voltage":         ApcValue("BATTV", IgnoreValue),
-			"battery.voltage.nominal": ApcValue("NOMBATTV", IgnoreValue),
-			"battery.date":            ApcValue("BATTDATE", IgnoreValue),
-			"battery.mfr.date":        ApcValue("BATTDATE", IgnoreValue),
-			"battery.temperature":     ApcValue("ITEMP", IgnoreValue),
-			"battery.type":            FixedValue("PbAc"),
-
-			"driver.name":                   FixedValue("usbhid-ups"),
-			"driver.version.internal":       FormattedValue("apcupsd %s", ApcValue("VERSION", IgnoreValue)),
-			"driver.version.date":           ApcValue("DRIVER", IgnoreValue),
-			"driver.parameter.pollfreq":     FixedValue("60"),
-			"driver.parameter.pollinterval": FixedValue("10"),
-
-			"input.voltage":         ApcValue("LINEV", IgnoreValue),
-			"input.voltage.nominal": ApcValue("NOMINV", IgnoreValue),
-			"input.sensitivity":     ApcValue("SENSE", IgnoreValue),
-			"input.transfer.high":   ApcValue("HITRANS", IgnoreValue),
-			"input.transfer.low":    ApcValue("LOTRANS", IgnoreValue),
-			"input.frequency":       ApcValue("LINEFREQ", IgnoreValue),
-			"input.transfer.reason": ApcValue("LASTXFER", IgnoreValue),
-
-			"output.voltage":         ApcValue("OUTPUTV", IgnoreValue),
-			"output.voltage.nominal": ApcValue("NOMOUTV", IgnoreValue),
-
-			"server.info":       FixedValue("TODO"),
-			"ups.beeper.status": FixedValue("enabled"),
-		},
-	}
-	config.loadProgramArgs()
-
-	log.Printf("Loaded configuration: %s", config)
-
-	listenAddress := config.address + ":" + strconv.Itoa(config.port)
-	l, err := net.Listen("tcp4", listenAddress)
-	if err != nil {
-		return errors.Wrap(err, "Couldn't start proxy")
+func startProxy(config *Config) error {
+	setLogLevel(config.logLevel)
+
+	logInfof("Loaded configuration: %s", config)
+
+	if err := config.validate(); err != nil {
+		return errors.Wrap(err, "Invalid configuration")
+	}
+	if err := config.loadUsers(); err != nil {
+		return errors.WithStack(err)
+	}
+	if err := config.loadState(); err != nil {
+		return errors.WithStack(err)
+	}
+	if err := config.loadAllowedNetworks(); err != nil {
+		return errors.WithStack(err)
+	}
+	if err := config.loadListeners(); err != nil {
+		return errors.WithStack(err)
+	}
+	for _, listener := range config.listeners {
+		if listener.proxyProtocol && len(config.proxyProtocolTrusted) == 0 {
+			logWarnf("PROXY protocol headers of all peers are accepted on %s, restrict them by using "+
+				"-proxy-protocol-trusted", listener.address)
+		}
+	}
+	if err := config.loadAuditLog(); err != nil {
+		return errors.WithStack(err)
+	}
+
+	if err := config.loadTLS(); err != nil {
+		return errors.WithStack(err)
+	}
+	if len(config.users) > 0 {
+		config.authGuard = NewAuthGuard(config.authFailureThreshold, config.authBanDuration, config.authFailureDelay)
+	}
+	if config.maxClientConnections > 0 || config.maxConnections > 0 {
+		config.connectionLimiter = NewConnectionLimiter(config.maxClientConnections, config.maxConnections)
 	}
-	defer l.Close()
+	if config.maxExecutions > 0 {
+		config.workQueue = NewWorkQueue(config.maxExecutions, config.maxQueuedExecutions)
+	}
+	if err := config.loadUpses(); err != nil {
+		return errors.WithStack(err)
+	}
+	if err := startMetrics(config); err != nil {
+		return errors.WithStack(err)
+	}
+
+	var netListeners []net.Listener
+	for _, listener := range config.listeners {
+		l, err := listen(listener, config)
+		if err != nil {
+			return errors.Wrapf(err, "Couldn't listen on address %s", listener.address)
+		}
+		defer l.Close()
+
+		if listener.tls {
+			logInfof("Started TLS listener on address %s", listener.address)
+		} else {
+			logInfof("Started apcupsd NUT proxy on address %s", listener.address)
+		}
+		netListeners = append(netListeners, l)
+	}
+
+	// all ports are bound, root privileges aren't needed anymore
+	if err := config.dropPrivileges(); err != nil {
+		return errors.WithStack(err)
+	}
+
+	// the sources are polled without root privileges, too
+	pollCtx, stopPollers := context.WithCancel(context.Background())
+	defer stopPollers()
+	config.startPollers(pollCtx)
+	config.startEventWatchers(pollCtx)
+
+	registry := NewSessionRegistry()
+	config.connectionTracker = NewConnectionTracker()
+	config.startHousekeeping(pollCtx)
 
-	log.Printf("Started apcupsd NUT proxy on address %s", listenAddress)
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
 
-	failedInARowCount := 0
+	// all listeners share the sessions, the proxy stops once any of them fails
+	errs := make(chan error, len(netListeners))
+	for i, l := range netListeners {
+		go func(l net.Listener, listener *Listener) {
+			errs <- serve(l, listener, config, registry)
+		}(l, config.listeners[i])
+	}
+
+	select {
+	case err := <-errs:
+		return err
+	case sig := <-signals:
+		logInfof("Received %s, shutting down", sig)
+	}
+
+	// stop accepting connections, before the open ones are drained
+	for _, l := range netListeners {
+		l.Close()
+	}
+	config.connectionTracker.drain(config.shutdownGracePeriod)
+
+	return nil
+}
+
+// the backoff after a temporary failure accepting a new connection doubles up to the maximum
+const (
+	acceptBackoffMin = 5 * time.Millisecond
+	acceptBackoffMax = time.Second
+)
+
+// serve accepts new connections on the given listener and handles them until the listener fails permanently.
+// Temporary failures, e.g. if there are too many open files, are retried after a backoff. The policy of the listener
+// decides which clients may connect.
+func serve(l net.Listener, listener *Listener, config *Config, registry *SessionRegistry) error {
+	queue := config.connectionOverflow == ConnectionOverflowQueue
+
+	var backoff time.Duration
 	for {
+		if queue {
+			// leave further connections in the backlog of the listener until a connection was closed
+			config.connectionLimiter.acquireSlot(true)
+		}
+
 		c, err := l.Accept()
 		if err != nil {
-			log.Printf("Failed accepting new connection: %s", err)
-			failedInARowCount++
+			if queue {
+				config.connectionLimiter.releaseSlot()
+			}
+			metricAcceptFailures.Add(1)
 
-			if failedInARowCount >= 3 {
-				return errors.Wrap(err, "Failed three times in a row accepting new connections")
+			if !isTemporaryAcceptError(err) {
+				return errors.Wrap(err, "Failed accepting new connections")
 			}
 
+			backoff = nextAcceptBackoff(backoff)
+			logErrorf("Failed accepting new connection, retrying in %s: %s", backoff, err)
+			time.Sleep(backoff)
+
 			continue
 		}
-		failedInARowCount = 0
+		backoff = 0
 
-		go handleConnection(c, &config)
+		if !queue && !config.connectionLimiter.acquireSlot(false) {
+			go func() {
+				logWarnf("Rejected connection from %s, the maximum number of connections is reached", c.RemoteAddr())
+				metricRejectedConnections.Add(1)
+				rejectConnection(c, "ERR ACCESS-DENIED", config.timeout)
+			}()
+			continue
+		}
+
+		go func() {
+			defer config.connectionLimiter.releaseSlot()
+			acceptConnection(c, listener, config, registry)
+		}()
+	}
+}
+
+// isTemporaryAcceptError returns whether accepting new connections may succeed again after the error.
+func isTemporaryAcceptError(err error) bool {
+	var netErr net.Error
+	if errors.Is(err, net.ErrClosed) || !errors.As(err, &netErr) {
+		return false
+	}
+
+	return netErr.Temporary()
+}
+
+// nextAcceptBackoff returns the doubled backoff, starting with the minimum and limited to the maximum.
+func nextAcceptBackoff(backoff time.Duration) time.Duration {
+	if backoff == 0 {
+		return acceptBackoffMin
+	}
+	if backoff *= 2; backoff > acceptBackoffMax {
+		return acceptBackoffMax
+	}
+
+	return backoff
+}
+
+// listen starts listening on the address of the listener, its connections start with a PROXY protocol header if
+// enabled and use TLS if required.
+func listen(listener *Listener, config *Config) (net.Listener, error) {
+	l, err := listenSocket(listener, config)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if listener.proxyProtocol {
+		l = NewProxyProtocolListener(l, config.timeout, config.proxyProtocolTrusted)
+	}
+	if listener.tls {
+		// the PROXY protocol header precedes the TLS handshake
+		l = tls.NewListener(l, config.tlsConfig)
+	}
+
+	return l, nil
+}
+
+// acceptConnection checks whether the client may connect and handles the connection if so.
+func acceptConnection(c net.Conn, listener *Listener, config *Config, registry *SessionRegistry) {
+	metricConnections.Add(1)
+	defer metricConnections.Add(-1)
+
+	if err := proxyProtocolError(c); err != nil {
+		logWarnf("Rejected connection from %s: %s", c.RemoteAddr(), err)
+		c.Close()
+		return
+	}
+
+	limited := false
+	if !listener.isAllowedClient(remoteHost(c)) {
+		if listener.unlistedClients != UnlistedClientsLimited {
+			logWarnf("Rejected connection from %s, the address is not within the allowed networks", c.RemoteAddr())
+			c.Close()
+			return
+		}
+
+		logInfof("Limiting connection from %s, the address is not within the allowed networks", c.RemoteAddr())
+		limited = true
+	}
+	if config.authGuard.isBanned(remoteHost(c)) {
+		logWarnf("Rejected connection from %s, the address is banned", c.RemoteAddr())
+		c.Close()
+		return
+	}
+	if !config.connectionLimiter.acquire(remoteHost(c)) {
+		logWarnf("Rejected connection from %s, the address reached the maximum number of connections", c.RemoteAddr())
+		metricRejectedConnections.Add(1)
+		rejectConnection(c, "ERR ACCESS-DENIED", config.timeout)
+		return
+	}
+	defer config.connectionLimiter.release(remoteHost(c))
+
+	handleConnection(c, config, registry, limited)
+}
+
+// rejectConnection sends the error to the client and closes the connection.
+func rejectConnection(c net.Conn, response string, timeout time.Duration) {
+	defer c.Close()
+
+	if err := c.SetWriteDeadline(time.Now().Add(timeout)); err != nil {
+		logErrorf("Setting the timeout for client %s failed: %+v", c.RemoteAddr(), err)
+		return
+	}
+	if _, err := c.Write([]byte(response + "\n")); err != nil {
+		logWarnf("Writing response for client %s failed: %s", c.RemoteAddr(), err)
+	}
+}
+
+// defaultVars returns the NUT variables supported by the proxy and the VarLoader used to load each of them.
+func defaultVars() map[string]VarLoader {
+	return map[string]VarLoader{
+		"device.mfr":    UpsDescription,
+		"device.model":  MemoizedValue("model", UpsModel),
+		"device.serial": ApcValue("SERIALNO", IgnoreValue),
+		"device.type":   FixedValue("ups"),
+
+		"ups.mfr":               UpsDescription,
+		"ups.mfr.date":          ApcValue("MANDATE", IgnoreValue),
+		"ups.id":                FixedValue("APC"),
+		"ups.vendorid":          FixedValue("051d"),
+		"ups.model":             MemoizedValue("model", UpsModel),
+		"ups.status":            MemoizedValue("status", UpsStatus),
+		"ups.load":              ApcValue("LOADPCT", IgnoreValue),
+		"ups.serial":            ApcValue("SERIALNO", IgnoreValue),
+		"ups.firmware":          ApcValue("FIRMWARE", IgnoreValue),
+		"ups.firmware.aux":      ApcValue("FIRMWARE", IgnoreValue),
+		"ups.productid":         ApcValue("APC", IgnoreValue),
+		"ups.temperature":       ApcValue("ITEMP", IgnoreValue),
+		"ups.realpower.nominal": ApcValue("NOMPOWER", IgnoreValue),
+		"ups.test.result":       UpsSelfTest,
+		"ups.delay.start":       FixedValue("0"),
+		"ups.delay.shutdown":    ApcValue("DSHUTD", IgnoreValue),
+		"ups.timer.reboot":      FixedValue("-1"),
+		"ups.timer.start":       FixedValue("-1"),
+		"ups.timer.shutdown":    FixedValue("-1"),
+
+		"battery.runtime":         ApcValueMinInSec("TIMELEFT", IgnoreValue),
+		"battery.runtime.low":     ApcValueMinInSec("DLOWBATT", IgnoreValue),
+		"battery.charge":          ApcValue("BCHARGE", IgnoreValue),
+		"battery.charge.low":      ApcValue("MBATTCHG", IgnoreValue),
+		"battery.charge.warning":  FixedValue("50"),
+		"battery.voltage":         ApcValue("BATTV", IgnoreValue),
+		"battery.voltage.nominal": ApcValue("NOMBATTV", IgnoreValue),
+		"battery.date":            ApcValue("BATTDATE", IgnoreValue),
+		"battery.mfr.date":        ApcValue("BATTDATE", IgnoreValue),
+		"battery.temperature":     ApcValue("ITEMP", IgnoreValue),
+		"battery.type":            FixedValue("PbAc"),
+
+		"driver.name":                   FixedValue("usbhid-ups"),
+		"driver.version.internal":       FormattedValue("apcupsd %s", ApcValue("VERSION", IgnoreValue)),
+		"driver.version.date":           ApcValue("DRIVER", IgnoreValue),
+		"driver.parameter.pollfreq":     DriverPollFreq,
+		"driver.parameter.pollinterval": DriverPollInterval,
+
+		"input.voltage":         ApcValue("LINEV", IgnoreValue),
+		"input.voltage.nominal": ApcValue("NOMINV", IgnoreValue),
+		"input.sensitivity":     ApcValue("SENSE", IgnoreValue),
+		"input.transfer.high":   ApcValue("HITRANS", IgnoreValue),
+		"input.transfer.low":    ApcValue("LOTRANS", IgnoreValue),
+		"input.frequency":       ApcValue("LINEFREQ", IgnoreValue),
+		"input.transfer.reason": ApcValue("LASTXFER", IgnoreValue),
+
+		"output.voltage":         ApcValue("OUTPUTV", IgnoreValue),
+		"output.voltage.nominal": ApcValue("NOMOUTV", IgnoreValue),
+
+		"server.info":       FixedValue("TODO"),
+		"ups.beeper.status": UpsBeeperStatus,
+
+		"proxy.source.status":       SourceStatus,
+		"proxy.source.last_success": SourceLastSuccess,
+		"proxy.source.error":        SourceError,
+		"proxy.data.age":            DataAge,
+		"proxy.data.stale":          DataStale,
 	}
 }
 
 // handleConnection will be invoked for each new connection and will handle all incoming commands.
-func handleConnection(c net.Conn, config *Config) {
+// Limited connections may only use the commands needed to discover the UPS.
+func handleConnection(c net.Conn, config *Config, registry *SessionRegistry, limited bool) {
 	defer c.Close()
 
-	log.Printf("Received request from address %s", c.RemoteAddr())
+	if !config.connectionTracker.open(c) {
+		// the proxy is shutting down
+		return
+	}
+	defer config.connectionTracker.close(c)
+
+	session := NewSession(remoteHost(c), registry)
+	session.limited = limited
+	defer session.close()
 
-	reader := bufio.NewReader(c)
-	writer := bufio.NewWriter(c)
+	certUser, err := clientCertificateUser(c, config.timeout)
+	if err != nil {
+		logWarnf("Rejected TLS connection from %s: %s", c.RemoteAddr(), err)
+		return
+	}
+	if certUser != "" {
+		logDebugf("Client %s authenticated with a certificate for user %s", c.RemoteAddr(), certUser)
+		session.certUser = certUser
+	}
+	_, session.tls = c.(*tls.Conn)
+
+	logDebugf("Received request from address %s", c.RemoteAddr())
+
+	// limits the time a client may take sending a single command, so it can't keep a connection open forever
+	timeoutReader := &byteTimeoutReader{conn: c, byteTimeout: config.byteTimeout,
+		readTimeout: timeoutOrDefault(config.readTimeout, config.timeout)}
+	reader := getReader(timeoutReader)
+	defer putReader(reader)
+	writer := getWriter(c)
+	defer putWriter(writer)
+
+	var sessionDeadline time.Time
+	if config.maxSessionDuration > 0 {
+		sessionDeadline = time.Now().Add(config.maxSessionDuration)
+	}
+	// deadline returns the time after the given timeout, limited by the maximum session duration
+	deadline := func(timeout time.Duration) time.Time {
+		deadline := time.Now().Add(timeout)
+		if !sessionDeadline.IsZero() && sessionDeadline.Before(deadline) {
+			return sessionDeadline
+		}
+		return deadline
+	}
+
+	// responses are written by the handler and by the source commands running in the background
+	respond := func(response string) bool {
+		if err := c.SetWriteDeadline(deadline(timeoutOrDefault(config.writeTimeout, config.timeout))); err != nil {
+			logErrorf("Setting the timeout for client %s failed: %+v", c.RemoteAddr(), err)
+			return false
+		}
+		if response != "" {
+			// ensure response ends with a newline
+			response = strings.TrimSpace(response) + "\n"
+			if _, err := writer.WriteString(response); err != nil {
+				logErrorf("Writing response for client %s failed: %+v", c.RemoteAddr(), err)
+				return false
+			}
+		}
+
+		if err := writer.Flush(); err != nil {
+			logErrorf("Flushing response to client %s failed: %+v", c.RemoteAddr(), err)
+			return false
+		}
+
+		return true
+	}
 
 	apcValues := NewApcValues()
+	// source command still waiting for the data source, nil if there is none
+	var pending *pendingCommand
+	// the writer must not be used by the pending command once the handler returned
+	defer func() {
+		pending.answer(func() {})
+	}()
 
-	for {
-		if err := c.SetDeadline(time.Now().Add(config.timeout)); err != nil {
-			log.Printf("Setting the timeout for client %s failed: %+v", c.RemoteAddr(), err)
+	for firstCommand := true; ; firstCommand = false {
+		idleTimeout := timeoutOrDefault(config.idleTimeout, config.timeout)
+		if firstCommand && config.firstCommandTimeout > 0 {
+			idleTimeout = config.firstCommandTimeout
+		}
+		if pending != nil {
+			// the idle time only starts once the pending command was answered, which takes up to the timeout
+			idleTimeout += config.timeout
+		}
+		idleDeadline := deadline(idleTimeout)
+		if err := c.SetReadDeadline(idleDeadline); err != nil {
+			logErrorf("Setting the timeout for client %s failed: %+v", c.RemoteAddr(), err)
 			return
 		}
+		timeoutReader.startCommand(idleDeadline, sessionDeadline)
 
-		command, err := reader.ReadString('\n')
-		if err != nil {
-			log.Printf("Reading command from client %s failed", c.RemoteAddr())
+		command, err := readLine(reader, config.maxLineLength)
+		if err == errLineTooLong {
+			logWarnf("Client %s sent a command exceeding %d bytes", c.RemoteAddr(), config.maxLineLength)
+			pending.wait()
+			pending = nil
+			if !respond("ERR INVALID-ARGUMENT") {
+				return
+			}
+			continue
+		} else if err == io.EOF {
+			logDebugf("Client %s closed the connection", c.RemoteAddr())
+			// clients may close their side of the connection right after sending the last command
+			pending.wait()
+			return
+		} else if err != nil && !sessionDeadline.IsZero() && !time.Now().Before(sessionDeadline) {
+			logInfof("Closing connection of client %s, it reached the maximum session duration", c.RemoteAddr())
+			return
+		} else if err != nil {
+			logWarnf("Reading command from client %s failed: %s", c.RemoteAddr(), err)
 			return
 		}
 
 		command = strings.TrimSpace(command)
+		route := routeOf(command)
+		if pending != nil {
+			// responses are sent in order, a session command only waits a limited time for the data source though
+			if sessionCommands[route] && !pending.waitFor(config.sessionCommandWait) &&
+				pending.answer(func() { respond("ERR DATA-STALE") }) {
+				logDebugf("Cancelled pending command of client %s, it sent a session command", c.RemoteAddr())
+				pending.cancel()
+				// the cancelled command may still use the values
+				apcValues = NewApcValues()
+			} else {
+				pending.wait()
+			}
+			pending = nil
+		}
+
+		if !config.connectionTracker.startCommand(c) {
+			logDebugf("Closing connection of client %s, the proxy is shutting down", c.RemoteAddr())
+			return
+		}
+
+		logDebugf("Received command: %s", redactCommand(command))
+
+		// loading the values must not take longer than the client is waiting for the response
+		ctx, cancel := context.WithDeadline(context.Background(), deadline(config.timeout))
+
+		if sourceCommands[route] {
+			// the source commands are handled in the background, so following session commands only wait for them
+			// as configured, the session is copied as these don't change it
+			pending = &pendingCommand{cancel: cancel, done: make(chan struct{})}
+			go func(pending *pendingCommand, session Session, apcValues IApcValues) {
+				defer close(pending.done)
+				defer pending.cancel()
+
+				response, _, err := commandReceived(ctx, command, config, &session, apcValues)
+				if err != nil {
+					logErrorf("Handling command \"%s\" for client %s failed: %+v", redactCommand(command),
+						c.RemoteAddr(), err)
+				}
 
-		log.Printf("Received command: %s", command)
+				pending.answer(func() {
+					if !respond(response) {
+						c.Close()
+					} else if !config.connectionTracker.finishCommand(c) {
+						logDebugf("Closing connection of client %s, the proxy is shutting down", c.RemoteAddr())
+						c.Close()
+					}
+				})
+			}(pending, *session, apcValues)
 
-		response, closeConnection, err := commandReceived(command, config, apcValues)
+			continue
+		}
+
+		response, closeConnection, err := commandReceived(ctx, command, config, session, apcValues)
+		cancel()
 		if err != nil {
-			log.Printf("Handling command \"%s\" for client %s failed: %+v", command, c.RemoteAddr(), err)
+			logErrorf("Handling command \"%s\" for client %s failed: %+v", redactCommand(command), c.RemoteAddr(), err)
 		}
-		if response != "" {
-			// ensure response ends with a newline
-			response = strings.TrimSpace(response) + "\n"
-			if _, err = writer.WriteString(response); err != nil {
-				log.Printf("Writing response for client %s failed: %+v", c.RemoteAddr(), err)
-				return
-			}
+
+		if !respond(response) {
+			return
 		}
 
-		if err := writer.Flush(); err != nil {
-			log.Printf("Flushing response to client %s failed: %+v", c.RemoteAddr(), err)
+		if !config.connectionTracker.finishCommand(c) {
+			logDebugf("Closing connection of client %s, the proxy is shutting down", c.RemoteAddr())
 			return
 		}
 
+		if session.startTLS {
+			session.startTLS = false
+			// the client has to wait for the response before starting the handshake
+			if reader.Buffered() > 0 {
+				logWarnf("Client %s sent data before the TLS handshake", c.RemoteAddr())
+				return
+			}
+			tlsConn := tls.Server(c, config.tlsConfig)
+			certUser, err := clientCertificateUser(tlsConn, config.timeout)
+			if err != nil {
+				logWarnf("Rejected STARTTLS of client %s: %s", c.RemoteAddr(), err)
+				return
+			}
+			if certUser != "" {
+				logDebugf("Client %s authenticated with a certificate for user %s", c.RemoteAddr(), certUser)
+				session.certUser = certUser
+			}
+			session.tls = true
+
+			// the deadlines are still set on the underlying connection, which the TLS connection uses
+			timeoutReader.conn = tlsConn
+			reader.Reset(timeoutReader)
+			writer.Reset(tlsConn)
+		}
+
 		if closeConnection {
 			if err = c.Close(); err != nil {
-				log.Printf("Closing connection of client %s failed: %+v", c.RemoteAddr(), err)
+				logErrorf("Closing connection of client %s failed: %+v", c.RemoteAddr(), err)
 			}
 
 			return
 		}
 	}
 }
+
+// pendingCommand is a source command handled in the background, it is answered either by itself once the values
+// were loaded or with an error once a session command of the client waited too long for it.
+type pendingCommand struct {
+	cancel context.CancelFunc
+	// closed once the command returned
+	done chan struct{}
+
+	mutex    sync.Mutex
+	answered bool
+}
+
+// answer calls respond unless the command was answered already and returns whether it was called.
+func (p *pendingCommand) answer(respond func()) bool {
+	if p == nil {
+		return false
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.answered {
+		return false
+	}
+	p.answered = true
+	respond()
+
+	return true
+}
+
+// wait waits until the command returned, which answers it unless it was answered already.
+func (p *pendingCommand) wait() {
+	if p != nil {
+		<-p.done
+	}
+}
+
+// waitFor waits until the command returned, but at most for the given timeout, and returns whether it returned. It
+// waits without a limit if the timeout is 0.
+func (p *pendingCommand) waitFor(timeout time.Duration) bool {
+	if timeout <= 0 {
+		p.wait()
+		return true
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-p.done:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+// errLineTooLong is returned by readLine if the line exceeds the maximum length
+var errLineTooLong = errors.New("Line too long")
+
+// readLine reads the next line including the newline. Lines longer than maxLength bytes will be skipped and
+// errLineTooLong will be returned instead, this ensures a client can't make the proxy buffer arbitrary much data.
+func readLine(reader *bufio.Reader, maxLength int) (string, error) {
+	var line []byte
+	tooLong := false
+
+	for {
+		chunk, err := reader.ReadSlice('\n')
+		if !tooLong {
+			line = append(line, chunk...)
+			if len(line) > maxLength {
+				tooLong = true
+				line = nil
+			}
+		}
+
+		if err == bufio.ErrBufferFull {
+			// the line doesn't fit into the buffer of the reader, continue reading it
+			continue
+		}
+		if err != nil {
+			return string(line), err
+		}
+		break
+	}
+
+	if tooLong {
+		return "", errLineTooLong
+	}
+
+	return string(line), nil
+}
+
+// byteTimeoutReader reads from a connection and fails if the client takes longer than the read timeout to send a
+// command or pauses longer than the byte timeout, once it started sending the command. Waiting for the first byte of a
+// command isn't limited by them, idle clients are only limited by the idle deadline of the command.
+type byteTimeoutReader struct {
+	conn        net.Conn
+	byteTimeout time.Duration
+	readTimeout time.Duration
+
+	// deadline of the current command, the idle deadline until its first byte was received
+	deadline time.Time
+	// deadline of the session, zero if unlimited
+	sessionDeadline time.Time
+	// whether the first byte of the current command was received
+	started bool
+}
+
+// startCommand prepares reading the next command, whose first byte has to be received before the given idle deadline.
+func (r *byteTimeoutReader) startCommand(idleDeadline time.Time, sessionDeadline time.Time) {
+	r.deadline = idleDeadline
+	r.sessionDeadline = sessionDeadline
+	r.started = false
+}
+
+// Read reads from the connection, limiting the time to the read and the pause to the byte timeout once the command
+// was started.
+func (r *byteTimeoutReader) Read(b []byte) (int, error) {
+	if r.started && (r.byteTimeout > 0 || r.readTimeout > 0) {
+		deadline := r.deadline
+		if r.byteTimeout > 0 && time.Now().Add(r.byteTimeout).Before(deadline) {
+			deadline = time.Now().Add(r.byteTimeout)
+		}
+		if err := r.conn.SetReadDeadline(deadline); err != nil {
+			return 0, errors.WithStack(err)
+		}
+	}
+
+	n, err := r.conn.Read(b)
+	if n > 0 && !r.started {
+		r.started = true
+		if r.readTimeout > 0 {
+			r.deadline = time.Now().Add(r.readTimeout)
+			if !r.sessionDeadline.IsZero() && r.sessionDeadline.Before(r.deadline) {
+				r.deadline = r.sessionDeadline
+			}
+		}
+	}
+
+	return n, err
+}
+
+// timeoutOrDefault returns the timeout, or the default timeout if it isn't configured.
+func timeoutOrDefault(timeout time.Duration, defaultTimeout time.Duration) time.Duration {
+	if timeout > 0 {
+		return timeout
+	}
+
+	return defaultTimeout
+}
+
+// remoteHost returns the address of the client without the port.
+func remoteHost(c net.Conn) string {
+	host, _, err := net.SplitHostPort(c.RemoteAddr().String())
+	if err != nil {
+		return c.RemoteAddr().String()
+	}
+
+	return host
+}
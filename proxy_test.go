@@ -0,0 +1,240 @@
+// Copyright [2021] [Christian Bandowski]
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"io"
+	"net"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestReadLine(t *testing.T) {
+	reader := bufio.NewReaderSize(strings.NewReader("LIST UPS\n"+strings.Repeat("x", 100)+"\nLOGOUT"), 16)
+
+	line, err := readLine(reader, 32)
+	assert.NoError(t, err)
+	assert.Equal(t, "LIST UPS\n", line)
+
+	line, err = readLine(reader, 32)
+	assert.Equal(t, errLineTooLong, err)
+	assert.Equal(t, "", line)
+
+	// the remaining line can still be read after the long one was skipped
+	line, err = readLine(reader, 32)
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, "LOGOUT", line)
+}
+
+// startTestServer serves the config on a random local port and returns a connected client.
+func startTestServer(t *testing.T, config *Config) net.Conn {
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go serve(l, &Listener{}, config, NewSessionRegistry())
+
+	c, err := net.Dial("tcp4", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+
+	return c
+}
+
+func TestHandleConnection_SlowClients(t *testing.T) {
+	testCases := []struct {
+		name   string
+		modify func(c *Config)
+		// sent by the client before it stops sending
+		sent string
+	}{
+		{"first command timeout", func(c *Config) { c.firstCommandTimeout = 100 * time.Millisecond }, ""},
+		{"byte timeout", func(c *Config) { c.byteTimeout = 100 * time.Millisecond }, "LIST U"},
+		{"idle timeout", func(c *Config) { c.idleTimeout = 100 * time.Millisecond }, ""},
+		{"read timeout", func(c *Config) { c.readTimeout = 100 * time.Millisecond }, "LIST U"},
+		{"max session duration", func(c *Config) { c.maxSessionDuration = 100 * time.Millisecond }, "LIST U"},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			config := &Config{upsName: "test", timeout: 10 * time.Second, maxLineLength: 1024}
+			testCase.modify(config)
+			c := startTestServer(t, config)
+
+			_, err := c.Write([]byte(testCase.sent))
+			assert.NoError(t, err)
+
+			// the connection is closed long before the timeout
+			assert.NoError(t, c.SetReadDeadline(time.Now().Add(2*time.Second)))
+			_, err = bufio.NewReader(c).ReadString('\n')
+			assert.Equal(t, io.EOF, err)
+		})
+	}
+}
+
+func TestHandleConnection_ByteTimeout_IdleClient(t *testing.T) {
+	config := &Config{upsName: "test", timeout: 10 * time.Second, maxLineLength: 1024,
+		byteTimeout: 100 * time.Millisecond}
+	c := startTestServer(t, config)
+	reader := bufio.NewReader(c)
+
+	_, err := c.Write([]byte("VER\n"))
+	assert.NoError(t, err)
+	_, err = reader.ReadString('\n')
+	assert.NoError(t, err)
+
+	// pausing between commands is not limited by the byte timeout
+	time.Sleep(300 * time.Millisecond)
+
+	_, err = c.Write([]byte("LIST UPS\n"))
+	assert.NoError(t, err)
+	line, err := reader.ReadString('\n')
+	assert.NoError(t, err)
+	assert.Equal(t, "BEGIN LIST UPS\n", line)
+}
+
+func TestHandleConnection_ReadTimeout_IdleClient(t *testing.T) {
+	config := &Config{upsName: "test", timeout: 10 * time.Second, maxLineLength: 1024,
+		readTimeout: 100 * time.Millisecond}
+	c := startTestServer(t, config)
+	reader := bufio.NewReader(c)
+
+	// waiting for the first byte of a command is only limited by the idle timeout
+	time.Sleep(300 * time.Millisecond)
+
+	_, err := c.Write([]byte("LIST UPS\n"))
+	assert.NoError(t, err)
+	line, err := reader.ReadString('\n')
+	assert.NoError(t, err)
+	assert.Equal(t, "BEGIN LIST UPS\n", line)
+}
+
+func TestHandleConnection_SessionCommandWhileLoading(t *testing.T) {
+	source := &mockDataSource{}
+	source.On("load", mock.Anything).Return(nil, errors.New("timeout")).Run(func(args mock.Arguments) {
+		// the source is stuck until the command is cancelled
+		<-args.Get(0).(context.Context).Done()
+	})
+	config := &Config{upsName: "test", timeout: 10 * time.Second, maxLineLength: 1024, source: source,
+		vars: defaultVars(), sessionCommandWait: 200 * time.Millisecond}
+	c := startTestServer(t, config)
+	reader := bufio.NewReader(c)
+
+	_, err := c.Write([]byte("GET VAR test battery.charge\n"))
+	assert.NoError(t, err)
+	time.Sleep(100 * time.Millisecond)
+	_, err = c.Write([]byte("LOGOUT\n"))
+	assert.NoError(t, err)
+
+	// the logout is answered long before the timeout, the pending command fails once the logout waited for it
+	assert.NoError(t, c.SetReadDeadline(time.Now().Add(2*time.Second)))
+	line, err := reader.ReadString('\n')
+	assert.NoError(t, err)
+	assert.Equal(t, "ERR DATA-STALE\n", line)
+	line, err = reader.ReadString('\n')
+	assert.NoError(t, err)
+	assert.Equal(t, "OK Goodbye\n", line)
+}
+
+func TestHandleConnection_SessionCommandWhileLoading_Answered(t *testing.T) {
+	source := &mockDataSource{}
+	source.On("load", mock.Anything).Return(map[string]string{"BCHARGE": "100.0"}, nil).
+		After(300 * time.Millisecond)
+	config := &Config{upsName: "test", timeout: 10 * time.Second, maxLineLength: 1024, source: source,
+		vars: defaultVars(), sessionCommandWait: 5 * time.Second}
+	c := startTestServer(t, config)
+	reader := bufio.NewReader(c)
+
+	_, err := c.Write([]byte("GET VAR test battery.charge\n"))
+	assert.NoError(t, err)
+	time.Sleep(100 * time.Millisecond)
+	_, err = c.Write([]byte("LOGOUT\n"))
+	assert.NoError(t, err)
+
+	// the data source answers within the wait of the logout, sending it separately doesn't change the responses
+	line, err := reader.ReadString('\n')
+	assert.NoError(t, err)
+	assert.Equal(t, "VAR test battery.charge \"100.0\"\n", line)
+	line, err = reader.ReadString('\n')
+	assert.NoError(t, err)
+	assert.Equal(t, "OK Goodbye\n", line)
+}
+
+func TestHandleConnection_PipelinedCommands(t *testing.T) {
+	source := &mockDataSource{}
+	source.On("load", mock.Anything).Return(map[string]string{"BCHARGE": "100.0"}, nil).
+		After(100 * time.Millisecond)
+	config := &Config{upsName: "test", timeout: 10 * time.Second, maxLineLength: 1024, source: source,
+		vars: defaultVars()}
+	c := startTestServer(t, config)
+	reader := bufio.NewReader(c)
+
+	_, err := c.Write([]byte("GET VAR test battery.charge\nLOGOUT\n"))
+	assert.NoError(t, err)
+
+	// the pipelined logout waits for the response of the pending command
+	line, err := reader.ReadString('\n')
+	assert.NoError(t, err)
+	assert.Equal(t, "VAR test battery.charge \"100.0\"\n", line)
+	line, err = reader.ReadString('\n')
+	assert.NoError(t, err)
+	assert.Equal(t, "OK Goodbye\n", line)
+}
+
+// failingListener fails accepting with the given errors and with net.ErrClosed afterwards.
+type failingListener struct {
+	net.Listener
+
+	errs []error
+}
+
+func (l *failingListener) Accept() (net.Conn, error) {
+	if len(l.errs) == 0 {
+		return nil, net.ErrClosed
+	}
+	err := l.errs[0]
+	l.errs = l.errs[1:]
+
+	return nil, err
+}
+
+func TestServe_AcceptFailures(t *testing.T) {
+	temporary := &net.OpError{Op: "accept", Net: "tcp", Err: syscall.EMFILE}
+	l := &failingListener{errs: []error{temporary, temporary, temporary, temporary}}
+	failures := metricAcceptFailures.Value()
+
+	err := serve(l, &Listener{}, &Config{}, NewSessionRegistry())
+
+	// temporary failures are retried, only the closed listener stops serving
+	assert.True(t, errors.Is(err, net.ErrClosed))
+	assert.Equal(t, failures+5, metricAcceptFailures.Value())
+}
+
+func TestNextAcceptBackoff(t *testing.T) {
+	assert.Equal(t, acceptBackoffMin, nextAcceptBackoff(0))
+	assert.Equal(t, 2*acceptBackoffMin, nextAcceptBackoff(acceptBackoffMin))
+	assert.Equal(t, acceptBackoffMax, nextAcceptBackoff(acceptBackoffMax/2+time.Millisecond))
+	assert.Equal(t, acceptBackoffMax, nextAcceptBackoff(acceptBackoffMax))
+}
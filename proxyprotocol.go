@@ -0,0 +1,254 @@
+// Copyright [2021] [Christian Bandowski]
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"github.com/pkg/errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// proxyProtocolV2Signature starts every PROXY protocol v2 header
+var proxyProtocolV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// proxyProtocolV1MaxLength is the maximum length of a PROXY protocol v1 header including CRLF
+const proxyProtocolV1MaxLength = 107
+
+// ProxyProtocolListener accepts connections that start with a PROXY protocol header, as sent by load balancers like
+// HAProxy or Traefik. The connections report the client address of the header as their remote address.
+type ProxyProtocolListener struct {
+	net.Listener
+
+	// maximum time to wait for the header
+	timeout time.Duration
+
+	// networks of the peers whose headers are accepted, the headers of all peers are accepted if there are none
+	trusted []*net.IPNet
+}
+
+// NewProxyProtocolListener creates a new instance of ProxyProtocolListener
+func NewProxyProtocolListener(l net.Listener, timeout time.Duration, trusted []*net.IPNet) *ProxyProtocolListener {
+	return &ProxyProtocolListener{Listener: l, timeout: timeout, trusted: trusted}
+}
+
+// Accept waits for the next connection. Its header is read once the remote address is requested or data is read, so
+// the header of a slow client doesn't block accepting further connections.
+func (l *ProxyProtocolListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	return &proxyProtocolConn{Conn: c, reader: bufio.NewReader(c), timeout: l.timeout, trusted: l.trusted}, nil
+}
+
+// proxyProtocolConn is a connection that starts with a PROXY protocol header.
+type proxyProtocolConn struct {
+	net.Conn
+
+	reader  *bufio.Reader
+	timeout time.Duration
+	trusted []*net.IPNet
+
+	once       sync.Once
+	remoteAddr net.Addr
+	err        error
+}
+
+// readHeader reads the header once and returns the error of reading it, if any.
+func (c *proxyProtocolConn) readHeader() error {
+	c.once.Do(func() {
+		c.remoteAddr = c.Conn.RemoteAddr()
+
+		// the header of any other peer could carry a spoofed client address
+		if !isTrustedPeer(c.remoteAddr, c.trusted) {
+			c.err = errors.Errorf("PROXY protocol header of untrusted peer %s", c.remoteAddr)
+			return
+		}
+
+		if err := c.Conn.SetReadDeadline(time.Now().Add(c.timeout)); err != nil {
+			c.err = errors.WithStack(err)
+			return
+		}
+		remoteAddr, err := readProxyProtocolHeader(c.reader)
+		if err != nil {
+			c.err = errors.Wrap(err, "Invalid PROXY protocol header")
+			return
+		}
+		if err := c.Conn.SetReadDeadline(time.Time{}); err != nil {
+			c.err = errors.WithStack(err)
+			return
+		}
+
+		// LOCAL connections, like health checks of the load balancer, don't carry a client address
+		if remoteAddr != nil {
+			c.remoteAddr = remoteAddr
+		}
+	})
+
+	return c.err
+}
+
+// Read reads data following the header.
+func (c *proxyProtocolConn) Read(b []byte) (int, error) {
+	if err := c.readHeader(); err != nil {
+		return 0, err
+	}
+
+	return c.reader.Read(b)
+}
+
+// RemoteAddr returns the client address of the header, or the address of the load balancer if the header doesn't
+// contain one.
+func (c *proxyProtocolConn) RemoteAddr() net.Addr {
+	_ = c.readHeader()
+
+	return c.remoteAddr
+}
+
+// proxyProtocolError reads the PROXY protocol header of the connection, if it has one, and returns the error of
+// reading it. Errors of connections wrapped by TLS are returned by the handshake instead.
+func proxyProtocolError(c net.Conn) error {
+	pc, ok := c.(*proxyProtocolConn)
+	if !ok {
+		return nil
+	}
+
+	return pc.readHeader()
+}
+
+// isTrustedPeer checks whether the address is within the trusted networks. All addresses are trusted if no networks
+// are configured.
+func isTrustedPeer(address net.Addr, trusted []*net.IPNet) bool {
+	if len(trusted) == 0 {
+		return true
+	}
+
+	tcpAddr, ok := address.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	for _, network := range trusted {
+		if network.Contains(tcpAddr.IP) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// readProxyProtocolHeader reads a PROXY protocol v1 or v2 header and returns the client address it contains. The
+// address is nil if the header doesn't contain one.
+func readProxyProtocolHeader(reader *bufio.Reader) (net.Addr, error) {
+	signature, err := reader.Peek(len(proxyProtocolV2Signature))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if bytes.Equal(signature, proxyProtocolV2Signature) {
+		return readProxyProtocolV2Header(reader)
+	}
+	if bytes.HasPrefix(signature, []byte("PROXY ")) {
+		return readProxyProtocolV1Header(reader)
+	}
+
+	return nil, errors.New("Missing PROXY protocol signature")
+}
+
+// readProxyProtocolV1Header reads a human-readable header like "PROXY TCP4 192.168.0.1 192.168.0.2 56324 3493\r\n".
+func readProxyProtocolV1Header(reader *bufio.Reader) (net.Addr, error) {
+	line, err := readLine(reader, proxyProtocolV1MaxLength)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if !strings.HasSuffix(line, "\r\n") {
+		return nil, errors.New("Header doesn't end with CRLF")
+	}
+
+	fields := strings.Fields(line)
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, errors.Errorf("Malformed header %q", strings.TrimSpace(line))
+	}
+
+	ip := net.ParseIP(fields[2])
+	if ip == nil || (ip.To4() != nil) != (fields[1] == "TCP4") {
+		return nil, errors.Errorf("Invalid source address %s", fields[2])
+	}
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Invalid source port %s", fields[4])
+	}
+
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
+
+// readProxyProtocolV2Header reads a binary header.
+func readProxyProtocolV2Header(reader *bufio.Reader) (net.Addr, error) {
+	header := make([]byte, len(proxyProtocolV2Signature)+4)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	versionCommand := header[12]
+	family := header[13]
+	addresses := make([]byte, binary.BigEndian.Uint16(header[14:16]))
+	if _, err := io.ReadFull(reader, addresses); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if versionCommand>>4 != 2 {
+		return nil, errors.Errorf("Unsupported version %d", versionCommand>>4)
+	}
+	switch versionCommand & 0x0f {
+	case 0x0:
+		// LOCAL
+		return nil, nil
+	case 0x1:
+		// PROXY
+	default:
+		return nil, errors.Errorf("Unsupported command %d", versionCommand&0x0f)
+	}
+
+	var ipLength int
+	switch family {
+	case 0x11:
+		// TCP over IPv4
+		ipLength = net.IPv4len
+	case 0x21:
+		// TCP over IPv6
+		ipLength = net.IPv6len
+	default:
+		// other protocols don't carry a TCP client address
+		return nil, nil
+	}
+
+	if len(addresses) < 2*ipLength+4 {
+		return nil, errors.Errorf("Address block of %d bytes is too short", len(addresses))
+	}
+	ip := net.IP(addresses[:ipLength])
+	port := binary.BigEndian.Uint16(addresses[2*ipLength:])
+
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
@@ -0,0 +1,204 @@
+// Copyright [2021] [Christian Bandowski]
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadProxyProtocolHeader(t *testing.T) {
+	v2Header := func(versionCommand byte, family byte, addresses ...byte) string {
+		return string(proxyProtocolV2Signature) + string([]byte{versionCommand, family, 0, byte(len(addresses))}) +
+			string(addresses)
+	}
+
+	testCases := []struct {
+		name         string
+		header       string
+		expAddress   string
+		errorMessage string
+	}{
+		{"v1 TCP4", "PROXY TCP4 192.168.0.1 192.168.0.2 56324 3493\r\n", "192.168.0.1:56324", ""},
+		{"v1 TCP6", "PROXY TCP6 fd00::1 fd00::2 56324 3493\r\n", "[fd00::1]:56324", ""},
+		{"v1 UNKNOWN", "PROXY UNKNOWN\r\n", "", ""},
+		{"v1 without CRLF", "PROXY TCP4 192.168.0.1 192.168.0.2 56324 3493\n", "", "Header doesn't end with CRLF"},
+		{"v1 malformed", "PROXY TCP4 192.168.0.1 56324\r\n", "", "Malformed header"},
+		{"v1 invalid address", "PROXY TCP4 fd00::1 192.168.0.2 56324 3493\r\n", "", "Invalid source address fd00::1"},
+		{"v1 invalid port", "PROXY TCP4 192.168.0.1 192.168.0.2 70000 3493\r\n", "", "Invalid source port 70000"},
+		{"v1 too long", "PROXY TCP4 " + strings.Repeat("1", 100) + "\r\n", "", "Line too long"},
+		{"v2 TCP4", v2Header(0x21, 0x11, 192, 168, 0, 1, 192, 168, 0, 2, 0xdc, 0x04, 0x0d, 0xa5),
+			"192.168.0.1:56324", ""},
+		{"v2 TCP6", v2Header(0x21, 0x21, 0xfd, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1,
+			0xfd, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 2, 0xdc, 0x04, 0x0d, 0xa5), "[fd00::1]:56324", ""},
+		{"v2 LOCAL", v2Header(0x20, 0x00), "", ""},
+		{"v2 UDP", v2Header(0x21, 0x12, 192, 168, 0, 1, 192, 168, 0, 2, 0xdc, 0x04, 0x0d, 0xa5), "", ""},
+		{"v2 unsupported version", v2Header(0x11, 0x11), "", "Unsupported version 1"},
+		{"v2 short addresses", v2Header(0x21, 0x11, 192, 168, 0, 1), "", "Address block of 4 bytes is too short"},
+		{"v2 truncated", string(proxyProtocolV2Signature) + "\x21\x11\x00\x0c", "", "EOF"},
+		{"missing signature", "LIST UPS\r\nLOGOUT\r\n", "", "Missing PROXY protocol signature"},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			address, err := readProxyProtocolHeader(bufio.NewReader(strings.NewReader(testCase.header)))
+
+			if testCase.errorMessage != "" {
+				if assert.Error(t, err) {
+					assert.Contains(t, err.Error(), testCase.errorMessage)
+				}
+			} else if assert.NoError(t, err) {
+				if testCase.expAddress == "" {
+					assert.Nil(t, address)
+				} else if assert.NotNil(t, address) {
+					assert.Equal(t, testCase.expAddress, address.String())
+				}
+			}
+		})
+	}
+}
+
+func TestServe_ProxyProtocol(t *testing.T) {
+	networks, err := parseNetworks("192.168.0.0/24")
+	if !assert.NoError(t, err) {
+		return
+	}
+	config := &Config{
+		upsName:       "test",
+		timeout:       time.Second,
+		maxLineLength: 1024,
+	}
+	listener := &Listener{
+		network:         "tcp4",
+		address:         "127.0.0.1:0",
+		proxyProtocol:   true,
+		allowedNetworks: networks,
+	}
+
+	l, err := listen(listener, config)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer l.Close()
+	go serve(l, listener, config, NewSessionRegistry())
+
+	t.Run("allowed client", func(t *testing.T) {
+		c, err := net.Dial("tcp4", l.Addr().String())
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer c.Close()
+
+		_, err = c.Write([]byte("PROXY TCP4 192.168.0.10 192.168.0.2 56324 3493\r\nLIST UPS\n"))
+		assert.NoError(t, err)
+
+		line, err := bufio.NewReader(c).ReadString('\n')
+		assert.NoError(t, err)
+		assert.Equal(t, "BEGIN LIST UPS\n", line)
+	})
+
+	t.Run("rejected client", func(t *testing.T) {
+		c, err := net.Dial("tcp4", l.Addr().String())
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer c.Close()
+
+		_, err = c.Write([]byte("PROXY TCP4 10.0.0.1 192.168.0.2 56324 3493\r\nLIST UPS\n"))
+		assert.NoError(t, err)
+
+		_, err = bufio.NewReader(c).ReadString('\n')
+		assert.Error(t, err)
+	})
+
+	t.Run("missing header", func(t *testing.T) {
+		c, err := net.Dial("tcp4", l.Addr().String())
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer c.Close()
+
+		_, err = c.Write([]byte("LIST UPS\n"))
+		assert.NoError(t, err)
+
+		_, err = bufio.NewReader(c).ReadString('\n')
+		assert.Error(t, err)
+	})
+}
+
+func TestServe_ProxyProtocol_UntrustedPeer(t *testing.T) {
+	allowed, err := parseNetworks("192.168.0.0/24")
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	testCases := []struct {
+		name     string
+		trusted  string
+		expError bool
+	}{
+		{"trusted peer", "127.0.0.1", false},
+		{"untrusted peer", "10.0.0.0/8", true},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			trusted, err := parseNetworks(testCase.trusted)
+			if !assert.NoError(t, err) {
+				return
+			}
+			config := &Config{
+				upsName:              "test",
+				timeout:              time.Second,
+				maxLineLength:        1024,
+				proxyProtocolTrusted: trusted,
+			}
+			listener := &Listener{
+				network:         "tcp4",
+				address:         "127.0.0.1:0",
+				proxyProtocol:   true,
+				allowedNetworks: allowed,
+			}
+
+			l, err := listen(listener, config)
+			if !assert.NoError(t, err) {
+				return
+			}
+			defer l.Close()
+			go serve(l, listener, config, NewSessionRegistry())
+
+			c, err := net.Dial("tcp4", l.Addr().String())
+			if !assert.NoError(t, err) {
+				return
+			}
+			defer c.Close()
+
+			// the spoofed address is within the allowed networks, the peer itself isn't
+			_, err = c.Write([]byte("PROXY TCP4 192.168.0.10 192.168.0.2 56324 3493\r\nLIST UPS\n"))
+			assert.NoError(t, err)
+
+			line, err := bufio.NewReader(c).ReadString('\n')
+			if testCase.expError {
+				assert.Error(t, err)
+			} else if assert.NoError(t, err) {
+				assert.Equal(t, "BEGIN LIST UPS\n", line)
+			}
+		})
+	}
+}
@@ -0,0 +1,59 @@
+// Copyright [2021] [Christian Bandowski]
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"runtime"
+	"time"
+)
+
+// shortest time after which idle connections may be closed, they are checked every half of it
+const minReapIdleAfter = time.Second
+
+// startHousekeeping closes idle connections and reports the number of goroutines and connections periodically until
+// the context is done, if enabled. So leaks caused by misbehaving clients are noticed early.
+func (c *Config) startHousekeeping(ctx context.Context) {
+	if c.reapIdleAfter > 0 {
+		// idle connections are closed at most half the limit too late
+		go runPeriodically(ctx, c.reapIdleAfter/2, func() {
+			if closed := c.connectionTracker.closeIdle(c.reapIdleAfter); closed > 0 {
+				metricReapedConnections.Add(int64(closed))
+			}
+		})
+	}
+	if c.selfCheckInterval > 0 {
+		go runPeriodically(ctx, c.selfCheckInterval, func() {
+			connections, busy := c.connectionTracker.count()
+			logInfof("Self-check: %d goroutines, %d connections of which %d are handling a command",
+				runtime.NumGoroutine(), connections, busy)
+		})
+	}
+}
+
+// runPeriodically runs the function after every interval until the context is done.
+func runPeriodically(ctx context.Context, interval time.Duration, f func()) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			f()
+		}
+	}
+}
@@ -0,0 +1,79 @@
+// Copyright [2021] [Christian Bandowski]
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestConnectionTracker_closeIdle(t *testing.T) {
+	tracker := NewConnectionTracker()
+	idle, _ := net.Pipe()
+	busy, _ := net.Pipe()
+	active, _ := net.Pipe()
+	for _, c := range []net.Conn{idle, busy, active} {
+		assert.True(t, tracker.open(c))
+	}
+	assert.True(t, tracker.startCommand(busy))
+	time.Sleep(50 * time.Millisecond)
+	assert.True(t, tracker.finishCommand(active))
+
+	assert.Equal(t, 1, tracker.closeIdle(30*time.Millisecond))
+
+	assert.True(t, isClosed(idle))
+	assert.False(t, isClosed(busy))
+	assert.False(t, isClosed(active))
+	// reaped connections aren't closed again until their handler stops tracking them
+	assert.Equal(t, 0, tracker.closeIdle(30*time.Millisecond))
+}
+
+func TestConnectionTracker_count(t *testing.T) {
+	tracker := NewConnectionTracker()
+	idle, _ := net.Pipe()
+	busy, _ := net.Pipe()
+	assert.True(t, tracker.open(idle))
+	assert.True(t, tracker.open(busy))
+	assert.True(t, tracker.startCommand(busy))
+
+	connections, busyCount := tracker.count()
+	assert.Equal(t, 2, connections)
+	assert.Equal(t, 1, busyCount)
+
+	tracker.close(busy)
+	connections, busyCount = tracker.count()
+	assert.Equal(t, 1, connections)
+	assert.Equal(t, 0, busyCount)
+}
+
+func TestConfig_startHousekeeping(t *testing.T) {
+	tracker := NewConnectionTracker()
+	idle, _ := net.Pipe()
+	assert.True(t, tracker.open(idle))
+	config := &Config{connectionTracker: tracker, reapIdleAfter: 20 * time.Millisecond,
+		selfCheckInterval: 10 * time.Millisecond}
+	reaped := metricReapedConnections.Value()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	config.startHousekeeping(ctx)
+
+	assert.Eventually(t, func() bool { return isClosed(idle) }, time.Second, 5*time.Millisecond)
+	assert.Eventually(t, func() bool { return metricReapedConnections.Value() == reaped+1 }, time.Second,
+		5*time.Millisecond)
+}
@@ -0,0 +1,141 @@
+// Copyright [2021] [Christian Bandowski]
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/pkg/errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// extension of the files containing recorded snapshots
+const snapshotExtension = ".status"
+
+// layout of the names of the snapshot files, which sort in the order they were recorded
+const snapshotTimeFormat = "20060102T150405.000000000Z"
+
+// RecordingDataSource saves every snapshot loaded by another source to a directory, so it can be replayed later on,
+// e.g. to reproduce issues of users. Failing to save a snapshot doesn't fail loading it.
+type RecordingDataSource struct {
+	source DataSource
+	dir    string
+
+	// returns the current time, replaced by tests
+	now func() time.Time
+}
+
+// NewRecordingDataSource creates a new instance of RecordingDataSource
+func NewRecordingDataSource(source DataSource, dir string) *RecordingDataSource {
+	return &RecordingDataSource{source: source, dir: dir, now: time.Now}
+}
+
+// load loads the values from the recorded source and saves them.
+func (s *RecordingDataSource) load(ctx context.Context) (map[string]string, error) {
+	values, err := s.source.load(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.record(values); err != nil {
+		logWarnf("Couldn't record the values loaded from %s: %v", s.source, err)
+	}
+
+	return values, nil
+}
+
+// record saves the values in the format of the status output of apcupsd.
+func (s *RecordingDataSource) record(values map[string]string) error {
+	if err := os.MkdirAll(s.dir, 0750); err != nil {
+		return errors.WithStack(err)
+	}
+
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var out bytes.Buffer
+	for _, key := range keys {
+		fmt.Fprintf(&out, "%-9s: %s\n", key, values[key])
+	}
+
+	name := s.now().UTC().Format(snapshotTimeFormat) + snapshotExtension
+	return errors.WithStack(os.WriteFile(filepath.Join(s.dir, name), out.Bytes(), 0640))
+}
+
+// String returns the recorded source.
+func (s *RecordingDataSource) String() string {
+	return fmt.Sprintf("%s (recorded to %s)", s.source, s.dir)
+}
+
+// ReplayDataSource feeds recorded snapshots back, one per load in the order they were recorded. The snapshots are
+// repeated after the last one.
+type ReplayDataSource struct {
+	dir string
+
+	mutex sync.Mutex
+
+	// index of the next snapshot
+	next int
+}
+
+// NewReplayDataSource creates a new instance of ReplayDataSource
+func NewReplayDataSource(dir string) *ReplayDataSource {
+	return &ReplayDataSource{dir: dir}
+}
+
+// load reads the next snapshot, the directory is listed again every time, so snapshots may be added meanwhile.
+func (s *ReplayDataSource) load(_ context.Context) (map[string]string, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error reading replay directory %s", s.dir)
+	}
+
+	var names []string
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), snapshotExtension) {
+			names = append(names, entry.Name())
+		}
+	}
+	if len(names) == 0 {
+		return nil, errors.Errorf("No snapshots found in replay directory %s", s.dir)
+	}
+
+	s.mutex.Lock()
+	name := names[s.next%len(names)]
+	s.next++
+	s.mutex.Unlock()
+
+	out, err := os.ReadFile(filepath.Join(s.dir, name))
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error reading snapshot %s", name)
+	}
+
+	logDebugf("Replaying snapshot %s", name)
+	return parseApcOutput(out, true)
+}
+
+// String returns the replay directory.
+func (s *ReplayDataSource) String() string {
+	return fmt.Sprintf("replay of %s", s.dir)
+}
@@ -0,0 +1,103 @@
+// Copyright [2021] [Christian Bandowski]
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRecordingDataSource_load(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "ups")
+	source := &mockDataSource{}
+	source.On("load", mock.Anything).Return(map[string]string{"STATUS": "ONLINE", "BCHARGE": "100.0"}, nil)
+	recording := NewRecordingDataSource(source, dir)
+	recording.now = func() time.Time { return time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC) }
+
+	values, err := recording.load(context.Background())
+
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"STATUS": "ONLINE", "BCHARGE": "100.0"}, values)
+	out, err := os.ReadFile(filepath.Join(dir, "20210601T120000.000000000Z.status"))
+	if assert.NoError(t, err) {
+		assert.Equal(t, "BCHARGE  : 100.0\nSTATUS   : ONLINE\n", string(out))
+	}
+}
+
+func TestRecordingDataSource_load_Error(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "ups")
+	source := &mockDataSource{}
+	source.On("load", mock.Anything).Return(nil, errors.New("unreachable"))
+
+	_, err := NewRecordingDataSource(source, dir).load(context.Background())
+
+	assert.EqualError(t, err, "unreachable")
+	assert.NoDirExists(t, dir)
+}
+
+func TestReplayDataSource_load(t *testing.T) {
+	dir := t.TempDir()
+	snapshots := map[string]string{
+		"20210601T120000.000000000Z.status": "STATUS   : ONLINE\nBCHARGE  : 100.0 Percent\n",
+		"20210601T120100.000000000Z.status": "STATUS   : ONBATT\nBCHARGE  : 90.0 Percent\n",
+		"notes.txt":                         "not a snapshot",
+	}
+	for name, content := range snapshots {
+		if !assert.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0600)) {
+			return
+		}
+	}
+	source := NewReplayDataSource(dir)
+
+	// the snapshots are replayed in order and repeated afterwards
+	for _, expStatus := range []string{"ONLINE", "ONBATT", "ONLINE"} {
+		values, err := source.load(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, expStatus, values["STATUS"])
+	}
+	assert.Equal(t, "replay of "+dir, source.String())
+}
+
+func TestReplayDataSource_load_Empty(t *testing.T) {
+	dir := t.TempDir()
+
+	_, err := NewReplayDataSource(dir).load(context.Background())
+
+	assert.EqualError(t, err, "No snapshots found in replay directory "+dir)
+}
+
+func TestRecordAndReplay(t *testing.T) {
+	dir := t.TempDir()
+	source := &mockDataSource{}
+	source.On("load", mock.Anything).Return(map[string]string{"STATUS": "ONBATT LOWBATT", "MODEL": "Back-UPS"}, nil)
+	config := Config{upsName: "ups", recordDir: dir}
+	config.loadDataSource()
+	config.source.(*RecordingDataSource).source = source
+
+	_, err := config.source.load(context.Background())
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	values, err := NewReplayDataSource(filepath.Join(dir, "ups")).load(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"STATUS": "ONBATT LOWBATT", "MODEL": "Back-UPS"}, values)
+}
@@ -0,0 +1,122 @@
+// Copyright [2021] [Christian Bandowski]
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"net"
+	"sync"
+	"time"
+)
+
+// AddressResolver resolves the host names of target addresses. Without a TTL the address is passed on unchanged, so
+// the host name is resolved freshly on every connection, otherwise the resolved IP is reused until the TTL expired.
+type AddressResolver struct {
+	ttl time.Duration
+
+	mutex sync.Mutex
+	hosts map[string]resolvedHost
+
+	// looks up the IPs of a host name, replaced by tests
+	lookup func(ctx context.Context, host string) ([]net.IPAddr, error)
+
+	// returns the current time, replaced by tests
+	now func() time.Time
+}
+
+// a host name resolved to an IP
+type resolvedHost struct {
+	ip      string
+	expires time.Time
+}
+
+// NewAddressResolver creates a new instance of AddressResolver
+func NewAddressResolver(ttl time.Duration) *AddressResolver {
+	return &AddressResolver{
+		ttl:    ttl,
+		hosts:  map[string]resolvedHost{},
+		lookup: net.DefaultResolver.LookupIPAddr,
+		now:    time.Now,
+	}
+}
+
+// resolve replaces the host name of the address, which may contain a port, by its IP if a TTL is configured.
+func (r *AddressResolver) resolve(ctx context.Context, address string) (string, error) {
+	if r == nil || r.ttl <= 0 {
+		return address, nil
+	}
+
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		host, port = address, ""
+	}
+	if net.ParseIP(host) != nil {
+		return address, nil
+	}
+
+	ip, err := r.resolveHost(ctx, host)
+	if err != nil {
+		return "", err
+	}
+
+	if port == "" {
+		return ip, nil
+	}
+	return net.JoinHostPort(ip, port), nil
+}
+
+// resolveHost returns the IP of the host, which is only looked up if the previous one expired.
+func (r *AddressResolver) resolveHost(ctx context.Context, host string) (string, error) {
+	r.mutex.Lock()
+	resolved, ok := r.hosts[host]
+	r.mutex.Unlock()
+	if ok && r.now().Before(resolved.expires) {
+		return resolved.ip, nil
+	}
+
+	ips, err := r.lookup(ctx, host)
+	if err != nil {
+		return "", errors.Wrapf(err, "Couldn't resolve %s", host)
+	}
+	if len(ips) == 0 {
+		return "", errors.Errorf("No IP found for %s", host)
+	}
+
+	ip := ips[0].String()
+	logDebugf("Resolved %s to %s", host, ip)
+
+	r.mutex.Lock()
+	r.hosts[host] = resolvedHost{ip: ip, expires: r.now().Add(r.ttl)}
+	r.mutex.Unlock()
+
+	return ip, nil
+}
+
+// forget drops the resolved IP of the address, so it is looked up again, e.g. because it isn't reachable anymore.
+func (r *AddressResolver) forget(address string) {
+	if r == nil {
+		return
+	}
+
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		host = address
+	}
+
+	r.mutex.Lock()
+	delete(r.hosts, host)
+	r.mutex.Unlock()
+}
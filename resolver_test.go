@@ -0,0 +1,100 @@
+// Copyright [2021] [Christian Bandowski]
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestAddressResolver_resolve(t *testing.T) {
+	addressToResult := map[string]string{
+		"apcupsd":          "10.0.0.1",
+		"apcupsd:3551":     "10.0.0.1:3551",
+		"192.168.0.1":      "192.168.0.1",
+		"192.168.0.1:3551": "192.168.0.1:3551",
+		"[::1]:3551":       "[::1]:3551",
+	}
+
+	for address, expResult := range addressToResult {
+		t.Run(address, func(t *testing.T) {
+			resolver := NewAddressResolver(time.Minute)
+			resolver.lookup = func(ctx context.Context, host string) ([]net.IPAddr, error) {
+				assert.Equal(t, "apcupsd", host)
+				return []net.IPAddr{{IP: net.ParseIP("10.0.0.1")}}, nil
+			}
+
+			result, err := resolver.resolve(context.Background(), address)
+
+			assert.NoError(t, err)
+			assert.Equal(t, expResult, result)
+		})
+	}
+}
+
+func TestAddressResolver_resolve_TTL(t *testing.T) {
+	lookups := 0
+	now := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	resolver := NewAddressResolver(time.Minute)
+	resolver.now = func() time.Time { return now }
+	resolver.lookup = func(ctx context.Context, host string) ([]net.IPAddr, error) {
+		lookups++
+		return []net.IPAddr{{IP: net.IPv4(10, 0, 0, byte(lookups))}}, nil
+	}
+
+	resolve := func() string {
+		result, err := resolver.resolve(context.Background(), "apcupsd:3551")
+		assert.NoError(t, err)
+		return result
+	}
+
+	assert.Equal(t, "10.0.0.1:3551", resolve())
+	now = now.Add(59 * time.Second)
+	assert.Equal(t, "10.0.0.1:3551", resolve())
+	now = now.Add(time.Second)
+	assert.Equal(t, "10.0.0.2:3551", resolve())
+	resolver.forget("apcupsd:3551")
+	assert.Equal(t, "10.0.0.3:3551", resolve())
+}
+
+func TestAddressResolver_resolve_NoTTL(t *testing.T) {
+	var nilResolver *AddressResolver
+	resolver := NewAddressResolver(0)
+	resolver.lookup = func(ctx context.Context, host string) ([]net.IPAddr, error) {
+		assert.Fail(t, "The host name must not be resolved")
+		return nil, nil
+	}
+
+	for _, r := range []*AddressResolver{nilResolver, resolver} {
+		result, err := r.resolve(context.Background(), "apcupsd:3551")
+		assert.NoError(t, err)
+		assert.Equal(t, "apcupsd:3551", result)
+	}
+}
+
+func TestAddressResolver_resolve_Error(t *testing.T) {
+	resolver := NewAddressResolver(time.Minute)
+	resolver.lookup = func(ctx context.Context, host string) ([]net.IPAddr, error) {
+		return nil, errors.New("no such host")
+	}
+
+	_, err := resolver.resolve(context.Background(), "apcupsd:3551")
+
+	assert.EqualError(t, err, "Couldn't resolve apcupsd: no such host")
+}
@@ -0,0 +1,63 @@
+// Copyright [2021] [Christian Bandowski]
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/pkg/errors"
+	"time"
+)
+
+// RetryingDataSource retries failed loads of another source, so a transient failure of apcupsd doesn't reach the
+// clients. The delay between the attempts doubles after every retry.
+type RetryingDataSource struct {
+	source  DataSource
+	retries int
+
+	// delay before the first retry
+	backoff time.Duration
+}
+
+// NewRetryingDataSource creates a new instance of RetryingDataSource
+func NewRetryingDataSource(source DataSource, retries int, backoff time.Duration) *RetryingDataSource {
+	return &RetryingDataSource{source: source, retries: retries, backoff: backoff}
+}
+
+// load loads the values until it succeeds, the retries are exhausted or the context is done.
+func (s *RetryingDataSource) load(ctx context.Context) (map[string]string, error) {
+	backoff := s.backoff
+	for attempt := 0; ; attempt++ {
+		values, err := s.source.load(ctx)
+		if err == nil || attempt >= s.retries || ctx.Err() != nil {
+			return values, err
+		}
+
+		logDebugf("Loading the values from %s failed, retrying in %s: %v", s.source, backoff, err)
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, errors.Wrapf(err, "Retrying was cancelled")
+		case <-timer.C:
+		}
+		backoff *= 2
+	}
+}
+
+// String returns the retried source.
+func (s *RetryingDataSource) String() string {
+	return fmt.Sprintf("%s (retried %d times)", s.source, s.retries)
+}
@@ -0,0 +1,70 @@
+// Copyright [2021] [Christian Bandowski]
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"testing"
+	"time"
+)
+
+func TestRetryingDataSource_load(t *testing.T) {
+	source := &mockDataSource{}
+	source.On("load", mock.Anything).Return(nil, errors.New("unreachable")).Twice()
+	source.On("load", mock.Anything).Return(map[string]string{"STATUS": "ONLINE"}, nil)
+
+	start := time.Now()
+	values, err := NewRetryingDataSource(source, 3, 10*time.Millisecond).load(context.Background())
+
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"STATUS": "ONLINE"}, values)
+	source.AssertNumberOfCalls(t, "load", 3)
+	// the backoff doubles, 10ms before the first and 20ms before the second retry
+	assert.GreaterOrEqual(t, int64(time.Since(start)), int64(30*time.Millisecond))
+}
+
+func TestRetryingDataSource_load_Exhausted(t *testing.T) {
+	source := &mockDataSource{}
+	source.On("load", mock.Anything).Return(nil, errors.New("unreachable"))
+
+	_, err := NewRetryingDataSource(source, 2, time.Millisecond).load(context.Background())
+
+	assert.EqualError(t, err, "unreachable")
+	source.AssertNumberOfCalls(t, "load", 3)
+}
+
+func TestRetryingDataSource_load_Cancelled(t *testing.T) {
+	source := &mockDataSource{}
+	source.On("load", mock.Anything).Return(nil, errors.New("unreachable"))
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	_, err := NewRetryingDataSource(source, 3, time.Minute).load(ctx)
+
+	assert.EqualError(t, err, "Retrying was cancelled: unreachable")
+	source.AssertNumberOfCalls(t, "load", 1)
+}
+
+func TestConfig_loadDataSource_Retries(t *testing.T) {
+	config := Config{dataSource: DataSourceNis, targetAddress: "127.0.0.1", sourceRetries: 2,
+		sourceRetryBackoff: time.Second}
+
+	config.loadDataSource()
+
+	assert.Equal(t, NewRetryingDataSource(&NisDataSource{address: "127.0.0.1"}, 2, time.Second), config.source)
+}
@@ -0,0 +1,67 @@
+// Copyright [2021] [Christian Bandowski]
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"github.com/pkg/errors"
+	"os"
+	"strings"
+)
+
+// readSecretFile reads a secret, like a password, from the given file. A trailing newline is removed, as most editors
+// add one.
+func readSecretFile(path string) (string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", errors.Wrapf(err, "Couldn't read secret file %s", path)
+	}
+
+	return strings.TrimRight(string(content), "\r\n"), nil
+}
+
+// readSecretEnv reads a secret, like a password, from the given environment variable, which has to be set.
+func readSecretEnv(name string) (string, error) {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return "", errors.Errorf("The environment variable %s is not set", name)
+	}
+
+	return value, nil
+}
+
+// redactSecret hides the secret for logging, it only shows whether one is set.
+func redactSecret(secret string) string {
+	if secret == "" {
+		return ""
+	}
+
+	return "***"
+}
+
+// redactUpsSpecs hides the secrets within the UPS specs for logging and separates the specs by spaces.
+func redactUpsSpecs(specs []string) string {
+	redacted := make([]string, 0, len(specs))
+	for _, spec := range specs {
+		options := splitList(spec)
+		for i, option := range options {
+			if strings.HasPrefix(option, "community=") {
+				options[i] = "community=" + redactSecret(strings.TrimPrefix(option, "community="))
+			}
+		}
+		redacted = append(redacted, strings.Join(options, ","))
+	}
+
+	return strings.Join(redacted, " ")
+}
@@ -0,0 +1,67 @@
+// Copyright [2021] [Christian Bandowski]
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadSecretFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "secret")
+	assert.NoError(t, os.WriteFile(path, []byte("top secret\n"), 0600))
+
+	secret, err := readSecretFile(path)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "top secret", secret)
+}
+
+func TestReadSecretFile_Missing(t *testing.T) {
+	_, err := readSecretFile(filepath.Join(t.TempDir(), "secret"))
+
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "Couldn't read secret file")
+	}
+}
+
+func TestReadSecretEnv(t *testing.T) {
+	assert.NoError(t, os.Setenv("APCUPSD_NUT_PROXY_TEST_SECRET", "top secret"))
+	defer os.Unsetenv("APCUPSD_NUT_PROXY_TEST_SECRET")
+
+	secret, err := readSecretEnv("APCUPSD_NUT_PROXY_TEST_SECRET")
+
+	assert.NoError(t, err)
+	assert.Equal(t, "top secret", secret)
+}
+
+func TestReadSecretEnv_Missing(t *testing.T) {
+	_, err := readSecretEnv("APCUPSD_NUT_PROXY_TEST_SECRET_MISSING")
+
+	assert.EqualError(t, err, "The environment variable APCUPSD_NUT_PROXY_TEST_SECRET_MISSING is not set")
+}
+
+func TestRedactSecret(t *testing.T) {
+	assert.Equal(t, "***", redactSecret("public"))
+	assert.Equal(t, "", redactSecret(""))
+}
+
+func TestRedactUpsSpecs(t *testing.T) {
+	specs := []string{"first,source=snmp,target=10.0.0.5,community=secret", "second, source=nis"}
+
+	assert.Equal(t, "first,source=snmp,target=10.0.0.5,community=*** second,source=nis", redactUpsSpecs(specs))
+}
@@ -0,0 +1,193 @@
+// Copyright [2021] [Christian Bandowski]
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/rand"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Session contains the state of a single client connection.
+type Session struct {
+	// address of the client, without the port
+	remoteAddr string
+
+	// credentials sent by the client, empty if not yet sent
+	username string
+	password string
+
+	// user identified by the client certificate of a TLS connection, empty if the client didn't present one
+	certUser string
+
+	// whether the connection uses TLS, either from the start or after STARTTLS
+	tls bool
+	// whether the connection has to be switched to TLS once the response to STARTTLS was sent
+	startTLS bool
+
+	// name of the UPS the client is logged in to, empty if not logged in
+	loginUpsName string
+
+	// whether the client was granted primary status by using the MASTER or PRIMARY command
+	primary bool
+
+	// whether the client enabled tracking of SET VAR and INSTCMD results by using SET TRACKING
+	tracking bool
+
+	// results of the tracked commands by tracking ID, true if the command succeeded
+	trackingResults map[string]bool
+	// tracking IDs in the order the commands were executed, the oldest results are dropped beyond maxTrackingResults
+	trackingIDs []string
+
+	// whether the client is outside of the allowed networks and may only use the limited commands
+	limited bool
+
+	// registry shared by all sessions, used to track the logins
+	registry *SessionRegistry
+}
+
+// NewSession creates a new session for a client with the given address.
+func NewSession(remoteAddr string, registry *SessionRegistry) *Session {
+	return &Session{
+		remoteAddr: remoteAddr,
+		registry:   registry,
+	}
+}
+
+// login marks the session as logged in to the given UPS.
+func (s *Session) login(upsName string) {
+	s.loginUpsName = upsName
+	s.registry.login(s, upsName)
+}
+
+// logout removes the login of the session, it stays known to the registry until it is closed.
+func (s *Session) logout() {
+	s.loginUpsName = ""
+	s.registry.login(s, "")
+}
+
+// isLoggedIn checks whether the session is logged in to any UPS.
+func (s *Session) isLoggedIn() bool {
+	return s.loginUpsName != ""
+}
+
+// identity returns the name of the user identified by the client certificate, or the user name sent by the client.
+func (s *Session) identity() string {
+	if s.certUser != "" {
+		return s.certUser
+	}
+
+	return s.username
+}
+
+// maximum number of tracking results kept per session, so long-lived connections don't grow without limit
+const maxTrackingResults = 100
+
+// track records the result of a tracked command and returns the tracking ID the client can use to query it. Only the
+// latest maxTrackingResults results are kept.
+func (s *Session) track(success bool) string {
+	if s.trackingResults == nil {
+		s.trackingResults = make(map[string]bool)
+	}
+	if len(s.trackingIDs) >= maxTrackingResults {
+		delete(s.trackingResults, s.trackingIDs[0])
+		s.trackingIDs = s.trackingIDs[1:]
+	}
+
+	id := newTrackingID()
+	s.trackingResults[id] = success
+	s.trackingIDs = append(s.trackingIDs, id)
+
+	return id
+}
+
+// trackingResult returns the result of the tracked command with the given ID, the second return value is false if
+// the ID is unknown.
+func (s *Session) trackingResult(id string) (bool, bool) {
+	success, ok := s.trackingResults[id]
+
+	return success, ok
+}
+
+// newTrackingID creates a random tracking ID in the UUID format used by upsd.
+func newTrackingID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		// should never happen, but the ID only has to be unique within the session
+		return fmt.Sprintf("%032x", time.Now().UnixNano())
+	}
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// close removes the session from the registry, has to be called once the connection was closed.
+func (s *Session) close() {
+	s.registry.remove(s)
+}
+
+// SessionRegistry keeps track of the sessions of all connections and to which UPS they are logged in.
+// It is safe for concurrent use.
+type SessionRegistry struct {
+	mutex sync.Mutex
+
+	// name of the UPS a session is logged in to
+	logins map[*Session]string
+}
+
+// NewSessionRegistry creates a new instance of SessionRegistry
+func NewSessionRegistry() *SessionRegistry {
+	return &SessionRegistry{
+		logins: make(map[*Session]string),
+	}
+}
+
+// login marks the given session as logged in to the given UPS.
+func (r *SessionRegistry) login(session *Session, upsName string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.logins[session] = upsName
+}
+
+// remove forgets the given session.
+func (r *SessionRegistry) remove(session *Session) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	delete(r.logins, session)
+}
+
+// clients returns the sorted addresses of all clients that are logged in to the given UPS.
+func (r *SessionRegistry) clients(upsName string) []string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	addresses := make([]string, 0)
+	for session, loginUpsName := range r.logins {
+		if loginUpsName == upsName {
+			addresses = append(addresses, session.remoteAddr)
+		}
+	}
+	sort.Strings(addresses)
+
+	return addresses
+}
+
+// numLogins returns the number of clients that are logged in to the given UPS.
+func (r *SessionRegistry) numLogins(upsName string) int {
+	return len(r.clients(upsName))
+}
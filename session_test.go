@@ -0,0 +1,109 @@
+// Copyright [2021] [Christian Bandowski]
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestSessionRegistry_clients(t *testing.T) {
+	registry := NewSessionRegistry()
+
+	session1 := NewSession("192.168.0.2", registry)
+	session2 := NewSession("192.168.0.1", registry)
+	session3 := NewSession("192.168.0.3", registry)
+	NewSession("192.168.0.4", registry)
+
+	session1.login("ups")
+	session2.login("ups")
+	session3.login("other")
+
+	assert.Equal(t, []string{"192.168.0.1", "192.168.0.2"}, registry.clients("ups"))
+	assert.Equal(t, []string{"192.168.0.3"}, registry.clients("other"))
+	assert.Empty(t, registry.clients("unknown"))
+
+	session1.close()
+
+	assert.Equal(t, []string{"192.168.0.1"}, registry.clients("ups"))
+}
+
+func TestSessionRegistry_numLogins(t *testing.T) {
+	registry := NewSessionRegistry()
+
+	session1 := NewSession("192.168.0.1", registry)
+	session2 := NewSession("192.168.0.2", registry)
+
+	assert.Equal(t, 0, registry.numLogins("ups"))
+
+	session1.login("ups")
+	session2.login("ups")
+
+	assert.Equal(t, 2, registry.numLogins("ups"))
+
+	session1.logout()
+
+	assert.Equal(t, 1, registry.numLogins("ups"))
+
+	session2.close()
+
+	assert.Equal(t, 0, registry.numLogins("ups"))
+}
+
+func TestSession_track(t *testing.T) {
+	session := NewSession("127.0.0.1", NewSessionRegistry())
+
+	successID := session.track(true)
+	failureID := session.track(false)
+
+	assert.NotEqual(t, successID, failureID)
+	assert.Regexp(t, "^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$", successID)
+
+	success, ok := session.trackingResult(successID)
+	assert.True(t, ok)
+	assert.True(t, success)
+
+	success, ok = session.trackingResult(failureID)
+	assert.True(t, ok)
+	assert.False(t, success)
+
+	_, ok = session.trackingResult("unknown")
+	assert.False(t, ok)
+}
+
+func TestSession_track_Limit(t *testing.T) {
+	session := NewSession("127.0.0.1", NewSessionRegistry())
+
+	firstID := session.track(true)
+	secondID := session.track(true)
+	for i := 2; i < maxTrackingResults+1; i++ {
+		session.track(true)
+	}
+
+	assert.Len(t, session.trackingResults, maxTrackingResults)
+	_, ok := session.trackingResult(firstID)
+	assert.False(t, ok)
+	_, ok = session.trackingResult(secondID)
+	assert.True(t, ok)
+}
+
+func TestSession_identity(t *testing.T) {
+	session := NewSession("127.0.0.1", NewSessionRegistry())
+	session.username = "user"
+	assert.Equal(t, "user", session.identity())
+
+	session.certUser = "nas"
+	assert.Equal(t, "nas", session.identity())
+}
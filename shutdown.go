@@ -0,0 +1,201 @@
+// Copyright [2021] [Christian Bandowski]
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// ConnectionTracker keeps track of the open connections, so they can be drained on shutdown. Connections handling a
+// command may finish it, idle ones are closed right away. It is shared by all listeners and safe for concurrent use.
+type ConnectionTracker struct {
+	mutex sync.Mutex
+
+	// open connections
+	connections map[net.Conn]*trackedConnection
+	// whether the proxy is shutting down
+	draining bool
+	// closed once the last connection was closed while draining
+	drained chan struct{}
+}
+
+// trackedConnection is the state of an open connection.
+type trackedConnection struct {
+	// whether the connection is handling a command
+	busy bool
+	// time the connection was opened or started or finished its last command
+	lastActivity time.Time
+	// whether the connection was closed for being idle for too long
+	reaped bool
+}
+
+// NewConnectionTracker creates a new instance of ConnectionTracker
+func NewConnectionTracker() *ConnectionTracker {
+	return &ConnectionTracker{connections: make(map[net.Conn]*trackedConnection), drained: make(chan struct{})}
+}
+
+// open tracks the connection and returns whether it may be handled, which isn't the case while draining. A nil
+// tracker accepts all connections without tracking them.
+func (t *ConnectionTracker) open(c net.Conn) bool {
+	if t == nil {
+		return true
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.draining {
+		return false
+	}
+	t.connections[c] = &trackedConnection{lastActivity: time.Now()}
+
+	return true
+}
+
+// close stops tracking the connection.
+func (t *ConnectionTracker) close(c net.Conn) {
+	if t == nil {
+		return
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	delete(t.connections, c)
+	if t.draining && len(t.connections) == 0 {
+		close(t.drained)
+	}
+}
+
+// startCommand marks the connection as handling a command and returns whether it may do so, which isn't the case
+// while draining.
+func (t *ConnectionTracker) startCommand(c net.Conn) bool {
+	return t.setBusy(c, true)
+}
+
+// finishCommand marks the connection as idle again and returns whether it may handle further commands, which isn't
+// the case while draining.
+func (t *ConnectionTracker) finishCommand(c net.Conn) bool {
+	return t.setBusy(c, false)
+}
+
+// setBusy sets whether the connection is handling a command and returns whether it may stay open.
+func (t *ConnectionTracker) setBusy(c net.Conn, busy bool) bool {
+	if t == nil {
+		return true
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.draining {
+		return false
+	}
+	if connection, ok := t.connections[c]; ok {
+		connection.busy = busy
+		connection.lastActivity = time.Now()
+	}
+
+	return true
+}
+
+// drain closes the idle connections and waits up to the grace period for the others to finish their command. The
+// connections still open afterwards are closed. New connections are rejected from now on.
+func (t *ConnectionTracker) drain(gracePeriod time.Duration) {
+	if t == nil {
+		return
+	}
+
+	t.mutex.Lock()
+	if t.draining {
+		t.mutex.Unlock()
+		return
+	}
+	t.draining = true
+	if len(t.connections) == 0 {
+		close(t.drained)
+	}
+	busyCount := 0
+	for c, connection := range t.connections {
+		if connection.busy {
+			busyCount++
+			continue
+		}
+		// fails the pending read of the idle connection
+		c.Close()
+	}
+	t.mutex.Unlock()
+
+	logInfof("Draining %d connections handling a command", busyCount)
+
+	select {
+	case <-t.drained:
+		return
+	case <-time.After(gracePeriod):
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	logWarnf("Closing %d connections still open after the grace period of %s", len(t.connections), gracePeriod)
+	for c := range t.connections {
+		c.Close()
+	}
+}
+
+// closeIdle closes the connections that didn't handle a command for longer than the given duration and returns their
+// number.
+func (t *ConnectionTracker) closeIdle(idleLimit time.Duration) int {
+	if t == nil {
+		return 0
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	closed := 0
+	for c, connection := range t.connections {
+		if !connection.busy && !connection.reaped && time.Since(connection.lastActivity) > idleLimit {
+			connection.reaped = true
+			logInfof("Closing connection of client %s, it was idle for longer than %s", c.RemoteAddr(), idleLimit)
+			// fails the pending read, the connection stops being tracked once its handler returns
+			c.Close()
+			closed++
+		}
+	}
+
+	return closed
+}
+
+// count returns the number of open connections and of those handling a command.
+func (t *ConnectionTracker) count() (int, int) {
+	if t == nil {
+		return 0, 0
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	busy := 0
+	for _, connection := range t.connections {
+		if connection.busy {
+			busy++
+		}
+	}
+
+	return len(t.connections), busy
+}
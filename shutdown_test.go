@@ -0,0 +1,90 @@
+// Copyright [2021] [Christian Bandowski]
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net"
+	"testing"
+	"time"
+)
+
+// isClosed returns whether the connection was closed locally.
+func isClosed(c net.Conn) bool {
+	return c.SetDeadline(time.Now()) != nil
+}
+
+func TestConnectionTracker_drain(t *testing.T) {
+	tracker := NewConnectionTracker()
+	idle, _ := net.Pipe()
+	busy, _ := net.Pipe()
+	assert.True(t, tracker.open(idle))
+	assert.True(t, tracker.open(busy))
+	assert.True(t, tracker.startCommand(busy))
+
+	go func() {
+		// the handler of the idle connection fails reading once it was closed and stops tracking it
+		for !isClosed(idle) {
+			time.Sleep(time.Millisecond)
+		}
+		tracker.close(idle)
+
+		time.Sleep(50 * time.Millisecond)
+		// the busy connection finishes its command and is closed
+		assert.False(t, tracker.finishCommand(busy))
+		tracker.close(busy)
+	}()
+
+	start := time.Now()
+	tracker.drain(time.Minute)
+
+	assert.Less(t, int64(time.Since(start)), int64(time.Minute))
+	assert.True(t, isClosed(idle))
+	assert.False(t, isClosed(busy))
+
+	// new connections and commands are rejected
+	other, _ := net.Pipe()
+	assert.False(t, tracker.open(other))
+	assert.False(t, tracker.startCommand(idle))
+}
+
+func TestConnectionTracker_drain_GracePeriod(t *testing.T) {
+	tracker := NewConnectionTracker()
+	busy, _ := net.Pipe()
+	assert.True(t, tracker.open(busy))
+	assert.True(t, tracker.startCommand(busy))
+
+	tracker.drain(50 * time.Millisecond)
+
+	assert.True(t, isClosed(busy))
+}
+
+func TestConnectionTracker_drain_NoConnections(t *testing.T) {
+	tracker := NewConnectionTracker()
+
+	tracker.drain(time.Minute)
+	tracker.drain(time.Minute)
+}
+
+func TestConnectionTracker_Nil(t *testing.T) {
+	var tracker *ConnectionTracker
+	c, _ := net.Pipe()
+
+	assert.True(t, tracker.open(c))
+	assert.True(t, tracker.startCommand(c))
+	assert.True(t, tracker.finishCommand(c))
+	tracker.close(c)
+	tracker.drain(time.Minute)
+}
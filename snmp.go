@@ -0,0 +1,460 @@
+// Copyright [2021] [Christian Bandowski]
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/pkg/errors"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// default port of SNMP agents
+const snmpDefaultPort = "161"
+
+// tags of the BER encoded values used by SNMP
+const (
+	snmpTagInteger        = 0x02
+	snmpTagOctetString    = 0x04
+	snmpTagNull           = 0x05
+	snmpTagObjectID       = 0x06
+	snmpTagSequence       = 0x30
+	snmpTagCounter32      = 0x41
+	snmpTagGauge32        = 0x42
+	snmpTagTimeTicks      = 0x43
+	snmpTagGetRequest     = 0xa0
+	snmpTagGetResponse    = 0xa2
+	snmpTagNoSuchObject   = 0x80
+	snmpTagNoSuchInstance = 0x81
+)
+
+// SNMP version 2c, encoded as version 1
+const snmpVersion2c = 1
+
+// snmpApcKey maps an object of the PowerNet-MIB of APC network management cards to the apcupsd key of its value.
+type snmpApcKey struct {
+	oid string
+	key string
+
+	// converts the value to the format of apcupsd
+	convert func(value snmpValue) string
+}
+
+// objects of the PowerNet-MIB loaded by the SNMP source
+var snmpApcKeys = []snmpApcKey{
+	{"1.3.6.1.4.1.318.1.1.1.1.1.1.0", "MODEL", snmpString},
+	{"1.3.6.1.4.1.318.1.1.1.1.1.2.0", "UPSNAME", snmpString},
+	{"1.3.6.1.4.1.318.1.1.1.1.2.1.0", "FIRMWARE", snmpString},
+	{"1.3.6.1.4.1.318.1.1.1.1.2.2.0", "MANDATE", snmpString},
+	{"1.3.6.1.4.1.318.1.1.1.1.2.3.0", "SERIALNO", snmpString},
+	{"1.3.6.1.4.1.318.1.1.1.2.1.1.0", "SNMPBATTSTATUS", snmpNumber},
+	{"1.3.6.1.4.1.318.1.1.1.2.1.3.0", "BATTDATE", snmpString},
+	{"1.3.6.1.4.1.318.1.1.1.2.2.1.0", "BCHARGE", snmpNumber},
+	{"1.3.6.1.4.1.318.1.1.1.2.2.2.0", "ITEMP", snmpNumber},
+	{"1.3.6.1.4.1.318.1.1.1.2.2.3.0", "TIMELEFT", snmpMinutes},
+	{"1.3.6.1.4.1.318.1.1.1.2.2.4.0", "SNMPREPLACEBATT", snmpNumber},
+	{"1.3.6.1.4.1.318.1.1.1.2.2.8.0", "BATTV", snmpNumber},
+	{"1.3.6.1.4.1.318.1.1.1.3.2.1.0", "LINEV", snmpNumber},
+	{"1.3.6.1.4.1.318.1.1.1.3.2.4.0", "LINEFREQ", snmpNumber},
+	{"1.3.6.1.4.1.318.1.1.1.4.1.1.0", "SNMPOUTPUTSTATUS", snmpNumber},
+	{"1.3.6.1.4.1.318.1.1.1.4.2.1.0", "OUTPUTV", snmpNumber},
+	{"1.3.6.1.4.1.318.1.1.1.4.2.3.0", "LOADPCT", snmpNumber},
+	{"1.3.6.1.4.1.318.1.1.1.7.2.3.0", "SELFTEST", snmpSelfTest},
+}
+
+// apcupsd status of the values of upsBasicOutputStatus
+var snmpOutputStatuses = map[string]string{
+	"2":  "ONLINE",
+	"3":  "ONBATT",
+	"4":  "ONLINE BOOST",
+	"5":  "COMMLOST",
+	"6":  "ONLINE",
+	"7":  "COMMLOST",
+	"8":  "SHUTTING DOWN",
+	"9":  "ONLINE",
+	"10": "ONLINE",
+	"11": "COMMLOST",
+	"12": "ONLINE TRIM",
+}
+
+// SnmpDataSource loads the values from the network management card of an APC UPS by using SNMP v2c, so apcupsd isn't
+// needed at all. The values are converted to the ones apcupsd would report.
+type SnmpDataSource struct {
+	address   string
+	community string
+}
+
+// NewSnmpDataSource creates a new instance of SnmpDataSource
+func NewSnmpDataSource(address string, community string) *SnmpDataSource {
+	return &SnmpDataSource{address: address, community: community}
+}
+
+// load requests all known objects of the PowerNet-MIB, objects the card doesn't support are skipped.
+func (s *SnmpDataSource) load(ctx context.Context) (map[string]string, error) {
+	oids := make([]string, len(snmpApcKeys))
+	for i, apcKey := range snmpApcKeys {
+		oids[i] = apcKey.oid
+	}
+
+	snmpValues, err := snmpGet(ctx, s.address, s.community, oids)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error requesting the status from %s", s.address)
+	}
+
+	values := make(map[string]string)
+	for _, apcKey := range snmpApcKeys {
+		if value, ok := snmpValues[apcKey.oid]; ok {
+			values[apcKey.key] = apcKey.convert(value)
+		}
+	}
+
+	// the status combines several objects
+	if status, ok := snmpOutputStatuses[values["SNMPOUTPUTSTATUS"]]; ok {
+		if values["SNMPBATTSTATUS"] == "3" {
+			status += " LOWBATT"
+		}
+		if values["SNMPREPLACEBATT"] == "2" {
+			status += " REPLACEBATT"
+		}
+		values["STATUS"] = status
+	}
+	delete(values, "SNMPOUTPUTSTATUS")
+	delete(values, "SNMPBATTSTATUS")
+	delete(values, "SNMPREPLACEBATT")
+
+	return values, nil
+}
+
+// String returns the address of the network management card.
+func (s *SnmpDataSource) String() string {
+	return fmt.Sprintf("SNMP agent %s", s.address)
+}
+
+// snmpValue is a BER encoded value of an SNMP variable binding.
+type snmpValue struct {
+	tag  byte
+	data []byte
+}
+
+// integer returns the value of integer types, like INTEGER, Gauge32 and TimeTicks.
+func (v snmpValue) integer() (int64, bool) {
+	switch v.tag {
+	case snmpTagInteger, snmpTagCounter32, snmpTagGauge32, snmpTagTimeTicks:
+	default:
+		return 0, false
+	}
+	if len(v.data) == 0 || len(v.data) > 8 {
+		return 0, false
+	}
+
+	var value int64
+	if v.tag == snmpTagInteger && v.data[0]&0x80 != 0 {
+		// negative numbers are encoded as two's complement
+		value = -1
+	}
+	for _, b := range v.data {
+		value = value<<8 | int64(b)
+	}
+
+	return value, true
+}
+
+// snmpString converts an OCTET STRING, other types are formatted as number.
+func snmpString(value snmpValue) string {
+	if value.tag == snmpTagOctetString {
+		return strings.TrimSpace(string(value.data))
+	}
+
+	return snmpNumber(value)
+}
+
+// snmpNumber converts a number.
+func snmpNumber(value snmpValue) string {
+	number, ok := value.integer()
+	if !ok {
+		return strings.TrimSpace(string(value.data))
+	}
+
+	return strconv.FormatInt(number, 10)
+}
+
+// snmpMinutes converts a TimeTicks value, which counts hundredths of seconds, to minutes.
+func snmpMinutes(value snmpValue) string {
+	ticks, ok := value.integer()
+	if !ok {
+		return ""
+	}
+
+	return strconv.FormatFloat(float64(ticks)/100/60, 'f', 1, 64)
+}
+
+// snmpSelfTest converts upsAdvTestDiagnosticsResults to the self test result of apcupsd.
+func snmpSelfTest(value snmpValue) string {
+	switch snmpNumber(value) {
+	case "1":
+		return "OK"
+	case "2":
+		return "NG"
+	default:
+		return "NO"
+	}
+}
+
+// snmpGet requests the values of the given objects from the SNMP agent at the given address by using SNMP v2c, the port
+// defaults to 161. Objects the agent doesn't know are missing in the result.
+func snmpGet(ctx context.Context, address string, community string, oids []string) (map[string]snmpValue, error) {
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		address = net.JoinHostPort(address, snmpDefaultPort)
+	}
+
+	requestID := rand.Int31()
+	request, err := encodeSnmpGetRequest(requestID, community, oids)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "udp", address)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Couldn't connect to %s", address)
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+
+	if _, err := conn.Write(request); err != nil {
+		return nil, errors.Wrapf(err, "Couldn't send request to %s", address)
+	}
+
+	buffer := make([]byte, 65535)
+	for {
+		n, err := conn.Read(buffer)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Couldn't read response from %s", address)
+		}
+
+		responseID, values, err := decodeSnmpGetResponse(buffer[:n])
+		if err != nil {
+			return nil, errors.Wrapf(err, "Invalid response from %s", address)
+		}
+		if responseID != requestID {
+			// a late response to an earlier request
+			continue
+		}
+
+		return values, nil
+	}
+}
+
+// encodeSnmpGetRequest encodes a GetRequest message for the given objects.
+func encodeSnmpGetRequest(requestID int32, community string, oids []string) ([]byte, error) {
+	var bindings []byte
+	for _, oid := range oids {
+		encodedOid, err := encodeSnmpOid(oid)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		bindings = append(bindings, encodeSnmpTLV(snmpTagSequence,
+			append(encodeSnmpTLV(snmpTagObjectID, encodedOid), encodeSnmpTLV(snmpTagNull, nil)...))...)
+	}
+
+	var pdu []byte
+	pdu = append(pdu, encodeSnmpTLV(snmpTagInteger, encodeSnmpInteger(int64(requestID)))...)
+	pdu = append(pdu, encodeSnmpTLV(snmpTagInteger, encodeSnmpInteger(0))...)
+	pdu = append(pdu, encodeSnmpTLV(snmpTagInteger, encodeSnmpInteger(0))...)
+	pdu = append(pdu, encodeSnmpTLV(snmpTagSequence, bindings)...)
+
+	var message []byte
+	message = append(message, encodeSnmpTLV(snmpTagInteger, encodeSnmpInteger(snmpVersion2c))...)
+	message = append(message, encodeSnmpTLV(snmpTagOctetString, []byte(community))...)
+	message = append(message, encodeSnmpTLV(snmpTagGetRequest, pdu)...)
+
+	return encodeSnmpTLV(snmpTagSequence, message), nil
+}
+
+// decodeSnmpGetResponse decodes a GetResponse message and returns its request ID and values by their object ID.
+func decodeSnmpGetResponse(message []byte) (int32, map[string]snmpValue, error) {
+	tag, content, _, err := decodeSnmpTLV(message)
+	if err != nil || tag != snmpTagSequence {
+		return 0, nil, errors.New("The message isn't a sequence")
+	}
+
+	// version and community
+	for i := 0; i < 2; i++ {
+		if _, _, content, err = decodeSnmpTLV(content); err != nil {
+			return 0, nil, errors.WithStack(err)
+		}
+	}
+
+	tag, pdu, _, err := decodeSnmpTLV(content)
+	if err != nil || tag != snmpTagGetResponse {
+		return 0, nil, errors.New("The message isn't a GetResponse")
+	}
+
+	var fields [3]int64
+	for i := range fields {
+		var data []byte
+		if tag, data, pdu, err = decodeSnmpTLV(pdu); err != nil || tag != snmpTagInteger {
+			return 0, nil, errors.New("Invalid GetResponse header")
+		}
+		fields[i], _ = snmpValue{tag: tag, data: data}.integer()
+	}
+	requestID, errorStatus := int32(fields[0]), fields[1]
+	if errorStatus != 0 {
+		return requestID, nil, errors.Errorf("The agent responded with error status %d", errorStatus)
+	}
+
+	tag, bindings, _, err := decodeSnmpTLV(pdu)
+	if err != nil || tag != snmpTagSequence {
+		return 0, nil, errors.New("Invalid variable bindings")
+	}
+
+	values := make(map[string]snmpValue)
+	for len(bindings) > 0 {
+		var binding, oidData, valueData []byte
+		var valueTag byte
+		if tag, binding, bindings, err = decodeSnmpTLV(bindings); err != nil || tag != snmpTagSequence {
+			return 0, nil, errors.New("Invalid variable binding")
+		}
+		if tag, oidData, binding, err = decodeSnmpTLV(binding); err != nil || tag != snmpTagObjectID {
+			return 0, nil, errors.New("Invalid object ID")
+		}
+		if valueTag, valueData, _, err = decodeSnmpTLV(binding); err != nil {
+			return 0, nil, errors.WithStack(err)
+		}
+
+		switch valueTag {
+		case snmpTagNull, snmpTagNoSuchObject, snmpTagNoSuchInstance:
+			// the agent doesn't know the object
+			continue
+		}
+		values[decodeSnmpOid(oidData)] = snmpValue{tag: valueTag, data: valueData}
+	}
+
+	return requestID, values, nil
+}
+
+// encodeSnmpTLV encodes the tag, the length and the content.
+func encodeSnmpTLV(tag byte, content []byte) []byte {
+	encoded := []byte{tag}
+	length := len(content)
+	if length < 0x80 {
+		encoded = append(encoded, byte(length))
+	} else {
+		var lengthBytes []byte
+		for ; length > 0; length >>= 8 {
+			lengthBytes = append([]byte{byte(length)}, lengthBytes...)
+		}
+		encoded = append(encoded, 0x80|byte(len(lengthBytes)))
+		encoded = append(encoded, lengthBytes...)
+	}
+
+	return append(encoded, content...)
+}
+
+// decodeSnmpTLV decodes the first value and returns its tag, its content and the remaining data.
+func decodeSnmpTLV(data []byte) (byte, []byte, []byte, error) {
+	if len(data) < 2 {
+		return 0, nil, nil, errors.New("Truncated value")
+	}
+
+	tag := data[0]
+	length := int(data[1])
+	offset := 2
+	if length&0x80 != 0 {
+		lengthBytes := length & 0x7f
+		if lengthBytes == 0 || lengthBytes > 3 || len(data) < 2+lengthBytes {
+			return 0, nil, nil, errors.New("Invalid length")
+		}
+
+		length = 0
+		for _, b := range data[2 : 2+lengthBytes] {
+			length = length<<8 | int(b)
+		}
+		offset += lengthBytes
+	}
+	if len(data) < offset+length {
+		return 0, nil, nil, errors.New("Truncated value")
+	}
+
+	return tag, data[offset : offset+length], data[offset+length:], nil
+}
+
+// encodeSnmpInteger encodes an integer with as few bytes as possible.
+func encodeSnmpInteger(value int64) []byte {
+	encoded := []byte{byte(value)}
+	for value > 0x7f || value < -0x80 {
+		value >>= 8
+		encoded = append([]byte{byte(value)}, encoded...)
+	}
+
+	return encoded
+}
+
+// encodeSnmpOid encodes an object ID like "1.3.6.1.2.1.1.1.0".
+func encodeSnmpOid(oid string) ([]byte, error) {
+	parts := strings.Split(oid, ".")
+	if len(parts) < 2 {
+		return nil, errors.Errorf("Invalid object ID %s", oid)
+	}
+
+	numbers := make([]uint64, len(parts))
+	for i, part := range parts {
+		number, err := strconv.ParseUint(part, 10, 32)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Invalid object ID %s", oid)
+		}
+		numbers[i] = number
+	}
+
+	// the first two numbers share a byte
+	encoded := []byte{byte(numbers[0]*40 + numbers[1])}
+	for _, number := range numbers[2:] {
+		var base128 []byte
+		base128 = append(base128, byte(number&0x7f))
+		for number >>= 7; number > 0; number >>= 7 {
+			base128 = append([]byte{byte(number&0x7f) | 0x80}, base128...)
+		}
+		encoded = append(encoded, base128...)
+	}
+
+	return encoded, nil
+}
+
+// decodeSnmpOid decodes an object ID to its dotted representation.
+func decodeSnmpOid(data []byte) string {
+	if len(data) == 0 {
+		return ""
+	}
+
+	parts := []string{strconv.Itoa(int(data[0]) / 40), strconv.Itoa(int(data[0]) % 40)}
+	var number uint64
+	for _, b := range data[1:] {
+		number = number<<7 | uint64(b&0x7f)
+		if b&0x80 == 0 {
+			parts = append(parts, strconv.FormatUint(number, 10))
+			number = 0
+		}
+	}
+
+	return strings.Join(parts, ".")
+}
@@ -0,0 +1,181 @@
+// Copyright [2021] [Christian Bandowski]
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"testing"
+	"time"
+)
+
+// startTestSnmpAgent starts an SNMP agent answering GetRequests with the given values, other objects are unknown.
+func startTestSnmpAgent(t *testing.T, values map[string]snmpValue) string {
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	go func() {
+		buffer := make([]byte, 65535)
+		for {
+			n, addr, err := conn.ReadFrom(buffer)
+			if err != nil {
+				return
+			}
+
+			requestID, oids := decodeTestSnmpGetRequest(t, buffer[:n])
+			var bindings []byte
+			for _, oid := range oids {
+				encodedOid, _ := encodeSnmpOid(oid)
+				value, ok := values[oid]
+				if !ok {
+					value = snmpValue{tag: snmpTagNoSuchObject}
+				}
+				bindings = append(bindings, encodeSnmpTLV(snmpTagSequence,
+					append(encodeSnmpTLV(snmpTagObjectID, encodedOid), encodeSnmpTLV(value.tag, value.data)...))...)
+			}
+
+			var pdu []byte
+			pdu = append(pdu, encodeSnmpTLV(snmpTagInteger, encodeSnmpInteger(requestID))...)
+			pdu = append(pdu, encodeSnmpTLV(snmpTagInteger, encodeSnmpInteger(0))...)
+			pdu = append(pdu, encodeSnmpTLV(snmpTagInteger, encodeSnmpInteger(0))...)
+			pdu = append(pdu, encodeSnmpTLV(snmpTagSequence, bindings)...)
+
+			var message []byte
+			message = append(message, encodeSnmpTLV(snmpTagInteger, encodeSnmpInteger(snmpVersion2c))...)
+			message = append(message, encodeSnmpTLV(snmpTagOctetString, []byte("public"))...)
+			message = append(message, encodeSnmpTLV(snmpTagGetResponse, pdu)...)
+
+			_, _ = conn.WriteTo(encodeSnmpTLV(snmpTagSequence, message), addr)
+		}
+	}()
+
+	return conn.LocalAddr().String()
+}
+
+// decodeTestSnmpGetRequest returns the request ID and the requested objects of a GetRequest.
+func decodeTestSnmpGetRequest(t *testing.T, message []byte) (int64, []string) {
+	_, content, _, err := decodeSnmpTLV(message)
+	assert.NoError(t, err)
+	_, _, content, _ = decodeSnmpTLV(content)
+	_, community, content, _ := decodeSnmpTLV(content)
+	assert.Equal(t, "public", string(community))
+	tag, pdu, _, _ := decodeSnmpTLV(content)
+	assert.Equal(t, byte(snmpTagGetRequest), tag)
+
+	tag, data, pdu, _ := decodeSnmpTLV(pdu)
+	requestID, _ := snmpValue{tag: tag, data: data}.integer()
+	_, _, pdu, _ = decodeSnmpTLV(pdu)
+	_, _, pdu, _ = decodeSnmpTLV(pdu)
+	_, bindings, _, _ := decodeSnmpTLV(pdu)
+
+	var oids []string
+	for len(bindings) > 0 {
+		var binding, oid []byte
+		_, binding, bindings, _ = decodeSnmpTLV(bindings)
+		_, oid, _, _ = decodeSnmpTLV(binding)
+		oids = append(oids, decodeSnmpOid(oid))
+	}
+
+	return requestID, oids
+}
+
+func TestSnmpDataSource_load(t *testing.T) {
+	address := startTestSnmpAgent(t, map[string]snmpValue{
+		"1.3.6.1.4.1.318.1.1.1.1.1.1.0": {snmpTagOctetString, []byte("Smart-UPS 1500")},
+		"1.3.6.1.4.1.318.1.1.1.2.1.1.0": {snmpTagInteger, encodeSnmpInteger(3)},
+		"1.3.6.1.4.1.318.1.1.1.2.2.1.0": {snmpTagGauge32, encodeSnmpInteger(100)},
+		"1.3.6.1.4.1.318.1.1.1.2.2.2.0": {snmpTagGauge32, encodeSnmpInteger(29)},
+		"1.3.6.1.4.1.318.1.1.1.2.2.3.0": {snmpTagTimeTicks, encodeSnmpInteger(255000)},
+		"1.3.6.1.4.1.318.1.1.1.4.1.1.0": {snmpTagInteger, encodeSnmpInteger(3)},
+		"1.3.6.1.4.1.318.1.1.1.7.2.3.0": {snmpTagInteger, encodeSnmpInteger(1)},
+	})
+	source := NewSnmpDataSource(address, "public")
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	values, err := source.load(ctx)
+
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"MODEL":    "Smart-UPS 1500",
+		"BCHARGE":  "100",
+		"ITEMP":    "29",
+		"TIMELEFT": "42.5",
+		"STATUS":   "ONBATT LOWBATT",
+		"SELFTEST": "OK",
+	}, values)
+	assert.Equal(t, "SNMP agent "+address, source.String())
+}
+
+func TestSnmpDataSource_load_Timeout(t *testing.T) {
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = NewSnmpDataSource(conn.LocalAddr().String(), "public").load(ctx)
+
+	assert.Error(t, err)
+}
+
+func TestEncodeSnmpOid(t *testing.T) {
+	oidToEncoded := map[string][]byte{
+		"1.3.6.1.4.1.318.1.1.1.1.1.1.0": {0x2b, 6, 1, 4, 1, 0x82, 0x3e, 1, 1, 1, 1, 1, 1, 0},
+		"1.3.6.1.2.1.1.1.0":             {0x2b, 6, 1, 2, 1, 1, 1, 0},
+	}
+
+	for oid, expEncoded := range oidToEncoded {
+		t.Run(oid, func(t *testing.T) {
+			encoded, err := encodeSnmpOid(oid)
+
+			assert.NoError(t, err)
+			assert.Equal(t, expEncoded, encoded)
+			assert.Equal(t, oid, decodeSnmpOid(encoded))
+		})
+	}
+
+	_, err := encodeSnmpOid("1.3.x")
+	assert.Error(t, err)
+}
+
+func TestSnmpValue_integer(t *testing.T) {
+	for _, number := range []int64{0, 1, 127, 128, 255, 256, -1, -128, -129, 255000} {
+		value, ok := snmpValue{tag: snmpTagInteger, data: encodeSnmpInteger(number)}.integer()
+
+		assert.True(t, ok)
+		assert.Equal(t, number, value)
+	}
+
+	_, ok := snmpValue{tag: snmpTagOctetString, data: []byte("1")}.integer()
+	assert.False(t, ok)
+}
+
+func TestSnmpTLV_LongLength(t *testing.T) {
+	content := make([]byte, 300)
+
+	tag, decoded, rest, err := decodeSnmpTLV(append(encodeSnmpTLV(snmpTagOctetString, content), 1))
+
+	assert.NoError(t, err)
+	assert.Equal(t, byte(snmpTagOctetString), tag)
+	assert.Equal(t, content, decoded)
+	assert.Equal(t, []byte{1}, rest)
+}
@@ -0,0 +1,66 @@
+// Copyright [2021] [Christian Bandowski]
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"net"
+)
+
+// listenSocket starts listening on the address of the listener, applying the socket options of the config.
+func listenSocket(listener *Listener, config *Config) (net.Listener, error) {
+	// keepalive probes detect clients that vanished, e.g. behind a flaky Wi-Fi link
+	listenConfig := net.ListenConfig{KeepAlive: config.tcpKeepAlive}
+
+	l, err := listenConfig.Listen(context.Background(), listener.network, listener.address)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if config.listenBacklog > 0 {
+		if err := setListenBacklog(l, config.listenBacklog); err != nil {
+			l.Close()
+			return nil, errors.Wrapf(err, "Couldn't set the listen backlog to %d", config.listenBacklog)
+		}
+	}
+	if !config.tcpNoDelay {
+		l = &noDelayListener{Listener: l}
+	}
+
+	return l, nil
+}
+
+// noDelayListener disables TCP_NODELAY on the accepted connections, so small responses may be combined into fewer
+// packets at the cost of latency.
+type noDelayListener struct {
+	net.Listener
+}
+
+// Accept accepts the next connection and disables TCP_NODELAY if it is a TCP connection.
+func (l *noDelayListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	if tcpConn, ok := c.(*net.TCPConn); ok {
+		if err := tcpConn.SetNoDelay(false); err != nil {
+			logWarnf("Disabling TCP_NODELAY for client %s failed: %s", c.RemoteAddr(), err)
+		}
+	}
+
+	return c, nil
+}
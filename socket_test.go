@@ -0,0 +1,73 @@
+// Copyright [2021] [Christian Bandowski]
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestListenSocket(t *testing.T) {
+	testCases := []struct {
+		name   string
+		config *Config
+	}{
+		{"defaults", &Config{tcpNoDelay: true}},
+		{"tuned", &Config{tcpKeepAlive: 5 * time.Second, listenBacklog: 16}},
+		{"keepalive disabled", &Config{tcpKeepAlive: -1, tcpNoDelay: true}},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			if testCase.config.listenBacklog > 0 && runtime.GOOS == "windows" {
+				t.Skip("the listen backlog can't be changed on Windows")
+			}
+
+			l, err := listenSocket(&Listener{network: "tcp4", address: "127.0.0.1:0"}, testCase.config)
+			if !assert.NoError(t, err) {
+				return
+			}
+			defer l.Close()
+
+			accepted := make(chan net.Conn, 1)
+			go func() {
+				c, err := l.Accept()
+				assert.NoError(t, err)
+				accepted <- c
+			}()
+
+			c, err := net.Dial("tcp4", l.Addr().String())
+			if !assert.NoError(t, err) {
+				return
+			}
+			defer c.Close()
+
+			serverConn := <-accepted
+			if assert.NotNil(t, serverConn) {
+				assert.IsType(t, &net.TCPConn{}, serverConn)
+				serverConn.Close()
+			}
+		})
+	}
+}
+
+func TestListenSocket_InvalidAddress(t *testing.T) {
+	_, err := listenSocket(&Listener{network: "tcp4", address: "256.0.0.1:0"}, &Config{})
+
+	assert.Error(t, err)
+}
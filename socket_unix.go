@@ -0,0 +1,46 @@
+// Copyright [2021] [Christian Bandowski]
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"github.com/pkg/errors"
+	"net"
+	"syscall"
+)
+
+// setListenBacklog changes the maximum number of pending connections of the listening socket, listening again on an
+// already listening socket only updates its backlog.
+func setListenBacklog(l net.Listener, backlog int) error {
+	sysConn, ok := l.(syscall.Conn)
+	if !ok {
+		return errors.Errorf("Listener %s doesn't support setting the backlog", l.Addr())
+	}
+	rawConn, err := sysConn.SyscallConn()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	var listenErr error
+	if err := rawConn.Control(func(fd uintptr) {
+		listenErr = syscall.Listen(int(fd), backlog)
+	}); err != nil {
+		return errors.WithStack(err)
+	}
+
+	return errors.WithStack(listenErr)
+}
@@ -0,0 +1,28 @@
+// Copyright [2021] [Christian Bandowski]
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build windows
+// +build windows
+
+package main
+
+import (
+	"github.com/pkg/errors"
+	"net"
+)
+
+// setListenBacklog fails, as the backlog of a listening socket can't be changed on Windows.
+func setListenBacklog(l net.Listener, backlog int) error {
+	return errors.New("Setting the listen backlog isn't supported on Windows")
+}
@@ -0,0 +1,222 @@
+// Copyright [2021] [Christian Bandowski]
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// default port of SSH servers
+const sshDefaultPort = "22"
+
+// SshDataSource loads the values by invoking apcaccess on a remote host by using SSH, for setups where the network
+// information server of apcupsd isn't reachable. The client authenticates by using a private key and verifies the host
+// key by using a known hosts file. The connection is reused by all loads until it fails.
+type SshDataSource struct {
+	address        string
+	user           string
+	keyFile        string
+	knownHostsFile string
+
+	// command executed on the remote host
+	command string
+
+	// whether the units are stripped by the proxy, as apcaccess doesn't support -u
+	stripUnits bool
+
+	mutex  sync.Mutex
+	client *ssh.Client
+}
+
+// NewSshDataSource creates a new instance of SshDataSource, which invokes apcaccess with the given arguments on the
+// remote host.
+func NewSshDataSource(address string, user string, keyFile string, knownHostsFile string, executable string,
+	args []string, stripUnits bool) *SshDataSource {
+
+	words := append([]string{executable}, args...)
+	if !stripUnits {
+		words = append(words, "-u")
+	}
+	for i, word := range words {
+		words[i] = shellQuote(word)
+	}
+
+	return &SshDataSource{
+		address:        address,
+		user:           user,
+		keyFile:        keyFile,
+		knownHostsFile: knownHostsFile,
+		command:        strings.Join(words, " "),
+		stripUnits:     stripUnits,
+	}
+}
+
+// load runs apcaccess on the remote host. If the connection failed, e.g. because it was closed by the server, it is
+// established again once.
+func (s *SshDataSource) load(ctx context.Context) (map[string]string, error) {
+	out, err := s.run(ctx)
+	if _, exited := errors.Cause(err).(*ssh.ExitError); err != nil && !exited && ctx.Err() == nil {
+		logDebugf("Running apcaccess at %s failed, connecting again: %v", s.address, err)
+		out, err = s.run(ctx)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error invoking apcaccess at %s", s.address)
+	}
+
+	return parseApcOutput(out, s.stripUnits)
+}
+
+// run runs the command in a new session of the connection, which is closed if it fails.
+func (s *SshDataSource) run(ctx context.Context) ([]byte, error) {
+	client, err := s.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	session, err := client.NewSession()
+	if err != nil {
+		s.disconnect(client)
+		return nil, errors.Wrapf(err, "Couldn't open session")
+	}
+	defer session.Close()
+
+	var out bytes.Buffer
+	session.Stdout = &out
+
+	done := make(chan error, 1)
+	go func() {
+		done <- session.Run(s.command)
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			if _, ok := err.(*ssh.ExitError); !ok {
+				s.disconnect(client)
+			}
+			return nil, errors.WithStack(err)
+		}
+		return out.Bytes(), nil
+	case <-ctx.Done():
+		// the connection may hang, so it isn't reused
+		s.disconnect(client)
+		return nil, errors.Wrapf(ctx.Err(), "Invoking apcaccess was cancelled")
+	}
+}
+
+// connect returns the established connection or establishes a new one.
+func (s *SshDataSource) connect(ctx context.Context) (*ssh.Client, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.client != nil {
+		return s.client, nil
+	}
+
+	clientConfig, err := s.clientConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	address := s.address
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		address = net.JoinHostPort(address, sshDefaultPort)
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Couldn't connect to %s", address)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		// limit the handshake, the deadline is reset for the established connection
+		if err := conn.SetDeadline(deadline); err != nil {
+			conn.Close()
+			return nil, errors.WithStack(err)
+		}
+	}
+
+	sshConn, channels, requests, err := ssh.NewClientConn(conn, address, clientConfig)
+	if err != nil {
+		conn.Close()
+		return nil, errors.Wrapf(err, "SSH handshake with %s failed", address)
+	}
+	if err := conn.SetDeadline(time.Time{}); err != nil {
+		sshConn.Close()
+		return nil, errors.WithStack(err)
+	}
+
+	logDebugf("Connected to %s by using SSH", address)
+	s.client = ssh.NewClient(sshConn, channels, requests)
+	return s.client, nil
+}
+
+// disconnect closes the connection, unless another one was established meanwhile.
+func (s *SshDataSource) disconnect(client *ssh.Client) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.client == client {
+		s.client = nil
+	}
+	client.Close()
+}
+
+// clientConfig reads the private key and the known hosts, so changes are used by the next connection.
+func (s *SshDataSource) clientConfig() (*ssh.ClientConfig, error) {
+	key, err := os.ReadFile(s.keyFile)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Couldn't read SSH key %s", s.keyFile)
+	}
+	signer, err := ssh.ParsePrivateKey(key)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Couldn't parse SSH key %s", s.keyFile)
+	}
+
+	hostKeyCallback, err := knownhosts.New(s.knownHostsFile)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Couldn't read known hosts %s", s.knownHostsFile)
+	}
+
+	return &ssh.ClientConfig{
+		User:            s.user,
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		HostKeyCallback: hostKeyCallback,
+	}, nil
+}
+
+// String returns the user and address of the remote host.
+func (s *SshDataSource) String() string {
+	return fmt.Sprintf("apcaccess at %s@%s", s.user, s.address)
+}
+
+// shellQuote quotes the word for a POSIX shell, unless it consists of safe characters only.
+func shellQuote(word string) string {
+	if word != "" && strings.Trim(word, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_./:=") == "" {
+		return word
+	}
+
+	return "'" + strings.ReplaceAll(word, "'", `'\''`) + "'"
+}
@@ -0,0 +1,212 @@
+// Copyright [2021] [Christian Bandowski]
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// testSshServer is an SSH server answering every command with the same output.
+type testSshServer struct {
+	address string
+
+	mutex       sync.Mutex
+	commands    []string
+	connections int
+}
+
+// startTestSshServer starts an SSH server accepting the client key and writes the files needed by the client.
+func startTestSshServer(t *testing.T, output string) (server *testSshServer, keyFile string, knownHostsFile string) {
+	_, hostKey, err := ed25519.GenerateKey(rand.Reader)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	hostSigner, err := ssh.NewSignerFromKey(hostKey)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	clientPublicKey, clientKey, err := ed25519.GenerateKey(rand.Reader)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	authorizedKey, err := ssh.NewPublicKey(clientPublicKey)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	serverConfig := &ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if conn.User() == "apcupsd" && bytes.Equal(key.Marshal(), authorizedKey.Marshal()) {
+				return nil, nil
+			}
+			return nil, assert.AnError
+		},
+	}
+	serverConfig.AddHostKey(hostSigner)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	server = &testSshServer{address: listener.Addr().String()}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.serve(conn, serverConfig, output)
+		}
+	}()
+
+	dir := t.TempDir()
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(clientKey)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	keyFile = filepath.Join(dir, "id_ed25519")
+	knownHostsFile = filepath.Join(dir, "known_hosts")
+	knownHosts := knownhosts.Line([]string{server.address}, hostSigner.PublicKey()) + "\n"
+	if !assert.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8}),
+		0600)) || !assert.NoError(t, os.WriteFile(knownHostsFile, []byte(knownHosts), 0600)) {
+		t.FailNow()
+	}
+
+	return server, keyFile, knownHostsFile
+}
+
+// serve handles the sessions of a connection, every exec request is answered with the output.
+func (s *testSshServer) serve(conn net.Conn, serverConfig *ssh.ServerConfig, output string) {
+	_, channels, requests, err := ssh.NewServerConn(conn, serverConfig)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(requests)
+
+	s.mutex.Lock()
+	s.connections++
+	s.mutex.Unlock()
+
+	for newChannel := range channels {
+		channel, channelRequests, err := newChannel.Accept()
+		if err != nil {
+			continue
+		}
+
+		go func() {
+			defer channel.Close()
+			for request := range channelRequests {
+				if request.Type != "exec" {
+					request.Reply(false, nil)
+					continue
+				}
+
+				var payload struct{ Command string }
+				if err := ssh.Unmarshal(request.Payload, &payload); err != nil {
+					request.Reply(false, nil)
+					continue
+				}
+				s.mutex.Lock()
+				s.commands = append(s.commands, payload.Command)
+				s.mutex.Unlock()
+
+				request.Reply(true, nil)
+				channel.Write([]byte(output))
+				channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{0}))
+				return
+			}
+		}()
+	}
+}
+
+func TestSshDataSource_load(t *testing.T) {
+	server, keyFile, knownHostsFile := startTestSshServer(t, "STATUS : ONLINE\nBCHARGE : 100.0\n")
+	source := NewSshDataSource(server.address, "apcupsd", keyFile, knownHostsFile, "apcaccess",
+		[]string{"-f", "/etc/apcupsd/my ups.conf"}, false)
+
+	for i := 0; i < 2; i++ {
+		values, err := source.load(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]string{"STATUS": "ONLINE", "BCHARGE": "100.0"}, values)
+	}
+
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	assert.Equal(t, []string{"apcaccess -f '/etc/apcupsd/my ups.conf' -u", "apcaccess -f '/etc/apcupsd/my ups.conf' -u"},
+		server.commands)
+	// the connection is reused
+	assert.Equal(t, 1, server.connections)
+}
+
+func TestSshDataSource_load_Reconnect(t *testing.T) {
+	server, keyFile, knownHostsFile := startTestSshServer(t, "STATUS : ONLINE\n")
+	source := NewSshDataSource(server.address, "apcupsd", keyFile, knownHostsFile, "apcaccess", nil, false)
+
+	_, err := source.load(context.Background())
+	assert.NoError(t, err)
+	// the server closed the connection meanwhile
+	source.client.Close()
+	_, err = source.load(context.Background())
+	assert.NoError(t, err)
+
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	assert.Equal(t, 2, server.connections)
+}
+
+func TestSshDataSource_load_UnknownHost(t *testing.T) {
+	server, keyFile, _ := startTestSshServer(t, "STATUS : ONLINE\n")
+	_, _, otherKnownHostsFile := startTestSshServer(t, "STATUS : ONLINE\n")
+	source := NewSshDataSource(server.address, "apcupsd", keyFile, otherKnownHostsFile, "apcaccess", nil, false)
+
+	_, err := source.load(context.Background())
+
+	assert.Error(t, err)
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	assert.Equal(t, 0, server.connections)
+}
+
+func TestShellQuote(t *testing.T) {
+	wordToResult := map[string]string{
+		"apcaccess":             "apcaccess",
+		"/etc/apcupsd/ups.conf": "/etc/apcupsd/ups.conf",
+		"my ups":                "'my ups'",
+		"it's":                  `'it'\''s'`,
+		"":                      "''",
+		"$(reboot)":             "'$(reboot)'",
+	}
+
+	for word, expResult := range wordToResult {
+		t.Run(word, func(t *testing.T) {
+			assert.Equal(t, expResult, shellQuote(word))
+		})
+	}
+}
@@ -0,0 +1,76 @@
+// Copyright [2021] [Christian Bandowski]
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ThrottledDataSource limits how often another source is loaded. Loads within the minimum interval after the last
+// one return its result, so e.g. apcaccess isn't invoked for each of several clients polling at the same time.
+type ThrottledDataSource struct {
+	source      DataSource
+	minInterval time.Duration
+
+	// held while loading, so concurrent loads wait for the result of the first one
+	mutex    sync.Mutex
+	values   map[string]string
+	err      error
+	loadTime time.Time
+
+	// returns the current time, replaced by tests
+	now func() time.Time
+}
+
+// NewThrottledDataSource creates a new instance of ThrottledDataSource
+func NewThrottledDataSource(source DataSource, minInterval time.Duration) *ThrottledDataSource {
+	return &ThrottledDataSource{source: source, minInterval: minInterval, now: time.Now}
+}
+
+// load loads the values from the source, unless it was loaded within the minimum interval.
+func (s *ThrottledDataSource) load(ctx context.Context) (map[string]string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if !s.loadTime.IsZero() && s.now().Sub(s.loadTime) < s.minInterval {
+		return s.values, s.err
+	}
+
+	values, err := s.source.load(ctx)
+	if ctx.Err() != nil {
+		// the result of a cancelled load must not be reused by other clients
+		return values, err
+	}
+	s.values, s.err, s.loadTime = values, err, s.now()
+
+	return values, err
+}
+
+// invalidate drops the result of the last load, so the next load loads the values again.
+func (s *ThrottledDataSource) invalidate() {
+	s.mutex.Lock()
+	s.values, s.err, s.loadTime = nil, nil, time.Time{}
+	s.mutex.Unlock()
+
+	invalidateSource(s.source)
+}
+
+// String returns the throttled source.
+func (s *ThrottledDataSource) String() string {
+	return fmt.Sprintf("%s (loaded at most every %s)", s.source, s.minInterval)
+}
@@ -0,0 +1,80 @@
+// Copyright [2021] [Christian Bandowski]
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"testing"
+	"time"
+)
+
+func TestThrottledDataSource_load(t *testing.T) {
+	source := &mockDataSource{}
+	source.On("load", mock.Anything).Return(map[string]string{"STATUS": "ONLINE"}, nil).Once()
+	source.On("load", mock.Anything).Return(nil, errors.New("unreachable")).Once()
+	now := time.Now()
+	throttled := NewThrottledDataSource(source, 2*time.Second)
+	throttled.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		values, err := throttled.load(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]string{"STATUS": "ONLINE"}, values)
+		now = now.Add(500 * time.Millisecond)
+	}
+	source.AssertNumberOfCalls(t, "load", 1)
+
+	// failures are reused within the interval, too
+	now = now.Add(time.Second)
+	for i := 0; i < 2; i++ {
+		_, err := throttled.load(context.Background())
+		assert.EqualError(t, err, "unreachable")
+	}
+	source.AssertNumberOfCalls(t, "load", 2)
+}
+
+func TestThrottledDataSource_load_Cancelled(t *testing.T) {
+	source := &mockDataSource{}
+	source.On("load", mock.Anything).Return(nil, context.Canceled)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	throttled := NewThrottledDataSource(source, time.Minute)
+
+	_, err := throttled.load(ctx)
+	assert.Equal(t, context.Canceled, err)
+	_, err = throttled.load(ctx)
+	assert.Equal(t, context.Canceled, err)
+
+	// the result of a cancelled load isn't reused
+	source.AssertNumberOfCalls(t, "load", 2)
+}
+
+func TestConfig_loadDataSource_MinReloadInterval(t *testing.T) {
+	config := Config{dataSource: DataSourceNis, targetAddress: "127.0.0.1", minReloadInterval: 2 * time.Second}
+
+	config.loadDataSource()
+
+	assert.IsType(t, &ThrottledDataSource{}, config.source)
+	assert.Equal(t, &NisDataSource{address: "127.0.0.1"}, config.source.(*ThrottledDataSource).source)
+
+	// single value requests can't reuse the values
+	config.singleValueRequests = true
+	config.loadDataSource()
+
+	assert.Equal(t, &NisDataSource{address: "127.0.0.1"}, config.source)
+}
@@ -0,0 +1,91 @@
+// Copyright [2021] [Christian Bandowski]
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"github.com/pkg/errors"
+	"net"
+	"os"
+	"time"
+)
+
+// loadTLS loads the certificate for the TLS listener, or prepares obtaining it by using ACME, if it is enabled.
+func (c *Config) loadTLS() error {
+	if !c.isTLSEnabled() {
+		return nil
+	}
+
+	if c.acmeDomains != "" {
+		tlsConfig, err := c.loadACME()
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		c.tlsConfig = tlsConfig
+	} else {
+		certificate, err := tls.LoadX509KeyPair(c.tlsCertFile, c.tlsKeyFile)
+		if err != nil {
+			return errors.Wrapf(err, "Couldn't load TLS certificate %s and key %s", c.tlsCertFile, c.tlsKeyFile)
+		}
+
+		c.tlsConfig = &tls.Config{
+			Certificates: []tls.Certificate{certificate},
+			MinVersion:   tls.VersionTLS12,
+		}
+	}
+
+	if c.tlsClientCAFile != "" {
+		caCertificates, err := os.ReadFile(c.tlsClientCAFile)
+		if err != nil {
+			return errors.Wrapf(err, "Couldn't read TLS client CA %s", c.tlsClientCAFile)
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caCertificates) {
+			return errors.Errorf("No certificates found in TLS client CA %s", c.tlsClientCAFile)
+		}
+
+		// clients have to present a certificate signed by the CA, its common name identifies the user
+		c.tlsConfig.ClientCAs = pool
+		c.tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return nil
+}
+
+// clientCertificateUser completes the TLS handshake of the connection and returns the common name of the verified
+// client certificate, which identifies the user. It returns an empty string for connections without TLS or without
+// client certificates.
+func clientCertificateUser(c net.Conn, timeout time.Duration) (string, error) {
+	tlsConn, ok := c.(*tls.Conn)
+	if !ok {
+		return "", nil
+	}
+
+	if err := tlsConn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return "", errors.WithStack(err)
+	}
+	if err := tlsConn.Handshake(); err != nil {
+		return "", errors.Wrap(err, "TLS handshake failed")
+	}
+
+	state := tlsConn.ConnectionState()
+	if len(state.VerifiedChains) == 0 || len(state.PeerCertificates) == 0 {
+		return "", nil
+	}
+
+	return state.PeerCertificates[0].Subject.CommonName, nil
+}
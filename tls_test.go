@@ -0,0 +1,275 @@
+// Copyright [2021] [Christian Bandowski]
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"github.com/stretchr/testify/assert"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeTestCertificate creates a self-signed certificate for 127.0.0.1 and returns the certificate and key files
+func writeTestCertificate(t *testing.T, commonName string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	certDer, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	certPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDer})
+	if err := os.WriteFile(certFile, certPem, 0600); err != nil {
+		t.Fatal(err)
+	}
+	keyPem := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+	if err := os.WriteFile(keyFile, keyPem, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return certFile, keyFile
+}
+
+func TestConfig_loadTLS(t *testing.T) {
+	certFile, keyFile := writeTestCertificate(t, "proxy")
+	config := &Config{tlsPort: 3494, tlsCertFile: certFile, tlsKeyFile: keyFile}
+
+	assert.NoError(t, config.loadTLS())
+	if assert.NotNil(t, config.tlsConfig) {
+		assert.Len(t, config.tlsConfig.Certificates, 1)
+	}
+}
+
+func TestConfig_loadTLS_Disabled(t *testing.T) {
+	config := &Config{}
+
+	assert.NoError(t, config.loadTLS())
+	assert.Nil(t, config.tlsConfig)
+}
+
+func TestConfig_loadTLS_MissingFiles(t *testing.T) {
+	config := &Config{tlsPort: 3494, tlsCertFile: "does-not-exist.pem", tlsKeyFile: "does-not-exist.pem"}
+
+	err := config.loadTLS()
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "Couldn't load TLS certificate does-not-exist.pem")
+	}
+}
+
+func TestServe_TLS(t *testing.T) {
+	certFile, keyFile := writeTestCertificate(t, "proxy")
+	config := &Config{
+		upsName:       "test",
+		timeout:       time.Second,
+		maxLineLength: 1024,
+		tlsPort:       3494,
+		tlsCertFile:   certFile,
+		tlsKeyFile:    keyFile,
+	}
+	if !assert.NoError(t, config.loadTLS()) {
+		return
+	}
+
+	l, err := tls.Listen("tcp4", "127.0.0.1:0", config.tlsConfig)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer l.Close()
+	go serve(l, &Listener{}, config, NewSessionRegistry())
+
+	c, err := tls.Dial("tcp4", l.Addr().String(), &tls.Config{InsecureSkipVerify: true})
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer c.Close()
+
+	_, err = c.Write([]byte("LIST UPS\n"))
+	assert.NoError(t, err)
+
+	line, err := bufio.NewReader(c).ReadString('\n')
+	assert.NoError(t, err)
+	assert.Equal(t, "BEGIN LIST UPS\n", line)
+}
+
+func TestServe_ClientCertificate(t *testing.T) {
+	certFile, keyFile := writeTestCertificate(t, "proxy")
+	clientCertFile, clientKeyFile := writeTestCertificate(t, "nas")
+	config := &Config{
+		upsName:         "test",
+		timeout:         time.Second,
+		maxLineLength:   1024,
+		tlsPort:         3494,
+		tlsCertFile:     certFile,
+		tlsKeyFile:      keyFile,
+		tlsClientCAFile: clientCertFile,
+		users: map[string]*User{
+			"nas": {name: "nas", actions: map[string]bool{"LOGIN": true}},
+		},
+	}
+	if !assert.NoError(t, config.loadTLS()) {
+		return
+	}
+
+	l, err := tls.Listen("tcp4", "127.0.0.1:0", config.tlsConfig)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer l.Close()
+	go serve(l, &Listener{}, config, NewSessionRegistry())
+
+	clientCertificate, err := tls.LoadX509KeyPair(clientCertFile, clientKeyFile)
+	if !assert.NoError(t, err) {
+		return
+	}
+	c, err := tls.Dial("tcp4", l.Addr().String(), &tls.Config{
+		InsecureSkipVerify: true,
+		Certificates:       []tls.Certificate{clientCertificate},
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer c.Close()
+
+	// no USERNAME and PASSWORD are needed
+	_, err = c.Write([]byte("LOGIN test\n"))
+	assert.NoError(t, err)
+
+	line, err := bufio.NewReader(c).ReadString('\n')
+	assert.NoError(t, err)
+	assert.Equal(t, "OK\n", line)
+}
+
+func TestServe_ClientCertificate_Missing(t *testing.T) {
+	certFile, keyFile := writeTestCertificate(t, "proxy")
+	clientCertFile, _ := writeTestCertificate(t, "nas")
+	config := &Config{
+		upsName:         "test",
+		timeout:         time.Second,
+		maxLineLength:   1024,
+		tlsPort:         3494,
+		tlsCertFile:     certFile,
+		tlsKeyFile:      keyFile,
+		tlsClientCAFile: clientCertFile,
+	}
+	if !assert.NoError(t, config.loadTLS()) {
+		return
+	}
+
+	l, err := tls.Listen("tcp4", "127.0.0.1:0", config.tlsConfig)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer l.Close()
+	go serve(l, &Listener{}, config, NewSessionRegistry())
+
+	c, err := tls.Dial("tcp4", l.Addr().String(), &tls.Config{InsecureSkipVerify: true})
+	if err == nil {
+		defer c.Close()
+
+		// with TLS 1.3 the client only notices the rejected handshake once it reads
+		_, err = c.Write([]byte("LIST UPS\n"))
+		if err == nil {
+			_, err = bufio.NewReader(c).ReadString('\n')
+		}
+	}
+	assert.Error(t, err)
+}
+
+func TestConfig_loadTLS_InvalidClientCA(t *testing.T) {
+	certFile, keyFile := writeTestCertificate(t, "proxy")
+	config := &Config{tlsPort: 3494, tlsCertFile: certFile, tlsKeyFile: keyFile, tlsClientCAFile: keyFile}
+
+	err := config.loadTLS()
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "No certificates found in TLS client CA")
+	}
+}
+
+func TestServe_StartTLS(t *testing.T) {
+	certFile, keyFile := writeTestCertificate(t, "proxy")
+	config := &Config{
+		upsName:       "test",
+		timeout:       time.Second,
+		maxLineLength: 1024,
+		tlsPort:       3494,
+		tlsCertFile:   certFile,
+		tlsKeyFile:    keyFile,
+	}
+	if !assert.NoError(t, config.loadTLS()) {
+		return
+	}
+	c := startTestServer(t, config)
+
+	_, err := c.Write([]byte("STARTTLS\n"))
+	assert.NoError(t, err)
+	line, err := bufio.NewReader(c).ReadString('\n')
+	if !assert.NoError(t, err) || !assert.Equal(t, "OK STARTTLS\n", line) {
+		return
+	}
+
+	tlsConn := tls.Client(c, &tls.Config{InsecureSkipVerify: true})
+	if !assert.NoError(t, tlsConn.Handshake()) {
+		return
+	}
+
+	_, err = tlsConn.Write([]byte("LIST UPS\n"))
+	assert.NoError(t, err)
+	reader := bufio.NewReader(tlsConn)
+	line, err = reader.ReadString('\n')
+	assert.NoError(t, err)
+	assert.Equal(t, "BEGIN LIST UPS\n", line)
+
+	_, err = tlsConn.Write([]byte("STARTTLS\n"))
+	assert.NoError(t, err)
+	for line != "END LIST UPS\n" && err == nil {
+		line, err = reader.ReadString('\n')
+	}
+	line, err = reader.ReadString('\n')
+	assert.NoError(t, err)
+	assert.Equal(t, "ERR ALREADY-SSL-MODE\n", line)
+}
@@ -0,0 +1,79 @@
+// Copyright [2021] [Christian Bandowski]
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"github.com/pkg/errors"
+	"strings"
+)
+
+// tokenize splits a command line into its tokens. Tokens are separated by whitespace, double-quoted tokens may
+// contain whitespace and use a backslash to escape quotes and backslashes.
+func tokenize(line string) ([]string, error) {
+	tokens := make([]string, 0)
+
+	var token strings.Builder
+	inToken := false
+	inQuotes := false
+	escaped := false
+
+	for _, r := range line {
+		switch {
+		case escaped:
+			token.WriteRune(r)
+			escaped = false
+		case inQuotes && r == '\\':
+			escaped = true
+		case r == '"':
+			inQuotes = !inQuotes
+			inToken = true
+		case !inQuotes && (r == ' ' || r == '\t'):
+			if inToken {
+				tokens = append(tokens, token.String())
+				token.Reset()
+				inToken = false
+			}
+		default:
+			token.WriteRune(r)
+			inToken = true
+		}
+	}
+
+	if inQuotes {
+		return nil, errors.New("Unterminated quote in command")
+	}
+	if inToken {
+		tokens = append(tokens, token.String())
+	}
+
+	return tokens, nil
+}
+
+// quote surrounds the given value with double quotes, quotes and backslashes within the value will be escaped.
+func quote(value string) string {
+	value = strings.ReplaceAll(value, "\\", "\\\\")
+	value = strings.ReplaceAll(value, "\"", "\\\"")
+
+	return "\"" + value + "\""
+}
+
+// formatArg returns the given value as-is, or quoted if it would otherwise not be read as a single token.
+func formatArg(value string) string {
+	if value == "" || strings.ContainsAny(value, " \t\"\\") {
+		return quote(value)
+	}
+
+	return value
+}
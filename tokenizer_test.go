@@ -0,0 +1,62 @@
+// Copyright [2021] [Christian Bandowski]
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestTokenize(t *testing.T) {
+	lineToTokens := map[string][]string{
+		"":                                  {},
+		"LOGOUT":                            {"LOGOUT"},
+		"GET VAR ups ups.status":            {"GET", "VAR", "ups", "ups.status"},
+		"GET VAR \"my ups\" ups.status":     {"GET", "VAR", "my ups", "ups.status"},
+		"SET VAR ups var \"\"":              {"SET", "VAR", "ups", "var", ""},
+		"SET VAR ups var \"a \\\"b\\\" c\"": {"SET", "VAR", "ups", "var", "a \"b\" c"},
+		"LOGIN \"back\\\\slash\"":           {"LOGIN", "back\\slash"},
+		"  LIST\tUPS  ":                     {"LIST", "UPS"},
+	}
+
+	for line, expTokens := range lineToTokens {
+		t.Run("line="+line, func(t *testing.T) {
+			tokens, err := tokenize(line)
+
+			assert.NoError(t, err)
+			assert.Equal(t, expTokens, tokens)
+		})
+	}
+}
+
+func TestTokenize_UnterminatedQuote(t *testing.T) {
+	tokens, err := tokenize("GET VAR \"my ups ups.status")
+
+	assert.Nil(t, tokens)
+	assert.EqualError(t, err, "Unterminated quote in command")
+}
+
+func TestQuote(t *testing.T) {
+	assert.Equal(t, `"value"`, quote("value"))
+	assert.Equal(t, `""`, quote(""))
+	assert.Equal(t, `"my \"quoted\" ups"`, quote(`my "quoted" ups`))
+	assert.Equal(t, `"back\\slash"`, quote(`back\slash`))
+}
+
+func TestFormatArg(t *testing.T) {
+	assert.Equal(t, `ups`, formatArg("ups"))
+	assert.Equal(t, `"my ups"`, formatArg("my ups"))
+	assert.Equal(t, `""`, formatArg(""))
+}
@@ -0,0 +1,396 @@
+// Copyright [2021] [Christian Bandowski]
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"github.com/pkg/errors"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// newUpsConfig creates the configuration of the UPS with the given name as a copy of the global configuration.
+func (c *Config) newUpsConfig(name string) *Config {
+	ups := *c
+	ups.upsName = name
+	ups.upses = nil
+	ups.state = NewUpsState()
+	if c.stateFile != "" {
+		// the state of every UPS is persisted separately
+		ups.stateFile = c.stateFile + "." + name
+	}
+
+	return &ups
+}
+
+// setUpsOption overrides a setting of the global configuration for the UPS. Supported options are:
+//
+//	target=<address>         address on which apcupsd is running
+//	fallback=<addresses>     space separated addresses used once the target isn't reachable
+//	source=<source>          how the values are loaded, "apcaccess", "nis", "file", "nut", "snmp", "aggregate",
+//	                         "dummy", "replay" or "ssh"
+//	scenario-file=<path>     scenario file simulated by the "dummy" source
+//	replay-dir=<path>        directory of the snapshots replayed by the "replay" source
+//	ssh-user=<user>          user logging in to the target by the "ssh" source
+//	ssh-key=<path>           private key used by the "ssh" source
+//	ssh-known-hosts=<path>   known hosts file verifying the target of the "ssh" source
+//	members=<names>          space separated UPSes combined by the "aggregate" source
+//	status-file=<path>       status file of apcupsd read by the "file" source
+//	upstream-ups=<name>      name of the UPS on the NUT server read by the "nut" source
+//	community=<community>    SNMP community used by the "snmp" source
+//	community-file=<path>    file containing the SNMP community, preferred over community
+//	events-file=<path>       events file of apcupsd, listed by LIST EVENTS
+//	description=<text>       short description of the UPS
+//	poll-interval=<duration> minimum time between loading the values of the UPS
+//	var.<name>=<value>       fixed value of a variable, replacing the one reported by apcupsd
+//	map.<name>=<loader>      loader of a variable, like the ones of -var-map, e.g. "apc:NOMPOWER|fixed:600"
+//	exclude=<names>          space separated variables hidden from the clients, in addition to -exclude-vars
+func (c *Config) setUpsOption(name string, value string) error {
+	switch {
+	case name == "target":
+		c.targetAddress = value
+	case name == "fallback":
+		c.fallbackTargets = value
+	case name == "source":
+		c.dataSource = value
+	case name == "scenario-file":
+		c.scenarioFile = value
+	case name == "replay-dir":
+		c.replayDir = value
+	case name == "ssh-user":
+		c.sshUser = value
+	case name == "ssh-key":
+		c.sshKeyFile = value
+	case name == "ssh-known-hosts":
+		c.sshKnownHostsFile = value
+	case name == "members":
+		c.aggregateMembers = value
+	case name == "status-file":
+		c.statusFile = value
+	case name == "upstream-ups":
+		c.upstreamUpsName = value
+	case name == "community":
+		c.snmpCommunity = value
+	case name == "community-file":
+		community, err := readSecretFile(value)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		c.snmpCommunity = community
+	case name == "events-file":
+		c.eventsFile = value
+	case name == "description":
+		c.upsDescription = value
+	case name == "poll-interval":
+		interval, err := time.ParseDuration(value)
+		if err != nil {
+			return errors.Wrapf(err, "Invalid poll interval %s", value)
+		}
+		c.pollInterval = interval
+	case isVarOption(name):
+		if err := c.applyVarOption(varOption{name: name, value: value}); err != nil {
+			return errors.WithStack(err)
+		}
+		// the variables of an upstream UPS replace the built-in ones later on, the option is applied to them again
+		c.varOptions = append(c.varOptions, varOption{name: name, value: value})
+	default:
+		return errors.Errorf("Unknown option %s", name)
+	}
+
+	return nil
+}
+
+// overrideVar replaces the variable with the given fixed value, it can't be changed by clients anymore. The variables
+// are shared with the global configuration, so they are copied first.
+func (c *Config) overrideVar(name string, value string) {
+	vars := make(map[string]VarLoader, len(c.vars)+1)
+	for varName, loader := range c.vars {
+		vars[varName] = loader
+	}
+	vars[name] = FixedValue(value)
+	c.vars = vars
+
+	varInfos := make(map[string]VarInfo, len(c.varInfos))
+	for varName, info := range c.varInfos {
+		varInfos[varName] = info
+	}
+	if info, ok := varInfos[name]; ok {
+		info.writable = false
+		varInfos[name] = info
+	}
+	c.varInfos = varInfos
+}
+
+// parseUps parses a UPS given as name followed by comma separated options, like
+// "rack,target=192.168.0.10:3551,source=nis,description=Rack UPS". The options are the ones of setUpsOption, values
+// must not contain commas. Options that aren't given are taken from the global flags.
+func (c *Config) parseUps(spec string) (*Config, error) {
+	fields := splitList(spec)
+	if len(fields) == 0 {
+		return nil, errors.New("Missing name")
+	}
+
+	ups := c.newUpsConfig(fields[0])
+	for _, option := range fields[1:] {
+		name, value := option, ""
+		if i := strings.Index(option, "="); i >= 0 {
+			name, value = option[:i], option[i+1:]
+		}
+
+		if err := ups.setUpsOption(name, value); err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+
+	return ups, nil
+}
+
+// loadUpsFile loads the UPSes from the given file, see parseUpsFile.
+func (c *Config) loadUpsFile(path string) ([]*Config, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Couldn't open UPS file %s", path)
+	}
+	defer file.Close()
+
+	upses, err := c.parseUpsFile(file)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Couldn't parse UPS file %s", path)
+	}
+
+	return upses, nil
+}
+
+// parseUpsFile parses UPSes in a format similar to the ups.conf file of NUT, e.g.
+//
+//	[rack]
+//		target = 192.168.0.10
+//		source = nis
+//		description = "Rack UPS, first floor"
+//		poll-interval = 10s
+//		var.battery.charge.low = 20
+//
+// The settings are the options of setUpsOption, settings that aren't given are taken from the global flags.
+func (c *Config) parseUpsFile(reader io.Reader) ([]*Config, error) {
+	var upses []*Config
+	var ups *Config
+
+	scanner := bufio.NewScanner(reader)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			name := strings.TrimSpace(line[1 : len(line)-1])
+			if name == "" {
+				return nil, errors.Errorf("Empty UPS name in line %d", lineNumber)
+			}
+
+			ups = c.newUpsConfig(name)
+			upses = append(upses, ups)
+			continue
+		}
+
+		if ups == nil {
+			return nil, errors.Errorf("Setting outside of a UPS section in line %d", lineNumber)
+		}
+
+		key, value := parseUserSetting(line)
+		if err := ups.setUpsOption(key, value); err != nil {
+			return nil, errors.Wrapf(err, "Invalid setting in line %d", lineNumber)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return upses, nil
+}
+
+// parseUpses parses the configured UPSes given as flags and in the UPS file. Without any, the proxy serves a single
+// UPS configured by the global flags.
+func (c *Config) parseUpses() ([]*Config, error) {
+	if len(c.upsSpecs) == 0 && c.upsFile == "" {
+		return []*Config{c}, nil
+	}
+
+	var upses []*Config
+	for _, spec := range c.upsSpecs {
+		ups, err := c.parseUps(spec)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Invalid UPS %s", spec)
+		}
+		upses = append(upses, ups)
+	}
+	if c.upsFile != "" {
+		fileUpses, err := c.loadUpsFile(c.upsFile)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		upses = append(upses, fileUpses...)
+	}
+
+	names := make(map[string]bool)
+	for _, ups := range upses {
+		if names[ups.upsName] {
+			return nil, errors.Errorf("The UPS %s is configured more than once", ups.upsName)
+		}
+		names[ups.upsName] = true
+	}
+
+	return upses, nil
+}
+
+// validateUps checks the name, the source, the events and the poll interval of the UPS.
+func (c *Config) validateUps() error {
+	if c.upsName == "" {
+		return errors.New("The UPS name must not be empty")
+	}
+	if strings.ContainsAny(c.upsName, "\"\\") {
+		return errors.Errorf("Invalid UPS name %s, it must not contain quotes or backslashes", c.upsName)
+	}
+	if c.dataSource != DataSourceApcaccess && c.dataSource != DataSourceNis && c.dataSource != DataSourceFile &&
+		c.dataSource != DataSourceNut && c.dataSource != DataSourceSnmp && c.dataSource != DataSourceAggregate &&
+		c.dataSource != DataSourceDummy && c.dataSource != DataSourceReplay && c.dataSource != DataSourceSsh {
+		return errors.Errorf("Invalid source %s, must be \"%s\", \"%s\", \"%s\", \"%s\", \"%s\", \"%s\", \"%s\", "+
+			"\"%s\" or \"%s\"", c.dataSource, DataSourceApcaccess, DataSourceNis, DataSourceFile, DataSourceNut,
+			DataSourceSnmp, DataSourceAggregate, DataSourceDummy, DataSourceReplay, DataSourceSsh)
+	}
+	if c.dataSource == DataSourceAggregate && len(c.aggregateMemberList()) == 0 {
+		return errors.New("The aggregate source requires members")
+	}
+	if c.dataSource == DataSourceFile && c.statusFile == "" {
+		return errors.New("The file source requires a status file")
+	}
+	if c.dataSource == DataSourceDummy && c.scenarioFile == "" {
+		return errors.New("The dummy source requires a scenario file")
+	}
+	if c.dataSource == DataSourceReplay && c.replayDir == "" {
+		return errors.New("The replay source requires a replay directory")
+	}
+	if c.dataSource == DataSourceSsh && (c.sshUser == "" || c.sshKeyFile == "" || c.sshKnownHostsFile == "") {
+		return errors.New("The ssh source requires a user, a key and a known hosts file")
+	}
+	if len(c.fallbackTargetList()) > 0 && (c.dataSource == DataSourceAggregate || c.dataSource == DataSourceFile ||
+		c.dataSource == DataSourceDummy || c.dataSource == DataSourceReplay) {
+		return errors.Errorf("The %s source doesn't support fallback targets", c.dataSource)
+	}
+	if c.singleValueRequests && c.dataSource != DataSourceApcaccess {
+		return errors.Errorf("The %s source doesn't support single value requests", c.dataSource)
+	}
+	if c.singleValueRequests && (len(c.fallbackTargetList()) > 0 || c.sourceRetries > 0 || c.recordDir != "" ||
+		c.cacheTTL > 0 || c.maxDataAge > 0) {
+		// these wrap the source, which only loads all values at once then
+		return errors.New("Single value requests can't be combined with fallback targets, source retries, " +
+			"recording, a cache TTL or a maximum data age")
+	}
+	if c.eventsFile != "" && c.maxEvents <= 0 {
+		return errors.Errorf("Invalid maximum number of events %d, must be positive", c.maxEvents)
+	}
+	if c.pollInterval < 0 {
+		return errors.Errorf("Invalid poll interval %s of UPS %s, must not be negative", c.pollInterval, c.upsName)
+	}
+	if c.dataSource == DataSourceApcaccess {
+		if _, err := exec.LookPath(c.apcAccessExecutable); err != nil {
+			return errors.Wrapf(err, "The apcaccess executable \"%s\" couldn't be found", c.apcAccessExecutable)
+		}
+	}
+
+	return nil
+}
+
+// loadUpses parses the configured UPSes and creates their sources. The UPSes copy the shared parts of the
+// configuration, so it has to be called once everything else is loaded.
+func (c *Config) loadUpses() error {
+	// the UPSes copy the global community
+	if err := c.loadSnmpCommunity(); err != nil {
+		return errors.WithStack(err)
+	}
+
+	upses, err := c.parseUpses()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if c.discoverNetworks != "" {
+		discovered, err := c.discoverUpses(context.Background(), upses)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		upses = append(upses, discovered...)
+	}
+
+	for _, ups := range upses {
+		if ups.dataSource != DataSourceAggregate {
+			ups.loadDataSource()
+		}
+	}
+	// the members of an aggregate need their sources already
+	for _, ups := range upses {
+		if ups.dataSource == DataSourceAggregate {
+			if err := ups.loadAggregateSource(upses); err != nil {
+				return errors.WithStack(err)
+			}
+		}
+	}
+
+	for _, ups := range upses {
+		logInfof("Serving UPS %s from %s", ups.upsName, ups.source)
+		if ups.dataSource == DataSourceNut {
+			// an unreachable upstream must not stop the other UPSes from being served
+			ups.upstreamVars = &upstreamVarsState{}
+			if err := ups.ensureUpstreamVars(context.Background()); err != nil {
+				logWarnf("Couldn't load the variables of UPS %s, retrying on the next request: %+v", ups.upsName, err)
+			}
+		}
+		if ups == c {
+			continue
+		}
+		if err := ups.loadState(); err != nil {
+			return errors.Wrapf(err, "Couldn't load the state of UPS %s", ups.upsName)
+		}
+	}
+	c.upses = upses
+
+	return nil
+}
+
+// upsConfigs returns the configurations of all UPSes served by the proxy.
+func (c *Config) upsConfigs() []*Config {
+	if len(c.upses) == 0 {
+		return []*Config{c}
+	}
+
+	return c.upses
+}
+
+// upsConfig returns the configuration of the UPS with the given name, nil if there is none.
+func (c *Config) upsConfig(name string) *Config {
+	for _, ups := range c.upsConfigs() {
+		if ups.upsName == name {
+			return ups
+		}
+	}
+
+	return nil
+}
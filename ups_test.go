@@ -0,0 +1,208 @@
+// Copyright [2021] [Christian Bandowski]
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConfig_parseUps(t *testing.T) {
+	config := &Config{
+		targetAddress:  "127.0.0.1",
+		dataSource:     DataSourceApcaccess,
+		statusFile:     "/var/log/apcupsd.status",
+		upsDescription: "default",
+		stateFile:      "state.json",
+		state:          NewUpsState(),
+	}
+
+	ups, err := config.parseUps("rack, target=192.168.0.10:3551, source=nis, description=Rack UPS")
+
+	if assert.NoError(t, err) {
+		assert.Equal(t, "rack", ups.upsName)
+		assert.Equal(t, "192.168.0.10:3551", ups.targetAddress)
+		assert.Equal(t, DataSourceNis, ups.dataSource)
+		assert.Equal(t, "/var/log/apcupsd.status", ups.statusFile)
+		assert.Equal(t, "Rack UPS", ups.upsDescription)
+		assert.Equal(t, "state.json.rack", ups.stateFile)
+		assert.NotSame(t, config.state, ups.state)
+	}
+
+	_, err = config.parseUps("rack,unknown")
+	assert.EqualError(t, err, "Unknown option unknown")
+
+	_, err = config.parseUps(" ")
+	assert.EqualError(t, err, "Missing name")
+}
+
+func TestConfig_setUpsOption(t *testing.T) {
+	config := &Config{
+		vars: map[string]VarLoader{
+			"battery.charge.low": FixedValue("10"),
+		},
+		varInfos: map[string]VarInfo{
+			"battery.charge.low": {writable: true},
+		},
+	}
+	ups := config.newUpsConfig("rack")
+
+	assert.NoError(t, ups.setUpsOption("poll-interval", "10s"))
+	assert.NoError(t, ups.setUpsOption("fallback", "10.0.0.2 10.0.0.3"))
+	assert.NoError(t, ups.setUpsOption("var.battery.charge.low", "20"))
+	assert.NoError(t, ups.setUpsOption("var.device.location", "rack 4"))
+	assert.NoError(t, ups.setUpsOption("map.ups.id", "fixed:rack"))
+	assert.NoError(t, ups.setUpsOption("exclude", "ups.id device.serial"))
+
+	assert.Equal(t, 10*time.Second, ups.pollInterval)
+	assert.Equal(t, []string{"10.0.0.2", "10.0.0.3"}, ups.fallbackTargetList())
+	assert.Equal(t, []string{"battery.charge.low", "device.location"}, ups.varNames())
+	assert.Empty(t, ups.writableVarNames())
+	value, err := ups.vars["battery.charge.low"]("battery.charge.low", ups, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "20", value)
+
+	// the global configuration is unchanged
+	assert.Equal(t, []string{"battery.charge.low"}, config.varNames())
+	assert.Equal(t, []string{"battery.charge.low"}, config.writableVarNames())
+
+	assert.Error(t, ups.setUpsOption("poll-interval", "soon"))
+	assert.EqualError(t, ups.setUpsOption("var.", "1"), "Unknown option var.")
+	assert.Error(t, ups.setUpsOption("map.ups.id", "rack"))
+}
+
+func TestConfig_setUpsOption_CommunityFile(t *testing.T) {
+	communityFile := filepath.Join(t.TempDir(), "community")
+	assert.NoError(t, os.WriteFile(communityFile, []byte("secret\n"), 0600))
+	ups := (&Config{snmpCommunity: "public"}).newUpsConfig("rack")
+
+	assert.NoError(t, ups.setUpsOption("community-file", communityFile))
+	assert.Equal(t, "secret", ups.snmpCommunity)
+
+	assert.Error(t, ups.setUpsOption("community-file", filepath.Join(t.TempDir(), "missing")))
+}
+
+func TestConfig_parseUpsFile(t *testing.T) {
+	config := &Config{targetAddress: "127.0.0.1", dataSource: DataSourceApcaccess}
+	content := `
+# UPSes of the server room
+[rack]
+	target = 192.168.0.10
+	source = nis
+	description = "Rack UPS, first floor"
+	poll-interval = 10s
+
+[office]
+	description = Office
+`
+
+	upses, err := config.parseUpsFile(strings.NewReader(content))
+
+	if assert.NoError(t, err) && assert.Len(t, upses, 2) {
+		assert.Equal(t, "rack", upses[0].upsName)
+		assert.Equal(t, "192.168.0.10", upses[0].targetAddress)
+		assert.Equal(t, DataSourceNis, upses[0].dataSource)
+		assert.Equal(t, "Rack UPS, first floor", upses[0].upsDescription)
+		assert.Equal(t, 10*time.Second, upses[0].pollInterval)
+		assert.Equal(t, "office", upses[1].upsName)
+		assert.Equal(t, "127.0.0.1", upses[1].targetAddress)
+		assert.Equal(t, "Office", upses[1].upsDescription)
+	}
+}
+
+func TestConfig_parseUpsFile_Invalid(t *testing.T) {
+	contentToError := map[string]string{
+		"target = 192.168.0.10":     "Setting outside of a UPS section in line 1",
+		"[ ]":                       "Empty UPS name in line 1",
+		"[rack]\nunknown = 1":       "Invalid setting in line 2: Unknown option unknown",
+		"[rack]\npoll-interval = 1": "Invalid setting in line 2: Invalid poll interval 1",
+	}
+
+	for content, expError := range contentToError {
+		t.Run(content, func(t *testing.T) {
+			_, err := (&Config{}).parseUpsFile(strings.NewReader(content))
+
+			if assert.Error(t, err) {
+				assert.Contains(t, err.Error(), expError)
+			}
+		})
+	}
+}
+
+func TestConfig_parseUpses_File(t *testing.T) {
+	upsFile := filepath.Join(t.TempDir(), "ups.conf")
+	if !assert.NoError(t, os.WriteFile(upsFile, []byte("[second]\n[first]\n"), 0600)) {
+		return
+	}
+
+	upses, err := (&Config{upsSpecs: stringListFlag{"first"}, upsFile: upsFile}).parseUpses()
+	assert.EqualError(t, err, "The UPS first is configured more than once")
+	assert.Nil(t, upses)
+
+	_, err = (&Config{upsFile: "does-not-exist"}).parseUpses()
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "Couldn't open UPS file does-not-exist")
+	}
+}
+
+func TestConfig_parseUpses_Default(t *testing.T) {
+	config := &Config{upsName: "ups"}
+
+	upses, err := config.parseUpses()
+
+	assert.NoError(t, err)
+	assert.Equal(t, []*Config{config}, upses)
+}
+
+func TestConfig_loadUpses(t *testing.T) {
+	stateFile := filepath.Join(t.TempDir(), "state.json")
+	if !assert.NoError(t, os.WriteFile(stateFile+".second", []byte(`{"vars":{"battery.charge.low":"20"}}`), 0600)) {
+		return
+	}
+	config := &Config{
+		upsName:    "ups",
+		dataSource: DataSourceApcaccess,
+		stateFile:  stateFile,
+		statusFile: "apcupsd.status",
+		upsSpecs: stringListFlag{"first,source=file", "second,source=nis", "third,source=snmp,target=10.0.0.5",
+			"fourth,target=10.0.0.6", "rack,source=aggregate,members=second third"},
+		state: NewUpsState(),
+	}
+
+	if !assert.NoError(t, config.loadUpses()) {
+		return
+	}
+
+	assert.Len(t, config.upsConfigs(), 5)
+	assert.Nil(t, config.upsConfig("ups"))
+	assert.Equal(t, &FileDataSource{path: "apcupsd.status"}, config.upsConfig("first").source)
+	assert.IsType(t, &NisDataSource{}, config.upsConfig("second").source)
+	assert.Equal(t, &SnmpDataSource{address: "10.0.0.5"}, config.upsConfig("third").source)
+	assert.IsType(t, &ExecDataSource{}, config.upsConfig("fourth").source)
+	assert.Equal(t, NewAggregateDataSource("rack", []*Config{config.upsConfig("second"), config.upsConfig("third")}),
+		config.upsConfig("rack").source)
+	assert.Equal(t, "20", config.upsConfig("second").state.vars["battery.charge.low"])
+}
+
+func TestConfig_upsConfig_Single(t *testing.T) {
+	config := &Config{upsName: "ups"}
+
+	assert.Same(t, config, config.upsConfig("ups"))
+	assert.Nil(t, config.upsConfig("unknown"))
+}
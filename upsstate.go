@@ -0,0 +1,300 @@
+// Copyright [2021] [Christian Bandowski]
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"github.com/pkg/errors"
+	"os"
+	"sync"
+	"time"
+)
+
+// UpsState contains the state of the UPS that is maintained by the proxy itself instead of apcupsd.
+// It is shared by all connections and safe for concurrent use.
+type UpsState struct {
+	mutex sync.RWMutex
+
+	// whether a client requested a forced shutdown by using the FSD command
+	forcedShutdown bool
+
+	// values of variables that were written by clients, they override the values retrieved from apcupsd
+	vars map[string]string
+
+	// beeper status set by using the beeper instant commands, empty if it was never changed
+	beeperStatus string
+
+	// file in which the state is persisted, empty if it is kept in memory only
+	stateFile string
+
+	// health of the data source, set by every load of the values and not persisted
+	sourceLoaded      bool
+	sourceError       string
+	sourceLastSuccess time.Time
+
+	// values loaded last by any connection, used to answer GET VAR ups.status without loading them again
+	snapshot     map[string]string
+	snapshotTime time.Time
+	// time the values were invalidated last, values loaded before it must be loaded again
+	invalidationTime time.Time
+}
+
+// persistedUpsState is the part of the UpsState that is persisted in the state file.
+type persistedUpsState struct {
+	Vars         map[string]string `json:"vars,omitempty"`
+	BeeperStatus string            `json:"beeperStatus,omitempty"`
+}
+
+// NewUpsState creates a new instance of UpsState
+func NewUpsState() *UpsState {
+	return &UpsState{vars: make(map[string]string)}
+}
+
+// load loads the persisted state from the given file and persists all further changes in it. A missing file is not
+// an error, it will be created on the first change.
+func (s *UpsState) load(stateFile string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.stateFile = stateFile
+
+	content, err := os.ReadFile(stateFile)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return errors.Wrapf(err, "Couldn't read state file %s", stateFile)
+	}
+
+	var persisted persistedUpsState
+	if err := json.Unmarshal(content, &persisted); err != nil {
+		return errors.Wrapf(err, "Couldn't parse state file %s", stateFile)
+	}
+	for name, value := range persisted.Vars {
+		s.vars[name] = value
+	}
+	s.beeperStatus = persisted.BeeperStatus
+
+	return nil
+}
+
+// persist writes the state to the state file, if any. The caller must hold the lock.
+func (s *UpsState) persist() error {
+	if s.stateFile == "" {
+		return nil
+	}
+
+	content, err := json.MarshalIndent(persistedUpsState{Vars: s.vars, BeeperStatus: s.beeperStatus}, "", "  ")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	// write to a temporary file first, so the state file is never left half written
+	tmpFile := s.stateFile + ".tmp"
+	if err := os.WriteFile(tmpFile, content, 0600); err != nil {
+		return errors.Wrapf(err, "Couldn't write state file %s", tmpFile)
+	}
+	if err := os.Rename(tmpFile, s.stateFile); err != nil {
+		return errors.Wrapf(err, "Couldn't replace state file %s", s.stateFile)
+	}
+
+	return nil
+}
+
+// setForcedShutdown marks the UPS as being in forced shutdown.
+func (s *UpsState) setForcedShutdown() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.forcedShutdown = true
+}
+
+// isForcedShutdown checks whether a forced shutdown was requested, a nil state is never in forced shutdown.
+func (s *UpsState) isForcedShutdown() bool {
+	if s == nil {
+		return false
+	}
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return s.forcedShutdown
+}
+
+// setVar stores the value of a variable written by a client and persists it.
+func (s *UpsState) setVar(name string, value string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	previous, existed := s.vars[name]
+	s.vars[name] = value
+
+	if err := s.persist(); err != nil {
+		// keep memory and file consistent
+		if existed {
+			s.vars[name] = previous
+		} else {
+			delete(s.vars, name)
+		}
+
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
+
+// getVar returns the value of a variable written by a client, a nil state has no values.
+func (s *UpsState) getVar(name string) (string, bool) {
+	if s == nil {
+		return "", false
+	}
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	value, ok := s.vars[name]
+	return value, ok
+}
+
+// setBeeperStatus stores the beeper status set by a client and persists it.
+func (s *UpsState) setBeeperStatus(status string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	previous := s.beeperStatus
+	s.beeperStatus = status
+
+	if err := s.persist(); err != nil {
+		// keep memory and file consistent
+		s.beeperStatus = previous
+
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
+
+// getBeeperStatus returns the beeper status set by a client, a nil state has no beeper status.
+func (s *UpsState) getBeeperStatus() (string, bool) {
+	if s == nil {
+		return "", false
+	}
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return s.beeperStatus, s.beeperStatus != ""
+}
+
+// recordSourceLoad records the result of loading the values from the data source, a nil state records nothing.
+func (s *UpsState) recordSourceLoad(err error) {
+	if s == nil {
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.sourceLoaded = true
+	if err != nil {
+		s.sourceError = err.Error()
+		return
+	}
+	s.sourceError = ""
+	s.sourceLastSuccess = time.Now()
+}
+
+// restoreSourceLastSuccess sets the time of the last successful load, e.g. of values persisted before a restart,
+// unless the values were loaded since. A nil state records nothing.
+func (s *UpsState) restoreSourceLastSuccess(lastSuccess time.Time) {
+	if s == nil {
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if lastSuccess.After(s.sourceLastSuccess) {
+		s.sourceLastSuccess = lastSuccess
+	}
+}
+
+// getSourceHealth returns whether the values were loaded at all, the error of the last load, if it failed, and the
+// time of the last successful load. A nil state has never loaded the values.
+func (s *UpsState) getSourceHealth() (bool, string, time.Time) {
+	if s == nil {
+		return false, "", time.Time{}
+	}
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return s.sourceLoaded, s.sourceError, s.sourceLastSuccess
+}
+
+// setSnapshot records the values loaded last by any connection, a nil state records nothing.
+func (s *UpsState) setSnapshot(values map[string]string) {
+	if s == nil {
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.snapshot = values
+	s.snapshotTime = time.Now()
+}
+
+// getSnapshot returns the values loaded last by any connection, if they were loaded within the maximum age.
+func (s *UpsState) getSnapshot(maxAge time.Duration) (map[string]string, bool) {
+	if s == nil {
+		return nil, false
+	}
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	if s.snapshot == nil || time.Since(s.snapshotTime) >= maxAge {
+		return nil, false
+	}
+
+	return s.snapshot, true
+}
+
+// invalidateValues drops the values loaded last, e.g. because they were changed. Values loaded by the connections
+// before aren't fresh anymore.
+func (s *UpsState) invalidateValues() {
+	if s == nil {
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.snapshot = nil
+	s.invalidationTime = time.Now()
+}
+
+// invalidatedSince returns whether the values were invalidated after the given time, a nil state never invalidates
+// them.
+func (s *UpsState) invalidatedSince(t time.Time) bool {
+	if s == nil {
+		return false
+	}
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return s.invalidationTime.After(t)
+}
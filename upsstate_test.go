@@ -0,0 +1,120 @@
+// Copyright [2021] [Christian Bandowski]
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestUpsState_ForcedShutdown(t *testing.T) {
+	state := NewUpsState()
+
+	assert.False(t, state.isForcedShutdown())
+
+	state.setForcedShutdown()
+
+	assert.True(t, state.isForcedShutdown())
+}
+
+func TestUpsState_ForcedShutdown_Nil(t *testing.T) {
+	var state *UpsState
+
+	assert.False(t, state.isForcedShutdown())
+}
+
+func TestUpsState_Vars(t *testing.T) {
+	state := NewUpsState()
+
+	_, ok := state.getVar("battery.charge.low")
+	assert.False(t, ok)
+
+	assert.NoError(t, state.setVar("battery.charge.low", "30"))
+
+	value, ok := state.getVar("battery.charge.low")
+	assert.True(t, ok)
+	assert.Equal(t, "30", value)
+}
+
+func TestUpsState_Vars_Nil(t *testing.T) {
+	var state *UpsState
+
+	_, ok := state.getVar("battery.charge.low")
+	assert.False(t, ok)
+}
+
+func TestUpsState_BeeperStatus_Nil(t *testing.T) {
+	var state *UpsState
+
+	_, ok := state.getBeeperStatus()
+	assert.False(t, ok)
+}
+
+func TestUpsState_Persistence(t *testing.T) {
+	stateFile := filepath.Join(t.TempDir(), "state.json")
+
+	state := NewUpsState()
+	assert.NoError(t, state.load(stateFile))
+	assert.NoError(t, state.setVar("ups.delay.shutdown", "120"))
+	assert.NoError(t, state.setBeeperStatus("muted"))
+
+	loadedState := NewUpsState()
+	assert.NoError(t, loadedState.load(stateFile))
+
+	value, ok := loadedState.getVar("ups.delay.shutdown")
+	assert.True(t, ok)
+	assert.Equal(t, "120", value)
+
+	status, ok := loadedState.getBeeperStatus()
+	assert.True(t, ok)
+	assert.Equal(t, "muted", status)
+}
+
+func TestUpsState_Load_Invalid(t *testing.T) {
+	stateFile := filepath.Join(t.TempDir(), "state.json")
+	assert.NoError(t, os.WriteFile(stateFile, []byte("no json"), 0600))
+
+	assert.Error(t, NewUpsState().load(stateFile))
+}
+
+func TestUpsState_Snapshot(t *testing.T) {
+	state := NewUpsState()
+
+	_, ok := state.getSnapshot(time.Minute)
+	assert.False(t, ok)
+
+	state.setSnapshot(map[string]string{"STATUS": "ONLINE"})
+	snapshot, ok := state.getSnapshot(time.Minute)
+	assert.True(t, ok)
+	assert.Equal(t, map[string]string{"STATUS": "ONLINE"}, snapshot)
+	_, ok = state.getSnapshot(0)
+	assert.False(t, ok)
+
+	state.invalidateValues()
+	_, ok = state.getSnapshot(time.Minute)
+	assert.False(t, ok)
+}
+
+func TestUpsState_Snapshot_Nil(t *testing.T) {
+	var state *UpsState
+
+	state.setSnapshot(map[string]string{"STATUS": "ONLINE"})
+	state.invalidateValues()
+	_, ok := state.getSnapshot(time.Minute)
+	assert.False(t, ok)
+}
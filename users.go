@@ -0,0 +1,221 @@
+// Copyright [2021] [Christian Bandowski]
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"github.com/pkg/errors"
+	"io"
+	"os"
+	"strings"
+)
+
+// User is a user that may authenticate by using the USERNAME and PASSWORD commands.
+type User struct {
+	name     string
+	password string
+
+	// actions the user may perform, like LOGIN or FSD
+	actions map[string]bool
+
+	// instant commands the user may execute, "ALL" allows all of them
+	instCmds map[string]bool
+}
+
+// actions granted by the upsmon setting, like upsd grants them
+var upsmonActions = map[string][]string{
+	"primary":   {"LOGIN", "MASTER", "FSD"},
+	"master":    {"LOGIN", "MASTER", "FSD"},
+	"secondary": {"LOGIN"},
+	"slave":     {"LOGIN"},
+}
+
+// loadUsersFile loads the users from the given file, which uses the format of the upsd.users file of NUT.
+func loadUsersFile(path string) (map[string]*User, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Couldn't open users file %s", path)
+	}
+	defer file.Close()
+
+	users, err := parseUsers(file)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Couldn't parse users file %s", path)
+	}
+
+	return users, nil
+}
+
+// parseUsers parses users in the format of the upsd.users file of NUT, e.g.
+//
+//	[monuser]
+//		password = secret
+//		upsmon primary
+//
+//	[admin]
+//		password_file = /run/secrets/admin
+//		actions = LOGIN SET
+//		instcmds = beeper.mute test.battery.start
+//
+// Besides the password setting the proxy supports password_file and password_env, which read the password from the
+// given file or environment variable, so it doesn't have to be stored in the users file.
+// The actions LOGIN and MASTER can be granted directly as well, users without any action are read-only.
+// Settings the proxy doesn't know are ignored.
+func parseUsers(reader io.Reader) (map[string]*User, error) {
+	users := make(map[string]*User)
+	var user *User
+
+	scanner := bufio.NewScanner(reader)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			name := strings.TrimSpace(line[1 : len(line)-1])
+			if name == "" {
+				return nil, errors.Errorf("Empty user name in line %d", lineNumber)
+			}
+
+			user = &User{name: name, actions: make(map[string]bool), instCmds: make(map[string]bool)}
+			users[name] = user
+			continue
+		}
+
+		if user == nil {
+			return nil, errors.Errorf("Setting outside of a user section in line %d", lineNumber)
+		}
+
+		key, value := parseUserSetting(line)
+		switch key {
+		case "password":
+			user.password = value
+		case "password_file":
+			password, err := readSecretFile(value)
+			if err != nil {
+				return nil, errors.Wrapf(err, "Invalid password file in line %d", lineNumber)
+			}
+			user.password = password
+		case "password_env":
+			password, err := readSecretEnv(value)
+			if err != nil {
+				return nil, errors.Wrapf(err, "Invalid password environment variable in line %d", lineNumber)
+			}
+			user.password = password
+		case "actions":
+			for _, action := range strings.Fields(value) {
+				user.actions[strings.ToUpper(action)] = true
+			}
+		case "instcmds":
+			for _, instCmd := range strings.Fields(strings.ReplaceAll(value, ",", " ")) {
+				user.instCmds[instCmd] = true
+			}
+		case "upsmon":
+			actions, ok := upsmonActions[strings.ToLower(value)]
+			if !ok {
+				return nil, errors.Errorf("Invalid upsmon mode %s in line %d", value, lineNumber)
+			}
+			for _, action := range actions {
+				user.actions[action] = true
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return users, nil
+}
+
+// parseUserSetting splits a line like "password = secret" into its key and value, the value may be quoted.
+func parseUserSetting(line string) (string, string) {
+	key := line
+	value := ""
+
+	if pos := strings.Index(line, "="); pos != -1 {
+		key = line[:pos]
+		value = strings.TrimSpace(line[pos+1:])
+	} else if pos := strings.IndexAny(line, " \t"); pos != -1 {
+		// settings like "upsmon primary" don't use an equals sign
+		key = line[:pos]
+		value = strings.TrimSpace(line[pos+1:])
+	}
+
+	if tokens, err := tokenize(value); err == nil && len(tokens) == 1 {
+		value = tokens[0]
+	}
+
+	return strings.ToLower(strings.TrimSpace(key)), value
+}
+
+// authenticate checks the given credentials. If no users are configured, authentication is disabled and all
+// credentials are accepted.
+func (c *Config) authenticate(username string, password string) bool {
+	if len(c.users) == 0 {
+		return true
+	}
+
+	user, ok := c.users[username]
+	if !ok {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(user.password), []byte(password)) == 1
+}
+
+// authenticatedUser returns the user identified by the client certificate or the credentials sent by the client, or
+// nil if the client couldn't be authenticated.
+func (c *Config) authenticatedUser(session *Session) *User {
+	if session.certUser != "" {
+		// the certificate was verified already, the sent credentials are irrelevant
+		return c.users[session.certUser]
+	}
+	if c.authenticate(session.username, session.password) {
+		return c.users[session.username]
+	}
+
+	return nil
+}
+
+// isPermitted checks whether the client may execute the command with the given route and arguments. Commands that
+// don't require credentials are permitted for everybody, all others require valid credentials, or a client certificate
+// of a known user, and the action of the command being granted to the user. All commands are permitted if
+// authentication is disabled.
+func (c *Config) isPermitted(session *Session, route commandRoute, args []string) bool {
+	if !credentialsRequired[route.verb] || len(c.users) == 0 {
+		return true
+	}
+
+	user := c.authenticatedUser(session)
+	if user == nil {
+		return false
+	}
+
+	action, ok := requiredActions[route]
+	if !ok {
+		return true
+	}
+	if action == "INSTCMD" {
+		// the command name is the second argument, missing arguments are rejected by the command itself
+		return len(args) < 2 || user.instCmds["ALL"] || user.instCmds[args[1]]
+	}
+
+	return user.actions[action]
+}
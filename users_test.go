@@ -0,0 +1,180 @@
+// Copyright [2021] [Christian Bandowski]
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseUsers(t *testing.T) {
+	input := `
+# users of the proxy
+[monuser]
+	password = secret
+	upsmon primary
+
+[admin]
+	password = "with spaces"
+	actions = SET fsd
+	instcmds = beeper.mute, test.battery.start
+
+[grafana]
+	password = secret
+`
+
+	users, err := parseUsers(strings.NewReader(input))
+
+	assert.NoError(t, err)
+	assert.Len(t, users, 3)
+	if assert.Contains(t, users, "monuser") {
+		assert.Equal(t, "monuser", users["monuser"].name)
+		assert.Equal(t, "secret", users["monuser"].password)
+		assert.Equal(t, map[string]bool{"LOGIN": true, "MASTER": true, "FSD": true}, users["monuser"].actions)
+	}
+	if assert.Contains(t, users, "admin") {
+		assert.Equal(t, "with spaces", users["admin"].password)
+		assert.Equal(t, map[string]bool{"SET": true, "FSD": true}, users["admin"].actions)
+		assert.Equal(t, map[string]bool{"beeper.mute": true, "test.battery.start": true}, users["admin"].instCmds)
+	}
+	if assert.Contains(t, users, "grafana") {
+		assert.Empty(t, users["grafana"].actions)
+		assert.Empty(t, users["grafana"].instCmds)
+	}
+}
+
+func TestParseUsers_SettingWithoutUser(t *testing.T) {
+	users, err := parseUsers(strings.NewReader("password = secret\n"))
+
+	assert.Nil(t, users)
+	assert.EqualError(t, err, "Setting outside of a user section in line 1")
+}
+
+func TestParseUsers_PasswordIndirection(t *testing.T) {
+	passwordFile := filepath.Join(t.TempDir(), "password")
+	assert.NoError(t, os.WriteFile(passwordFile, []byte("from file\n"), 0600))
+	assert.NoError(t, os.Setenv("APCUPSD_NUT_PROXY_TEST_PASSWORD", "from env"))
+	defer os.Unsetenv("APCUPSD_NUT_PROXY_TEST_PASSWORD")
+
+	users, err := parseUsers(strings.NewReader("[file]\npassword_file = " + passwordFile +
+		"\n[env]\npassword_env = APCUPSD_NUT_PROXY_TEST_PASSWORD\n"))
+
+	assert.NoError(t, err)
+	if assert.Len(t, users, 2) {
+		assert.Equal(t, "from file", users["file"].password)
+		assert.Equal(t, "from env", users["env"].password)
+	}
+}
+
+func TestParseUsers_PasswordEnvMissing(t *testing.T) {
+	users, err := parseUsers(strings.NewReader("[env]\npassword_env = APCUPSD_NUT_PROXY_TEST_MISSING\n"))
+
+	assert.Nil(t, users)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "Invalid password environment variable in line 2")
+	}
+}
+
+func TestParseUsers_InvalidUpsmon(t *testing.T) {
+	users, err := parseUsers(strings.NewReader("[monuser]\nupsmon boss\n"))
+
+	assert.Nil(t, users)
+	assert.EqualError(t, err, "Invalid upsmon mode boss in line 2")
+}
+
+func TestConfig_authenticate(t *testing.T) {
+	config := &Config{}
+
+	// without users all credentials are accepted
+	assert.True(t, config.authenticate("any", "thing"))
+
+	config.users = map[string]*User{
+		"monuser": {name: "monuser", password: "secret"},
+	}
+
+	assert.True(t, config.authenticate("monuser", "secret"))
+	assert.False(t, config.authenticate("monuser", "wrong"))
+	assert.False(t, config.authenticate("unknown", "secret"))
+}
+
+func TestConfig_isPermitted(t *testing.T) {
+	config := &Config{
+		users: map[string]*User{
+			"nas": {name: "nas", password: "secret",
+				actions: map[string]bool{"LOGIN": true, "FSD": true}},
+			"admin": {name: "admin", password: "secret",
+				actions: map[string]bool{"SET": true}, instCmds: map[string]bool{"beeper.mute": true}},
+			"root": {name: "root", password: "secret",
+				instCmds: map[string]bool{"ALL": true}},
+			"grafana": {name: "grafana", password: "secret"},
+		},
+	}
+
+	testCases := []struct {
+		username  string
+		password  string
+		route     commandRoute
+		args      []string
+		permitted bool
+	}{
+		{"nas", "secret", commandRoute{"LOGIN", ""}, []string{"ups"}, true},
+		{"nas", "secret", commandRoute{"FSD", ""}, []string{"ups"}, true},
+		{"nas", "secret", commandRoute{"MASTER", ""}, []string{"ups"}, false},
+		{"nas", "wrong", commandRoute{"LOGIN", ""}, []string{"ups"}, false},
+		{"admin", "secret", commandRoute{"SET", "VAR"}, []string{"ups", "foo", "1"}, true},
+		{"admin", "secret", commandRoute{"SET", "TRACKING"}, []string{"ON"}, true},
+		{"admin", "secret", commandRoute{"INSTCMD", ""}, []string{"ups", "beeper.mute"}, true},
+		{"admin", "secret", commandRoute{"INSTCMD", ""}, []string{"ups", "shutdown.return"}, false},
+		{"root", "secret", commandRoute{"INSTCMD", ""}, []string{"ups", "shutdown.return"}, true},
+		{"grafana", "secret", commandRoute{"LOGIN", ""}, []string{"ups"}, false},
+		{"grafana", "secret", commandRoute{"SET", "VAR"}, []string{"ups", "foo", "1"}, false},
+		{"", "", commandRoute{"LIST", "VAR"}, []string{"ups"}, true},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.username+" "+testCase.route.verb+" "+testCase.route.subVerb, func(t *testing.T) {
+			session := NewSession("127.0.0.1", NewSessionRegistry())
+			session.username = testCase.username
+			session.password = testCase.password
+
+			assert.Equal(t, testCase.permitted, config.isPermitted(session, testCase.route, testCase.args))
+		})
+	}
+}
+
+func TestConfig_isPermitted_NoUsers(t *testing.T) {
+	assert.True(t, (&Config{}).isPermitted(NewSession("127.0.0.1", NewSessionRegistry()),
+		commandRoute{"FSD", ""}, []string{"ups"}))
+}
+
+func TestConfig_isPermitted_ClientCertificate(t *testing.T) {
+	config := &Config{
+		users: map[string]*User{
+			"nas": {name: "nas", password: "secret", actions: map[string]bool{"LOGIN": true}},
+		},
+	}
+
+	session := NewSession("127.0.0.1", NewSessionRegistry())
+	session.certUser = "nas"
+	assert.True(t, config.isPermitted(session, commandRoute{"LOGIN", ""}, []string{"ups"}))
+
+	session.certUser = "unknown"
+	session.username = "nas"
+	session.password = "secret"
+	assert.False(t, config.isPermitted(session, commandRoute{"LOGIN", ""}, []string{"ups"}))
+}
@@ -0,0 +1,249 @@
+// Copyright [2021] [Christian Bandowski]
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// VarType is the type of the value of a NUT variable.
+type VarType int
+
+// supported variable types, like in NUT variables are numbers unless defined otherwise
+const (
+	VarTypeNumber VarType = iota
+	VarTypeString
+)
+
+// VarInfo contains the metadata of a NUT variable, like whether it can be written by clients.
+type VarInfo struct {
+	// human-readable description, e.g. "Battery charge (percent of full)"
+	description string
+
+	// type of the value
+	varType VarType
+
+	// maximum length of string values
+	maxLength int
+
+	// whether the variable can be changed by using SET VAR
+	writable bool
+
+	// discrete values the variable can have, empty if the variable isn't enumerated
+	enum []string
+
+	// ranges of the values a numeric variable can have, empty if the variable has no known range
+	ranges []VarRange
+}
+
+// VarRange is an inclusive range of values a numeric variable can have.
+type VarRange struct {
+	min int
+	max int
+}
+
+// defaultVarInfos returns the metadata of the NUT variables, variables without an entry use the zero value.
+func defaultVarInfos() map[string]VarInfo {
+	return map[string]VarInfo{
+		"device.mfr": {description: "Device manufacturer",
+			varType: VarTypeString, maxLength: 64},
+		"device.model": {description: "Device model",
+			varType: VarTypeString, maxLength: 64},
+		"device.serial": {description: "Device serial number",
+			varType: VarTypeString, maxLength: 32},
+		"device.type": {description: "Device type",
+			varType: VarTypeString, maxLength: 16},
+
+		"ups.mfr": {description: "UPS manufacturer",
+			varType: VarTypeString, maxLength: 64},
+		"ups.mfr.date": {description: "UPS manufacturing date",
+			varType: VarTypeString, maxLength: 16},
+		"ups.id": {description: "UPS system identifier",
+			varType: VarTypeString, maxLength: 16},
+		"ups.vendorid": {description: "Vendor ID for USB devices",
+			varType: VarTypeString, maxLength: 8},
+		"ups.model": {description: "UPS model",
+			varType: VarTypeString, maxLength: 64},
+		"ups.status": {description: "UPS status",
+			varType: VarTypeString, maxLength: 64},
+		"ups.load": {description: "Load on UPS (percent)"},
+		"ups.serial": {description: "UPS serial number",
+			varType: VarTypeString, maxLength: 32},
+		"ups.firmware": {description: "UPS firmware",
+			varType: VarTypeString, maxLength: 32},
+		"ups.firmware.aux": {description: "Auxiliary device firmware",
+			varType: VarTypeString, maxLength: 32},
+		"ups.productid": {description: "Product ID for USB devices",
+			varType: VarTypeString, maxLength: 32},
+		"ups.temperature":       {description: "UPS temperature (degrees C)"},
+		"ups.realpower.nominal": {description: "UPS real power rating (W)"},
+		"ups.test.result": {description: "Results of last self test",
+			varType: VarTypeString, maxLength: 64},
+		"ups.delay.start":    {description: "Interval to wait before (re)starting the load (seconds)"},
+		"ups.delay.shutdown": {description: "Interval to wait after shutdown with delay command (seconds)"},
+		"ups.timer.reboot":   {description: "Time before the load will be rebooted (seconds)"},
+		"ups.timer.start":    {description: "Time before the load will be started (seconds)"},
+		"ups.timer.shutdown": {description: "Time before the load will be shutdown (seconds)"},
+		"ups.beeper.status": {description: "UPS beeper status",
+			varType: VarTypeString, maxLength: 16, enum: []string{"enabled", "disabled", "muted"}},
+
+		"battery.runtime":     {description: "Battery runtime (seconds)"},
+		"battery.runtime.low": {description: "Remaining battery runtime when UPS switches to LB (seconds)"},
+		"battery.charge": {description: "Battery charge (percent of full)",
+			ranges: []VarRange{{0, 100}}},
+		"battery.charge.low": {description: "Remaining battery level when UPS switches to LB (percent)",
+			ranges: []VarRange{{0, 100}}},
+		"battery.charge.warning":  {description: "Battery level when UPS switches to Warning state (percent)"},
+		"battery.voltage":         {description: "Battery voltage (V)"},
+		"battery.voltage.nominal": {description: "Nominal battery voltage (V)"},
+		"battery.date": {description: "Battery installation or last change date",
+			varType: VarTypeString, maxLength: 16},
+		"battery.mfr.date": {description: "Battery manufacturing date",
+			varType: VarTypeString, maxLength: 16},
+		"battery.temperature": {description: "Battery temperature (degrees C)"},
+		"battery.type": {description: "Battery chemistry",
+			varType: VarTypeString, maxLength: 16},
+
+		"driver.name": {description: "Driver name",
+			varType: VarTypeString, maxLength: 32},
+		"driver.version.internal": {description: "Internal driver version",
+			varType: VarTypeString, maxLength: 64},
+		"driver.version.date": {description: "Driver version date",
+			varType: VarTypeString, maxLength: 64},
+		"driver.parameter.pollfreq":     {description: "Polling frequency for full updates (seconds)"},
+		"driver.parameter.pollinterval": {description: "Polling interval for status updates (seconds)"},
+
+		"input.voltage":         {description: "Input voltage (V)"},
+		"input.voltage.nominal": {description: "Nominal input voltage (V)"},
+		"input.sensitivity": {description: "Input power sensitivity",
+			varType: VarTypeString, maxLength: 16, enum: []string{"High", "Medium", "Low", "Auto Adjust"}},
+		// the transfer voltages depend on whether the UPS is a 120 V or 230 V model
+		"input.transfer.high": {description: "High voltage transfer point (V)",
+			ranges: []VarRange{{120, 145}, {240, 300}}},
+		"input.transfer.low": {description: "Low voltage transfer point (V)",
+			ranges: []VarRange{{75, 110}, {150, 220}}},
+		"input.frequency": {description: "Input line frequency (Hz)"},
+		"input.transfer.reason": {description: "Reason for last transfer to battery",
+			varType: VarTypeString, maxLength: 64},
+
+		"output.voltage":         {description: "Output voltage (V)"},
+		"output.voltage.nominal": {description: "Nominal output voltage (V)"},
+
+		"server.info": {description: "Server information",
+			varType: VarTypeString, maxLength: 64},
+
+		"proxy.source.status": {description: "Status of the last load from the data source",
+			varType: VarTypeString, maxLength: 16, enum: []string{"ok", "error"}},
+		"proxy.source.last_success": {description: "Time of the last successful load from the data source",
+			varType: VarTypeString, maxLength: 32},
+		"proxy.source.error": {description: "Error of the last load from the data source",
+			varType: VarTypeString, maxLength: 256},
+		"proxy.data.age":   {description: "Time since the values were loaded successfully (seconds)"},
+		"proxy.data.stale": {description: "Whether the last known values are used as the data source fails"},
+	}
+}
+
+// varNames returns the names of all variables, sorted by name.
+func (c *Config) varNames() []string {
+	names := make([]string, 0, len(c.vars))
+	for name := range c.vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// writableVarNames returns the names of all variables that are marked as writable, sorted by name.
+func (c *Config) writableVarNames() []string {
+	names := make([]string, 0)
+	for name, info := range c.varInfos {
+		if _, ok := c.vars[name]; ok && info.writable {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// typeDescription returns the type of the variable as expected by the GET TYPE command, e.g. "RW STRING:16".
+func (info VarInfo) typeDescription() string {
+	var types []string
+	if info.writable {
+		types = append(types, "RW")
+	}
+
+	if len(info.enum) > 0 {
+		types = append(types, "ENUM")
+	} else if len(info.ranges) > 0 {
+		types = append(types, "RANGE")
+	} else if info.varType == VarTypeString {
+		types = append(types, fmt.Sprintf("STRING:%d", info.maxLength))
+	} else {
+		types = append(types, "NUMBER")
+	}
+
+	return strings.Join(types, " ")
+}
+
+// descriptionOrDefault returns the description of the variable, or the text NUT uses if there is no description.
+func (info VarInfo) descriptionOrDefault() string {
+	if info.description == "" {
+		return "Description unavailable"
+	}
+
+	return info.description
+}
+
+// valueError checks whether the value is valid for the variable and returns the NUT error if it isn't, or an empty
+// string if the value is valid.
+func (info VarInfo) valueError(value string) string {
+	if len(info.enum) > 0 {
+		for _, enumValue := range info.enum {
+			if value == enumValue {
+				return ""
+			}
+		}
+
+		return "ERR INVALID-VALUE"
+	}
+
+	if info.varType == VarTypeString {
+		if info.maxLength > 0 && len(value) > info.maxLength {
+			return "ERR TOO-LONG"
+		}
+
+		return ""
+	}
+
+	number, err := strconv.Atoi(value)
+	if err != nil {
+		return "ERR INVALID-VALUE"
+	}
+	if len(info.ranges) == 0 {
+		return ""
+	}
+	for _, r := range info.ranges {
+		if number >= r.min && number <= r.max {
+			return ""
+		}
+	}
+
+	return "ERR INVALID-VALUE"
+}
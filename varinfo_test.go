@@ -0,0 +1,117 @@
+// Copyright [2021] [Christian Bandowski]
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestConfig_varNames(t *testing.T) {
+	config := &Config{
+		vars: map[string]VarLoader{
+			"b": IgnoreValue,
+			"c": IgnoreValue,
+			"a": IgnoreValue,
+		},
+	}
+
+	assert.Equal(t, []string{"a", "b", "c"}, config.varNames())
+}
+
+func TestConfig_writableVarNames(t *testing.T) {
+	config := &Config{
+		vars: map[string]VarLoader{
+			"b":        IgnoreValue,
+			"a":        IgnoreValue,
+			"readonly": IgnoreValue,
+		},
+		varInfos: map[string]VarInfo{
+			"b":        {writable: true},
+			"a":        {writable: true},
+			"readonly": {},
+			"unknown":  {writable: true},
+		},
+	}
+
+	assert.Equal(t, []string{"a", "b"}, config.writableVarNames())
+}
+
+func TestDefaultVarInfos_NoneWritable(t *testing.T) {
+	config := &Config{
+		vars:     defaultVars(),
+		varInfos: defaultVarInfos(),
+	}
+
+	assert.Empty(t, config.writableVarNames())
+}
+
+func TestVarInfo_typeDescription(t *testing.T) {
+	infoToResult := map[string]struct {
+		info      VarInfo
+		expResult string
+	}{
+		"number":    {VarInfo{}, "NUMBER"},
+		"string":    {VarInfo{varType: VarTypeString, maxLength: 16}, "STRING:16"},
+		"rw string": {VarInfo{varType: VarTypeString, maxLength: 8, writable: true}, "RW STRING:8"},
+		"enum":      {VarInfo{varType: VarTypeString, enum: []string{"a"}}, "ENUM"},
+		"rw range":  {VarInfo{writable: true, ranges: []VarRange{{0, 100}}}, "RW RANGE"},
+		"rw number": {VarInfo{writable: true}, "RW NUMBER"},
+	}
+
+	for name, testCase := range infoToResult {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, testCase.expResult, testCase.info.typeDescription())
+		})
+	}
+}
+
+func TestVarInfo_descriptionOrDefault(t *testing.T) {
+	assert.Equal(t, "Battery charge", VarInfo{description: "Battery charge"}.descriptionOrDefault())
+	assert.Equal(t, "Description unavailable", VarInfo{}.descriptionOrDefault())
+}
+
+func TestDefaultVarInfos_Descriptions(t *testing.T) {
+	varInfos := defaultVarInfos()
+
+	for name := range defaultVars() {
+		t.Run(name, func(t *testing.T) {
+			assert.NotEmpty(t, varInfos[name].description)
+		})
+	}
+}
+
+func TestVarInfo_valueError(t *testing.T) {
+	testCases := []struct {
+		info     VarInfo
+		value    string
+		expError string
+	}{
+		{VarInfo{}, "42", ""},
+		{VarInfo{}, "abc", "ERR INVALID-VALUE"},
+		{VarInfo{ranges: []VarRange{{0, 10}, {20, 30}}}, "25", ""},
+		{VarInfo{ranges: []VarRange{{0, 10}, {20, 30}}}, "15", "ERR INVALID-VALUE"},
+		{VarInfo{enum: []string{"a", "b"}}, "b", ""},
+		{VarInfo{enum: []string{"a", "b"}}, "c", "ERR INVALID-VALUE"},
+		{VarInfo{varType: VarTypeString, maxLength: 3}, "abc", ""},
+		{VarInfo{varType: VarTypeString, maxLength: 3}, "abcd", "ERR TOO-LONG"},
+	}
+
+	for _, testCase := range testCases {
+		t.Run("value="+testCase.value, func(t *testing.T) {
+			assert.Equal(t, testCase.expError, testCase.info.valueError(testCase.value))
+		})
+	}
+}
@@ -17,8 +17,10 @@ package main
 import (
 	"fmt"
 	"github.com/pkg/errors"
+	"math"
 	"strconv"
 	"strings"
+	"time"
 )
 
 // A VarLoader is a function that will be attached to NUT variables and load these values. It can access the
@@ -67,6 +69,32 @@ func ApcValue(apcKey string, fallback VarLoader) func(name string, config *Confi
 	}
 }
 
+// MemoizedValue is a function that creates a VarLoader which evaluates the given VarLoader once per request for all
+// variables using the same key, as long as the apc values are memoized.
+func MemoizedValue(key string, loader VarLoader) func(name string, config *Config, av IApcValues) (string, error) {
+	return func(name string, config *Config, av IApcValues) (string, error) {
+		memoized, ok := av.(*MemoizedApcValues)
+		if !ok {
+			return loader(name, config, av)
+		}
+
+		return memoized.memoize(key, func() (string, error) { return loader(name, config, av) })
+	}
+}
+
+// LocalValue is a function that creates a VarLoader which retrieves the value written by a client by using SET VAR.
+// The fallback is used as long as no value was written.
+func LocalValue(fallback VarLoader) func(name string, config *Config, av IApcValues) (string, error) {
+	return func(name string, config *Config, av IApcValues) (string, error) {
+		value, ok := config.state.getVar(name)
+		if !ok {
+			return fallback(name, config, av)
+		}
+
+		return value, nil
+	}
+}
+
 // ApcValueMinInSec is a function that creates a VarLoader that retrieves an apc value by its key, converts it to a
 // float and returns this one multiplied by 60. Assuming the apc value is in minutes, this will ensure the result is in
 // minutes.
@@ -131,6 +159,21 @@ func UpsStatus(name string, config *Config, av IApcValues) (string, error) {
 		return "", nil
 	}
 
+	if config.state.isForcedShutdown() {
+		// clients requested a forced shutdown, report it before the status retrieved from apcupsd
+		status, err := upsStatusFromApcStatus(value, name, config, av)
+		if err != nil || status == "" {
+			return "FSD", err
+		}
+
+		return "FSD " + status, nil
+	}
+
+	return upsStatusFromApcStatus(value, name, config, av)
+}
+
+// upsStatusFromApcStatus converts the apcupsd status to the corresponding NUT status.
+func upsStatusFromApcStatus(value string, name string, config *Config, av IApcValues) (string, error) {
 	if strings.Contains(value, "ONLINE") {
 		// use CHRG prefix in case the battery is charging (BCHARGE < 100)
 		chargingValue, err := ApcValue("BCHARGE", IgnoreValue)(name, config, av)
@@ -192,3 +235,133 @@ func UpsSelfTest(name string, config *Config, av IApcValues) (string, error) {
 
 	return IgnoreValue(name, config, av)
 }
+
+// UpsBeeperStatus is a VarLoader that returns the UPS beeper status set by using the beeper instant commands, or the
+// status based on the configured alarm delay if it was never changed.
+func UpsBeeperStatus(name string, config *Config, av IApcValues) (string, error) {
+	if status, ok := config.state.getBeeperStatus(); ok {
+		return status, nil
+	}
+
+	value, err := ApcValue("ALARMDEL", IgnoreValue)(name, config, av)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	if value == "" {
+		return "", nil
+	}
+
+	value = strings.ToLower(value)
+	if strings.Contains(value, "no alarm") {
+		return "disabled", nil
+	}
+	if strings.Contains(value, "low battery") {
+		// the beeper is quiet until the battery is low
+		return "muted", nil
+	}
+
+	return "enabled", nil
+}
+
+// SourceStatus is a VarLoader that returns whether the last load of the values from the data source succeeded, "ok"
+// or "error". It is empty until the values were loaded.
+func SourceStatus(name string, config *Config, av IApcValues) (string, error) {
+	loaded, sourceError, _ := config.state.getSourceHealth()
+	if !loaded {
+		return "", nil
+	}
+	if sourceError != "" {
+		return "error", nil
+	}
+
+	return "ok", nil
+}
+
+// SourceLastSuccess is a VarLoader that returns the time the values were loaded successfully from the data source the
+// last time.
+func SourceLastSuccess(name string, config *Config, av IApcValues) (string, error) {
+	_, _, lastSuccess := config.state.getSourceHealth()
+	if lastSuccess.IsZero() {
+		return "", nil
+	}
+
+	return lastSuccess.Format(time.RFC3339), nil
+}
+
+// SourceError is a VarLoader that returns the error of the last load of the values from the data source, if it failed.
+func SourceError(name string, config *Config, av IApcValues) (string, error) {
+	_, sourceError, _ := config.state.getSourceHealth()
+	return sourceError, nil
+}
+
+// DataAge is a VarLoader that returns the seconds since the values were loaded successfully from the data source.
+func DataAge(name string, config *Config, av IApcValues) (string, error) {
+	_, _, lastSuccess := config.state.getSourceHealth()
+	if lastSuccess.IsZero() {
+		return "", nil
+	}
+
+	return strconv.Itoa(int(time.Since(lastSuccess).Seconds())), nil
+}
+
+// DataStale is a VarLoader that returns 1 if the last values loaded successfully are used, because the data source
+// fails, otherwise 0.
+func DataStale(name string, config *Config, av IApcValues) (string, error) {
+	loaded, sourceError, _ := config.state.getSourceHealth()
+	if !loaded {
+		return "", nil
+	}
+	if sourceError != "" {
+		return "1", nil
+	}
+
+	return "0", nil
+}
+
+// default poll settings of the usbhid-ups driver, reported if the values are loaded for every request
+const (
+	defaultDriverPollInterval = "10"
+	defaultDriverPollFreq     = "60"
+)
+
+// DriverPollInterval is a VarLoader that returns the minimum time between loading the values in seconds, or the
+// default of the driver if they are loaded for every request.
+func DriverPollInterval(name string, config *Config, av IApcValues) (string, error) {
+	if interval := reloadInterval(config); interval > 0 {
+		return durationSeconds(interval), nil
+	}
+
+	return defaultDriverPollInterval, nil
+}
+
+// DriverPollFreq is a VarLoader that returns the interval of the background polling in seconds, or the minimum time
+// between loading the values if they aren't polled in the background.
+func DriverPollFreq(name string, config *Config, av IApcValues) (string, error) {
+	if config.backgroundPollInterval > 0 {
+		return durationSeconds(config.backgroundPollInterval), nil
+	}
+	if interval := reloadInterval(config); interval > 0 {
+		return durationSeconds(interval), nil
+	}
+
+	return defaultDriverPollFreq, nil
+}
+
+// reloadInterval returns the minimum time between loading the values, which is the longest of the poll interval, the
+// minimum reload interval and the cache TTL.
+func reloadInterval(config *Config) time.Duration {
+	interval := config.pollInterval
+	if config.minReloadInterval > interval && !config.singleValueRequests {
+		interval = config.minReloadInterval
+	}
+	if config.cacheTTL > interval {
+		interval = config.cacheTTL
+	}
+
+	return interval
+}
+
+// durationSeconds returns the duration in whole seconds, rounded up.
+func durationSeconds(duration time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(duration.Seconds())))
+}
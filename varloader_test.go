@@ -18,6 +18,7 @@ import (
 	"errors"
 	"github.com/stretchr/testify/assert"
 	"testing"
+	"time"
 )
 
 // mocks
@@ -72,6 +73,21 @@ func TestApcValue_Fallback_Error(t *testing.T) {
 	assert.EqualError(t, err, "FailingVarLoader")
 }
 
+func TestLocalValue(t *testing.T) {
+	state := NewUpsState()
+	config := &Config{state: state}
+
+	result, err := LocalValue(FixedValue("10"))("battery.charge.low", config, &ApcValues{})
+	assert.NoError(t, err)
+	assert.Equal(t, "10", result)
+
+	assert.NoError(t, state.setVar("battery.charge.low", "30"))
+
+	result, err = LocalValue(FixedValue("10"))("battery.charge.low", config, &ApcValues{})
+	assert.NoError(t, err)
+	assert.Equal(t, "30", result)
+}
+
 func TestFormattedValue(t *testing.T) {
 	result, err := FormattedValue("format %s", SucceedingVarLoader)("name", &Config{}, &ApcValues{})
 
@@ -171,6 +187,20 @@ func TestUpsStatus_OnlineWithBCharge(t *testing.T) {
 	assert.Equal(t, "CHRG ONLINE", result)
 }
 
+func TestUpsStatus_ForcedShutdown(t *testing.T) {
+	state := NewUpsState()
+	state.setForcedShutdown()
+
+	result, err := UpsStatus("name", &Config{state: state}, &ApcValues{
+		values: map[string]string{
+			"STATUS": "ONBATT",
+		},
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, "FSD OB DISCHRG ONBATT", result)
+}
+
 func TestUpsSelfTest(t *testing.T) {
 	statusToResult := map[string]string{
 		"OK": "OK - Battery GOOD",
@@ -235,3 +265,147 @@ func TestApcValueMinInSec_Fallback(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, "60", result)
 }
+
+func TestUpsBeeperStatus(t *testing.T) {
+	alarmDelToResult := map[string]string{
+		"30 Seconds":  "enabled",
+		"5 Seconds":   "enabled",
+		"Always":      "enabled",
+		"Low Battery": "muted",
+		"No alarm":    "disabled",
+	}
+
+	for alarmDel, expResult := range alarmDelToResult {
+		t.Run("ALARMDEL="+alarmDel, func(t *testing.T) {
+			result, err := UpsBeeperStatus("name", &Config{}, &ApcValues{
+				values: map[string]string{
+					"ALARMDEL": alarmDel,
+				},
+			})
+
+			assert.NoError(t, err)
+			assert.Equal(t, expResult, result)
+		})
+	}
+}
+
+func TestUpsBeeperStatus_Missing(t *testing.T) {
+	result, err := UpsBeeperStatus("name", &Config{}, &ApcValues{
+		values: map[string]string{},
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, "", result)
+}
+
+func TestUpsBeeperStatus_LocalState(t *testing.T) {
+	state := NewUpsState()
+	assert.NoError(t, state.setBeeperStatus("muted"))
+
+	result, err := UpsBeeperStatus("name", &Config{state: state}, &ApcValues{
+		values: map[string]string{
+			"ALARMDEL": "30 Seconds",
+		},
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, "muted", result)
+}
+
+func TestSourceHealth(t *testing.T) {
+	config := &Config{state: NewUpsState()}
+	load := func() (string, string, string) {
+		status, err := SourceStatus("proxy.source.status", config, nil)
+		assert.NoError(t, err)
+		lastSuccess, err := SourceLastSuccess("proxy.source.last_success", config, nil)
+		assert.NoError(t, err)
+		sourceError, err := SourceError("proxy.source.error", config, nil)
+		assert.NoError(t, err)
+		return status, lastSuccess, sourceError
+	}
+
+	status, lastSuccess, sourceError := load()
+	assert.Equal(t, "", status)
+	assert.Equal(t, "", lastSuccess)
+	assert.Equal(t, "", sourceError)
+
+	config.state.recordSourceLoad(nil)
+	status, lastSuccess, sourceError = load()
+	assert.Equal(t, "ok", status)
+	assert.NotEmpty(t, lastSuccess)
+	assert.Equal(t, "", sourceError)
+
+	config.state.recordSourceLoad(errors.New("apcupsd not reachable"))
+	status, lastSuccessAfterError, sourceError := load()
+	assert.Equal(t, "error", status)
+	assert.Equal(t, lastSuccess, lastSuccessAfterError)
+	assert.Equal(t, "apcupsd not reachable", sourceError)
+}
+
+func TestMemoizedValue(t *testing.T) {
+	calls := 0
+	loader := MemoizedValue("key", func(name string, config *Config, av IApcValues) (string, error) {
+		calls++
+		return name, nil
+	})
+	memoized := newMemoizedApcValues(NewApcValues())
+
+	first, err := loader("first", &Config{}, memoized)
+	assert.NoError(t, err)
+	second, err := loader("second", &Config{}, memoized)
+	assert.NoError(t, err)
+
+	assert.Equal(t, "first", first)
+	assert.Equal(t, "first", second)
+	assert.Equal(t, 1, calls)
+
+	// without memoized apc values the loader is evaluated each time
+	result, err := loader("third", &Config{}, NewApcValues())
+	assert.NoError(t, err)
+	assert.Equal(t, "third", result)
+	assert.Equal(t, 2, calls)
+}
+
+func TestMemoizedValue_Error(t *testing.T) {
+	calls := 0
+	loader := MemoizedValue("key", func(name string, config *Config, av IApcValues) (string, error) {
+		calls++
+		return "", errors.New("failed")
+	})
+	memoized := newMemoizedApcValues(NewApcValues())
+
+	_, err := loader("first", &Config{}, memoized)
+	assert.EqualError(t, err, "failed")
+	_, err = loader("second", &Config{}, memoized)
+	assert.EqualError(t, err, "failed")
+
+	assert.Equal(t, 2, calls)
+}
+
+func TestDriverPollSettings(t *testing.T) {
+	testCases := []struct {
+		name         string
+		config       *Config
+		pollInterval string
+		pollFreq     string
+	}{
+		{"loaded for every request", &Config{}, "10", "60"},
+		{"poll interval", &Config{pollInterval: 1500 * time.Millisecond}, "2", "2"},
+		{"min reload interval", &Config{minReloadInterval: 2 * time.Second}, "2", "2"},
+		{"single value requests", &Config{minReloadInterval: 2 * time.Second, singleValueRequests: true}, "10", "60"},
+		{"cache TTL", &Config{minReloadInterval: 2 * time.Second, cacheTTL: 30 * time.Second}, "30", "30"},
+		{"background poll", &Config{pollInterval: 5 * time.Second, backgroundPollInterval: time.Minute}, "5", "60"},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			pollInterval, err := DriverPollInterval("driver.parameter.pollinterval", testCase.config, nil)
+			assert.NoError(t, err)
+			assert.Equal(t, testCase.pollInterval, pollInterval)
+
+			pollFreq, err := DriverPollFreq("driver.parameter.pollfreq", testCase.config, nil)
+			assert.NoError(t, err)
+			assert.Equal(t, testCase.pollFreq, pollFreq)
+		})
+	}
+}
@@ -0,0 +1,249 @@
+// Copyright [2021] [Christian Bandowski]
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"github.com/pkg/errors"
+	"strings"
+)
+
+// parseVarMapping parses a variable mapping given as name followed by the loader, like "ups.load=apc:LOADPCT", see
+// parseVarLoader.
+func parseVarMapping(mapping string, vars map[string]VarLoader) (string, VarLoader, error) {
+	i := strings.Index(mapping, "=")
+	if i <= 0 {
+		return "", nil, errors.Errorf("Invalid variable mapping %s, must be <name>=<loader>", mapping)
+	}
+	name := strings.TrimSpace(mapping[:i])
+
+	loader, err := parseVarLoader(strings.TrimSpace(mapping[i+1:]), vars)
+	if err != nil {
+		return "", nil, errors.Wrapf(err, "Invalid mapping of variable %s", name)
+	}
+
+	return name, loader, nil
+}
+
+// parseVarLoader parses the loader of a variable mapping. Supported loaders are:
+//
+//	apc:<key>[|<fallback>]      value of the apc key, the fallback loader is used if apcupsd doesn't report it
+//	minutes:<key>[|<fallback>]  value of the apc key in minutes, converted to seconds
+//	fixed:<value>               fixed value
+//	var:<name>                  loader of another variable, as it is configured before the mapping is applied
+//	expr:<expression>           number computed from the apc values, e.g. "LOADPCT / 100 * NOMPOWER", see
+//	                            parseExpression
+//
+// Values are empty if apcupsd doesn't report the key and there is no fallback. The loader may be followed by
+// conversion rules separated by ";", like "apc:ITEMP;celsius-to-fahrenheit;precision:1", see parseConversion.
+func parseVarLoader(spec string, vars map[string]VarLoader) (VarLoader, error) {
+	rules := strings.Split(spec, ";")
+	loader, err := parseBaseVarLoader(strings.TrimSpace(rules[0]), vars)
+	if err != nil || len(rules) == 1 {
+		return loader, err
+	}
+
+	conversions := make([]conversion, len(rules)-1)
+	for i, rule := range rules[1:] {
+		if conversions[i], err = parseConversion(strings.TrimSpace(rule)); err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+
+	return ConvertedValue(loader, conversions...), nil
+}
+
+// parseBaseVarLoader parses the loader of a variable mapping without its conversion rules.
+func parseBaseVarLoader(spec string, vars map[string]VarLoader) (VarLoader, error) {
+	i := strings.Index(spec, ":")
+	if i < 0 {
+		return nil, errors.Errorf("Invalid loader %s, must be <type>:<argument>", spec)
+	}
+	loaderType, arg := spec[:i], spec[i+1:]
+
+	switch loaderType {
+	case "apc", "minutes":
+		key, fallback, err := parseApcKey(arg, vars)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		if loaderType == "minutes" {
+			return ApcValueMinInSec(key, fallback), nil
+		}
+
+		return ApcValue(key, fallback), nil
+	case "fixed":
+		return FixedValue(arg), nil
+	case "expr":
+		expr, err := parseExpression(arg)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		return ComputedValue(expr), nil
+	case "var":
+		loader, ok := vars[arg]
+		if !ok {
+			return nil, errors.Errorf("Unknown variable %s", arg)
+		}
+
+		return loader, nil
+	default:
+		return nil, errors.Errorf(
+			"Unknown loader type %s, must be \"apc\", \"minutes\", \"fixed\", \"var\" or \"expr\"", loaderType)
+	}
+}
+
+// parseApcKey parses the apc key of a loader, optionally followed by the fallback loader separated by "|".
+func parseApcKey(arg string, vars map[string]VarLoader) (string, VarLoader, error) {
+	key, fallbackSpec := arg, ""
+	if i := strings.Index(arg, "|"); i >= 0 {
+		key, fallbackSpec = arg[:i], arg[i+1:]
+	}
+	if key == "" {
+		return "", nil, errors.New("Missing apc key")
+	}
+	if fallbackSpec == "" {
+		return key, IgnoreValue, nil
+	}
+
+	fallback, err := parseVarLoader(fallbackSpec, vars)
+	if err != nil {
+		return "", nil, errors.Wrap(err, "Invalid fallback")
+	}
+
+	return key, fallback, nil
+}
+
+// applyVarMappings replaces the loaders of the variables by the configured mappings, variables that don't exist yet
+// are added. Invalid mappings are skipped, they are reported by validate.
+func (c *Config) applyVarMappings() {
+	for _, mapping := range splitList(c.varMappings) {
+		name, loader, err := parseVarMapping(mapping, c.vars)
+		if err != nil {
+			continue
+		}
+
+		c.vars[name] = loader
+	}
+}
+
+// mapVar replaces the loader of the variable of the UPS by the given one. The variables are shared with the global
+// configuration, so they are copied first. Variables clients may write keep being overridden by the written values.
+func (c *Config) mapVar(name string, spec string) error {
+	loader, err := parseVarLoader(spec, c.vars)
+	if err != nil {
+		return errors.Wrapf(err, "Invalid mapping of variable %s", name)
+	}
+
+	for _, writable := range splitList(c.writableVars) {
+		if writable == name && localWritableVars[name] {
+			loader = LocalValue(loader)
+		}
+	}
+
+	vars := make(map[string]VarLoader, len(c.vars)+1)
+	for varName, varLoader := range c.vars {
+		vars[varName] = varLoader
+	}
+	vars[name] = loader
+	c.vars = vars
+
+	return nil
+}
+
+// parseStaticVar parses a static variable given as name followed by its value, like "device.location=Basement".
+func parseStaticVar(staticVar string) (string, string, error) {
+	i := strings.Index(staticVar, "=")
+	if i <= 0 {
+		return "", "", errors.Errorf("Invalid static variable %s, must be <name>=<value>", staticVar)
+	}
+
+	return strings.TrimSpace(staticVar[:i]), strings.TrimSpace(staticVar[i+1:]), nil
+}
+
+// staticVarList returns the static variables of -static-vars followed by the ones given by -static-var.
+func (c *Config) staticVarList() []string {
+	return append(splitList(c.staticVars), c.staticVarSpecs...)
+}
+
+// applyStaticVars adds the configured static variables, replacing the variables with the same name. Invalid ones are
+// skipped, they are reported by validate.
+func (c *Config) applyStaticVars() {
+	for _, staticVar := range c.staticVarList() {
+		name, value, err := parseStaticVar(staticVar)
+		if err != nil {
+			continue
+		}
+
+		c.overrideVar(name, value)
+	}
+}
+
+// A varOption is an option of the UPS changing its variables, see setUpsOption.
+type varOption struct {
+	name  string
+	value string
+}
+
+// isVarOption returns whether the UPS option with the given name changes the variables, like exclude, var.<name> and
+// map.<name>.
+func isVarOption(name string) bool {
+	return name == "exclude" ||
+		strings.HasPrefix(name, "var.") && len(name) > len("var.") ||
+		strings.HasPrefix(name, "map.") && len(name) > len("map.")
+}
+
+// applyVarOption changes the variables of the UPS as given by the option.
+func (c *Config) applyVarOption(option varOption) error {
+	switch {
+	case option.name == "exclude":
+		c.excludeVars(strings.Fields(option.value))
+	case strings.HasPrefix(option.name, "var."):
+		c.overrideVar(strings.TrimPrefix(option.name, "var."), option.value)
+	case strings.HasPrefix(option.name, "map."):
+		return c.mapVar(strings.TrimPrefix(option.name, "map."), option.value)
+	}
+
+	return nil
+}
+
+// reapplyVarOptions applies the mappings, static variables and exclusions of the global configuration and the UPS
+// options again, after the variables were replaced by the ones of an upstream UPS.
+func (c *Config) reapplyVarOptions() {
+	c.applyVarMappings()
+	c.applyStaticVars()
+	c.excludeVars(splitList(c.excludedVars))
+	for _, option := range c.varOptions {
+		// invalid options were rejected already while parsing the UPS
+		_ = c.applyVarOption(option)
+	}
+}
+
+// excludeVars removes the given variables, they aren't listed anymore and reading them fails with VAR-NOT-SUPPORTED.
+// The variables are shared with the global configuration of the UPSes, so they are copied first.
+func (c *Config) excludeVars(names []string) {
+	if len(names) == 0 {
+		return
+	}
+
+	vars := make(map[string]VarLoader, len(c.vars))
+	for varName, loader := range c.vars {
+		vars[varName] = loader
+	}
+	for _, name := range names {
+		delete(vars, name)
+	}
+	c.vars = vars
+}
@@ -0,0 +1,137 @@
+// Copyright [2021] [Christian Bandowski]
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestParseVarMapping(t *testing.T) {
+	vars := map[string]VarLoader{"device.type": FixedValue("ups")}
+	values := &ApcValues{values: map[string]string{"LOADPCT": "27.0", "TIMELEFT": "12.5"}}
+
+	testCases := []struct {
+		name    string
+		mapping string
+		// expected value, if the mapping is valid
+		value string
+		err   string
+	}{
+		{"apc value", "ups.load=apc:LOADPCT", "27.0", ""},
+		{"missing apc value", "ups.power=apc:NOMPOWER", "", ""},
+		{"fallback", "ups.power=apc:NOMPOWER|fixed:600", "600", ""},
+		{"nested fallback", "ups.power=apc:NOMPOWER|apc:LOADPCT|fixed:600", "27.0", ""},
+		{"minutes", "battery.runtime=minutes:TIMELEFT", "750", ""},
+		{"fixed value", "ups.id=fixed:Rack UPS", "Rack UPS", ""},
+		{"other variable", "ups.type=var:device.type", "ups", ""},
+		{"expression", "ups.realpower=expr:LOADPCT * 2", "54", ""},
+		{"conversions", "battery.runtime=apc:TIMELEFT; minutes-to-seconds; scale:0.5", "375", ""},
+		{"converted fallback", "ups.power=apc:NOMPOWER|fixed:600;scale:2", "1200", ""},
+		{"invalid conversion", "ups.load=apc:LOADPCT;percent", "", "Invalid mapping of variable ups.load: " +
+			"Unknown conversion percent, must be \"minutes-to-seconds\", \"fahrenheit-to-celsius\", " +
+			"\"celsius-to-fahrenheit\", \"scale\", \"precision\" or \"strip\""},
+		{"invalid expression", "ups.realpower=expr:LOADPCT *", "",
+			"Invalid mapping of variable ups.realpower: Invalid expression LOADPCT *: Unexpected end"},
+		{"unknown variable", "ups.type=var:device.kind", "",
+			"Invalid mapping of variable ups.type: Unknown variable device.kind"},
+		{"missing apc key", "ups.load=apc:", "", "Invalid mapping of variable ups.load: Missing apc key"},
+		{"invalid fallback", "ups.load=apc:LOADPCT|none", "",
+			"Invalid mapping of variable ups.load: Invalid fallback: Invalid loader none, must be <type>:<argument>"},
+		{"unknown loader type", "ups.load=snmp:1.3.6", "", "Invalid mapping of variable ups.load: " +
+			"Unknown loader type snmp, must be \"apc\", \"minutes\", \"fixed\", \"var\" or \"expr\""},
+		{"missing loader", "ups.load", "", "Invalid variable mapping ups.load, must be <name>=<loader>"},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			name, loader, err := parseVarMapping(testCase.mapping, vars)
+			if testCase.err != "" {
+				assert.EqualError(t, err, testCase.err)
+				return
+			}
+			assert.NoError(t, err)
+
+			value, err := loader(name, &Config{}, values)
+			assert.NoError(t, err)
+			assert.Equal(t, testCase.value, value)
+		})
+	}
+}
+
+func TestConfig_applyVarMappings(t *testing.T) {
+	config := &Config{vars: defaultVars(), varMappings: "ups.load=apc:LOAD, invalid, ups.location=fixed:Basement"}
+	values := &ApcValues{values: map[string]string{"LOAD": "42.0", "LOADPCT": "27.0"}}
+
+	config.applyVarMappings()
+
+	value, err := config.vars["ups.load"]("ups.load", config, values)
+	assert.NoError(t, err)
+	assert.Equal(t, "42.0", value)
+	value, err = config.vars["ups.location"]("ups.location", config, values)
+	assert.NoError(t, err)
+	assert.Equal(t, "Basement", value)
+}
+
+func TestConfig_mapVar(t *testing.T) {
+	global := &Config{vars: defaultVars(), writableVars: "battery.charge.low", state: NewUpsState()}
+	ups := global.newUpsConfig("rack")
+	values := &ApcValues{values: map[string]string{"MBATTCHG": "10"}}
+
+	assert.NoError(t, ups.mapVar("battery.charge.low", "fixed:20"))
+	assert.NoError(t, ups.state.setVar("battery.charge.low", "30"))
+
+	value, err := ups.vars["battery.charge.low"]("battery.charge.low", ups, values)
+	assert.NoError(t, err)
+	assert.Equal(t, "30", value)
+	value, err = global.vars["battery.charge.low"]("battery.charge.low", global, values)
+	assert.NoError(t, err)
+	assert.Equal(t, "10", value)
+	assert.Error(t, ups.mapVar("battery.charge.low", "fixed"))
+}
+
+func TestConfig_applyStaticVars(t *testing.T) {
+	config := &Config{
+		vars:           defaultVars(),
+		varInfos:       defaultVarInfos(),
+		staticVars:     "device.location=Server room, invalid, ups.id=Rack",
+		staticVarSpecs: stringListFlag{"device.description=Server room, Rack 2", "ups.id=Rack 2"},
+	}
+
+	config.applyStaticVars()
+
+	value, err := config.vars["device.location"]("device.location", config, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "Server room", value)
+	value, err = config.vars["ups.id"]("ups.id", config, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "Rack 2", value)
+	value, err = config.vars["device.description"]("device.description", config, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "Server room, Rack 2", value)
+	assert.NotContains(t, config.varNames(), "invalid")
+}
+
+func TestConfig_excludeVars(t *testing.T) {
+	global := &Config{vars: defaultVars(), varInfos: defaultVarInfos()}
+	ups := global.newUpsConfig("rack")
+
+	ups.excludeVars([]string{"ups.serial", "device.serial", "ups.unknown"})
+
+	assert.NotContains(t, ups.varNames(), "ups.serial")
+	assert.NotContains(t, ups.varNames(), "device.serial")
+	assert.Contains(t, ups.varNames(), "ups.status")
+	assert.Contains(t, global.varNames(), "ups.serial")
+}
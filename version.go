@@ -0,0 +1,32 @@
+// Copyright [2021] [Christian Bandowski]
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import "fmt"
+
+// build metadata, populated at build time by using -ldflags "-X main.version=..."
+var (
+	version   = "dev"
+	commit    = "unknown"
+	buildDate = "unknown"
+)
+
+// version of the NUT network protocol supported by the proxy
+const networkProtocolVersion = "1.3"
+
+// versionString returns the version of the proxy including the build metadata.
+func versionString() string {
+	return fmt.Sprintf("apcupsd-nut-proxy %s (commit %s, built %s)", version, commit, buildDate)
+}
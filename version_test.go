@@ -0,0 +1,27 @@
+// Copyright [2021] [Christian Bandowski]
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestVersionString(t *testing.T) {
+	defer func(v, c, b string) { version, commit, buildDate = v, c, b }(version, commit, buildDate)
+	version, commit, buildDate = "1.2.3", "abc1234", "2021-03-14"
+
+	assert.Equal(t, "apcupsd-nut-proxy 1.2.3 (commit abc1234, built 2021-03-14)", versionString())
+}
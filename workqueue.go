@@ -0,0 +1,112 @@
+// Copyright [2021] [Christian Bandowski]
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"sync"
+)
+
+// errQueueFull is returned if an execution can't be queued, because too many are waiting already
+var errQueueFull = errors.New("Too many executions are waiting for a free worker")
+
+// WorkQueue limits the number of simultaneous executions of the backend, e.g. of apcaccess processes. Executions
+// wait in a bounded queue for a free worker, once the queue is full they fail right away. It is shared by all UPSes
+// and safe for concurrent use.
+type WorkQueue struct {
+	// one entry per running execution
+	workers chan struct{}
+
+	mutex      sync.Mutex
+	pending    int
+	maxPending int
+}
+
+// NewWorkQueue creates a new instance of WorkQueue
+func NewWorkQueue(workers int, maxPending int) *WorkQueue {
+	return &WorkQueue{workers: make(chan struct{}, workers), maxPending: maxPending}
+}
+
+// run waits for a free worker and runs the function, unless the queue is full or the context is done while waiting.
+// A nil queue runs the function right away.
+func (q *WorkQueue) run(ctx context.Context, f func()) error {
+	if q == nil {
+		f()
+		return nil
+	}
+
+	select {
+	case q.workers <- struct{}{}:
+		// a worker is free, there's no need to queue
+	default:
+		if err := q.wait(ctx); err != nil {
+			return err
+		}
+	}
+	metricRunningExecutions.Add(1)
+	defer func() {
+		metricRunningExecutions.Add(-1)
+		<-q.workers
+	}()
+
+	f()
+	return nil
+}
+
+// wait queues the caller until a worker is free and reserves it.
+func (q *WorkQueue) wait(ctx context.Context) error {
+	q.mutex.Lock()
+	if q.pending >= q.maxPending {
+		q.mutex.Unlock()
+		metricRejectedExecutions.Add(1)
+		return errQueueFull
+	}
+	q.pending++
+	q.mutex.Unlock()
+	metricQueuedExecutions.Add(1)
+
+	defer func() {
+		q.mutex.Lock()
+		q.pending--
+		q.mutex.Unlock()
+		metricQueuedExecutions.Add(-1)
+	}()
+
+	select {
+	case q.workers <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return errors.Wrap(ctx.Err(), "Waiting for a free worker was cancelled")
+	}
+}
+
+// wrap returns an execCmd that executes the commands by the given one, limited by the queue. A nil queue returns the
+// given one.
+func (q *WorkQueue) wrap(exec execCmd) execCmd {
+	if q == nil {
+		return exec
+	}
+
+	return func(ctx context.Context, name string, args ...string) ([]byte, error) {
+		var out []byte
+		var err error
+		if queueErr := q.run(ctx, func() { out, err = exec(ctx, name, args...) }); queueErr != nil {
+			return nil, queueErr
+		}
+
+		return out, err
+	}
+}
@@ -0,0 +1,115 @@
+// Copyright [2021] [Christian Bandowski]
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWorkQueue_run(t *testing.T) {
+	queue := NewWorkQueue(2, 10)
+	var mutex sync.Mutex
+	running, maxRunning := 0, 0
+
+	var wg sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := queue.run(context.Background(), func() {
+				mutex.Lock()
+				running++
+				if running > maxRunning {
+					maxRunning = running
+				}
+				mutex.Unlock()
+
+				time.Sleep(20 * time.Millisecond)
+
+				mutex.Lock()
+				running--
+				mutex.Unlock()
+			})
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, 2, maxRunning)
+}
+
+func TestWorkQueue_run_Full(t *testing.T) {
+	queue := NewWorkQueue(1, 1)
+	rejected := metricRejectedExecutions.Value()
+	release := make(chan struct{})
+	started := make(chan struct{})
+
+	go queue.run(context.Background(), func() {
+		close(started)
+		<-release
+	})
+	<-started
+	queued := make(chan error)
+	go func() { queued <- queue.run(context.Background(), func() {}) }()
+	assert.Eventually(t, func() bool {
+		queue.mutex.Lock()
+		defer queue.mutex.Unlock()
+		return queue.pending == 1
+	}, time.Second, time.Millisecond)
+
+	// the worker is busy and the queue is full
+	assert.Equal(t, errQueueFull, queue.run(context.Background(), func() {}))
+	assert.Equal(t, rejected+1, metricRejectedExecutions.Value())
+
+	close(release)
+	assert.NoError(t, <-queued)
+}
+
+func TestWorkQueue_run_Cancelled(t *testing.T) {
+	queue := NewWorkQueue(1, 1)
+	release := make(chan struct{})
+	defer close(release)
+	started := make(chan struct{})
+	go queue.run(context.Background(), func() {
+		close(started)
+		<-release
+	})
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err := queue.run(ctx, func() { t.Error("must not run") })
+
+	assert.EqualError(t, err, "Waiting for a free worker was cancelled: context deadline exceeded")
+}
+
+func TestWorkQueue_wrap(t *testing.T) {
+	exec := func(ctx context.Context, name string, args ...string) ([]byte, error) {
+		return []byte(name), nil
+	}
+
+	var queue *WorkQueue
+	out, err := queue.wrap(exec)(context.Background(), "apcaccess")
+	assert.NoError(t, err)
+	assert.Equal(t, "apcaccess", string(out))
+
+	out, err = NewWorkQueue(1, 0).wrap(exec)(context.Background(), "apcaccess")
+	assert.NoError(t, err)
+	assert.Equal(t, "apcaccess", string(out))
+}